@@ -1,8 +1,16 @@
 module example.com/ip-opt-gui
 
-go 1.25
+go 1.25.0
 
-require gioui.org v0.8.0
+require (
+	gioui.org v0.8.0
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/oschwald/maxminddb-golang/v2 v2.6.0
+	github.com/quic-go/quic-go v0.60.0
+	golang.org/x/crypto v0.54.0
+	golang.org/x/net v0.57.0
+	golang.org/x/sys v0.47.0
+)
 
 require (
 	gioui.org/shader v1.0.8 // indirect
@@ -10,6 +18,5 @@ require (
 	golang.org/x/exp v0.0.0-20240707233637-46b078467d37 // indirect
 	golang.org/x/exp/shiny v0.0.0-20240707233637-46b078467d37 // indirect
 	golang.org/x/image v0.18.0 // indirect
-	golang.org/x/sys v0.22.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
 )
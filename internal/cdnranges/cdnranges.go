@@ -0,0 +1,149 @@
+package cdnranges
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+type Provider struct {
+	Key   string
+	Name  string
+	URLs  []string
+	Parse func([]byte) ([]netip.Prefix, error)
+}
+
+var Providers = []Provider{
+	{Key: "cloudflare", Name: "Cloudflare", URLs: []string{"https://www.cloudflare.com/ips-v4", "https://www.cloudflare.com/ips-v6"}, Parse: ParseText},
+	{Key: "fastly", Name: "Fastly", URLs: []string{"https://api.fastly.com/public-ip-list"}, Parse: parseJSONLists("addresses", "ipv6_addresses")},
+	{Key: "cloudfront", Name: "CloudFront", URLs: []string{"https://d7uri8nf7uskq.cloudfront.net/tools/list-cloudfront-ips"}, Parse: parseJSONLists("CLOUDFRONT_GLOBAL_IP_LIST", "CLOUDFRONT_REGIONAL_EDGE_IP_LIST")},
+	{Key: "gcore", Name: "GCore", URLs: []string{"https://api.gcore.com/cdn/public-ip-list"}, Parse: parseJSONLists("addresses", "addresses_v6")},
+}
+
+func ParseText(b []byte) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := netip.ParsePrefix(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p.Masked())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no ranges found")
+	}
+	return out, nil
+}
+
+func parseJSONLists(keys ...string) func([]byte) ([]netip.Prefix, error) {
+	return func(b []byte) ([]netip.Prefix, error) {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		var out []netip.Prefix
+		for _, k := range keys {
+			raw, ok := doc[k]
+			if !ok {
+				continue
+			}
+			var list []string
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, err
+			}
+			for _, s := range list {
+				p, err := netip.ParsePrefix(strings.TrimSpace(s))
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, p.Masked())
+			}
+		}
+		if len(out) == 0 {
+			return nil, errors.New("no ranges found")
+		}
+		return out, nil
+	}
+}
+
+type Set map[string][]netip.Prefix
+
+func (s Set) Match(ip netip.Addr) (string, bool) {
+	ip = ip.Unmap()
+	for _, p := range Providers {
+		for _, pfx := range s[p.Key] {
+			if pfx.Contains(ip) {
+				return p.Key, true
+			}
+		}
+	}
+	return "", false
+}
+
+func Sample(prefixes []netip.Prefix, n int, ipv4, ipv6 bool) []netip.Addr {
+	var pool []netip.Prefix
+	for _, p := range prefixes {
+		if (p.Addr().Is4() && ipv4) || (p.Addr().Is6() && ipv6) {
+			pool = append(pool, p)
+		}
+	}
+	var out []netip.Addr
+	seen := map[netip.Addr]bool{}
+	for round := 0; len(out) < n && len(pool) > 0; round++ {
+		added := false
+		for _, p := range pool {
+			if len(out) >= n {
+				break
+			}
+			ip, ok := nthSubnetHost(p, round)
+			if !ok || seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			out = append(out, ip)
+			added = true
+		}
+		if !added {
+			break
+		}
+	}
+	return out
+}
+
+func nthSubnetHost(p netip.Prefix, i int) (netip.Addr, bool) {
+	sub := 24
+	if p.Addr().Is6() {
+		sub = 48
+	}
+	if p.Bits() > sub {
+		sub = p.Bits()
+	}
+	if i > 0 && (sub-p.Bits() >= 31 || i >= 1<<(sub-p.Bits())) {
+		return netip.Addr{}, false
+	}
+	b := p.Addr().AsSlice()
+	shift := len(b)*8 - sub
+	off := uint64(i) << (shift % 8)
+	for k := len(b) - 1 - shift/8; k >= 0 && off > 0; k-- {
+		sum := uint64(b[k]) + off&0xff
+		b[k] = byte(sum)
+		off = off>>8 + sum>>8
+	}
+	b[len(b)-1] |= 1
+	ip, _ := netip.AddrFromSlice(b)
+	if !p.Contains(ip) {
+		return netip.Addr{}, false
+	}
+	return ip, true
+}
@@ -0,0 +1,57 @@
+package cdnranges
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	ps, err := ParseText([]byte("173.245.48.0/20\n# comment\n\n2400:cb00::/32\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps[0].String() != "173.245.48.0/20" || ps[1].String() != "2400:cb00::/32" {
+		t.Fatalf("unexpected prefixes: %v", ps)
+	}
+	if _, err := ParseText([]byte("not-a-prefix\n")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	parse := parseJSONLists("addresses", "ipv6_addresses")
+	ps, err := parse([]byte(`{"addresses":["23.235.32.0/20"],"ipv6_addresses":["2a04:4e40::/32"],"other":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("unexpected prefixes: %v", ps)
+	}
+}
+
+func TestSetMatch(t *testing.T) {
+	s := Set{"fastly": {netip.MustParsePrefix("151.101.0.0/16")}}
+	if k, ok := s.Match(netip.MustParseAddr("151.101.1.1")); !ok || k != "fastly" {
+		t.Fatalf("Match = %q %v", k, ok)
+	}
+	if _, ok := s.Match(netip.MustParseAddr("1.1.1.1")); ok {
+		t.Fatal("unexpected match")
+	}
+}
+
+func TestSample(t *testing.T) {
+	ps := []netip.Prefix{netip.MustParsePrefix("104.16.0.0/22"), netip.MustParsePrefix("172.64.0.0/24"), netip.MustParsePrefix("2606:4700::/32")}
+	got := Sample(ps, 4, true, false)
+	want := []string{"104.16.0.1", "172.64.0.1", "104.16.1.1", "104.16.2.1"}
+	if len(got) != len(want) {
+		t.Fatalf("Sample = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Fatalf("Sample = %v, want %v", got, want)
+		}
+	}
+	if v6 := Sample(ps, 2, false, true); len(v6) != 2 || v6[1].String() != "2606:4700:1::1" {
+		t.Fatalf("Sample v6 = %v", v6)
+	}
+}
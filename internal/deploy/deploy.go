@@ -0,0 +1,302 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+const (
+	DefaultPath  = "/etc/hosts"
+	DnsmasqPath  = "/etc/dnsmasq.conf"
+	defaultPort  = "22"
+	dialTimeout  = 10 * time.Second
+	backupLayout = "20060102_150405"
+)
+
+type Kind string
+
+const (
+	KindHosts   Kind = "hosts"
+	KindOpenWrt Kind = "openwrt"
+	KindWSL     Kind = "wsl"
+)
+
+type Target struct {
+	User     string
+	Addr     string
+	KeyPath  string
+	Password string
+	Path     string
+	Kind     Kind
+	// DisableGenerateHosts turns off generateHosts in a WSL distro's
+	// wsl.conf so the written /etc/hosts survives a restart.
+	DisableGenerateHosts bool
+}
+
+func (t Target) String() string {
+	if t.Kind == KindWSL {
+		return "wsl:" + t.Addr
+	}
+	return t.User + "@" + t.Addr
+}
+
+// GenerateHosts and ConfChanged are only set for WSL targets: the distro
+// still regenerates /etc/hosts on start, or wsl.conf was edited to stop it.
+type Result struct {
+	Target        Target
+	Backup        string
+	Changed       bool
+	GenerateHosts bool
+	ConfChanged   bool
+	Err           error
+}
+
+// ParseTargets reads one target per line:
+//
+//	user@host[:port] [key=~/.ssh/id_ed25519] [password=secret] [path=/etc/hosts] [type=openwrt]
+func ParseTargets(text string) ([]Target, error) {
+	var out []Target
+	for n, line := range strings.Split(text, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		user, host, ok := strings.Cut(fields[0], "@")
+		if !ok || user == "" || host == "" {
+			return nil, fmt.Errorf("line %d: want user@host, got %q", n+1, fields[0])
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+		}
+		t := Target{User: user, Addr: host, Kind: KindHosts}
+		for _, f := range fields[1:] {
+			k, v, _ := strings.Cut(f, "=")
+			switch k {
+			case "key":
+				t.KeyPath = v
+			case "password":
+				t.Password = v
+			case "path":
+				t.Path = v
+			case "type":
+				t.Kind = Kind(v)
+				if t.Kind != KindHosts && t.Kind != KindOpenWrt {
+					return nil, fmt.Errorf("line %d: unknown type %q", n+1, v)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown option %q", n+1, k)
+			}
+		}
+		if t.Path == "" {
+			t.Path = DefaultPath
+			if t.Kind == KindOpenWrt {
+				t.Path = DnsmasqPath
+			}
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// Deploy replaces the managed block in the target's hosts file (or, for
+// OpenWrt, dnsmasq.conf followed by a dnsmasq restart) with mappings, keeping a
+// timestamped backup next to it. Non-root users go through sudo -n, so
+// passwordless sudo is required for them.
+func Deploy(ctx context.Context, t Target, mappings []hostsfile.Mapping, header []string) Result {
+	if t.Kind == KindWSL {
+		return deployWSL(wslRunner{ctx, t.Addr}, t, hostsfile.BuildManagedBlock(mappings, header), time.Now())
+	}
+	res := Result{Target: t}
+	client, err := dial(ctx, t)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+	r := sshRunner{client}
+	sudo := t.User != "root"
+	if t.Kind != KindOpenWrt {
+		res.Backup, res.Changed, res.Err = apply(r, t.Path, hostsfile.BuildManagedBlock(mappings, header), sudo, true, time.Now())
+		return res
+	}
+	res.Backup, res.Changed, res.Err = apply(r, t.Path, DnsmasqBlock(mappings, header), sudo, false, time.Now())
+	if res.Changed && res.Err == nil {
+		res.Err = restartDnsmasq(r, sudo)
+	}
+	return res
+}
+
+// DnsmasqBlock renders mappings as dnsmasq address entries. Note that
+// address=/example.com/ also answers for every subdomain of example.com.
+func DnsmasqBlock(mappings []hostsfile.Mapping, header []string) string {
+	var lines []string
+	for _, m := range mappings {
+		ip, d := strings.TrimSpace(m.IP), strings.TrimSpace(m.Domain)
+		if ip == "" || d == "" {
+			continue
+		}
+		lines = append(lines, "address=/"+d+"/"+ip)
+	}
+	return hostsfile.WrapManagedBlock(lines, header)
+}
+
+func restartDnsmasq(r runner, sudo bool) error {
+	cmd := "/etc/init.d/dnsmasq restart"
+	if sudo {
+		cmd = "sudo -n " + cmd
+	}
+	if _, err := r.Run(cmd, nil); err != nil {
+		return fmt.Errorf("restart dnsmasq: %w", err)
+	}
+	return nil
+}
+
+type runner interface {
+	Run(cmd string, stdin io.Reader) ([]byte, error)
+}
+
+func apply(r runner, path, block string, sudo, lint bool, now time.Time) (backup string, changed bool, err error) {
+	prefix := ""
+	if sudo {
+		prefix = "sudo -n "
+	}
+	out, err := r.Run("cat -- "+shellQuote(path), nil)
+	if err != nil {
+		return "", false, fmt.Errorf("read %s: %w", path, err)
+	}
+	orig := string(out)
+	next := hostsfile.ApplyManagedBlock(orig, block)
+	if next == orig {
+		return "", false, nil
+	}
+	if lint {
+		if errs := hostsfile.NewErrors(hostsfile.Lint(orig), hostsfile.Lint(next)); len(errs) > 0 {
+			return "", false, &hostsfile.LintError{Issues: errs}
+		}
+	}
+	backup = path + ".bak." + now.Format(backupLayout)
+	if _, err := r.Run(prefix+"cp -p -- "+shellQuote(path)+" "+shellQuote(backup), nil); err != nil {
+		return "", false, fmt.Errorf("backup %s: %w", path, err)
+	}
+	if _, err := r.Run(prefix+"tee -- "+shellQuote(path)+" >/dev/null", strings.NewReader(next)); err != nil {
+		return backup, false, fmt.Errorf("write %s: %w", path, err)
+	}
+	return backup, true, nil
+}
+
+func dial(ctx context.Context, t Target) (*ssh.Client, error) {
+	var auth []ssh.AuthMethod
+	keys := []string{t.KeyPath}
+	if t.KeyPath == "" && t.Password == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			keys = []string{filepath.Join(home, ".ssh", "id_ed25519"), filepath.Join(home, ".ssh", "id_rsa")}
+		}
+	}
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		signer, err := loadKey(k)
+		if err != nil {
+			if t.KeyPath != "" {
+				return nil, err
+			}
+			continue
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if t.Password != "" {
+		auth = append(auth, ssh.Password(t.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no ssh key or password configured")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts: %w", err)
+	}
+	cfg := &ssh.ClientConfig{
+		User: t.User,
+		Auth: auth,
+		HostKeyCallback: func(host string, remote net.Addr, key ssh.PublicKey) error {
+			err := hostKeys(host, remote, key)
+			var ke *knownhosts.KeyError
+			if errors.As(err, &ke) && len(ke.Want) == 0 {
+				return fmt.Errorf("host key for %s not in known_hosts; connect once with `ssh %s` to add it", host, t)
+			}
+			return err
+		},
+		Timeout: dialTimeout,
+	}
+
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.Addr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func loadKey(path string) (ssh.Signer, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+type sshRunner struct{ c *ssh.Client }
+
+func (r sshRunner) Run(cmd string, stdin io.Reader) ([]byte, error) {
+	s, err := r.c.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	var stdout, stderr bytes.Buffer
+	s.Stdin = stdin
+	s.Stdout = &stdout
+	s.Stderr = &stderr
+	if err := s.Run(cmd); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
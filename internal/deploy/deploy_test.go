@@ -0,0 +1,158 @@
+package deploy
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+func TestParseTargets(t *testing.T) {
+	ts, err := ParseTargets("# nas\nadmin@nas.local key=~/.ssh/nas\nroot@10.0.0.2:2222 password=pw path=/etc/hosts.d/extra\nroot@192.168.1.1 type=openwrt\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 3 {
+		t.Fatalf("got %d targets", len(ts))
+	}
+	if ts[0].Addr != "nas.local:22" || ts[0].KeyPath != "~/.ssh/nas" || ts[0].Path != DefaultPath {
+		t.Fatalf("target 0 = %+v", ts[0])
+	}
+	if ts[1].String() != "root@10.0.0.2:2222" || ts[1].Password != "pw" || ts[1].Path != "/etc/hosts.d/extra" {
+		t.Fatalf("target 1 = %+v", ts[1])
+	}
+	if ts[2].Kind != KindOpenWrt || ts[2].Path != DnsmasqPath {
+		t.Fatalf("target 2 = %+v", ts[2])
+	}
+	if _, err := ParseTargets("nas.local\n"); err == nil {
+		t.Fatalf("expected error for missing user")
+	}
+}
+
+type fakeRunner struct {
+	files map[string]string
+	cmds  []string
+}
+
+func (f *fakeRunner) Run(cmd string, stdin io.Reader) ([]byte, error) {
+	f.cmds = append(f.cmds, cmd)
+	fields := strings.Fields(strings.TrimPrefix(cmd, "sudo -n "))
+	arg := func(i int) string { return strings.Trim(fields[i], "'") }
+	switch fields[0] {
+	case "cat":
+		s, ok := f.files[arg(2)]
+		if !ok {
+			return nil, errors.New("no such file")
+		}
+		return []byte(s), nil
+	case "cp":
+		f.files[arg(4)] = f.files[arg(3)]
+	case "tee":
+		b, _ := io.ReadAll(stdin)
+		f.files[arg(2)] = string(b)
+	}
+	return nil, nil
+}
+
+func TestApply(t *testing.T) {
+	r := &fakeRunner{files: map[string]string{"/etc/hosts": "127.0.0.1 localhost\n"}}
+	block := hostsfile.BuildManagedBlock([]hostsfile.Mapping{{IP: "1.2.3.4", Domain: "example.com"}}, nil)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	backup, changed, err := apply(r, "/etc/hosts", block, true, true, now)
+	if err != nil || !changed {
+		t.Fatalf("apply = %v, %v", changed, err)
+	}
+	if backup != "/etc/hosts.bak.20240501_120000" || r.files[backup] != "127.0.0.1 localhost\n" {
+		t.Fatalf("backup %q = %q", backup, r.files[backup])
+	}
+	if !strings.Contains(r.files["/etc/hosts"], "1.2.3.4 example.com") {
+		t.Fatalf("hosts not updated:\n%s", r.files["/etc/hosts"])
+	}
+	if !strings.HasPrefix(r.cmds[2], "sudo -n tee") {
+		t.Fatalf("write did not use sudo: %q", r.cmds[2])
+	}
+
+	r.cmds = nil
+	if _, changed, err := apply(r, "/etc/hosts", block, true, true, now); err != nil || changed || len(r.cmds) != 1 {
+		t.Fatalf("second apply changed=%v err=%v cmds=%q", changed, err, r.cmds)
+	}
+}
+
+func TestDnsmasqBlock(t *testing.T) {
+	got := DnsmasqBlock([]hostsfile.Mapping{
+		{IP: "1.2.3.4", Domain: "example.com"},
+		{IP: "2001:db8::1", Domain: "example.com"},
+		{IP: "", Domain: "skip.com"},
+	}, []string{"generated by test"})
+	want := "# ip-opt-gui begin\n# generated by test\naddress=/example.com/1.2.3.4\naddress=/example.com/2001:db8::1\n# ip-opt-gui end\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	conf := "# user config\ncache-size=1000\n"
+	r := &fakeRunner{files: map[string]string{DnsmasqPath: conf}}
+	if _, changed, err := apply(r, DnsmasqPath, got, false, false, time.Now()); err != nil || !changed {
+		t.Fatalf("apply = %v, %v", changed, err)
+	}
+	if r.files[DnsmasqPath] != conf+got {
+		t.Fatalf("dnsmasq.conf = %q", r.files[DnsmasqPath])
+	}
+}
+
+func TestParseWSLList(t *testing.T) {
+	var b []byte
+	for _, r := range "\ufeffUbuntu-22.04\r\ndocker-desktop\r\n\r\nDebian\r\n" {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	got := parseWSLList(b)
+	if strings.Join(got, ",") != "Ubuntu-22.04,Debian" {
+		t.Fatalf("parseWSLList = %q", got)
+	}
+}
+
+func TestGenerateHosts(t *testing.T) {
+	if !generateHostsEnabled("") || !generateHostsEnabled("[boot]\nsystemd=true\n") {
+		t.Fatalf("generateHosts should default to true")
+	}
+	if generateHostsEnabled("[network]\ngenerateHosts = false # keep\n") {
+		t.Fatalf("generateHosts = false not detected")
+	}
+	got := disableGenerateHosts("[boot]\nsystemd=true\n")
+	if got != "[boot]\nsystemd=true\n\n[network]\ngenerateHosts = false\n" {
+		t.Fatalf("disableGenerateHosts added section = %q", got)
+	}
+	got = disableGenerateHosts("[network]\nhostname=box\ngenerateHosts=true\n")
+	if got != "[network]\nhostname=box\ngenerateHosts = false\n" {
+		t.Fatalf("disableGenerateHosts replaced key = %q", got)
+	}
+}
+
+func TestDeployWSL(t *testing.T) {
+	r := &fakeRunner{files: map[string]string{DefaultPath: "127.0.0.1 localhost\n", wslConfPath: "[boot]\nsystemd=true\n"}}
+	block := hostsfile.BuildManagedBlock([]hostsfile.Mapping{{IP: "1.2.3.4", Domain: "example.com"}}, nil)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	res := deployWSL(r, WSLTarget("Ubuntu", false), block, now)
+	if res.Err != nil || !res.Changed || !res.GenerateHosts || res.ConfChanged {
+		t.Fatalf("deployWSL = %+v", res)
+	}
+	if !strings.Contains(r.files[DefaultPath], "1.2.3.4 example.com") {
+		t.Fatalf("hosts not updated:\n%s", r.files[DefaultPath])
+	}
+
+	res = deployWSL(r, WSLTarget("Ubuntu", true), block, now)
+	if res.Err != nil || res.Changed || res.GenerateHosts || !res.ConfChanged {
+		t.Fatalf("deployWSL with disable = %+v", res)
+	}
+	if generateHostsEnabled(r.files[wslConfPath]) || r.files[wslConfPath+".bak.20240501_120000"] != "[boot]\nsystemd=true\n" {
+		t.Fatalf("wsl.conf = %q", r.files[wslConfPath])
+	}
+	for _, c := range r.cmds {
+		if strings.HasPrefix(c, "sudo") {
+			t.Fatalf("wsl command used sudo: %q", c)
+		}
+	}
+}
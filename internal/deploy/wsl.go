@@ -0,0 +1,164 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const wslConfPath = "/etc/wsl.conf"
+
+var ErrNoWSL = errors.New("wsl is only available on windows")
+
+// WSLTarget is the /etc/hosts of a local WSL distribution. Commands run as
+// root through wsl.exe, so neither ssh nor sudo is involved.
+func WSLTarget(distro string, disableGenerateHosts bool) Target {
+	return Target{User: "root", Addr: distro, Path: DefaultPath, Kind: KindWSL, DisableGenerateHosts: disableGenerateHosts}
+}
+
+// WSLDistros lists the installed WSL distributions, leaving out Docker
+// Desktop's internal ones, which have no usable /etc/hosts.
+func WSLDistros(ctx context.Context) ([]string, error) {
+	if runtime.GOOS != "windows" {
+		return nil, ErrNoWSL
+	}
+	cmd := exec.CommandContext(ctx, "wsl.exe", "--list", "--quiet")
+	hideWindow(cmd)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("wsl --list: %w", err)
+	}
+	return parseWSLList(out), nil
+}
+
+// parseWSLList reads `wsl --list --quiet`, which wsl.exe prints in UTF-16LE.
+func parseWSLList(out []byte) []string {
+	var names []string
+	sc := bufio.NewScanner(strings.NewReader(decodeWSLOutput(out)))
+	for sc.Scan() {
+		name := strings.TrimSpace(strings.Trim(sc.Text(), "\ufeff\x00"))
+		if name != "" && !strings.HasPrefix(name, "docker-desktop") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func decodeWSLOutput(b []byte) string {
+	if len(b) < 2 || len(b)%2 != 0 || (b[1] != 0 && !bytes.HasPrefix(b, []byte{0xff, 0xfe})) {
+		return string(b)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return string(utf16.Decode(u))
+}
+
+type wslRunner struct {
+	ctx    context.Context
+	distro string
+}
+
+func (r wslRunner) Run(cmd string, stdin io.Reader) ([]byte, error) {
+	c := exec.CommandContext(r.ctx, "wsl.exe", "-d", r.distro, "-u", "root", "--", "sh", "-c", cmd)
+	hideWindow(c)
+	c.Stdin = stdin
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(decodeWSLOutput(stderr.Bytes())); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// deployWSL writes block into the distro's hosts file. Unless generateHosts
+// is turned off in /etc/wsl.conf, WSL rebuilds /etc/hosts from the Windows
+// hosts file on every start, so the setting is reported and, when the target
+// asks for it, switched off (taking effect after `wsl --shutdown`).
+func deployWSL(r runner, t Target, block string, now time.Time) Result {
+	res := Result{Target: t}
+	conf, err := r.Run("cat -- "+shellQuote(wslConfPath), nil)
+	exists := err == nil
+	res.GenerateHosts = generateHostsEnabled(string(conf))
+	if res.GenerateHosts && t.DisableGenerateHosts {
+		if exists {
+			if _, err := r.Run("cp -p -- "+shellQuote(wslConfPath)+" "+shellQuote(wslConfPath+".bak."+now.Format(backupLayout)), nil); err != nil {
+				res.Err = fmt.Errorf("backup %s: %w", wslConfPath, err)
+				return res
+			}
+		}
+		if _, err := r.Run("tee -- "+shellQuote(wslConfPath)+" >/dev/null", strings.NewReader(disableGenerateHosts(string(conf)))); err != nil {
+			res.Err = fmt.Errorf("write %s: %w", wslConfPath, err)
+			return res
+		}
+		res.GenerateHosts, res.ConfChanged = false, true
+	}
+	res.Backup, res.Changed, res.Err = apply(r, t.Path, block, false, true, now)
+	return res
+}
+
+// generateHostsEnabled reports whether wsl.conf leaves [network]
+// generateHosts at its default of true.
+func generateHostsEnabled(conf string) bool {
+	section := ""
+	enabled := true
+	for _, line := range strings.Split(conf, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if ok && section == "network" && strings.EqualFold(strings.TrimSpace(k), "generateHosts") {
+			v, _, _ = strings.Cut(v, "#")
+			enabled = !strings.EqualFold(strings.TrimSpace(v), "false")
+		}
+	}
+	return enabled
+}
+
+// disableGenerateHosts sets generateHosts = false in the [network] section,
+// adding the key or the section as needed and leaving the rest untouched.
+func disableGenerateHosts(conf string) string {
+	lines := strings.Split(strings.TrimRight(conf, "\n"), "\n")
+	if conf == "" {
+		lines = nil
+	}
+	section, network := "", -1
+	for i, line := range lines {
+		t := strings.TrimSpace(line)
+		if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+			section = strings.ToLower(strings.TrimSpace(t[1 : len(t)-1]))
+			if section == "network" && network < 0 {
+				network = i
+			}
+			continue
+		}
+		if k, _, ok := strings.Cut(t, "="); ok && section == "network" && strings.EqualFold(strings.TrimSpace(k), "generateHosts") {
+			lines[i] = "generateHosts = false"
+			return strings.Join(lines, "\n") + "\n"
+		}
+	}
+	if network < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "[network]", "generateHosts = false")
+	} else {
+		lines = append(lines[:network+1], append([]string{"generateHosts = false"}, lines[network+1:]...)...)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package deploy
+
+import "os/exec"
+
+func hideWindow(*exec.Cmd) {}
@@ -0,0 +1,284 @@
+package dnsserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+const (
+	DefaultAddr     = "127.0.0.1:53"
+	answerTTL       = 60
+	upstreamTimeout = 3 * time.Second
+	maxUDPSize      = 4096
+)
+
+// Server answers A/AAAA queries for the optimized domains and forwards
+// everything else to the upstream resolvers, over both UDP and TCP.
+type Server struct {
+	Addr     string
+	Upstream []string
+
+	Answered  atomic.Int64
+	Forwarded atomic.Int64
+
+	mu      sync.RWMutex
+	records map[string][]netip.Addr
+
+	pc net.PacketConn
+	ln net.Listener
+	wg sync.WaitGroup
+}
+
+func New(addr string, upstream []string) *Server {
+	var ups []string
+	for _, u := range upstream {
+		if strings.Contains(u, "://") {
+			continue // DoH endpoints are only used for resolution, not relayed to.
+		}
+		if u = normalizeAddr(u); u != "" && u != normalizeAddr(addr) {
+			ups = append(ups, u)
+		}
+	}
+	return &Server{Addr: addr, Upstream: ups}
+}
+
+func (s *Server) SetRecords(mappings []hostsfile.Mapping) int {
+	recs := map[string][]netip.Addr{}
+	for _, m := range mappings {
+		ip, err := netip.ParseAddr(strings.TrimSpace(m.IP))
+		if err != nil {
+			continue
+		}
+		name := canonical(m.Domain)
+		recs[name] = append(recs[name], ip.Unmap())
+	}
+	s.mu.Lock()
+	s.records = recs
+	s.mu.Unlock()
+	return len(recs)
+}
+
+func (s *Server) Start() error {
+	if len(s.Upstream) == 0 {
+		return errors.New("no upstream dns servers")
+	}
+	pc, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	s.pc, s.ln = pc, ln
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return nil
+}
+
+func (s *Server) Close() error {
+	err := s.pc.Close()
+	if lerr := s.ln.Close(); err == nil {
+		err = lerr
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		q := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp, err := s.handle(q, false); err == nil {
+				_, _ = s.pc.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			_ = c.SetDeadline(time.Now().Add(2 * upstreamTimeout))
+			var l [2]byte
+			if _, err := io.ReadFull(c, l[:]); err != nil {
+				return
+			}
+			q := make([]byte, binary.BigEndian.Uint16(l[:]))
+			if _, err := io.ReadFull(c, q); err != nil {
+				return
+			}
+			resp, err := s.handle(q, true)
+			if err != nil {
+				return
+			}
+			out := make([]byte, 2+len(resp))
+			binary.BigEndian.PutUint16(out, uint16(len(resp)))
+			copy(out[2:], resp)
+			_, _ = c.Write(out)
+		}()
+	}
+}
+
+func (s *Server) handle(query []byte, tcp bool) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	if resp, ok := s.answer(h, q); ok {
+		s.Answered.Add(1)
+		return resp, nil
+	}
+	s.Forwarded.Add(1)
+	return s.forward(query, tcp)
+}
+
+// answer replies locally for known names. A name with only IPv4 mappings gets
+// an empty AAAA answer (and vice versa) so clients cannot bypass the pinned
+// address through the other family, matching how a hosts entry behaves.
+func (s *Server) answer(h dnsmessage.Header, q dnsmessage.Question) ([]byte, bool) {
+	if q.Class != dnsmessage.ClassINET || (q.Type != dnsmessage.TypeA && q.Type != dnsmessage.TypeAAAA) {
+		return nil, false
+	}
+	s.mu.RLock()
+	addrs, ok := s.records[canonical(q.Name.String())]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, false
+	}
+	if err := b.Question(q); err != nil {
+		return nil, false
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, false
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: answerTTL}
+	for _, a := range addrs {
+		var err error
+		switch {
+		case q.Type == dnsmessage.TypeA && a.Is4():
+			err = b.AResource(rh, dnsmessage.AResource{A: a.As4()})
+		case q.Type == dnsmessage.TypeAAAA && a.Is6():
+			err = b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: a.As16()})
+		}
+		if err != nil {
+			return nil, false
+		}
+	}
+	resp, err := b.Finish()
+	return resp, err == nil
+}
+
+func (s *Server) forward(query []byte, tcp bool) ([]byte, error) {
+	var lastErr error
+	for _, up := range s.Upstream {
+		resp, err := exchange(up, query, tcp)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func exchange(addr string, query []byte, tcp bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+	network := "udp"
+	if tcp {
+		network = "tcp"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	if !tcp {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, maxUDPSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func canonical(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+func normalizeAddr(server string) string {
+	server = strings.TrimSpace(server)
+	if server == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+}
@@ -0,0 +1,86 @@
+package dnsserver
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+func buildQuery(t *testing.T, name string, qtype dnsmessage.Type) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	if err := b.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		t.Fatal(err)
+	}
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestAnswerLocal(t *testing.T) {
+	s := New(DefaultAddr, []string{"127.0.0.1:1"})
+	if n := s.SetRecords([]hostsfile.Mapping{{IP: "1.2.3.4", Domain: "Example.com"}, {IP: "bad", Domain: "x.com"}}); n != 1 {
+		t.Fatalf("SetRecords = %d", n)
+	}
+
+	resp, err := s.handle(buildQuery(t, "example.COM.", dnsmessage.TypeA), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 42 || len(m.Answers) != 1 || m.Answers[0].Body.(*dnsmessage.AResource).A != [4]byte{1, 2, 3, 4} {
+		t.Fatalf("unexpected answer: %+v", m)
+	}
+
+	resp, err = s.handle(buildQuery(t, "example.com.", dnsmessage.TypeAAAA), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Unpack(resp); err != nil || len(m.Answers) != 0 || m.RCode != dnsmessage.RCodeSuccess {
+		t.Fatalf("AAAA for v4-only name = %+v, %v", m, err)
+	}
+	if s.Answered.Load() != 2 || s.Forwarded.Load() != 0 {
+		t.Fatalf("answered=%d forwarded=%d", s.Answered.Load(), s.Forwarded.Load())
+	}
+}
+
+func TestForward(t *testing.T) {
+	up, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := up.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		buf[2] |= 0x80
+		_, _ = up.WriteTo(buf[:n], addr)
+	}()
+
+	s := New(DefaultAddr, []string{up.LocalAddr().String(), DefaultAddr})
+	if len(s.Upstream) != 1 {
+		t.Fatalf("listen address not dropped from upstream: %v", s.Upstream)
+	}
+	q := buildQuery(t, "other.com.", dnsmessage.TypeA)
+	resp, err := s.handle(q, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != len(q) || resp[2]&0x80 == 0 || s.Forwarded.Load() != 1 {
+		t.Fatalf("response not relayed from upstream: %x", resp)
+	}
+}
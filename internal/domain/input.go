@@ -3,9 +3,17 @@ package domain
 import (
 	"bufio"
 	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+
+	"golang.org/x/net/idna"
 )
 
 func NormalizeDomain(s string) (string, bool) {
@@ -21,12 +29,39 @@ func NormalizeDomain(s string) (string, bool) {
 	if s == "" {
 		return "", false
 	}
+	if !isASCII(s) {
+		a, err := idna.Lookup.ToASCII(s)
+		if err != nil {
+			return "", false
+		}
+		s = strings.TrimSuffix(a, ".")
+	}
 	if !isDomainName(s) {
 		return "", false
 	}
 	return s, true
 }
 
+func Display(d string) string {
+	if !strings.Contains(d, "xn--") {
+		return d
+	}
+	u, err := idna.Lookup.ToUnicode(d)
+	if err != nil {
+		return d
+	}
+	return u
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
 func isDomainName(s string) bool {
 	if len(s) == 0 || len(s) > 253 {
 		return false
@@ -53,9 +88,143 @@ func isDomainName(s string) bool {
 	return true
 }
 
-func ParseDomains(text string) []string {
+var DefaultSubdomains = []string{"www", "api", "cdn", "img", "static", "assets", "m"}
+
+func NormalizeWildcard(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "*.") {
+		return "", false
+	}
+	return NormalizeDomain(s[2:])
+}
+
+type Target struct {
+	Domain string
+	Port   int
+}
+
+type Group struct {
+	Name       string
+	Port       int
+	DNSServers []string
+	Allow      []netip.Prefix
+	Targets    []Target
+}
+
+func ParseGroups(text string, subdomains []string) []Group {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	type section struct {
+		group Group
+		body  strings.Builder
+	}
+	sections := []*section{{}}
+	for _, line := range strings.Split(text, "\n") {
+		if g, ok := parseGroupHeader(line); ok {
+			sections = append(sections, &section{group: g})
+			continue
+		}
+		cur := sections[len(sections)-1]
+		cur.body.WriteString(line)
+		cur.body.WriteString("\n")
+	}
+
+	seen := map[string]bool{}
+	var out []Group
+	for _, sec := range sections {
+		g := sec.group
+		for _, t := range ExpandTargets(sec.body.String(), subdomains) {
+			if seen[t.Domain] {
+				continue
+			}
+			seen[t.Domain] = true
+			g.Targets = append(g.Targets, t)
+		}
+		if len(g.Targets) > 0 {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+func GroupNames(text string) []string {
 	var out []string
 	seen := map[string]bool{}
+	for _, line := range strings.Split(text, "\n") {
+		if g, ok := parseGroupHeader(line); ok && !seen[g.Name] {
+			seen[g.Name] = true
+			out = append(out, g.Name)
+		}
+	}
+	return out
+}
+
+func parseGroupHeader(line string) (Group, bool) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return Group{}, false
+	}
+	fields := strings.Fields(line[1 : len(line)-1])
+	if len(fields) == 0 {
+		return Group{}, false
+	}
+	g := Group{Name: fields[0]}
+	for _, f := range fields[1:] {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(k) {
+		case "port":
+			if p, err := strconv.Atoi(v); err == nil && p > 0 && p <= 65535 {
+				g.Port = p
+			}
+		case "dns":
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					g.DNSServers = append(g.DNSServers, s)
+				}
+			}
+		case "allow":
+			for _, s := range strings.Split(v, ",") {
+				s = strings.TrimSpace(s)
+				if p, err := netip.ParsePrefix(s); err == nil {
+					g.Allow = append(g.Allow, p.Masked())
+				} else if ip, err := netip.ParseAddr(s); err == nil {
+					g.Allow = append(g.Allow, netip.PrefixFrom(ip, ip.BitLen()))
+				}
+			}
+		}
+	}
+	return g, true
+}
+
+func ParseDomains(text string) []string {
+	return ExpandDomains(text, nil)
+}
+
+func ExpandDomains(text string, subdomains []string) []string {
+	ts := ExpandTargets(text, subdomains)
+	out := make([]string, 0, len(ts))
+	for _, t := range ts {
+		out = append(out, t.Domain)
+	}
+	return out
+}
+
+func ExpandTargets(text string, subdomains []string) []Target {
+	var out []Target
+	seen := map[string]bool{}
+	add := func(d string, port int) {
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, Target{Domain: d, Port: port})
+		}
+	}
 
 	text = strings.ReplaceAll(text, "\r\n", "\n")
 	text = strings.ReplaceAll(text, "\r", "\n")
@@ -65,22 +234,59 @@ func ParseDomains(text string) []string {
 			line = line[:i]
 		}
 		line = strings.TrimSpace(line)
-		if line == "" {
+		if line == "" || strings.HasPrefix(line, "[") {
 			continue
 		}
 
 		line = strings.ReplaceAll(line, ",", " ")
 		line = strings.ReplaceAll(line, ";", " ")
 		for _, token := range strings.Fields(line) {
-			if d, ok := NormalizeDomain(token); ok && !seen[d] {
-				seen[d] = true
-				out = append(out, d)
+			token, port := stripURL(token)
+			if base, ok := NormalizeWildcard(token); ok {
+				for _, sub := range subdomains {
+					if d, ok := NormalizeDomain(sub + "." + base); ok {
+						add(d, port)
+					}
+				}
+				continue
+			}
+			if d, ok := NormalizeDomain(token); ok {
+				add(d, port)
 			}
 		}
 	}
 	return out
 }
 
+func stripURL(token string) (string, int) {
+	port := 0
+	if i := strings.Index(token, "://"); i >= 0 {
+		u, err := url.Parse(token)
+		if err != nil {
+			return token, 0
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http", "ws":
+			port = 80
+		case "https", "wss":
+			port = 443
+		}
+		if p, err := strconv.Atoi(u.Port()); err == nil {
+			port = p
+		}
+		return u.Hostname(), port
+	}
+	if i := strings.IndexAny(token, "/?"); i >= 0 {
+		token = token[:i]
+	}
+	if host, p, err := net.SplitHostPort(token); err == nil {
+		if n, err := strconv.Atoi(p); err == nil && n > 0 && n <= 65535 {
+			return host, n
+		}
+	}
+	return token, 0
+}
+
 func ReadDomainsFromFile(path string) ([]string, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -95,11 +301,19 @@ func ReadDomainsFromHosts(path string) ([]string, error) {
 		return nil, err
 	}
 	defer f.Close()
+	return parseHostsDomains(f)
+}
+
+func ParseHostsDomains(text string) []string {
+	ds, _ := parseHostsDomains(strings.NewReader(text))
+	return ds
+}
 
+func parseHostsDomains(r io.Reader) ([]string, error) {
 	seen := map[string]bool{}
 	var out []string
 
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if i := strings.IndexByte(line, '#'); i >= 0 {
@@ -126,6 +340,88 @@ func ReadDomainsFromHosts(path string) ([]string, error) {
 	return out, nil
 }
 
+func ParseHostsHints(text string) map[string][]netip.Addr {
+	out := map[string][]netip.Addr{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil || ip.IsUnspecified() || ip.IsLoopback() {
+			continue
+		}
+		ip = ip.Unmap()
+		for _, token := range fields[1:] {
+			d, ok := NormalizeDomain(token)
+			if !ok || slices.Contains(out[d], ip) {
+				continue
+			}
+			out[d] = append(out[d], ip)
+		}
+	}
+	return out
+}
+
+const (
+	sourceBegin = "# source: "
+	sourceEnd   = "# end source"
+)
+
+func MergeSource(text, source string, domains []string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var kept []string
+	skipping := false
+	for _, line := range strings.Split(text, "\n") {
+		trim := strings.TrimSpace(line)
+		if !skipping && trim == sourceBegin+source {
+			skipping = true
+			continue
+		}
+		if skipping {
+			if trim == sourceEnd {
+				skipping = false
+			}
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	out := strings.TrimRight(strings.Join(kept, "\n"), "\n")
+	if out != "" {
+		out += "\n\n"
+	}
+	out += sourceBegin + source + "\n"
+	for _, d := range domains {
+		out += d + "\n"
+	}
+	out += sourceEnd + "\n"
+	return out
+}
+
+func LooksLikeHosts(text string) bool {
+	var entries, hostsLike int
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entries++
+		if len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+			hostsLike++
+		}
+	}
+	return entries > 0 && hostsLike*2 > entries
+}
+
 func EnsureReadableFile(path string) (string, error) {
 	if strings.TrimSpace(path) == "" {
 		return "", errors.New("empty path")
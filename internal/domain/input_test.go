@@ -27,3 +27,143 @@ ok.example.com # tail
 	}
 }
 
+func TestExpandDomainsWildcard(t *testing.T) {
+	in := "*.Example.com\nwww.example.com\n*.bad_domain\nfoo.org"
+	got := ExpandDomains(in, []string{"www", "api", "bad sub"})
+	want := []string{"www.example.com", "api.example.com", "foo.org"}
+	if len(got) != len(want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	}
+	if ds := ParseDomains("*.example.com"); len(ds) != 0 {
+		t.Fatalf("wildcard without subdomains should expand to nothing, got %#v", ds)
+	}
+}
+
+func TestNormalizeDomainIDN(t *testing.T) {
+	d, ok := NormalizeDomain("例子.测试")
+	if !ok || d != "xn--fsqu00a.xn--0zwm56d" {
+		t.Fatalf("got %q, %v", d, ok)
+	}
+	if got := Display(d); got != "例子.测试" {
+		t.Fatalf("display got %q", got)
+	}
+	if d, ok := NormalizeDomain("Bücher.example。com"); !ok || d != "xn--bcher-kva.example.com" {
+		t.Fatalf("got %q, %v", d, ok)
+	}
+	if got := Display("example.com"); got != "example.com" {
+		t.Fatalf("display got %q", got)
+	}
+}
+
+func TestExpandTargetsURLs(t *testing.T) {
+	in := `
+https://Example.com/path?x=1
+http://api.example.com:8080/v1
+static.example.com:8443
+cdn.example.com/assets/app.js
+ftp://files.example.com
+`
+	got := ExpandTargets(in, nil)
+	want := []Target{
+		{Domain: "example.com", Port: 443},
+		{Domain: "api.example.com", Port: 8080},
+		{Domain: "static.example.com", Port: 8443},
+		{Domain: "cdn.example.com"},
+		{Domain: "files.example.com"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %#v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("target %d: got %#v, want %#v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLooksLikeHosts(t *testing.T) {
+	if !LooksLikeHosts("# hosts\n127.0.0.1 localhost\n::1 localhost ip6-localhost\n") {
+		t.Fatalf("hosts content not detected")
+	}
+	if LooksLikeHosts("example.com\nfoo.example.com\n1.1.1.1 one.one\n") {
+		t.Fatalf("domain list detected as hosts")
+	}
+	if LooksLikeHosts("# only comments\n") {
+		t.Fatalf("empty content detected as hosts")
+	}
+}
+
+func TestParseHostsHints(t *testing.T) {
+	hints := ParseHostsHints("# GitHub520\n140.82.112.4 github.com # comment\n140.82.112.3 github.com api.github.com\n0.0.0.0 ads.example.com\n127.0.0.1 localhost\n140.82.112.4 github.com\n")
+	if len(hints) != 2 {
+		t.Fatalf("unexpected hints: %v", hints)
+	}
+	if gh := hints["github.com"]; len(gh) != 2 || gh[0].String() != "140.82.112.4" || gh[1].String() != "140.82.112.3" {
+		t.Fatalf("unexpected github.com hints: %v", gh)
+	}
+	if api := hints["api.github.com"]; len(api) != 1 {
+		t.Fatalf("unexpected api.github.com hints: %v", api)
+	}
+}
+
+func TestMergeSource(t *testing.T) {
+	src := "https://example.com/list.txt"
+	text := "a.com\n"
+	text = MergeSource(text, src, []string{"b.com", "c.com"})
+	want := "a.com\n\n# source: " + src + "\nb.com\nc.com\n# end source\n"
+	if text != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", text, want)
+	}
+	text = MergeSource(text, src, []string{"d.com"})
+	want = "a.com\n\n# source: " + src + "\nd.com\n# end source\n"
+	if text != want {
+		t.Fatalf("refresh got:\n%s\nwant:\n%s", text, want)
+	}
+	if ds := ParseDomains(text); len(ds) != 2 {
+		t.Fatalf("merged text parses to %#v", ds)
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	in := `
+plain.example.com
+
+[github dns=1.1.1.1,8.8.8.8]
+github.com
+api.github.com:8443
+
+[games port=27015] # steam
+game.example.net
+github.com
+
+[pinned allow=104.16.0.0/13,2606:4700::/32,203.0.113.7]
+pinned.example.org
+`
+	gs := ParseGroups(in, nil)
+	if len(gs) != 4 {
+		t.Fatalf("got %d groups: %#v", len(gs), gs)
+	}
+	if gs[0].Name != "" || len(gs[0].Targets) != 1 || gs[0].Targets[0].Domain != "plain.example.com" {
+		t.Fatalf("unexpected default group: %#v", gs[0])
+	}
+	gh := gs[1]
+	if gh.Name != "github" || len(gh.DNSServers) != 2 || gh.DNSServers[1] != "8.8.8.8" || len(gh.Targets) != 2 || gh.Targets[1].Port != 8443 {
+		t.Fatalf("unexpected github group: %#v", gh)
+	}
+	games := gs[2]
+	if games.Name != "games" || games.Port != 27015 || len(games.Targets) != 1 {
+		t.Fatalf("unexpected games group (duplicate domain must stay in first group): %#v", games)
+	}
+	pinned := gs[3]
+	if len(pinned.Allow) != 3 || pinned.Allow[0].String() != "104.16.0.0/13" || pinned.Allow[2].String() != "203.0.113.7/32" {
+		t.Fatalf("unexpected allow list: %v", pinned.Allow)
+	}
+	if ds := ParseDomains(in); len(ds) != 5 {
+		t.Fatalf("group headers leaked into domains: %#v", ds)
+	}
+}
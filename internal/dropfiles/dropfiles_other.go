@@ -0,0 +1,11 @@
+//go:build !windows
+
+package dropfiles
+
+import "errors"
+
+var ErrUnsupported = errors.New("file drop not supported on this platform")
+
+func Enable(hwnd uintptr, onDrop func(paths []string)) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,101 @@
+//go:build windows
+
+package dropfiles
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var ErrUnsupported = errors.New("file drop not supported on this platform")
+
+var (
+	mu       sync.Mutex
+	prevProc = map[uintptr]uintptr{}
+	handlers = map[uintptr]func([]string){}
+	wndProc  = syscall.NewCallback(subclassProc)
+)
+
+func Enable(hwnd uintptr, onDrop func(paths []string)) error {
+	if hwnd == 0 {
+		return errors.New("invalid window handle")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[hwnd] = onDrop
+	if _, ok := prevProc[hwnd]; ok {
+		return nil
+	}
+
+	// An elevated process (needed to write hosts) does not receive drops from
+	// a non-elevated Explorer unless the messages are explicitly allowed.
+	for _, msg := range []uintptr{wmDropFiles, wmCopyData, wmCopyGlobalData} {
+		procChangeWindowMessageFilterEx.Call(hwnd, msg, msgfltAllow, 0)
+	}
+
+	prev, _, callErr := procSetWindowLongPtrW.Call(hwnd, gwlpWndProc, wndProc)
+	if prev == 0 {
+		if callErr != syscall.Errno(0) {
+			return callErr
+		}
+		return errors.New("subclass window failed")
+	}
+	prevProc[hwnd] = prev
+	procDragAcceptFiles.Call(hwnd, 1)
+	return nil
+}
+
+func subclassProc(hwnd, msg, wParam, lParam uintptr) uintptr {
+	mu.Lock()
+	prev := prevProc[hwnd]
+	onDrop := handlers[hwnd]
+	mu.Unlock()
+
+	if msg == wmDropFiles {
+		paths := queryFiles(wParam)
+		procDragFinish.Call(wParam)
+		if onDrop != nil && len(paths) > 0 {
+			onDrop(paths)
+		}
+		return 0
+	}
+	ret, _, _ := procCallWindowProcW.Call(prev, hwnd, msg, wParam, lParam)
+	return ret
+}
+
+func queryFiles(hdrop uintptr) []string {
+	n, _, _ := procDragQueryFileW.Call(hdrop, 0xFFFFFFFF, 0, 0)
+	paths := make([]string, 0, n)
+	for i := uintptr(0); i < n; i++ {
+		size, _, _ := procDragQueryFileW.Call(hdrop, i, 0, 0)
+		if size == 0 {
+			continue
+		}
+		buf := make([]uint16, size+1)
+		procDragQueryFileW.Call(hdrop, i, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+		paths = append(paths, syscall.UTF16ToString(buf))
+	}
+	return paths
+}
+
+const (
+	wmCopyData       = 0x004A
+	wmCopyGlobalData = 0x0049
+	wmDropFiles      = 0x0233
+	msgfltAllow      = 1
+	gwlpWndProc      = ^uintptr(3) // -4
+)
+
+var (
+	modShell32 = syscall.NewLazyDLL("shell32.dll")
+	modUser32  = syscall.NewLazyDLL("user32.dll")
+
+	procDragAcceptFiles             = modShell32.NewProc("DragAcceptFiles")
+	procDragQueryFileW              = modShell32.NewProc("DragQueryFileW")
+	procDragFinish                  = modShell32.NewProc("DragFinish")
+	procSetWindowLongPtrW           = modUser32.NewProc("SetWindowLongPtrW")
+	procCallWindowProcW             = modUser32.NewProc("CallWindowProcW")
+	procChangeWindowMessageFilterEx = modUser32.NewProc("ChangeWindowMessageFilterEx")
+)
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	tuneStart     = 2
+	tuneWindow    = 32
+	tuneFailSlack = 0.10
+	tuneRTTSlack  = 1.3
+	tuneStdSlack  = 1.5
+)
+
+// concurrencyTuner steers the probe slot limit: it starts low, grows while
+// probe results stay as good as the best window seen so far, and halves when
+// timeouts or RTT median/spread rise above that baseline.
+type concurrencyTuner struct {
+	max int
+	set func(int)
+	log func(string)
+
+	mu    sync.Mutex
+	limit int
+
+	ok, fail int
+	samples  []time.Duration
+
+	baseRate float64
+	baseMed  time.Duration
+	baseStd  time.Duration
+}
+
+func newConcurrencyTuner(max int, set func(int), log func(string)) *concurrencyTuner {
+	return &concurrencyTuner{max: max, set: set, log: log, limit: min(tuneStart, max), baseRate: -1}
+}
+
+func (t *concurrencyTuner) observe(d time.Duration, ok bool) {
+	t.mu.Lock()
+	if ok {
+		t.ok++
+		t.samples = append(t.samples, d)
+	} else {
+		t.fail++
+	}
+	if t.ok+t.fail < tuneWindow {
+		t.mu.Unlock()
+		return
+	}
+	from, to, why := t.evaluate()
+	t.mu.Unlock()
+	if from != to {
+		if t.set != nil {
+			t.set(to)
+		}
+		if t.log != nil {
+			t.log(fmt.Sprintf("auto concurrency: %d -> %d (%s)", from, to, why))
+		}
+	}
+}
+
+func (t *concurrencyTuner) evaluate() (from, to int, why string) {
+	rate := float64(t.fail) / float64(t.ok+t.fail)
+	var med, sd time.Duration
+	if len(t.samples) > 0 {
+		med, sd = Quantile(t.samples, 0.5), stddev(t.samples)
+	}
+	t.ok, t.fail, t.samples = 0, 0, t.samples[:0]
+
+	if t.baseRate < 0 || rate < t.baseRate {
+		t.baseRate = rate
+	}
+	if med > 0 && (t.baseMed == 0 || med < t.baseMed) {
+		t.baseMed = med
+	}
+	if sd > 0 && (t.baseStd == 0 || sd < t.baseStd) {
+		t.baseStd = sd
+	}
+	why = fmt.Sprintf("failures %.0f%%, median %s, stddev %s", rate*100, med.Round(time.Millisecond), sd.Round(time.Millisecond))
+
+	from, to = t.limit, t.limit
+	switch {
+	case rate > t.baseRate+tuneFailSlack,
+		med > 0 && float64(med) > float64(t.baseMed)*tuneRTTSlack,
+		sd > 0 && float64(sd) > float64(t.baseStd)*tuneStdSlack:
+		to = max(1, t.limit/2)
+	case t.limit < t.max:
+		to = min(t.max, t.limit+max(1, t.limit/2))
+	}
+	t.limit = to
+	return from, to, why
+}
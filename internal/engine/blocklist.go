@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const DefaultBlocklist = "0.0.0.0/8\n127.0.0.0/8\n::/128\n::1/128"
+
+func ParseBlocklist(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		for _, f := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			if strings.Contains(f, "/") {
+				p, err := netip.ParsePrefix(f)
+				if err != nil {
+					return nil, fmt.Errorf("invalid blocklist entry %q", f)
+				}
+				out = append(out, p.Masked())
+				continue
+			}
+			ip, err := netip.ParseAddr(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid blocklist entry %q", f)
+			}
+			out = append(out, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+		}
+	}
+	return out, nil
+}
+
+func inPrefixes(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package engine
+
+import "net/netip"
+
+var bogonRanges = []struct {
+	prefix netip.Prefix
+	reason string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), "reserved"},
+	{netip.MustParsePrefix("100.64.0.0/10"), "shared address space"},
+	{netip.MustParsePrefix("192.0.0.0/24"), "reserved"},
+	{netip.MustParsePrefix("192.0.2.0/24"), "documentation"},
+	{netip.MustParsePrefix("198.18.0.0/15"), "benchmarking"},
+	{netip.MustParsePrefix("198.51.100.0/24"), "documentation"},
+	{netip.MustParsePrefix("203.0.113.0/24"), "documentation"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "reserved"},
+	{netip.MustParsePrefix("100::/64"), "discard"},
+	{netip.MustParsePrefix("2001:db8::/32"), "documentation"},
+}
+
+func bogonReason(ip netip.Addr) (string, bool) {
+	ip = ip.Unmap()
+	switch {
+	case ip.IsUnspecified():
+		return "unspecified", true
+	case ip.IsLoopback():
+		return "loopback", true
+	case ip.IsPrivate():
+		return "private", true
+	case ip.IsLinkLocalUnicast():
+		return "link-local", true
+	case ip.IsMulticast():
+		return "multicast", true
+	}
+	for _, r := range bogonRanges {
+		if r.prefix.Contains(ip) {
+			return r.reason, true
+		}
+	}
+	return "", false
+}
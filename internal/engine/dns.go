@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dnsTimeout  = 3 * time.Second
+	systemTTL   = 60 * time.Second
+	negativeTTL = 30 * time.Second
+	dnsAttempts = 3
+	dnsBackoff  = 200 * time.Millisecond
+)
+
+type rcodeError struct {
+	RCode  dnsmessage.RCode
+	Server string
+}
+
+func (e *rcodeError) Error() string {
+	return fmt.Sprintf("dns %s from %s", strings.TrimPrefix(e.RCode.String(), "RCode"), e.Server)
+}
+
+func retryableDNS(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var rc *rcodeError
+	if errors.As(err, &rc) {
+		return rc.RCode == dnsmessage.RCodeServerFailure
+	}
+	var de *net.DNSError
+	if errors.As(err, &de) {
+		return de.IsTemporary || de.IsTimeout
+	}
+	return true
+}
+
+func withDNSRetry(ctx context.Context, fn func() (dnsAnswer, error)) (dnsAnswer, int, error) {
+	backoff := dnsBackoff
+	for attempt := 1; ; attempt++ {
+		ans, err := fn()
+		if err == nil || attempt >= dnsAttempts || !retryableDNS(err) || ctx.Err() != nil {
+			return ans, attempt, err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ans, attempt, err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// dnsAnswer.TTL is the smallest TTL in the answer; TTLs holds each address's
+// own record TTL, parallel to Addrs, and is nil when the source has none.
+type dnsAnswer struct {
+	Addrs  []netip.Addr
+	TTLs   []time.Duration
+	CNAMEs []string
+	TTL    time.Duration
+}
+
+func (a dnsAnswer) ttlOf(i int) time.Duration {
+	if i < len(a.TTLs) {
+		return a.TTLs[i]
+	}
+	return 0
+}
+
+func queryServer(ctx context.Context, server, domain string, qtype dnsmessage.Type) (dnsAnswer, error) {
+	addr := normalizeDNSServer(server)
+	name, err := dnsmessage.NewName(strings.TrimSuffix(domain, ".") + ".")
+	if err != nil {
+		return dnsAnswer{}, err
+	}
+	id := uint16(rand.Uint32())
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := q.Pack()
+	if err != nil {
+		return dnsAnswer{}, err
+	}
+
+	var raw []byte
+	if isDoH(server) {
+		raw, err = exchangeDoH(ctx, server, packed)
+	} else {
+		raw, err = exchange(ctx, addr, packed, false)
+	}
+	if err != nil {
+		return dnsAnswer{}, err
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(raw); err != nil {
+		return dnsAnswer{}, err
+	}
+	if m.Truncated && !isDoH(server) {
+		if raw, err = exchange(ctx, addr, packed, true); err != nil {
+			return dnsAnswer{}, err
+		}
+		if err := m.Unpack(raw); err != nil {
+			return dnsAnswer{}, err
+		}
+	}
+	if m.ID != id {
+		return dnsAnswer{}, errors.New("dns response id mismatch")
+	}
+	if m.RCode != dnsmessage.RCodeSuccess {
+		return dnsAnswer{}, &rcodeError{RCode: m.RCode, Server: server}
+	}
+	return parseAnswer(m), nil
+}
+
+func parseAnswer(m dnsmessage.Message) dnsAnswer {
+	var a dnsAnswer
+	var minTTL uint32
+	seen := false
+	for _, rr := range m.Answers {
+		var ips []netip.Addr
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = []netip.Addr{netip.AddrFrom4(b.A)}
+		case *dnsmessage.AAAAResource:
+			ips = []netip.Addr{netip.AddrFrom16(b.AAAA).Unmap()}
+		case *dnsmessage.HTTPSResource:
+			ips = svcbHints(&b.SVCBResource)
+		case *dnsmessage.SVCBResource:
+			ips = svcbHints(b)
+		default:
+			continue
+		}
+		for _, ip := range ips {
+			a.Addrs = append(a.Addrs, ip)
+			a.TTLs = append(a.TTLs, time.Duration(rr.Header.TTL)*time.Second)
+		}
+		if !seen || rr.Header.TTL < minTTL {
+			minTTL, seen = rr.Header.TTL, true
+		}
+	}
+	if len(m.Questions) == 1 {
+		a.CNAMEs = cnameChain(m.Questions[0].Name.String(), m.Answers)
+	}
+	if seen {
+		a.TTL = time.Duration(minTTL) * time.Second
+		return a
+	}
+	a.TTL = negativeTTL
+	for _, rr := range m.Authorities {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			a.TTL = time.Duration(min(soa.MinTTL, rr.Header.TTL)) * time.Second
+		}
+	}
+	return a
+}
+
+// svcbHints returns the ipv4hint and ipv6hint addresses of an HTTPS or SVCB
+// record; alias-mode records carry none.
+func svcbHints(r *dnsmessage.SVCBResource) []netip.Addr {
+	var out []netip.Addr
+	if v, ok := r.GetParam(dnsmessage.SVCParamIPv4Hint); ok {
+		for ; len(v) >= 4; v = v[4:] {
+			out = append(out, netip.AddrFrom4([4]byte(v[:4])))
+		}
+	}
+	if v, ok := r.GetParam(dnsmessage.SVCParamIPv6Hint); ok {
+		for ; len(v) >= 16; v = v[16:] {
+			out = append(out, netip.AddrFrom16([16]byte(v[:16])).Unmap())
+		}
+	}
+	return out
+}
+
+func cnameChain(name string, answers []dnsmessage.Resource) []string {
+	var chain []string
+	for range answers {
+		next := ""
+		for _, rr := range answers {
+			if c, ok := rr.Body.(*dnsmessage.CNAMEResource); ok && strings.EqualFold(rr.Header.Name.String(), name) {
+				next = c.CNAME.String()
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		chain = append(chain, strings.TrimSuffix(next, "."))
+		name = next
+	}
+	return chain
+}
+
+// DefaultTrustedDoH are addressed by IP so the baseline itself does not
+// depend on a possibly polluted plain-DNS lookup.
+var DefaultTrustedDoH = []string{
+	"https://1.1.1.1/dns-query",
+	"https://223.5.5.5/dns-query",
+}
+
+var dohClient = http.DefaultClient
+
+// isDoH reports whether server is a DNS-over-HTTPS URL rather than a plain
+// UDP/TCP resolver address.
+func isDoH(server string) bool {
+	return strings.HasPrefix(server, "https://")
+}
+
+// exchangeDoH sends query to a DNS-over-HTTPS endpoint as an RFC 8484 POST.
+func exchangeDoH(ctx context.Context, url string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := dohClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
+
+func exchange(ctx context.Context, addr string, query []byte, tcp bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	network := "udp"
+	if tcp {
+		network = "tcp"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	if !tcp {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,392 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func fakeDNSServer(t *testing.T, answer func(q dnsmessage.Question) []dnsmessage.Resource) (string, *int32) {
+	t.Helper()
+	return fakeDNSServerFunc(t, func(m *dnsmessage.Message) {
+		m.Answers = answer(m.Questions[0])
+	})
+}
+
+func fakeDNSServerFunc(t *testing.T, handle func(m *dnsmessage.Message)) (string, *int32) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	var queries int32
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&queries, 1)
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil || len(m.Questions) != 1 {
+				continue
+			}
+			m.Response = true
+			handle(&m)
+			out, err := m.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(out, addr)
+		}
+	}()
+	return pc.LocalAddr().String(), &queries
+}
+
+func TestQueryServer(t *testing.T) {
+	addr, _ := fakeDNSServer(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		if q.Type != dnsmessage.TypeA {
+			return nil
+		}
+		h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+		h1, h2 := h, h
+		h1.TTL, h2.TTL = 300, 120
+		return []dnsmessage.Resource{
+			{Header: h1, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}},
+			{Header: h2, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}}},
+		}
+	})
+
+	ans, err := queryServer(context.Background(), addr, "example.test", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ans.Addrs) != 2 || ans.Addrs[0] != netip.MustParseAddr("10.0.0.1") || ans.TTL != 120*time.Second {
+		t.Fatalf("unexpected answer: %+v", ans)
+	}
+	if !slices.Equal(ans.TTLs, []time.Duration{300 * time.Second, 120 * time.Second}) {
+		t.Fatalf("per-address ttls = %v", ans.TTLs)
+	}
+}
+
+func TestResolveCandidatesCache(t *testing.T) {
+	addr, queries := fakeDNSServer(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300}
+		return []dnsmessage.Resource{{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 3}}}}
+	})
+
+	cache := NewDNSCache()
+	for i := 0; i < 2; i++ {
+		cands, _, err := resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{addr}, IPv4: true, Cache: cache, KeepBogons: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, c := range cands {
+			found = found || (c.IP == netip.MustParseAddr("10.0.0.3") && c.ResolvedVia == addr)
+		}
+		if !found {
+			t.Fatalf("round %d: missing server answer in %+v", i, cands)
+		}
+	}
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Fatalf("expected 1 upstream query with cache, got %d", n)
+	}
+
+	if _, _, err := resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{addr}, IPv4: true, Cache: cache, Refresh: true, KeepBogons: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(queries); n != 2 {
+		t.Fatalf("expected refresh to bypass cache, got %d queries", n)
+	}
+}
+
+func TestDNSCacheTTLAndPersist(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewDNSCache()
+	c.now = func() time.Time { return now }
+	c.put("1.1.1.1", "a.test", uint16(dnsmessage.TypeA), dnsAnswer{Addrs: []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.3")}, TTLs: []time.Duration{time.Minute, 20 * time.Second}, TTL: time.Minute})
+	c.put("1.1.1.1", "b.test", uint16(dnsmessage.TypeA), dnsAnswer{Addrs: []netip.Addr{netip.MustParseAddr("10.0.0.2")}, TTL: 0})
+	if _, ok := c.get("1.1.1.1", "b.test", uint16(dnsmessage.TypeA)); ok {
+		t.Fatal("zero ttl answer must not be cached")
+	}
+
+	if ans, ok := c.get("1.1.1.1", "a.test", uint16(dnsmessage.TypeA)); !ok || ans.TTL != time.Minute {
+		t.Fatalf("fresh entry = %+v %v", ans, ok)
+	}
+	now = now.Add(45 * time.Second)
+	ans, ok := c.get("1.1.1.1", "a.test", uint16(dnsmessage.TypeA))
+	if !ok || ans.TTL != 15*time.Second || len(ans.TTLs) != 2 || ans.TTLs[0] != 15*time.Second || ans.TTLs[1] != 0 {
+		t.Fatalf("aged entry = %+v %v, want ttl 15s and ttls [15s 0]", ans, ok)
+	}
+	now = now.Add(75 * time.Second)
+	if _, ok := c.get("1.1.1.1", "a.test", uint16(dnsmessage.TypeA)); ok {
+		t.Fatal("expired entry returned")
+	}
+
+	live := NewDNSCache()
+	live.put("system", "a.test", uint16(dnsmessage.TypeAAAA), dnsAnswer{Addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")}, TTL: time.Minute})
+	path := filepath.Join(t.TempDir(), "dns-cache.json")
+	if err := live.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadDNSCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ans, ok := loaded.get("system", "a.test", uint16(dnsmessage.TypeAAAA)); !ok || len(ans.Addrs) != 1 || ans.Addrs[0] != netip.MustParseAddr("2001:db8::1") {
+		t.Fatalf("loaded entry = %v %v", ans.Addrs, ok)
+	}
+}
+
+func TestResolveCandidatesRetry(t *testing.T) {
+	var calls int32
+	flaky, _ := fakeDNSServerFunc(t, func(m *dnsmessage.Message) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			m.RCode = dnsmessage.RCodeServerFailure
+			return
+		}
+		q := m.Questions[0]
+		h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}
+		m.Answers = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 4}}}}
+	})
+	broken, brokenQueries := fakeDNSServerFunc(t, func(m *dnsmessage.Message) {
+		m.RCode = dnsmessage.RCodeServerFailure
+	})
+
+	cands, info, err := resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{flaky, broken}, IPv4: true, KeepBogons: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, c := range cands {
+		found = found || (c.IP == netip.MustParseAddr("10.0.0.4") && c.ResolvedVia == flaky)
+	}
+	if !found {
+		t.Fatalf("expected flaky server answer after retry, got %+v", cands)
+	}
+	if f := info.Errors; len(f) != 1 || f[0].Server != broken || f[0].Type != "A" || f[0].Attempts != dnsAttempts {
+		t.Fatalf("unexpected failures: %+v", f)
+	}
+	if n := atomic.LoadInt32(brokenQueries); n != dnsAttempts {
+		t.Fatalf("expected %d queries to broken server, got %d", dnsAttempts, n)
+	}
+}
+
+func TestResolveCandidatesHTTPSHints(t *testing.T) {
+	addr, _ := fakeDNSServer(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		switch q.Type {
+		case dnsmessage.TypeA:
+			h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300}
+			return []dnsmessage.Resource{{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 6}}}}
+		case dnsmessage.TypeHTTPS:
+			h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeHTTPS, Class: dnsmessage.ClassINET, TTL: 60}
+			rr := &dnsmessage.HTTPSResource{SVCBResource: dnsmessage.SVCBResource{Priority: 1, Target: dnsmessage.MustNewName(".")}}
+			rr.SetParam(dnsmessage.SVCParamIPv4Hint, []byte{10, 0, 0, 6, 10, 0, 0, 7})
+			rr.SetParam(dnsmessage.SVCParamIPv6Hint, netip.MustParseAddr("2001:db8::7").AsSlice())
+			return []dnsmessage.Resource{{Header: h, Body: rr}}
+		}
+		return nil
+	})
+
+	for _, https := range []bool{false, true} {
+		cands, _, err := resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{addr}, IPv4: true, KeepBogons: true, HTTPS: https})
+		if err != nil {
+			t.Fatal(err)
+		}
+		via, ttl := map[netip.Addr]string{}, map[netip.Addr]time.Duration{}
+		for _, c := range cands {
+			via[c.IP], ttl[c.IP] = c.ResolvedVia, c.TTL
+		}
+		if want := map[bool]time.Duration{false: 300 * time.Second, true: time.Minute}[https]; ttl[netip.MustParseAddr("10.0.0.6")] != want {
+			t.Fatalf("https=%v: ttl = %s, want the smallest record ttl %s", https, ttl[netip.MustParseAddr("10.0.0.6")], want)
+		}
+		if via[netip.MustParseAddr("10.0.0.6")] != addr {
+			t.Fatalf("https=%v: A answer via %q", https, via[netip.MustParseAddr("10.0.0.6")])
+		}
+		hint, ok := via[netip.MustParseAddr("10.0.0.7")]
+		if ok != https || (https && hint != "https:"+addr) {
+			t.Fatalf("https=%v: hint via %q (present %v)", https, hint, ok)
+		}
+		if _, ok := via[netip.MustParseAddr("2001:db8::7")]; ok {
+			t.Fatalf("https=%v: ipv6 hint kept with ipv6 disabled", https)
+		}
+	}
+}
+
+func TestResolveCandidatesHijack(t *testing.T) {
+	answer := func(ips ...byte) func(q dnsmessage.Question) []dnsmessage.Resource {
+		return func(q dnsmessage.Question) []dnsmessage.Resource {
+			if q.Type != dnsmessage.TypeA {
+				return nil
+			}
+			var out []dnsmessage.Resource
+			for _, b := range ips {
+				h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}
+				out = append(out, dnsmessage.Resource{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, b}}})
+			}
+			return out
+		}
+	}
+	plain, _ := fakeDNSServer(t, answer(8, 9))
+	upstream, _ := fakeDNSServer(t, answer(9))
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, _ := io.ReadAll(r.Body)
+		resp, err := exchange(r.Context(), upstream, q, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(resp)
+	}))
+	defer doh.Close()
+	prev := dohClient
+	dohClient = doh.Client()
+	defer func() { dohClient = prev }()
+
+	cands, _, err := resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{plain, doh.URL + "/dns-query"}, IPv4: true, KeepBogons: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hijacked := map[netip.Addr]bool{}
+	for _, c := range cands {
+		hijacked[c.IP] = c.Hijacked
+	}
+	if h, ok := hijacked[netip.MustParseAddr("10.0.0.8")]; !ok || !h {
+		t.Fatalf("plain-only answer not flagged: %+v", cands)
+	}
+	if h, ok := hijacked[netip.MustParseAddr("10.0.0.9")]; !ok || h {
+		t.Fatalf("answer confirmed over doh flagged: %+v", cands)
+	}
+}
+
+func TestResolveCandidatesCNAMEChain(t *testing.T) {
+	addr, _ := fakeDNSServer(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		mid := dnsmessage.MustNewName("cdn.example.test.")
+		edge := dnsmessage.MustNewName("example.map.fastly.test.")
+		return []dnsmessage.Resource{
+			{Header: dnsmessage.ResourceHeader{Name: mid, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 300}, Body: &dnsmessage.CNAMEResource{CNAME: edge}},
+			{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 300}, Body: &dnsmessage.CNAMEResource{CNAME: mid}},
+			{Header: dnsmessage.ResourceHeader{Name: edge, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 5}}},
+		}
+	})
+
+	cache := NewDNSCache()
+	for i := 0; i < 2; i++ {
+		_, info, err := resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{addr}, IPv4: true, Cache: cache, KeepBogons: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var chain []string
+		for _, c := range info.CNAMEs {
+			if c.Server == addr {
+				chain = c.Chain
+			}
+		}
+		if len(chain) != 2 || chain[0] != "cdn.example.test" || chain[1] != "example.map.fastly.test" {
+			t.Fatalf("round %d: unexpected chain %v", i, chain)
+		}
+		if info.MinTTL != 60*time.Second {
+			t.Fatalf("round %d: min ttl = %v, want 60s", i, info.MinTTL)
+		}
+	}
+}
+
+func TestResolveCandidatesDropsBogons(t *testing.T) {
+	addr, _ := fakeDNSServer(t, func(q dnsmessage.Question) []dnsmessage.Resource {
+		h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}
+		return []dnsmessage.Resource{
+			{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 9}}},
+			{Header: h, Body: &dnsmessage.AResource{A: [4]byte{240, 0, 0, 1}}},
+			{Header: h, Body: &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}}},
+		}
+	})
+
+	cands, info, err := resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{addr}, IPv4: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cands) != 1 || cands[0].IP != netip.MustParseAddr("93.184.216.34") {
+		t.Fatalf("unexpected candidates: %+v", cands)
+	}
+	reasons := map[netip.Addr]string{}
+	for _, b := range info.Bogons {
+		reasons[b.IP] = b.Reason
+	}
+	if reasons[netip.MustParseAddr("10.0.0.9")] != "private" || reasons[netip.MustParseAddr("240.0.0.1")] != "reserved" {
+		t.Fatalf("unexpected drops: %+v", info.Bogons)
+	}
+
+	cands, _, err = resolveCandidates(context.Background(), "localhost", resolveOptions{Servers: []string{addr}, IPv4: true, KeepBogons: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cands) < 3 {
+		t.Fatalf("expected bogons kept, got %+v", cands)
+	}
+}
+
+func TestBenchmarkResolvers(t *testing.T) {
+	answerWith := func(ip [4]byte) func(q dnsmessage.Question) []dnsmessage.Resource {
+		return func(q dnsmessage.Question) []dnsmessage.Resource {
+			h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}
+			return []dnsmessage.Resource{{Header: h, Body: &dnsmessage.AResource{A: ip}}}
+		}
+	}
+	a, _ := fakeDNSServer(t, answerWith([4]byte{10, 0, 0, 1}))
+	b, _ := fakeDNSServer(t, answerWith([4]byte{10, 0, 0, 1}))
+	odd, _ := fakeDNSServer(t, answerWith([4]byte{10, 9, 9, 9}))
+	refused, _ := fakeDNSServerFunc(t, func(m *dnsmessage.Message) { m.RCode = dnsmessage.RCodeRefused })
+
+	var last, total int
+	bs := BenchmarkResolvers(context.Background(), []string{refused, odd, a, b}, []string{"one.test", "two.test"}, 2, func(d, n int) { last, total = max(last, d), n })
+	if total != 16 || last != 16 {
+		t.Fatalf("progress = %d/%d", last, total)
+	}
+	if len(bs) != 4 || bs[3].Server != refused {
+		t.Fatalf("order = %+v", bs)
+	}
+	if r := bs[3]; r.Failures != 4 || r.FailureRate() != 1 || r.LastError == "" {
+		t.Fatalf("refusing server = %+v", r)
+	}
+	for _, x := range bs[:3] {
+		if x.Queries != 4 || x.Failures != 0 || x.Median <= 0 || x.Compared != 2 {
+			t.Fatalf("server %s = %+v", x.Server, x)
+		}
+		if want := x.Server == odd; (x.DivergenceRate() == 1) != want {
+			t.Fatalf("server %s divergence = %.2f", x.Server, x.DivergenceRate())
+		}
+	}
+}
+
+func TestSortResolverBench(t *testing.T) {
+	ms := time.Millisecond
+	bs := []ResolverBench{
+		{Server: "dead", Queries: 10, Failures: 10},
+		{Server: "flaky-fast", Queries: 10, Failures: 3, Samples: []time.Duration{ms}, Median: ms},
+		{Server: "slow", Queries: 10, Samples: []time.Duration{80 * ms}, Median: 80 * ms},
+		{Server: "fast", Queries: 100, Failures: 1, Samples: []time.Duration{20 * ms}, Median: 20 * ms},
+	}
+	sortResolverBench(bs)
+	var got []string
+	for _, b := range bs {
+		got = append(got, b.Server)
+	}
+	if want := []string{"fast", "slow", "flaky-fast", "dead"}; !slices.Equal(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultBenchDomains mixes CDN-backed and plain sites, so resolvers that
+// steer users to far-away or wrong edges show up as divergent.
+var DefaultBenchDomains = []string{
+	"www.baidu.com",
+	"www.qq.com",
+	"www.taobao.com",
+	"www.bilibili.com",
+	"github.com",
+	"www.microsoft.com",
+	"www.apple.com",
+	"www.cloudflare.com",
+	"www.wikipedia.org",
+	"www.google.com",
+}
+
+// ResolverBench is one DNS server's benchmark result. A domain counts as
+// Diverged when the server answered it with addresses none of the other
+// servers returned; Compared is the number of domains where that could be
+// checked.
+type ResolverBench struct {
+	Server    string
+	Queries   int
+	Failures  int
+	Samples   []time.Duration
+	Median    time.Duration
+	P90       time.Duration
+	Compared  int
+	Diverged  int
+	LastError string
+}
+
+func (b ResolverBench) FailureRate() float64 {
+	if b.Queries == 0 {
+		return 0
+	}
+	return float64(b.Failures) / float64(b.Queries)
+}
+
+func (b ResolverBench) DivergenceRate() float64 {
+	if b.Compared == 0 {
+		return 0
+	}
+	return float64(b.Diverged) / float64(b.Compared)
+}
+
+// BenchmarkResolvers sends an A query for every domain, rounds times, to each
+// server without retries, and returns the servers in recommended order.
+// Servers are measured in parallel, but each one's queries are sequential so
+// they do not queue behind each other. onProgress may be nil.
+func BenchmarkResolvers(ctx context.Context, servers, domains []string, rounds int, onProgress func(done, total int)) []ResolverBench {
+	rounds = max(1, rounds)
+	total := len(servers) * len(domains) * rounds
+	var (
+		mu      sync.Mutex
+		done    int
+		wg      sync.WaitGroup
+		out     = make([]ResolverBench, len(servers))
+		answers = make([]map[string][]netip.Addr, len(servers))
+	)
+	for i, srv := range servers {
+		out[i].Server = srv
+		answers[i] = map[string][]netip.Addr{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := &out[i]
+			for r := 0; r < rounds; r++ {
+				for _, d := range domains {
+					if ctx.Err() != nil {
+						return
+					}
+					start := time.Now()
+					ans, err := queryServer(ctx, srv, d, dnsmessage.TypeA)
+					b.Queries++
+					if err != nil {
+						b.Failures++
+						b.LastError = err.Error()
+					} else {
+						b.Samples = append(b.Samples, time.Since(start))
+						answers[i][d] = append(answers[i][d], ans.Addrs...)
+					}
+					mu.Lock()
+					done++
+					n := done
+					mu.Unlock()
+					if onProgress != nil {
+						onProgress(n, total)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range out {
+		b := &out[i]
+		if len(b.Samples) > 0 {
+			b.Median = Quantile(b.Samples, 0.5)
+			b.P90 = Quantile(b.Samples, 0.9)
+		}
+		for d, mine := range answers[i] {
+			if len(mine) == 0 {
+				continue
+			}
+			var others []netip.Addr
+			for j := range answers {
+				if j != i {
+					others = append(others, answers[j][d]...)
+				}
+			}
+			if len(others) == 0 {
+				continue
+			}
+			b.Compared++
+			if !slices.ContainsFunc(mine, func(a netip.Addr) bool { return slices.Contains(others, a) }) {
+				b.Diverged++
+			}
+		}
+	}
+	sortResolverBench(out)
+	return out
+}
+
+// sortResolverBench orders servers by reliability first, in 5% steps so a
+// single lost packet does not outrank a much faster server, then by median
+// latency. Servers that never answered go last.
+func sortResolverBench(bs []ResolverBench) {
+	bucket := func(b ResolverBench) int { return int(b.FailureRate() * 20) }
+	sort.SliceStable(bs, func(i, j int) bool {
+		a, b := bs[i], bs[j]
+		if (len(a.Samples) == 0) != (len(b.Samples) == 0) {
+			return len(a.Samples) > 0
+		}
+		if bucket(a) != bucket(b) {
+			return bucket(a) < bucket(b)
+		}
+		if a.Median != b.Median {
+			return a.Median < b.Median
+		}
+		return strings.Compare(a.Server, b.Server) < 0
+	})
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type dnsCacheKey struct {
+	Server string `json:"server"`
+	Domain string `json:"domain"`
+	Type   uint16 `json:"type"`
+}
+
+type dnsCacheEntry struct {
+	dnsCacheKey
+	Addrs   []netip.Addr    `json:"addrs"`
+	TTLs    []time.Duration `json:"ttls,omitempty"`
+	CNAMEs  []string        `json:"cnames,omitempty"`
+	TTL     time.Duration   `json:"ttl,omitempty"`
+	Expires time.Time       `json:"expires"`
+}
+
+type DNSCache struct {
+	mu      sync.Mutex
+	entries map[dnsCacheKey]dnsCacheEntry
+	now     func() time.Time
+}
+
+func NewDNSCache() *DNSCache {
+	return &DNSCache{entries: map[dnsCacheKey]dnsCacheEntry{}, now: time.Now}
+}
+
+func LoadDNSCache(path string) (*DNSCache, error) {
+	c := NewDNSCache()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return c, err
+	}
+	var list []dnsCacheEntry
+	if err := json.Unmarshal(b, &list); err != nil {
+		return c, err
+	}
+	now := c.now()
+	for _, e := range list {
+		if e.Expires.After(now) {
+			c.entries[e.dnsCacheKey] = e
+		}
+	}
+	return c, nil
+}
+
+func (c *DNSCache) Save(path string) error {
+	c.mu.Lock()
+	now := c.now()
+	list := make([]dnsCacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if e.Expires.After(now) {
+			list = append(list, e)
+		}
+	}
+	c.mu.Unlock()
+
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+func (c *DNSCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	n := 0
+	for _, e := range c.entries {
+		if e.Expires.After(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *DNSCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[dnsCacheKey]dnsCacheEntry{}
+}
+
+// get returns a cached answer with its TTLs counted down by the whole seconds
+// the entry has spent in the cache, the way a resolver's own cache reports
+// them.
+func (c *DNSCache) get(server, domain string, qtype uint16) (dnsAnswer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := dnsCacheKey{Server: server, Domain: domain, Type: qtype}
+	e, ok := c.entries[k]
+	if !ok {
+		return dnsAnswer{}, false
+	}
+	now := c.now()
+	if !e.Expires.After(now) {
+		delete(c.entries, k)
+		return dnsAnswer{}, false
+	}
+	ttl := e.Expires.Sub(now)
+	var elapsed time.Duration
+	if e.TTL > 0 {
+		elapsed = max(e.TTL-ttl, 0).Truncate(time.Second)
+		ttl = e.TTL - elapsed
+	}
+	ttls := make([]time.Duration, len(e.TTLs))
+	for i, t := range e.TTLs {
+		ttls[i] = max(t-elapsed, 0)
+	}
+	return dnsAnswer{
+		Addrs:  append([]netip.Addr(nil), e.Addrs...),
+		TTLs:   ttls,
+		CNAMEs: append([]string(nil), e.CNAMEs...),
+		TTL:    ttl,
+	}, true
+}
+
+func (c *DNSCache) put(server, domain string, qtype uint16, ans dnsAnswer) {
+	if ans.TTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := dnsCacheKey{Server: server, Domain: domain, Type: qtype}
+	c.entries[k] = dnsCacheEntry{
+		dnsCacheKey: k,
+		Addrs:       append([]netip.Addr(nil), ans.Addrs...),
+		TTLs:        append([]time.Duration(nil), ans.TTLs...),
+		CNAMEs:      append([]string(nil), ans.CNAMEs...),
+		TTL:         ans.TTL,
+		Expires:     c.now().Add(ans.TTL),
+	}
+}
@@ -7,23 +7,88 @@ import (
 	"math"
 	"net"
 	"net/netip"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
+
 	"example.com/ip-opt-gui/internal/model"
 )
 
 type Config struct {
-	DNSServers  []string
-	Port        int
-	Timeout     time.Duration
-	Attempts    int
-	Concurrency int
-	IPv4        bool
-	IPv6        bool
+	DNSServers      []string
+	Port            int
+	Timeout         time.Duration
+	Attempts        int
+	Interval        time.Duration
+	WarmUp          bool
+	Rounds          int
+	RoundDelay      time.Duration
+	Concurrency     int
+	IPv4            bool
+	IPv6            bool
+	Ports           map[string]int
+	DomainDNS       map[string][]string
+	Allow           map[string][]netip.Prefix
+	Prefer          func(netip.Addr) bool
+	Exclude         func(netip.Addr) bool
+	Seed            func(domain string, resolved []Candidate) []Candidate
+	DNSCache        *DNSCache
+	RefreshDNS      bool
+	AdaptiveTimeout bool
+	AutoConcurrency bool
+	RateLimit       *RateLimiter
+	Skipper         *Skipper
+	ResultCache     *ResultCache
+	Family          FamilyPolicy
+	TieBreak        FamilyTieBreak
+	Blocklist       []netip.Prefix
+	KeepBogons      bool
+	QUIC            bool
+	QUICWeight      float64
+	Jitter          JitterMetric
+	MTUCheck        bool
+	Traceroute      bool
+	LossBurst       int
+	LossInterval    time.Duration
+	PrefixExpand    int
+	MinSamples      int
+	Outliers        OutlierFilter
+	// FullReport measures loss for every reachable candidate instead of only
+	// the top few, so a complete per-candidate report can be exported.
+	FullReport bool
+	// HTTPSRecords also queries each server for HTTPS (type 65) records and
+	// adds their ipv4hint/ipv6hint addresses as candidates.
+	HTTPSRecords bool
+	// TrustedDoH is queried for every domain alongside its DNS servers as a
+	// baseline for hijack detection. Candidates no DoH server returned are
+	// dropped unless KeepHijacked is set.
+	TrustedDoH   []string
+	KeepHijacked bool
+}
+
+func (c Config) serversFor(domain string) []string {
+	servers := c.DNSServers
+	if s, ok := c.DomainDNS[domain]; ok && len(s) > 0 {
+		servers = s
+	}
+	for _, s := range c.TrustedDoH {
+		if !slices.Contains(servers, s) {
+			servers = append(slices.Clip(servers), s)
+		}
+	}
+	return servers
+}
+
+func (c Config) portFor(domain string) int {
+	if p, ok := c.Ports[domain]; ok && p > 0 && p <= 65535 {
+		return p
+	}
+	return c.Port
 }
 
 func (c Config) validate() error {
@@ -45,10 +110,88 @@ func (c Config) validate() error {
 	return nil
 }
 
+type FamilyPolicy string
+
+const (
+	FamilyBestOverall   FamilyPolicy = "best-overall"
+	FamilyBestPerFamily FamilyPolicy = "best-per-family"
+	FamilyPreferV6      FamilyPolicy = "prefer-v6"
+)
+
+// FamilyTieBreak picks the address family when a v4 and a v6 candidate are
+// equally reachable and their latency differs by less than the noise margin.
+type FamilyTieBreak string
+
+const (
+	TieIndifferent FamilyTieBreak = ""
+	TiePreferV4    FamilyTieBreak = "v4"
+	TiePreferV6    FamilyTieBreak = "v6"
+)
+
+const (
+	tieLatencyRatio = 0.05
+	tieLatencyFloor = 2 * time.Millisecond
+)
+
+func (t FamilyTieBreak) prefers(ip netip.Addr) bool {
+	switch t {
+	case TiePreferV4:
+		return ip.Unmap().Is4()
+	case TiePreferV6:
+		return ip.Is6() && !ip.Is4In6()
+	}
+	return false
+}
+
+func closeLatency(a, b time.Duration) bool {
+	return max(a-b, b-a) <= max(tieLatencyFloor, time.Duration(tieLatencyRatio*float64(min(a, b))))
+}
+
+type Stage string
+
+const (
+	StageResolving Stage = "resolving"
+	StageProbing   Stage = "probing"
+	StageWaiting   Stage = "waiting"
+)
+
 type Callbacks struct {
-	OnLog      func(string)
-	OnResult   func(model.DomainResult)
-	OnProgress func(done, total int)
+	OnLog         func(string)
+	OnResult      func(model.DomainResult)
+	OnProgress    func(done, total int)
+	OnDomainStage func(domain string, stage Stage, doneCandidates, totalCandidates int)
+	OnRound       func(res model.DomainResult, round, rounds int)
+	// OnCandidate fires after each first-pass probe with the candidates
+	// probed so far for the domain, ranked, so rows can fill in live.
+	OnCandidate func(res model.DomainResult)
+	OnEvent     func(Event)
+}
+
+func (cb Callbacks) log(s string) {
+	if cb.OnLog != nil {
+		cb.OnLog(s)
+	}
+}
+
+func (cb Callbacks) stage(domain string, stage Stage, done, total int) {
+	if cb.OnDomainStage != nil {
+		cb.OnDomainStage(domain, stage, done, total)
+	}
+}
+
+func (cb Callbacks) round(domain string, stats []model.CandidateStat, round, rounds int) {
+	if cb.OnRound != nil {
+		snapshot := append([]model.CandidateStat(nil), stats...)
+		cb.OnRound(model.DomainResult{Domain: domain, Candidates: snapshot, Best: snapshot[0]}, round, rounds)
+	}
+}
+
+func (cb Callbacks) candidate(domain string, stats []model.CandidateStat, less func(a, b model.CandidateStat) bool) {
+	if cb.OnCandidate != nil {
+		snapshot := append([]model.CandidateStat(nil), stats...)
+		sort.SliceStable(snapshot, func(i, j int) bool { return less(snapshot[i], snapshot[j]) })
+		cb.OnCandidate(model.DomainResult{Domain: domain, Candidates: snapshot, Best: snapshot[0]})
+	}
 }
 
 func Run(ctx context.Context, domains []string, cfg Config, cb Callbacks) error {
@@ -65,13 +208,27 @@ func Run(ctx context.Context, domains []string, cfg Config, cb Callbacks) error
 		cb.OnProgress(0, total)
 	}
 
+	at := newAdaptiveTimeout(cfg.Timeout, cfg.AdaptiveTimeout)
+	sched := newProbeScheduler(cfg.Concurrency)
+	if cfg.AutoConcurrency {
+		at.tuner = newConcurrencyTuner(cfg.Concurrency, sched.setLimit, cb.log)
+		sched.setLimit(at.tuner.limit)
+		cb.log(fmt.Sprintf("auto concurrency: starting at %d, max %d", at.tuner.limit, cfg.Concurrency))
+	}
 	workCh := make(chan string)
 	var wg sync.WaitGroup
 
 	worker := func() {
 		defer wg.Done()
 		for domain := range workCh {
-			res := RunOneDomain(ctx, domain, cfg, cb.OnLog)
+			dctx, end := cfg.Skipper.begin(ctx, domain)
+			res := runOneDomain(dctx, domain, cfg, cb, at, sched)
+			end()
+			if res.Err != nil && ctx.Err() == nil && cfg.Skipper.isSkipped(domain) {
+				res.Err = ErrSkipped
+				cb.log(domain + ": skipped")
+			}
+			cb.result(res)
 			if cb.OnResult != nil {
 				cb.OnResult(res)
 			}
@@ -101,131 +258,681 @@ func Run(ctx context.Context, domains []string, cfg Config, cb Callbacks) error
 	return nil
 }
 
-func RunOneDomain(ctx context.Context, domain string, cfg Config, logf func(string)) model.DomainResult {
+func RunOneDomain(ctx context.Context, domain string, cfg Config, cb Callbacks) model.DomainResult {
+	res := runOneDomain(ctx, domain, cfg, cb, newAdaptiveTimeout(cfg.Timeout, cfg.AdaptiveTimeout), newProbeScheduler(cfg.Concurrency))
+	cb.result(res)
+	return res
+}
+
+// runOneDomain resolves domain and probes its candidates in parallel; every
+// probe takes a slot from sched, which is shared with the other domains. If ctx
+// is cancelled while probing, the result carries ctx's error along with the
+// candidates that finished, ranked.
+func runOneDomain(ctx context.Context, domain string, cfg Config, cb Callbacks, at *adaptiveTimeout, sched *probeScheduler) model.DomainResult {
 	res := model.DomainResult{Domain: domain}
 
-	candidates, err := ResolveCandidates(ctx, domain, cfg.DNSServers, cfg.IPv4, cfg.IPv6)
+	cb.stage(domain, StageResolving, 0, 0)
+	candidates, info, err := resolveCandidates(ctx, domain, resolveOptions{
+		Servers:    cfg.serversFor(domain),
+		IPv4:       cfg.IPv4,
+		IPv6:       cfg.IPv6,
+		Cache:      cfg.DNSCache,
+		Refresh:    cfg.RefreshDNS,
+		KeepBogons: cfg.KeepBogons,
+		HTTPS:      cfg.HTTPSRecords,
+	})
+	res.CNAMEs = info.CNAMEs
+	res.MinTTL = info.MinTTL
+	res.ResolverErrors = info.Errors
+	res.Queries = info.Queries
+	for _, b := range info.Bogons {
+		cb.log(fmt.Sprintf("%s: dropped %s from %s (%s)", domain, b.IP, b.Via, b.Reason))
+	}
+	for _, re := range info.Errors {
+		cb.log(fmt.Sprintf("%s: resolver %s %s failed after %d attempt(s): %s", domain, re.Server, re.Type, re.Attempts, re.Err))
+		cb.event(Event{Level: LevelWarn, Domain: domain, Error: re.String(), Msg: "resolver failed"})
+	}
 	if err != nil {
 		res.Err = err
 		return res
 	}
+	kept := candidates[:0]
+	for _, c := range candidates {
+		if c.Hijacked {
+			res.Hijacked = append(res.Hijacked, c.IP)
+			cb.event(Event{Level: LevelWarn, Domain: domain, IP: c.IP.String(), Msg: "possible dns hijack"})
+			if !cfg.KeepHijacked {
+				cb.log(fmt.Sprintf("%s: dropped %s from %s (no encrypted resolver returned it)", domain, c.IP, c.ResolvedVia))
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	candidates = kept
+	if cfg.Seed != nil {
+		seen := map[netip.Addr]bool{}
+		for _, c := range candidates {
+			seen[c.IP] = true
+		}
+		for _, c := range cfg.Seed(domain, candidates) {
+			if c.IP.IsValid() && !seen[c.IP] {
+				seen[c.IP] = true
+				candidates = append(candidates, c)
+			}
+		}
+	}
 	if len(candidates) == 0 {
 		res.Err = errors.New("no candidate ip")
+		if len(res.Hijacked) > 0 {
+			res.Err = fmt.Errorf("all %d candidate ips look hijacked", len(res.Hijacked))
+		}
 		return res
 	}
+	if len(cfg.Blocklist) > 0 {
+		kept := candidates[:0]
+		for _, c := range candidates {
+			if !inPrefixes(cfg.Blocklist, c.IP) {
+				kept = append(kept, c)
+			}
+		}
+		if dropped := len(candidates) - len(kept); dropped > 0 {
+			cb.log(fmt.Sprintf("%s: dropped %d blocklisted ips", domain, dropped))
+		}
+		if len(kept) == 0 {
+			res.Err = fmt.Errorf("all %d candidate ips blocklisted", len(candidates))
+			return res
+		}
+		candidates = kept
+	}
+	if allow := cfg.Allow[domain]; len(allow) > 0 {
+		kept := candidates[:0]
+		for _, c := range candidates {
+			if inPrefixes(allow, c.IP) {
+				kept = append(kept, c)
+			}
+		}
+		if dropped := len(candidates) - len(kept); dropped > 0 {
+			cb.log(fmt.Sprintf("%s: dropped %d ips outside allow-list", domain, dropped))
+		}
+		if len(kept) == 0 {
+			res.Err = fmt.Errorf("all %d candidate ips outside allow-list", len(candidates))
+			return res
+		}
+		candidates = kept
+	}
+	if cfg.Exclude != nil {
+		kept := candidates[:0]
+		for _, c := range candidates {
+			if !cfg.Exclude(c.IP) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			res.Err = fmt.Errorf("all %d candidate ips excluded", len(candidates))
+			return res
+		}
+		candidates = kept
+	}
+
+	cacheKey := candidateKey(cfg.portFor(domain), candidates)
+	if prev, ok := cfg.ResultCache.lookup(domain, cacheKey); ok && sched.acquire(ctx, domain) == nil {
+		st := probeCandidate(ctx, prev.Best.IP, cfg.portFor(domain), at, cfg.RateLimit, 1, 0, false, cfg.Outliers)
+		sched.release()
+		if st.Successes > 0 {
+			cb.log(fmt.Sprintf("%s: candidates unchanged and %s still reachable (%s), reusing previous result", domain, prev.Best.IP, st.P95))
+			prev.CNAMEs, prev.MinTTL, prev.ResolverErrors, prev.Queries, prev.Hijacked = res.CNAMEs, res.MinTTL, res.ResolverErrors, res.Queries, res.Hijacked
+			prev.Cached = true
+			return prev
+		}
+		if ctx.Err() == nil {
+			cb.log(fmt.Sprintf("%s: previous best %s failed health probe, probing all candidates", domain, prev.Best.IP))
+		}
+	}
 
 	stats := make([]model.CandidateStat, 0, len(candidates))
-	for _, c := range candidates {
-		if ctx.Err() != nil {
-			res.Err = ctx.Err()
-			return res
+	total := len(candidates)
+	var (
+		mu     sync.Mutex
+		probed int
+		live   []model.CandidateStat
+	)
+	less := candidateLess(cfg.Prefer, cfg.QUICWeight, cfg.Jitter, cfg.MinSamples, cfg.TieBreak)
+	partial := func(err error) model.DomainResult {
+		res.Err = err
+		if len(stats) > 0 {
+			sortCandidates(stats, cfg.Prefer, cfg.QUICWeight, cfg.Jitter, cfg.MinSamples, cfg.TieBreak)
+			res.Candidates = stats
+			res.Best = stats[0]
+		}
+		return res
+	}
+	// fanOut runs fn for indexes 0..n-1 concurrently, each under a probe slot,
+	// and reports progress as they finish.
+	fanOut := func(n int, fn func(i int)) error {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if sched.acquire(ctx, domain) != nil {
+					return
+				}
+				fn(i)
+				sched.release()
+				mu.Lock()
+				probed++
+				cb.stage(domain, StageProbing, probed, total)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		return ctx.Err()
+	}
+	probe := func(batch []Candidate) error {
+		out := make([]model.CandidateStat, len(batch))
+		probed = len(stats)
+		err := fanOut(len(batch), func(i int) {
+			c := batch[i]
+			st := probeCandidate(ctx, c.IP, cfg.portFor(domain), at, cfg.RateLimit, cfg.Attempts, cfg.Interval, cfg.WarmUp, cfg.Outliers)
+			st.ResolvedVia, st.TTL, st.Hijacked = c.ResolvedVia, c.TTL, c.Hijacked
+			if cfg.QUIC && st.Successes > 0 {
+				probeQUIC(ctx, &st, domain, cfg.portFor(domain), cfg.Timeout, cfg.RateLimit, cfg.Attempts)
+			}
+			if cfg.MTUCheck && st.Successes > 0 && tlsPorts[cfg.portFor(domain)] && cfg.RateLimit.Wait(ctx) == nil {
+				checked, blackhole, err := largePacketCheck(ctx, domain, c.IP, cfg.portFor(domain), 2*cfg.Timeout, cfg.RateLimit)
+				st.MTUChecked, st.MTUBlackhole = checked && ctx.Err() == nil, blackhole
+				if blackhole {
+					st.LastError = "large packets stalled: " + err.Error()
+					cb.log(fmt.Sprintf("%s -> %s: connect ok but large packets stall, possible mtu blackhole", domain, c.IP))
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			out[i] = st
+			cb.log(fmt.Sprintf("%s -> %s (success %.0f%%, p95 %s)", domain, st.IP.String(), st.SuccessRate()*100, st.P95))
+			cb.probe(domain, st)
+			mu.Lock()
+			live = append(live, st)
+			cb.candidate(domain, live, less)
+			mu.Unlock()
+		})
+		for _, st := range out {
+			if st.IP.IsValid() {
+				stats = append(stats, st)
+			}
 		}
-		st := ProbeCandidate(ctx, c.IP, cfg.Port, cfg.Timeout, cfg.Attempts)
-		st.ResolvedVia = c.ResolvedVia
-		stats = append(stats, st)
-		if logf != nil {
-			logf(fmt.Sprintf("%s -> %s (success %.0f%%, p95 %s)", domain, st.IP.String(), st.SuccessRate()*100, st.P95))
+		return err
+	}
+
+	groups := GroupByPrefix(candidates)
+	if cfg.PrefixExpand <= 0 || len(groups) == len(candidates) {
+		cb.stage(domain, StageProbing, 0, total)
+		if err := probe(candidates); err != nil {
+			return partial(err)
+		}
+	} else {
+		reps := make([]Candidate, len(groups))
+		for i, g := range groups {
+			reps[i] = g[0]
+		}
+		total = len(reps)
+		cb.stage(domain, StageProbing, 0, total)
+		if err := probe(reps); err != nil {
+			return partial(err)
+		}
+
+		order := make([]int, len(groups))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return less(stats[order[a]], stats[order[b]]) })
+		var extra []Candidate
+		expanded := 0
+		for _, i := range order {
+			if expanded >= cfg.PrefixExpand || stats[i].Successes == 0 {
+				break
+			}
+			extra = append(extra, groups[i][1:]...)
+			expanded++
+		}
+		total += len(extra)
+		cb.log(fmt.Sprintf("%s: %d candidates in %d prefixes, expanding %d (%d more probes)", domain, len(candidates), len(groups), expanded, len(extra)))
+		if err := probe(extra); err != nil {
+			return partial(err)
+		}
+	}
+
+	for round := 2; round <= cfg.Rounds; round++ {
+		sortCandidates(stats, cfg.Prefer, cfg.QUICWeight, cfg.Jitter, cfg.MinSamples, cfg.TieBreak)
+		cb.round(domain, stats, round-1, cfg.Rounds)
+		cb.stage(domain, StageWaiting, round-1, cfg.Rounds)
+		if err := sleepCtx(ctx, cfg.RoundDelay); err != nil {
+			return partial(err)
 		}
+		total, probed = len(stats), 0
+		cb.stage(domain, StageProbing, 0, total)
+		err := fanOut(len(stats), func(i int) {
+			st := probeCandidate(ctx, stats[i].IP, cfg.portFor(domain), at, cfg.RateLimit, cfg.Attempts, cfg.Interval, false, cfg.Outliers)
+			if ctx.Err() == nil {
+				mergeRound(&stats[i], st, cfg.Timeout, cfg.Outliers)
+			}
+		})
+		if err != nil {
+			return partial(err)
+		}
+		cb.log(fmt.Sprintf("%s: round %d/%d done", domain, round, cfg.Rounds))
 	}
 
-	sort.Slice(stats, func(i, j int) bool { return better(stats[i], stats[j]) })
+	rank := func() {
+		sortCandidates(stats, cfg.Prefer, cfg.QUICWeight, cfg.Jitter, cfg.MinSamples, cfg.TieBreak)
+		if cfg.Family == FamilyPreferV6 {
+			preferIPv6(stats)
+		}
+	}
+	rank()
+	if cfg.LossBurst > 0 {
+		n := lossTopCandidates
+		if cfg.FullReport {
+			n = len(stats)
+		}
+		measured := stats[:min(n, len(stats))]
+		for i := range measured {
+			if measured[i].Successes == 0 || sched.acquire(ctx, domain) != nil {
+				continue
+			}
+			measured[i].BurstSent, measured[i].BurstLost = measureLoss(ctx, measured[i].IP, cfg.portFor(domain), cfg.Timeout, cfg.RateLimit, cfg.LossBurst, cfg.LossInterval)
+			sched.release()
+			if r, ok := measured[i].LossRate(); ok {
+				cb.log(fmt.Sprintf("%s -> %s loss %.0f%% (%d/%d)", domain, measured[i].IP, r*100, measured[i].BurstLost, measured[i].BurstSent))
+			}
+		}
+		rank()
+	}
+	if cfg.Traceroute && stats[0].Successes > 0 && sched.acquire(ctx, domain) == nil {
+		hops, err := Traceroute(ctx, stats[0].IP, traceMaxHops, cfg.Timeout)
+		sched.release()
+		if err != nil {
+			cb.log(fmt.Sprintf("%s: traceroute to %s failed: %v", domain, stats[0].IP, err))
+		}
+		stats[0].Hops = hops
+	}
 	res.Candidates = stats
 	res.Best = stats[0]
+	cfg.ResultCache.store(domain, cacheKey, res)
 	return res
 }
 
+// Candidate.TTL is the smallest record TTL any DNS server gave for the
+// address, or 0 when only the system resolver returned it. Hijacked is set
+// when a DoH server answered for the address family but none returned this
+// address, the usual sign of a polluted plain-DNS answer.
 type Candidate struct {
 	IP          netip.Addr
 	ResolvedVia string
+	TTL         time.Duration
+	Hijacked    bool
 }
 
 func ResolveCandidates(ctx context.Context, domain string, servers []string, ipv4, ipv6 bool) ([]Candidate, error) {
-	type resolved struct {
-		ip  netip.Addr
-		via string
-	}
-	seen := map[netip.Addr]string{}
+	cands, _, err := resolveCandidates(ctx, domain, resolveOptions{Servers: servers, IPv4: ipv4, IPv6: ipv6, HTTPS: true})
+	return cands, err
+}
 
-	addIPs := func(via string, ips []netip.Addr) {
-		for _, ip := range ips {
-			if ip.IsValid() && !ip.IsUnspecified() {
-				if _, ok := seen[ip]; !ok {
-					seen[ip] = via
-				}
+type resolveInfo struct {
+	CNAMEs  []model.CNAMEChain
+	Errors  []model.ResolverError
+	Queries []model.ResolverQuery
+	Bogons  []bogonDrop
+	MinTTL  time.Duration
+}
+
+type bogonDrop struct {
+	IP     netip.Addr
+	Via    string
+	Reason string
+}
+
+// resolveOptions are the Config settings resolveCandidates looks up a domain
+// with.
+type resolveOptions struct {
+	Servers    []string
+	IPv4, IPv6 bool
+	Cache      *DNSCache
+	Refresh    bool
+	KeepBogons bool
+	HTTPS      bool
+}
+
+func resolveCandidates(ctx context.Context, domain string, opt resolveOptions) ([]Candidate, resolveInfo, error) {
+	seen := map[netip.Addr]string{}
+	ttls := map[netip.Addr]time.Duration{}
+	trusted := map[netip.Addr]bool{}
+	encAnswered := map[bool]bool{}
+	var info resolveInfo
+
+	addIPs := func(via string, ans dnsAnswer) {
+		for i, ip := range ans.Addrs {
+			if !ip.IsValid() || (ip.Is4() && !opt.IPv4) || (ip.Is6() && !opt.IPv6) {
+				continue
+			}
+			if ttl := ans.ttlOf(i); ttl > 0 && (ttls[ip] == 0 || ttl < ttls[ip]) {
+				ttls[ip] = ttl
 			}
+			if _, ok := seen[ip]; ok {
+				continue
+			}
+			if reason, bogon := bogonReason(ip); bogon && (!opt.KeepBogons || ip.IsUnspecified()) {
+				info.Bogons = append(info.Bogons, bogonDrop{IP: ip, Via: via, Reason: reason})
+				seen[ip] = ""
+				continue
+			}
+			seen[ip] = via
 		}
 	}
 
-	sysIPs, _ := lookupWithResolver(ctx, net.DefaultResolver, domain)
-	addIPs("system", filterIPVersions(sysIPs, ipv4, ipv6))
-
-	for _, s := range servers {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			continue
+	var qtypes []dnsmessage.Type
+	if opt.IPv4 {
+		qtypes = append(qtypes, dnsmessage.TypeA)
+	}
+	if opt.IPv6 {
+		qtypes = append(qtypes, dnsmessage.TypeAAAA)
+	}
+	lookup := func(server string, qt dnsmessage.Type) (dnsAnswer, error) {
+		q := model.ResolverQuery{Server: server, Type: strings.TrimPrefix(qt.String(), "Type")}
+		if opt.Cache != nil && !opt.Refresh {
+			if ans, ok := opt.Cache.get(server, domain, uint16(qt)); ok {
+				q.OK, q.Cached, q.Addrs = true, true, ans.Addrs
+				info.Queries = append(info.Queries, q)
+				return ans, nil
+			}
+		}
+		start := time.Now()
+		ans, attempts, err := withDNSRetry(ctx, func() (dnsAnswer, error) {
+			if server == "system" {
+				return systemLookup(ctx, domain, qt)
+			}
+			return queryServer(ctx, server, domain, qt)
+		})
+		q.RTT = time.Since(start)
+		if ctx.Err() == nil {
+			q.OK, q.Addrs = err == nil, ans.Addrs
+			info.Queries = append(info.Queries, q)
 		}
-		r := resolverForServer(s)
-		ips, err := lookupWithResolver(ctx, r, domain)
 		if err != nil {
-			continue
+			if ctx.Err() == nil {
+				info.Errors = append(info.Errors, model.ResolverError{
+					Server:   server,
+					Type:     q.Type,
+					Attempts: attempts,
+					Err:      err.Error(),
+				})
+			}
+			return dnsAnswer{}, err
+		}
+		if opt.Cache != nil {
+			opt.Cache.put(server, domain, uint16(qt), ans)
+		}
+		return ans, nil
+	}
+
+	sources := []string{"system"}
+	for _, s := range opt.Servers {
+		if s = strings.TrimSpace(s); s != "" {
+			sources = append(sources, s)
+		}
+	}
+	for _, s := range sources {
+		var chain []string
+		types := qtypes
+		if opt.HTTPS && s != "system" {
+			types = append(slices.Clip(qtypes), dnsmessage.TypeHTTPS)
+		}
+		for _, qt := range types {
+			ans, err := lookup(s, qt)
+			if err != nil {
+				continue
+			}
+			if len(chain) == 0 {
+				chain = ans.CNAMEs
+			}
+			if s != "system" && len(ans.Addrs) > 0 && (info.MinTTL == 0 || ans.TTL < info.MinTTL) {
+				info.MinTTL = ans.TTL
+			}
+			if isDoH(s) {
+				for _, ip := range ans.Addrs {
+					trusted[ip], encAnswered[ip.Is4()] = true, true
+				}
+			}
+			via := s
+			if qt == dnsmessage.TypeHTTPS {
+				via = "https:" + s
+			}
+			addIPs(via, ans)
+		}
+		if len(chain) > 0 {
+			info.CNAMEs = append(info.CNAMEs, model.CNAMEChain{Server: s, Chain: chain})
 		}
-		addIPs(s, filterIPVersions(ips, ipv4, ipv6))
 	}
 
 	var out []Candidate
 	for ip, via := range seen {
-		out = append(out, Candidate{IP: ip, ResolvedVia: via})
+		if via != "" {
+			out = append(out, Candidate{IP: ip, ResolvedVia: via, TTL: ttls[ip], Hijacked: encAnswered[ip.Is4()] && !trusted[ip]})
+		}
 	}
 	sort.Slice(out, func(i, j int) bool { return out[i].IP.Less(out[j].IP) })
-	return out, nil
+	if info.MinTTL == 0 && len(out) > 0 {
+		info.MinTTL = systemTTL
+	}
+	return out, info, nil
+}
+
+func systemLookup(ctx context.Context, domain string, qt dnsmessage.Type) (dnsAnswer, error) {
+	network := "ip4"
+	if qt == dnsmessage.TypeAAAA {
+		network = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, network, domain)
+	if de, ok := err.(*net.DNSError); ok && de.IsNotFound {
+		return dnsAnswer{TTL: negativeTTL}, nil
+	}
+	if err != nil {
+		return dnsAnswer{}, err
+	}
+	ans := dnsAnswer{Addrs: make([]netip.Addr, 0, len(ips)), TTL: systemTTL}
+	for _, ip := range ips {
+		ans.Addrs = append(ans.Addrs, ip.Unmap())
+	}
+	if cname, err := net.DefaultResolver.LookupCNAME(ctx, domain); err == nil {
+		if c := strings.TrimSuffix(cname, "."); !strings.EqualFold(c, strings.TrimSuffix(domain, ".")) {
+			ans.CNAMEs = []string{c}
+		}
+	}
+	return ans, nil
 }
 
 func ProbeCandidate(ctx context.Context, ip netip.Addr, port int, timeout time.Duration, attempts int) model.CandidateStat {
+	return probeCandidate(ctx, ip, port, newAdaptiveTimeout(timeout, false), nil, attempts, 0, false, OutliersKeep)
+}
+
+func probeCandidate(ctx context.Context, ip netip.Addr, port int, at *adaptiveTimeout, lim *RateLimiter, attempts int, interval time.Duration, warmUp bool, outliers OutlierFilter) model.CandidateStat {
+	timeout := at.base
 	st := model.CandidateStat{IP: ip}
+	if warmUp {
+		if err := lim.Wait(ctx); err == nil {
+			_, _ = tcpPing(ctx, ip, port, at.timeout())
+		}
+	}
 	for i := 0; i < attempts; i++ {
-		if ctx.Err() != nil {
-			st.LastError = ctx.Err().Error()
+		if (i > 0 || warmUp) && interval > 0 {
+			if err := sleepCtx(ctx, interval); err != nil {
+				st.LastError = err.Error()
+				break
+			}
+		}
+		if err := lim.Wait(ctx); err != nil {
+			st.LastError = err.Error()
 			break
 		}
-		d, err := tcpPing(ctx, ip, port, timeout)
+		d, err := tcpPing(ctx, ip, port, at.timeout())
 		if err != nil {
+			at.fail()
 			st.Failures++
 			st.LastError = err.Error()
 			continue
 		}
+		at.observe(d)
 		st.Successes++
 		st.Samples = append(st.Samples, d)
 	}
+	summarize(&st, timeout, outliers)
+	return st
+}
+
+func mergeRound(dst *model.CandidateStat, src model.CandidateStat, timeout time.Duration, outliers OutlierFilter) {
+	dst.Successes += src.Successes
+	dst.Failures += src.Failures
+	dst.Samples = append(dst.Samples, src.Samples...)
+	if src.LastError != "" {
+		dst.LastError = src.LastError
+	}
+	summarize(dst, timeout, outliers)
+}
 
+func summarize(st *model.CandidateStat, timeout time.Duration, outliers OutlierFilter) {
 	if len(st.Samples) > 0 {
-		st.P50 = quantile(st.Samples, 0.50)
-		st.P95 = quantile(st.Samples, 0.95)
-		st.JitterStd = stddev(st.Samples)
+		samples := outliers.filter(st.Samples)
+		st.Outliers = len(st.Samples) - len(samples)
+		st.P50 = Quantile(samples, 0.50)
+		st.P95 = Quantile(samples, 0.95)
+		st.JitterStd = stddev(samples)
+		st.JitterRFC = rfc3550Jitter(samples)
 	} else {
 		st.P50 = timeout
 		st.P95 = timeout
 		st.JitterStd = timeout
+		st.JitterRFC = timeout
 	}
-	return st
 }
 
-func better(a, b model.CandidateStat) bool {
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func GroupByPrefix(candidates []Candidate) [][]Candidate {
+	idx := map[netip.Prefix]int{}
+	var groups [][]Candidate
+	for _, c := range candidates {
+		bits := 24
+		if c.IP.Is6() {
+			bits = 48
+		}
+		p, err := c.IP.Prefix(bits)
+		if err != nil {
+			groups = append(groups, []Candidate{c})
+			continue
+		}
+		i, ok := idx[p]
+		if !ok {
+			i = len(groups)
+			idx[p] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], c)
+	}
+	return groups
+}
+
+func candidateLess(prefer func(netip.Addr) bool, quicWeight float64, jitter JitterMetric, minSamples int, tie FamilyTieBreak) func(a, b model.CandidateStat) bool {
+	preferred := func(st model.CandidateStat) bool {
+		return prefer != nil && st.Successes > 0 && prefer(st.IP)
+	}
+	return func(a, b model.CandidateStat) bool {
+		if pa, pb := preferred(a), preferred(b); pa != pb {
+			return pa
+		}
+		return better(a, b, quicWeight, jitter, minSamples, tie)
+	}
+}
+
+func preferIPv6(stats []model.CandidateStat) {
+	usable6 := func(st model.CandidateStat) bool { return st.Successes > 0 && st.IP.Is6() }
+	sort.SliceStable(stats, func(i, j int) bool { return usable6(stats[i]) && !usable6(stats[j]) })
+}
+
+func BestOfFamily(stats []model.CandidateStat, v6 bool) (model.CandidateStat, bool) {
+	for _, st := range stats {
+		if st.Successes > 0 && st.IP.Is6() == v6 {
+			return st, true
+		}
+	}
+	return model.CandidateStat{}, false
+}
+
+func sortCandidates(stats []model.CandidateStat, prefer func(netip.Addr) bool, quicWeight float64, jitter JitterMetric, minSamples int, tie FamilyTieBreak) {
+	less := candidateLess(prefer, quicWeight, jitter, minSamples, tie)
+	sort.Slice(stats, func(i, j int) bool { return less(stats[i], stats[j]) })
+}
+
+// better ranks by blackhole status, then (with minSamples > 0) whether enough
+// latency samples were collected, then the Wilson lower bound of the success
+// rate, so a candidate measured once cannot outrank a well-measured one.
+// Burst loss comes next; it is only measured for the top candidates after
+// the last round, and an unmeasured candidate counts as fully lossy so it
+// cannot overtake them. Between equally reachable candidates of different
+// families whose latency is within the noise margin, tie picks the family
+// before latency decides.
+func better(a, b model.CandidateStat, quicWeight float64, jitter JitterMetric, minSamples int, tie FamilyTieBreak) bool {
+	if a.MTUBlackhole != b.MTUBlackhole {
+		return !a.MTUBlackhole
+	}
+	if minSamples > 0 {
+		if ea, eb := len(a.Samples) >= minSamples, len(b.Samples) >= minSamples; ea != eb {
+			return ea
+		}
+	}
+	if al, bl := a.SuccessLowerBound(), b.SuccessLowerBound(); al != bl {
+		return al > bl
+	}
 	ar, br := a.SuccessRate(), b.SuccessRate()
 	if ar != br {
 		return ar > br
 	}
-	if a.P95 != b.P95 {
-		return a.P95 < b.P95
+	if la, lb := burstLoss(a), burstLoss(b); la != lb {
+		return la < lb
+	}
+	as, bs := latencyScore(a, quicWeight), latencyScore(b, quicWeight)
+	if pa, pb := tie.prefers(a.IP), tie.prefers(b.IP); pa != pb && a.Successes > 0 && closeLatency(as, bs) {
+		return pa
+	}
+	if as != bs {
+		return as < bs
 	}
 	if a.P50 != b.P50 {
 		return a.P50 < b.P50
 	}
-	if a.JitterStd != b.JitterStd {
-		return a.JitterStd < b.JitterStd
+	if aj, bj := jitter.Of(a), jitter.Of(b); aj != bj {
+		return aj < bj
 	}
 	return a.IP.Less(b.IP)
 }
 
+func latencyScore(st model.CandidateStat, quicWeight float64) time.Duration {
+	if quicWeight <= 0 || !st.QUICProbed() {
+		return st.P95
+	}
+	w := min(quicWeight, 1)
+	return time.Duration((1-w)*float64(st.P95) + w*float64(st.QUICP95))
+}
+
 func tcpPing(ctx context.Context, ip netip.Addr, port int, timeout time.Duration) (time.Duration, error) {
 	address := net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port))
 	dialer := net.Dialer{Timeout: timeout}
@@ -238,17 +945,6 @@ func tcpPing(ctx context.Context, ip netip.Addr, port int, timeout time.Duration
 	return time.Since(start), nil
 }
 
-func resolverForServer(server string) *net.Resolver {
-	addr := normalizeDNSServer(server)
-	dialer := net.Dialer{Timeout: 3 * time.Second}
-	return &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			return dialer.DialContext(ctx, "udp", addr)
-		},
-	}
-}
-
 func normalizeDNSServer(server string) string {
 	server = strings.TrimSpace(server)
 	if server == "" {
@@ -263,37 +959,7 @@ func normalizeDNSServer(server string) string {
 	return net.JoinHostPort(server, "53")
 }
 
-func lookupWithResolver(ctx context.Context, r *net.Resolver, domain string) ([]netip.Addr, error) {
-	addrs, err := r.LookupIPAddr(ctx, domain)
-	if err != nil {
-		return nil, err
-	}
-	out := make([]netip.Addr, 0, len(addrs))
-	for _, a := range addrs {
-		if a.IP == nil {
-			continue
-		}
-		if ip, ok := netip.AddrFromSlice(a.IP); ok {
-			out = append(out, ip)
-		}
-	}
-	return out, nil
-}
-
-func filterIPVersions(ips []netip.Addr, ipv4, ipv6 bool) []netip.Addr {
-	out := ips[:0]
-	for _, ip := range ips {
-		if ip.Is4() && ipv4 {
-			out = append(out, ip)
-		}
-		if ip.Is6() && ipv6 {
-			out = append(out, ip)
-		}
-	}
-	return out
-}
-
-func quantile(samples []time.Duration, q float64) time.Duration {
+func Quantile(samples []time.Duration, q float64) time.Duration {
 	if len(samples) == 0 {
 		return 0
 	}
@@ -332,4 +998,3 @@ func stddev(samples []time.Duration) time.Duration {
 	v /= float64(len(samples))
 	return time.Duration(math.Sqrt(v))
 }
-
@@ -1,12 +1,27 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"example.com/ip-opt-gui/internal/model"
 )
 
 func TestProbeCandidate(t *testing.T) {
@@ -42,3 +57,1056 @@ func TestProbeCandidate(t *testing.T) {
 		t.Fatalf("expected success, got failures=%d last=%s", st.Failures, st.LastError)
 	}
 }
+
+func TestRunOneDomainStages(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := Config{
+		Port:        ln.Addr().(*net.TCPAddr).Port,
+		Timeout:     500 * time.Millisecond,
+		Attempts:    1,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+	}
+	var stages []string
+	res := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{
+		OnDomainStage: func(domain string, stage Stage, done, total int) {
+			stages = append(stages, string(stage)+" "+strconv.Itoa(done)+"/"+strconv.Itoa(total))
+		},
+	})
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	want := []string{"resolving 0/0", "probing 0/1", "probing 1/1"}
+	if len(stages) != len(want) {
+		t.Fatalf("stages = %v, want %v", stages, want)
+	}
+	for i := range want {
+		if stages[i] != want[i] {
+			t.Fatalf("stages = %v, want %v", stages, want)
+		}
+	}
+}
+
+func TestRunOneDomainRounds(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := Config{
+		Port:        ln.Addr().(*net.TCPAddr).Port,
+		Timeout:     500 * time.Millisecond,
+		Attempts:    2,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+		Rounds:      3,
+		RoundDelay:  10 * time.Millisecond,
+	}
+	var rounds []string
+	var waits int
+	res := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{
+		OnRound: func(r model.DomainResult, round, total int) {
+			rounds = append(rounds, strconv.Itoa(round)+"/"+strconv.Itoa(total)+" "+strconv.Itoa(r.Best.Attempts()))
+		},
+		OnDomainStage: func(domain string, stage Stage, done, total int) {
+			if stage == StageWaiting {
+				waits++
+			}
+		},
+	})
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	if res.Best.Successes != 6 || len(res.Best.Samples) != 6 {
+		t.Fatalf("expected samples merged over 3 rounds, got %+v", res.Best)
+	}
+	if len(rounds) != 2 || rounds[0] != "1/3 2" || rounds[1] != "2/3 4" || waits != 2 {
+		t.Fatalf("intermediate rounds = %v, waits = %d", rounds, waits)
+	}
+}
+
+func TestRunOneDomainEvents(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := Config{
+		Port:        ln.Addr().(*net.TCPAddr).Port,
+		Timeout:     500 * time.Millisecond,
+		Attempts:    1,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+	}
+	var events []Event
+	RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{OnEvent: func(ev Event) { events = append(events, ev) }})
+	if len(events) != 2 {
+		t.Fatalf("events = %+v", events)
+	}
+	probe, result := events[0], events[1]
+	if probe.Msg != "probe" || probe.Level != LevelInfo || probe.IP != "127.0.0.1" || probe.RTTMs <= 0 || probe.Time.IsZero() {
+		t.Fatalf("unexpected probe event: %+v", probe)
+	}
+	if result.Msg != "result" || result.Domain != "127.0.0.1" || result.Rate == nil || *result.Rate != 1 {
+		t.Fatalf("unexpected result event: %+v", result)
+	}
+
+	ln.Close()
+	events = nil
+	RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{OnEvent: func(ev Event) { events = append(events, ev) }})
+	if len(events) != 2 || events[0].Level != LevelWarn || events[0].Error == "" || events[1].Level != LevelWarn {
+		t.Fatalf("unexpected events for unreachable candidate: %+v", events)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := Config{
+		Port:        ln.Addr().(*net.TCPAddr).Port,
+		Timeout:     500 * time.Millisecond,
+		Attempts:    1,
+		Concurrency: 2,
+		IPv4:        true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples := make(chan MonitorSample, 16)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Monitor(ctx, []MonitorTarget{{Domain: "a.test", IP: netip.MustParseAddr("127.0.0.1")}}, cfg, 10*time.Millisecond, func(s MonitorSample) {
+			select {
+			case samples <- s:
+			default:
+			}
+		})
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-samples:
+			if !s.OK() || s.Domain != "a.test" {
+				t.Fatalf("unexpected sample: %+v", s)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for sample")
+		}
+	}
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("Monitor returned %v, want context.Canceled", err)
+	}
+}
+
+func TestProbeInterval(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	ip := netip.MustParseAddr("127.0.0.1")
+
+	start := time.Now()
+	st := probeCandidate(context.Background(), ip, port, newAdaptiveTimeout(time.Second, false), nil, 3, 50*time.Millisecond, false, OutliersKeep)
+	if st.Successes != 3 {
+		t.Fatalf("expected 3 successes, got %+v", st)
+	}
+	if el := time.Since(start); el < 100*time.Millisecond {
+		t.Fatalf("attempts not spaced: %v", el)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	st = probeCandidate(ctx, ip, port, newAdaptiveTimeout(time.Second, false), nil, 3, time.Second, false, OutliersKeep)
+	if st.Successes != 1 || st.LastError == "" {
+		t.Fatalf("expected cancellation during spacing, got %+v", st)
+	}
+}
+
+func TestProbeWarmUp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	st := probeCandidate(context.Background(), netip.MustParseAddr("127.0.0.1"), port, newAdaptiveTimeout(time.Second, false), nil, 2, 0, true, OutliersKeep)
+	if st.Successes != 2 || len(st.Samples) != 2 {
+		t.Fatalf("warm-up must not be counted: %+v", st)
+	}
+	deadline := time.Now().Add(time.Second)
+	for accepted.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := accepted.Load(); n != 3 {
+		t.Fatalf("expected 3 connections including warm-up, got %d", n)
+	}
+}
+
+func TestSortCandidatesPrefer(t *testing.T) {
+	fast := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 3, P95: 10 * time.Millisecond}
+	slow := model.CandidateStat{IP: netip.MustParseAddr("2.2.2.2"), Successes: 3, P95: 90 * time.Millisecond}
+	dead := model.CandidateStat{IP: netip.MustParseAddr("3.3.3.3"), Failures: 3, P95: time.Second}
+	prefer := func(ip netip.Addr) bool { return ip != fast.IP }
+
+	stats := []model.CandidateStat{dead, fast, slow}
+	sortCandidates(stats, prefer, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != slow.IP || stats[1].IP != fast.IP || stats[2].IP != dead.IP {
+		t.Fatalf("unexpected order: %v %v %v", stats[0].IP, stats[1].IP, stats[2].IP)
+	}
+
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != fast.IP {
+		t.Fatalf("expected fastest first without preference, got %v", stats[0].IP)
+	}
+}
+
+func TestLargePacketCheck(t *testing.T) {
+	listen := func(handle func(net.Conn)) int {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go handle(c)
+			}
+		}()
+		return ln.Addr().(*net.TCPAddr).Port
+	}
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	// serve answers TLS, but a ClientHello record over maxHello bytes is
+	// swallowed the way a path MTU blackhole would.
+	serve := func(maxHello int) func(net.Conn) {
+		return func(c net.Conn) {
+			defer c.Close()
+			hdr := make([]byte, 5)
+			if _, err := io.ReadFull(c, hdr); err != nil {
+				return
+			}
+			if int(hdr[3])<<8|int(hdr[4]) > maxHello {
+				time.Sleep(time.Second)
+				return
+			}
+			tc := tls.Server(&prefixConn{Conn: c, r: io.MultiReader(bytes.NewReader(hdr), c)}, srv.TLS)
+			_ = tc.Handshake()
+		}
+	}
+	silent := listen(func(c net.Conn) {
+		time.Sleep(time.Second)
+		c.Close()
+	})
+	plain := listen(func(c net.Conn) {
+		_, _ = c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		c.Close()
+	})
+	ok := listen(serve(1 << 14))
+	hole := listen(serve(1000))
+
+	ip := netip.MustParseAddr("127.0.0.1")
+	check := func(port int) (bool, bool, error) {
+		return largePacketCheck(context.Background(), "example.test", ip, port, 200*time.Millisecond, nil)
+	}
+	if checked, blackhole, err := check(silent); checked || blackhole || err == nil {
+		t.Fatalf("listener that never answers: checked=%v blackhole=%v err=%v", checked, blackhole, err)
+	}
+	if checked, blackhole, err := check(plain); checked || blackhole || err == nil {
+		t.Fatalf("non-tls server: checked=%v blackhole=%v err=%v", checked, blackhole, err)
+	}
+	if checked, blackhole, err := check(ok); !checked || blackhole || err != nil {
+		t.Fatalf("tls server: checked=%v blackhole=%v err=%v", checked, blackhole, err)
+	}
+	if checked, blackhole, err := check(hole); !checked || !blackhole || err == nil {
+		t.Fatalf("large hello swallowed: checked=%v blackhole=%v err=%v", checked, blackhole, err)
+	}
+
+	clean := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 2, Failures: 1, P95: 90 * time.Millisecond}
+	stalled := model.CandidateStat{IP: netip.MustParseAddr("2.2.2.2"), Successes: 3, P95: 10 * time.Millisecond, MTUBlackhole: true}
+	stats := []model.CandidateStat{stalled, clean}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != clean.IP {
+		t.Fatalf("blackholed candidate must rank last, got %v first", stats[0].IP)
+	}
+}
+
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func TestQUICWeight(t *testing.T) {
+	tcpFast := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 3, P95: 20 * time.Millisecond, QUICSuccesses: 3, QUICP95: 200 * time.Millisecond}
+	quicFast := model.CandidateStat{IP: netip.MustParseAddr("2.2.2.2"), Successes: 3, P95: 40 * time.Millisecond, QUICSuccesses: 3, QUICP95: 30 * time.Millisecond}
+
+	stats := []model.CandidateStat{quicFast, tcpFast}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != tcpFast.IP {
+		t.Fatalf("expected tcp latency to decide without weight, got %v", stats[0].IP)
+	}
+	sortCandidates(stats, nil, 0.5, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != quicFast.IP {
+		t.Fatalf("expected quic latency to count with weight, got %v", stats[0].IP)
+	}
+
+	st := model.CandidateStat{IP: netip.MustParseAddr("127.0.0.1")}
+	probeQUIC(context.Background(), &st, "localhost", 9, 100*time.Millisecond, nil, 1)
+	if st.QUICFailures != 1 || st.QUICLastError == "" || st.QUICP95 != 100*time.Millisecond {
+		t.Fatalf("unexpected quic probe result: %+v", st)
+	}
+}
+
+func TestJitterMetric(t *testing.T) {
+	ms := func(v ...int) []time.Duration {
+		var out []time.Duration
+		for _, x := range v {
+			out = append(out, time.Duration(x)*time.Millisecond)
+		}
+		return out
+	}
+	if j := rfc3550Jitter(ms(10)); j != 0 {
+		t.Fatalf("single sample jitter = %v", j)
+	}
+	if j := rfc3550Jitter(ms(10, 26, 10)); j != 16*time.Millisecond {
+		t.Fatalf("constant swing jitter = %v", j)
+	}
+	if j := rfc3550Jitter(ms(10, 42, 42)); j != 30*time.Millisecond {
+		t.Fatalf("smoothed jitter = %v", j)
+	}
+
+	steady := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 4, P95: 20 * time.Millisecond, P50: 15 * time.Millisecond, JitterStd: 6 * time.Millisecond, JitterRFC: 2 * time.Millisecond}
+	spiky := model.CandidateStat{IP: netip.MustParseAddr("2.2.2.2"), Successes: 4, P95: 20 * time.Millisecond, P50: 15 * time.Millisecond, JitterStd: 4 * time.Millisecond, JitterRFC: 8 * time.Millisecond}
+	stats := []model.CandidateStat{steady, spiky}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != spiky.IP {
+		t.Fatalf("stddev metric picked %v", stats[0].IP)
+	}
+	sortCandidates(stats, nil, 0, JitterRFC3550, 0, TieIndifferent)
+	if stats[0].IP != steady.IP {
+		t.Fatalf("rfc3550 metric picked %v", stats[0].IP)
+	}
+}
+
+func TestConfidenceRanking(t *testing.T) {
+	samples := func(n int, d time.Duration) []time.Duration {
+		out := make([]time.Duration, n)
+		for i := range out {
+			out[i] = d
+		}
+		return out
+	}
+	lucky := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 1, Samples: samples(1, 5*time.Millisecond), P95: 5 * time.Millisecond}
+	solid := model.CandidateStat{IP: netip.MustParseAddr("2.2.2.2"), Successes: 29, Failures: 1, Samples: samples(29, 30*time.Millisecond), P95: 30 * time.Millisecond}
+	stats := []model.CandidateStat{lucky, solid}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != solid.IP {
+		t.Fatalf("1/1 outranked 29/30: %v", stats[0].IP)
+	}
+
+	few := model.CandidateStat{IP: netip.MustParseAddr("3.3.3.3"), Successes: 4, Samples: samples(4, 5*time.Millisecond), P95: 5 * time.Millisecond}
+	many := model.CandidateStat{IP: netip.MustParseAddr("4.4.4.4"), Successes: 6, Failures: 2, Samples: samples(6, 30*time.Millisecond), P95: 30 * time.Millisecond}
+	stats = []model.CandidateStat{many, few}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != few.IP {
+		t.Fatalf("without min samples 4/4 should beat 6/8, got %v", stats[0].IP)
+	}
+	sortCandidates(stats, nil, 0, JitterStddev, 5, TieIndifferent)
+	if stats[0].IP != many.IP {
+		t.Fatalf("min samples 5 should rank the 4-sample candidate last, got %v", stats[0].IP)
+	}
+}
+
+func TestFamilyTieBreak(t *testing.T) {
+	v4 := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 10, P95: 40 * time.Millisecond, P50: 38 * time.Millisecond}
+	v6 := model.CandidateStat{IP: netip.MustParseAddr("2606:4700::1"), Successes: 10, P95: 41 * time.Millisecond, P50: 39 * time.Millisecond}
+	for _, tc := range []struct {
+		tie  FamilyTieBreak
+		want netip.Addr
+	}{
+		{TieIndifferent, v4.IP},
+		{TiePreferV4, v4.IP},
+		{TiePreferV6, v6.IP},
+	} {
+		stats := []model.CandidateStat{v4, v6}
+		sortCandidates(stats, nil, 0, JitterStddev, 0, tc.tie)
+		if stats[0].IP != tc.want {
+			t.Fatalf("tie %q: best = %v, want %v", tc.tie, stats[0].IP, tc.want)
+		}
+	}
+
+	slow6 := v6
+	slow6.P95 = 80 * time.Millisecond
+	stats := []model.CandidateStat{slow6, v4}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TiePreferV6)
+	if stats[0].IP != v4.IP {
+		t.Fatalf("preference must not override a clear latency gap: %v", stats[0].IP)
+	}
+}
+
+func TestOutlierFilter(t *testing.T) {
+	ms := func(v ...int) []time.Duration {
+		out := make([]time.Duration, len(v))
+		for i, n := range v {
+			out[i] = time.Duration(n) * time.Millisecond
+		}
+		return out
+	}
+	cases := []struct {
+		f    OutlierFilter
+		in   []time.Duration
+		want []time.Duration
+	}{
+		{OutliersKeep, ms(20, 400, 21), ms(20, 400, 21)},
+		{OutliersTrim, ms(20, 400, 21), ms(20, 21)},
+		{OutliersTrim, ms(20, 20, 20, 20, 20, 20, 20, 20, 20, 20, 30), ms(20, 20, 20, 20, 20, 20, 20, 20, 20)},
+		{OutliersMAD, ms(20, 400, 21), ms(20, 21)},
+		{OutliersMAD, ms(20, 22, 21), ms(20, 22, 21)},
+		{OutliersMAD, ms(20, 20, 20, 24), ms(20, 20, 20, 24)},
+		{OutliersMAD, ms(20, 80), ms(20, 80)},
+	}
+	for _, c := range cases {
+		got := c.f.filter(c.in)
+		if len(got) != len(c.want) {
+			t.Fatalf("%q filter(%v) = %v, want %v", c.f, c.in, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%q filter(%v) = %v, want %v", c.f, c.in, got, c.want)
+			}
+		}
+	}
+
+	st := model.CandidateStat{Successes: 3, Samples: ms(20, 400, 21)}
+	summarize(&st, time.Second, OutliersMAD)
+	if st.P95 > 21*time.Millisecond || st.Outliers != 1 || len(st.Samples) != 3 {
+		t.Fatalf("summarized with mad: p95 = %v, outliers = %d, samples = %d", st.P95, st.Outliers, len(st.Samples))
+	}
+}
+
+func TestScore(t *testing.T) {
+	good := model.CandidateStat{Successes: 30, P95: 38 * time.Millisecond, JitterStd: 4 * time.Millisecond}
+	total, parts := Score(good, JitterStddev)
+	if len(parts) != 3 || parts[0].Kind != ScoreSuccess || parts[1].Kind != ScoreLatency || parts[2].Kind != ScoreJitter {
+		t.Fatalf("parts = %+v", parts)
+	}
+	if total < 85 || total > 95 {
+		t.Fatalf("score of a fast, fully reachable ip = %.1f", total)
+	}
+
+	lossy := good
+	lossy.BurstSent, lossy.BurstLost = 10, 5
+	if lt, _ := Score(lossy, JitterStddev); lt != total-10 {
+		t.Fatalf("50%% loss should cost 10 points: %.1f vs %.1f", lt, total)
+	}
+	dead := model.CandidateStat{Failures: 3, P95: time.Second}
+	if dt, parts := Score(dead, JitterStddev); dt != 0 || len(parts) != 1 {
+		t.Fatalf("unreachable score = %.1f, parts = %+v", dt, parts)
+	}
+}
+
+func TestFamilyPolicy(t *testing.T) {
+	v4 := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 3, P95: 10 * time.Millisecond}
+	v6 := model.CandidateStat{IP: netip.MustParseAddr("2606:4700::1"), Successes: 3, P95: 40 * time.Millisecond}
+	dead6 := model.CandidateStat{IP: netip.MustParseAddr("2606:4700::2"), Failures: 3, P95: time.Second}
+
+	stats := []model.CandidateStat{dead6, v6, v4}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if best, ok := BestOfFamily(stats, true); !ok || best.IP != v6.IP {
+		t.Fatalf("best v6 = %v %v", best.IP, ok)
+	}
+	if best, ok := BestOfFamily(stats, false); !ok || best.IP != v4.IP {
+		t.Fatalf("best v4 = %v %v", best.IP, ok)
+	}
+	if _, ok := BestOfFamily([]model.CandidateStat{dead6}, true); ok {
+		t.Fatal("failed candidate must not be a family best")
+	}
+
+	preferIPv6(stats)
+	if stats[0].IP != v6.IP || stats[1].IP != v4.IP || stats[2].IP != dead6.IP {
+		t.Fatalf("unexpected order: %v %v %v", stats[0].IP, stats[1].IP, stats[2].IP)
+	}
+}
+
+func TestRunOneDomainExclude(t *testing.T) {
+	cfg := Config{
+		Port:        443,
+		Timeout:     500 * time.Millisecond,
+		Attempts:    1,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+		Exclude:     func(ip netip.Addr) bool { return ip.IsLoopback() },
+	}
+	res := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{})
+	if res.Err == nil || res.Err.Error() != "all 1 candidate ips excluded" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	if _, err := ParseBlocklist("1.2.3.4 bogus"); err == nil {
+		t.Fatal("expected error for invalid entry")
+	}
+	list, err := ParseBlocklist(DefaultBlocklist + "\n10.0.0.0/8, 203.0.113.7 # isp redirect\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"127.0.0.1", "0.0.0.0", "::1", "10.1.2.3", "203.0.113.7", "::ffff:127.0.0.1"} {
+		if !inPrefixes(list, netip.MustParseAddr(s)) {
+			t.Fatalf("%s should be blocked", s)
+		}
+	}
+	if inPrefixes(list, netip.MustParseAddr("203.0.113.8")) {
+		t.Fatal("203.0.113.8 should not be blocked")
+	}
+
+	cfg := Config{Port: 443, Timeout: 500 * time.Millisecond, Attempts: 1, Concurrency: 1, IPv4: true, KeepBogons: true, Blocklist: list}
+	res := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{})
+	if res.Err == nil || res.Err.Error() != "all 1 candidate ips blocklisted" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	cfg.Blocklist = nil
+	cfg.Allow = map[string][]netip.Prefix{"127.0.0.1": {netip.MustParsePrefix("10.0.0.0/8")}}
+	res = RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{})
+	if res.Err == nil || res.Err.Error() != "all 1 candidate ips outside allow-list" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestGroupByPrefix(t *testing.T) {
+	var cands []Candidate
+	for _, s := range []string{"1.1.1.1", "1.1.2.1", "1.1.1.9", "2606:4700::1", "2606:4700:0:1::2", "2606:4701::1"} {
+		cands = append(cands, Candidate{IP: netip.MustParseAddr(s)})
+	}
+	groups := GroupByPrefix(cands)
+	sizes := make([]int, len(groups))
+	for i, g := range groups {
+		sizes[i] = len(g)
+	}
+	if len(groups) != 4 || sizes[0] != 2 || sizes[1] != 1 || sizes[2] != 2 || sizes[3] != 1 {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+	if groups[0][1].IP != netip.MustParseAddr("1.1.1.9") {
+		t.Fatalf("unexpected group member order: %v", groups[0])
+	}
+}
+
+func TestRunOneDomainSeed(t *testing.T) {
+	cfg := Config{
+		Port:        1,
+		Timeout:     200 * time.Millisecond,
+		Attempts:    1,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+		Seed: func(domain string, resolved []Candidate) []Candidate {
+			return []Candidate{resolved[0], {IP: netip.MustParseAddr("127.0.0.2"), ResolvedVia: "cdn:test"}}
+		},
+	}
+	res := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{})
+	if len(res.Candidates) != 2 {
+		t.Fatalf("expected resolved + seeded candidate, got %+v", res.Candidates)
+	}
+	var seeded bool
+	for _, c := range res.Candidates {
+		seeded = seeded || c.ResolvedVia == "cdn:test"
+	}
+	if !seeded {
+		t.Fatalf("seeded candidate missing: %+v", res.Candidates)
+	}
+}
+
+func TestRunOneDomainCandidates(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := Config{
+		Port:        ln.Addr().(*net.TCPAddr).Port,
+		Timeout:     200 * time.Millisecond,
+		Attempts:    1,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+		Seed: func(domain string, resolved []Candidate) []Candidate {
+			return []Candidate{{IP: netip.MustParseAddr("127.0.0.2")}, {IP: netip.MustParseAddr("127.0.0.3")}}
+		},
+	}
+	var sizes []int
+	res := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{
+		OnCandidate: func(r model.DomainResult) {
+			sizes = append(sizes, len(r.Candidates))
+			if r.Best.IP != r.Candidates[0].IP {
+				t.Errorf("best %s is not the first ranked candidate", r.Best.IP)
+			}
+		},
+	})
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	if len(sizes) != 3 || sizes[0] != 1 || sizes[2] != 3 {
+		t.Fatalf("candidate snapshots = %v, want 1..3", sizes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res = RunOneDomain(ctx, "127.0.0.1", cfg, Callbacks{
+		OnCandidate: func(model.DomainResult) { cancel() },
+	})
+	if res.Err == nil || len(res.Candidates) != 1 || res.Best.IP != res.Candidates[0].IP {
+		t.Fatalf("stopped early: err = %v, candidates = %+v", res.Err, res.Candidates)
+	}
+}
+
+func TestRunSkipDomain(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	skip := NewSkipper()
+	skip.Skip("127.0.0.3")
+	cfg := Config{
+		Port:        ln.Addr().(*net.TCPAddr).Port,
+		Timeout:     200 * time.Millisecond,
+		Attempts:    1,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+		Skipper:     skip,
+		Seed: func(domain string, resolved []Candidate) []Candidate {
+			if domain != "127.0.0.2" {
+				return nil
+			}
+			return []Candidate{{IP: netip.MustParseAddr("127.0.0.4")}, {IP: netip.MustParseAddr("127.0.0.5")}}
+		},
+	}
+	var mu sync.Mutex
+	results := map[string]model.DomainResult{}
+	err = Run(context.Background(), []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}, cfg, Callbacks{
+		OnCandidate: func(r model.DomainResult) {
+			if r.Domain == "127.0.0.2" {
+				skip.Skip(r.Domain)
+			}
+		},
+		OnResult: func(r model.DomainResult) {
+			mu.Lock()
+			results[r.Domain] = r
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results["127.0.0.1"]; r.Err != nil {
+		t.Fatalf("untouched domain failed: %v", r.Err)
+	}
+	if r := results["127.0.0.2"]; !errors.Is(r.Err, ErrSkipped) || len(r.Candidates) != 1 {
+		t.Fatalf("skipped mid-probe: err = %v, candidates = %d", r.Err, len(r.Candidates))
+	}
+	if r := results["127.0.0.3"]; !errors.Is(r.Err, ErrSkipped) || len(r.Candidates) != 0 {
+		t.Fatalf("skipped before start: err = %v, candidates = %d", r.Err, len(r.Candidates))
+	}
+}
+
+func TestRunOneDomainResultCache(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := Config{
+		Port:        ln.Addr().(*net.TCPAddr).Port,
+		Timeout:     200 * time.Millisecond,
+		Attempts:    3,
+		Concurrency: 1,
+		IPv4:        true,
+		KeepBogons:  true,
+		ResultCache: NewResultCache(),
+	}
+	first := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{})
+	if first.Err != nil || first.Cached {
+		t.Fatalf("first run: err = %v, cached = %v", first.Err, first.Cached)
+	}
+	second := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{})
+	if !second.Cached || second.Best.Attempts() != first.Best.Attempts() {
+		t.Fatalf("second run should reuse stats: cached = %v, attempts = %d", second.Cached, second.Best.Attempts())
+	}
+
+	ln.Close()
+	third := RunOneDomain(context.Background(), "127.0.0.1", cfg, Callbacks{})
+	if third.Cached || third.Best.Successes != 0 {
+		t.Fatalf("failed health probe should force a full run: %+v", third)
+	}
+	if _, ok := cfg.ResultCache.lookup("127.0.0.1", candidateKey(cfg.Port, []Candidate{{IP: netip.MustParseAddr("127.0.0.1")}})); ok {
+		t.Fatal("unreachable result should not be reusable")
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	at := newAdaptiveTimeout(2*time.Second, true)
+	for i := 0; i < adaptiveMinSamples-1; i++ {
+		at.observe(200 * time.Millisecond)
+	}
+	if got := at.timeout(); got != 2*time.Second {
+		t.Fatalf("timeout before enough samples = %v", got)
+	}
+	at.observe(200 * time.Millisecond)
+	if got := at.timeout(); got != 600*time.Millisecond {
+		t.Fatalf("timeout = %v, want 600ms", got)
+	}
+	for i := 0; i < adaptiveWindow; i++ {
+		at.observe(time.Millisecond)
+	}
+	if got := at.timeout(); got != adaptiveFloor {
+		t.Fatalf("timeout = %v, want floor %v", got, adaptiveFloor)
+	}
+
+	fixed := newAdaptiveTimeout(time.Second, false)
+	for i := 0; i < adaptiveWindow; i++ {
+		fixed.observe(time.Millisecond)
+	}
+	if got := fixed.timeout(); got != time.Second {
+		t.Fatalf("non-adaptive timeout = %v", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if NewRateLimiter(0) != nil {
+		t.Fatalf("expected nil limiter for zero rate")
+	}
+	var nilLim *RateLimiter
+	if err := nilLim.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	lim := NewRateLimiter(50)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := lim.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if el := time.Since(start); el < 90*time.Millisecond {
+		t.Fatalf("6 waits at 50/s took %v, want >= 100ms", el)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewRateLimiter(0.1)
+	_ = slow.Wait(ctx)
+	if err := slow.Wait(ctx); err == nil {
+		t.Fatalf("expected context error")
+	}
+}
+
+func TestTracerouteLoopback(t *testing.T) {
+	hops, err := Traceroute(context.Background(), netip.MustParseAddr("127.0.0.1"), 3, time.Second)
+	if err != nil {
+		t.Skipf("icmp unavailable: %v", err)
+	}
+	if len(hops) != 1 || hops[0].TTL != 1 || hops[0].IP != netip.MustParseAddr("127.0.0.1") {
+		t.Fatalf("unexpected hops: %+v", hops)
+	}
+}
+
+func TestTraceReplyMatching(t *testing.T) {
+	if traceID() == traceID() {
+		t.Fatalf("traceroutes share an icmp id")
+	}
+	inner := make([]byte, 28)
+	inner[0] = 0x45
+	copy(inner[16:20], []byte{10, 0, 0, 1})
+	binary.BigEndian.PutUint16(inner[24:26], 0x1234)
+	binary.BigEndian.PutUint16(inner[26:28], 5)
+	m := &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: inner}}
+	id, seq, dst, final, ok := echoIDSeq(m, false)
+	if !ok || final || id != 0x1234 || seq != 5 || dst != netip.MustParseAddr("10.0.0.1") {
+		t.Fatalf("echoIDSeq = %#x %d %v %v %v", id, seq, dst, final, ok)
+	}
+}
+
+func TestMeasureLoss(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+	ip := netip.MustParseAddr("127.0.0.1")
+	open := ln.Addr().(*net.TCPAddr).Port
+
+	if sent, lost := measureLoss(context.Background(), ip, open, 500*time.Millisecond, nil, 5, 10*time.Millisecond); sent != 5 || lost != 0 {
+		t.Fatalf("open port: sent=%d lost=%d", sent, lost)
+	}
+	if sent, lost := measureLoss(context.Background(), ip, 9, 500*time.Millisecond, nil, 4, 10*time.Millisecond); sent != 4 || lost != 4 {
+		t.Fatalf("closed port: sent=%d lost=%d", sent, lost)
+	}
+
+	lossy := model.CandidateStat{IP: netip.MustParseAddr("1.1.1.1"), Successes: 3, BurstSent: 20, BurstLost: 4}
+	clean := model.CandidateStat{IP: netip.MustParseAddr("2.2.2.2"), Successes: 3, BurstSent: 20}
+	unmeasured := model.CandidateStat{IP: netip.MustParseAddr("3.3.3.3"), Successes: 3}
+	stats := []model.CandidateStat{unmeasured, lossy, clean}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != clean.IP || stats[1].IP != lossy.IP || stats[2].IP != unmeasured.IP {
+		t.Fatalf("unexpected order: %v %v %v", stats[0].IP, stats[1].IP, stats[2].IP)
+	}
+
+	blackholed := model.CandidateStat{IP: netip.MustParseAddr("4.4.4.4"), Successes: 3, BurstSent: 20, MTUChecked: true, MTUBlackhole: true}
+	unreliable := model.CandidateStat{IP: netip.MustParseAddr("5.5.5.5"), Successes: 1, Failures: 2, BurstSent: 20}
+	stats = []model.CandidateStat{blackholed, unreliable, lossy}
+	sortCandidates(stats, nil, 0, JitterStddev, 0, TieIndifferent)
+	if stats[0].IP != lossy.IP {
+		t.Fatalf("loss outranked blackhole or success checks: best = %v", stats[0].IP)
+	}
+}
+
+func TestConcurrencyTuner(t *testing.T) {
+	var logs []string
+	tu := newConcurrencyTuner(8, nil, func(s string) { logs = append(logs, s) })
+	window := func(d time.Duration, fails int) {
+		for i := 0; i < tuneWindow; i++ {
+			tu.observe(d+time.Duration(i%4)*time.Millisecond, i >= fails)
+		}
+	}
+
+	window(20*time.Millisecond, 0)
+	window(20*time.Millisecond, 0)
+	if tu.limit != 4 {
+		t.Fatalf("limit after two clean windows = %d, want 4", tu.limit)
+	}
+	window(20*time.Millisecond, tuneWindow/2)
+	if tu.limit != 2 {
+		t.Fatalf("limit after failing window = %d, want 2", tu.limit)
+	}
+	window(60*time.Millisecond, 0)
+	if tu.limit != 1 {
+		t.Fatalf("limit after slow window = %d, want 1", tu.limit)
+	}
+	if len(logs) != 4 || !strings.HasPrefix(logs[2], "auto concurrency: 4 -> 2") {
+		t.Fatalf("logs = %q", logs)
+	}
+}
+
+func TestProbeSchedulerRoundRobin(t *testing.T) {
+	s := newProbeScheduler(1)
+	if err := s.acquire(context.Background(), "hold"); err != nil {
+		t.Fatal(err)
+	}
+	queued := func(n int) {
+		for {
+			s.mu.Lock()
+			c := 0
+			for _, q := range s.queues {
+				c += len(q)
+			}
+			s.mu.Unlock()
+			if c == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	for i, d := range []string{"a", "a", "a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.acquire(context.Background(), d); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, d)
+			mu.Unlock()
+			s.release()
+		}()
+		queued(i + 1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- s.acquire(ctx, "c") }()
+	queued(5)
+	cancel()
+	if err := <-errCh; err == nil {
+		t.Fatal("cancelled acquire succeeded")
+	}
+
+	s.release()
+	wg.Wait()
+	if got := strings.Join(order, ""); got != "abaa" {
+		t.Fatalf("grant order = %q, want abaa", got)
+	}
+	if s.active != 0 || len(s.order) != 0 {
+		t.Fatalf("active = %d, order = %q after drain", s.active, s.order)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+	cfg := Config{Port: ln.Addr().(*net.TCPAddr).Port, Timeout: time.Second}
+
+	loopback := netip.MustParseAddr("127.0.0.1")
+	if v := Verify(context.Background(), "localhost", []netip.Addr{loopback}, nil, cfg); !v.OK() || v.IP != loopback {
+		t.Fatalf("verify localhost: ip=%v err=%v", v.IP, v.Err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	v := Verify(ctx, "localhost", []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil, cfg)
+	if v.OK() || v.Unreachable() || !strings.Contains(v.Err.Error(), "not the written ip") {
+		t.Fatalf("mismatched mapping verified: %+v", v)
+	}
+
+	ln.Close()
+	if v := Verify(context.Background(), "localhost", []netip.Addr{loopback}, []netip.Addr{loopback}, cfg); !v.Unreachable() || v.Regressed() {
+		t.Fatalf("closed port not reported unreachable: %+v", v)
+	}
+
+	prev, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip("127.0.0.2 not available:", err)
+	}
+	defer prev.Close()
+	cfg.Port = prev.Addr().(*net.TCPAddr).Port
+	before := netip.MustParseAddr("127.0.0.2")
+	if v := Verify(context.Background(), "localhost", []netip.Addr{loopback}, []netip.Addr{before}, cfg); !v.Regressed() || v.Previous != before {
+		t.Fatalf("reachable previous mapping not reported: %+v", v)
+	}
+}
+
+func TestShouldRollBack(t *testing.T) {
+	ip := netip.MustParseAddr("192.0.2.1")
+	prev := netip.MustParseAddr("192.0.2.2")
+	ok := Verification{IP: ip}
+	regressed := Verification{IP: ip, Err: errors.New("refused"), Previous: prev}
+	wasDown := Verification{IP: ip, Err: errors.New("refused")}
+	notPicked := Verification{Err: errors.New("not the written ip")}
+
+	cases := []struct {
+		name string
+		vs   []Verification
+		want bool
+	}{
+		{"all ok", []Verification{ok, ok, ok}, false},
+		{"one of three regressed", []Verification{regressed, ok, ok}, true},
+		{"one of four regressed", []Verification{regressed, ok, ok, ok}, false},
+		{"unreachable before the write", []Verification{wasDown, wasDown, ok}, false},
+		{"mapping not picked up", []Verification{notPicked, notPicked}, false},
+		{"regressed among outages", []Verification{regressed, wasDown, wasDown}, true},
+		{"empty", nil, false},
+	}
+	for _, c := range cases {
+		if got := ShouldRollBack(c.vs); got != c.want {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestResolverTally(t *testing.T) {
+	best := netip.MustParseAddr("10.0.0.1")
+	other := netip.MustParseAddr("10.0.0.2")
+	var tally ResolverTally
+	tally.Add([]model.ResolverQuery{
+		{Server: "system", Type: "A", OK: true, RTT: 10 * time.Millisecond, Addrs: []netip.Addr{other}},
+		{Server: "8.8.8.8:53", Type: "A", OK: true, RTT: 30 * time.Millisecond, Addrs: []netip.Addr{best, other}},
+		{Server: "8.8.8.8:53", Type: "AAAA", OK: true, RTT: 10 * time.Millisecond},
+		{Server: "9.9.9.9:53", Type: "A", RTT: 2 * time.Second},
+	}, best)
+	tally.Add([]model.ResolverQuery{
+		{Server: "8.8.8.8:53", Type: "A", OK: true, Cached: true, Addrs: []netip.Addr{best}},
+	}, best)
+
+	stats := tally.Stats()
+	if len(stats) != 3 || stats[0].Server != "system" || stats[1].Server != "8.8.8.8:53" || stats[2].Server != "9.9.9.9:53" {
+		t.Fatalf("stats = %+v", stats)
+	}
+	g := stats[1]
+	if g.Queries != 2 || g.Successes != 2 || g.AvgRTT() != 20*time.Millisecond {
+		t.Fatalf("cached answers must not count as queries: %+v avg %v", g, g.AvgRTT())
+	}
+	if g.Domains != 2 || g.BestHits != 2 {
+		t.Fatalf("8.8.8.8 domains/best = %d/%d", g.Domains, g.BestHits)
+	}
+	if s := stats[0]; s.BestHits != 0 || s.Domains != 1 {
+		t.Fatalf("system = %+v", s)
+	}
+	if q := stats[2]; q.Queries != 1 || q.Successes != 0 || q.AvgRTT() != 0 || q.Domains != 0 {
+		t.Fatalf("failing resolver = %+v", q)
+	}
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+type Level string
+
+const (
+	LevelInfo  Level = "info"
+	LevelWarn  Level = "warn"
+	LevelError Level = "error"
+)
+
+type Event struct {
+	Time   time.Time `json:"ts"`
+	Level  Level     `json:"level"`
+	Domain string    `json:"domain,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	RTTMs  float64   `json:"rtt_ms,omitempty"`
+	Rate   *float64  `json:"success_rate,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Msg    string    `json:"msg"`
+}
+
+func (cb Callbacks) event(ev Event) {
+	if cb.OnEvent == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	cb.OnEvent(ev)
+}
+
+func (cb Callbacks) probe(domain string, st model.CandidateStat) {
+	rate := st.SuccessRate()
+	ev := Event{Level: LevelInfo, Domain: domain, IP: st.IP.String(), Rate: &rate, Msg: "probe"}
+	if st.Successes > 0 {
+		ev.RTTMs = durationMs(st.P95)
+	} else {
+		ev.Level = LevelWarn
+		ev.Error = st.LastError
+	}
+	cb.event(ev)
+}
+
+func (cb Callbacks) result(res model.DomainResult) {
+	if res.Err != nil {
+		cb.event(Event{Level: LevelError, Domain: res.Domain, Error: res.Err.Error(), Msg: "result"})
+		return
+	}
+	rate := res.Best.SuccessRate()
+	ev := Event{Level: LevelInfo, Domain: res.Domain, IP: res.Best.IP.String(), Rate: &rate, Msg: "result"}
+	if res.Best.Successes > 0 {
+		ev.RTTMs = durationMs(res.Best.P95)
+	} else {
+		ev.Level = LevelWarn
+		ev.Error = res.Best.LastError
+	}
+	cb.event(ev)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+type JitterMetric string
+
+const (
+	JitterStddev  JitterMetric = "stddev"
+	JitterRFC3550 JitterMetric = "rfc3550"
+)
+
+func (m JitterMetric) Of(st model.CandidateStat) time.Duration {
+	if m == JitterRFC3550 {
+		return st.JitterRFC
+	}
+	return st.JitterStd
+}
+
+// rfc3550Jitter smooths consecutive RTT differences with gain 1/16 as in
+// RFC 3550 section 6.4.1. The estimate is seeded with the first difference
+// because a handful of probes would otherwise never move it off zero.
+func rfc3550Jitter(samples []time.Duration) time.Duration {
+	if len(samples) < 2 {
+		return 0
+	}
+	var j float64
+	for i := 1; i < len(samples); i++ {
+		d := float64(samples[i] - samples[i-1])
+		if d < 0 {
+			d = -d
+		}
+		if i == 1 {
+			j = d
+			continue
+		}
+		j += (d - j) / 16
+	}
+	return time.Duration(j)
+}
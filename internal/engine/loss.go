@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+const (
+	lossTopCandidates   = 3
+	defaultLossInterval = 100 * time.Millisecond
+)
+
+func measureLoss(ctx context.Context, ip netip.Addr, port int, timeout time.Duration, lim *RateLimiter, burst int, interval time.Duration) (sent, lost int) {
+	if interval <= 0 {
+		interval = defaultLossInterval
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; i < burst; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+		if lim.Wait(ctx) != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := tcpPing(ctx, ip, port, timeout)
+			if ctx.Err() != nil {
+				return
+			}
+			mu.Lock()
+			sent++
+			if err != nil {
+				lost++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return sent, lost
+}
+
+func burstLoss(st model.CandidateStat) float64 {
+	if r, ok := st.LossRate(); ok {
+		return r
+	}
+	return 1
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+type MonitorTarget struct {
+	Domain string
+	IP     netip.Addr
+}
+
+type MonitorSample struct {
+	Domain  string
+	IP      netip.Addr
+	Time    time.Time
+	Latency time.Duration
+	Err     error
+}
+
+func (s MonitorSample) OK() bool { return s.Err == nil }
+
+func Monitor(ctx context.Context, targets []MonitorTarget, cfg Config, interval time.Duration, onSample func(MonitorSample)) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return errors.New("invalid interval")
+	}
+	if len(targets) == 0 {
+		return errors.New("empty target list")
+	}
+
+	sem := make(chan struct{}, cfg.Concurrency)
+	round := func() {
+		var wg sync.WaitGroup
+		for _, t := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(t MonitorTarget) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if cfg.RateLimit.Wait(ctx) != nil {
+					return
+				}
+				start := time.Now()
+				d, err := tcpPing(ctx, t.IP, cfg.portFor(t.Domain), cfg.Timeout)
+				if ctx.Err() != nil {
+					return
+				}
+				if onSample != nil {
+					onSample(MonitorSample{Domain: t.Domain, IP: t.IP, Time: start, Latency: d, Err: err})
+				}
+			}(t)
+		}
+		wg.Wait()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		round()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// tlsPorts are the ports the large-packet check runs on. On anything else
+// a TLS handshake can stall for reasons that have nothing to do with MTU.
+var tlsPorts = map[int]bool{443: true, 465: true, 853: true, 993: true, 995: true, 8443: true}
+
+// largePacketCheck compares a TLS handshake whose ClientHello is larger than
+// one full-size segment (hybrid post-quantum key share) with an X25519-only
+// one that fits in a single packet. Only when the small handshake finishes
+// and the large one times out is the IP a blackhole; a server that is slow
+// or does not speak TLS leaves checked false.
+func largePacketCheck(ctx context.Context, serverName string, ip netip.Addr, port int, timeout time.Duration, lim *RateLimiter) (checked, blackhole bool, err error) {
+	err = tlsHandshake(ctx, serverName, ip, port, timeout, tls.X25519MLKEM768, tls.X25519)
+	if err == nil {
+		return true, false, nil
+	}
+	if ctx.Err() != nil {
+		return false, false, ctx.Err()
+	}
+	if !isTimeout(err) {
+		return false, false, err
+	}
+	if werr := lim.Wait(ctx); werr != nil {
+		return false, false, werr
+	}
+	if serr := tlsHandshake(ctx, serverName, ip, port, timeout, tls.X25519); serr != nil {
+		return false, false, serr
+	}
+	return true, true, err
+}
+
+func tlsHandshake(ctx context.Context, serverName string, ip netip.Addr, port int, timeout time.Duration, curves ...tls.CurveID) error {
+	hctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(hctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tc := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		CurvePreferences:   curves,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	return tc.HandshakeContext(hctx)
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// OutlierFilter selects how slow spikes (a GC pause, a Wi-Fi retry) are kept
+// out of P50/P95/jitter. Only the slow side is filtered since an RTT cannot be
+// anomalously fast; the raw samples stay on the stat either way.
+type OutlierFilter string
+
+const (
+	OutliersKeep OutlierFilter = ""
+	OutliersTrim OutlierFilter = "trim"
+	OutliersMAD  OutlierFilter = "mad"
+)
+
+const (
+	outlierMinSamples = 3
+	trimFraction      = 0.10
+	madCutoff         = 3
+	// madScale turns the median absolute deviation into a stddev estimate
+	// for normally distributed data.
+	madScale = 1.4826
+	// madFloorRatio keeps a small wobble around a tight median from being
+	// rejected when the MAD is (nearly) zero.
+	madFloorRatio = 0.25
+)
+
+// filter returns samples without outliers, preserving their order.
+func (f OutlierFilter) filter(samples []time.Duration) []time.Duration {
+	if len(samples) < outlierMinSamples {
+		return samples
+	}
+	drop := make([]bool, len(samples))
+	switch f {
+	case OutliersTrim:
+		idx := make([]int, len(samples))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(a, b int) bool { return samples[idx[a]] > samples[idx[b]] })
+		for _, i := range idx[:int(math.Ceil(float64(len(samples))*trimFraction))] {
+			drop[i] = true
+		}
+	case OutliersMAD:
+		med := Quantile(samples, 0.5)
+		dev := make([]time.Duration, len(samples))
+		for i, s := range samples {
+			dev[i] = max(s-med, med-s)
+		}
+		spread := max(time.Duration(madCutoff*madScale*float64(Quantile(dev, 0.5))), time.Duration(madFloorRatio*float64(med)))
+		for i, s := range samples {
+			drop[i] = s > med+spread
+		}
+	default:
+		return samples
+	}
+	kept := make([]time.Duration, 0, len(samples))
+	for i, s := range samples {
+		if !drop[i] {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
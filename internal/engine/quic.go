@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+func quicPing(ctx context.Context, serverName string, ip netip.Addr, port int, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tlsConf := &tls.Config{ServerName: serverName, NextProtos: []string{"h3"}}
+	start := time.Now()
+	conn, err := quic.DialAddr(ctx, net.JoinHostPort(ip.String(), strconv.Itoa(port)), tlsConf, &quic.Config{HandshakeIdleTimeout: timeout})
+	if err != nil {
+		return 0, err
+	}
+	d := time.Since(start)
+	_ = conn.CloseWithError(0, "")
+	return d, nil
+}
+
+func probeQUIC(ctx context.Context, st *model.CandidateStat, serverName string, port int, timeout time.Duration, lim *RateLimiter, attempts int) {
+	var samples []time.Duration
+	for i := 0; i < attempts; i++ {
+		if err := lim.Wait(ctx); err != nil {
+			st.QUICLastError = err.Error()
+			break
+		}
+		d, err := quicPing(ctx, serverName, st.IP, port, timeout)
+		if err != nil {
+			st.QUICFailures++
+			st.QUICLastError = err.Error()
+			continue
+		}
+		st.QUICSuccesses++
+		samples = append(samples, d)
+	}
+	if len(samples) > 0 {
+		st.QUICP50 = Quantile(samples, 0.50)
+		st.QUICP95 = Quantile(samples, 0.95)
+	} else {
+		st.QUICP50 = timeout
+		st.QUICP95 = timeout
+	}
+}
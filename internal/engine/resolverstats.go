@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+// ResolverStat summarizes one DNS server over a run. Cached answers count
+// towards BestHits but not towards Queries or the response time, since the
+// server was not asked again.
+type ResolverStat struct {
+	Server    string
+	Queries   int
+	Successes int
+	Domains   int
+	BestHits  int
+	rttTotal  time.Duration
+}
+
+func (s ResolverStat) AvgRTT() time.Duration {
+	if s.Successes == 0 {
+		return 0
+	}
+	return s.rttTotal / time.Duration(s.Successes)
+}
+
+// ResolverTally accumulates ResolverStat per server in the order the servers
+// are first seen.
+type ResolverTally struct {
+	order []string
+	stats map[string]*ResolverStat
+}
+
+// Add counts one domain's queries. best is the IP chosen for the domain, or
+// the zero Addr if none was; a server gets a BestHit when any of its answers
+// for the domain contained best.
+func (t *ResolverTally) Add(queries []model.ResolverQuery, best netip.Addr) {
+	if t.stats == nil {
+		t.stats = map[string]*ResolverStat{}
+	}
+	answered := map[string]bool{}
+	hit := map[string]bool{}
+	for _, q := range queries {
+		s := t.stats[q.Server]
+		if s == nil {
+			s = &ResolverStat{Server: q.Server}
+			t.stats[q.Server] = s
+			t.order = append(t.order, q.Server)
+		}
+		if !q.Cached {
+			s.Queries++
+			if q.OK {
+				s.Successes++
+				s.rttTotal += q.RTT
+			}
+		}
+		if q.OK && len(q.Addrs) > 0 {
+			answered[q.Server] = true
+		}
+		if best.IsValid() && slices.Contains(q.Addrs, best) {
+			hit[q.Server] = true
+		}
+	}
+	for srv := range answered {
+		t.stats[srv].Domains++
+	}
+	for srv := range hit {
+		t.stats[srv].BestHits++
+	}
+}
+
+func (t *ResolverTally) Stats() []ResolverStat {
+	out := make([]ResolverStat, 0, len(t.order))
+	for _, srv := range t.order {
+		out = append(out, *t.stats[srv])
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+// ResultCache remembers each domain's last full result together with the
+// candidate set it was measured on, so an unchanged domain can be refreshed
+// with a single health probe of its best IP instead of a full run.
+type ResultCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+}
+
+type cachedResult struct {
+	key string
+	res model.DomainResult
+}
+
+func NewResultCache() *ResultCache {
+	return &ResultCache{entries: map[string]cachedResult{}}
+}
+
+func (c *ResultCache) lookup(domain, key string) (model.DomainResult, bool) {
+	if c == nil {
+		return model.DomainResult{}, false
+	}
+	c.mu.Lock()
+	e, ok := c.entries[domain]
+	c.mu.Unlock()
+	if !ok || e.key != key || e.res.Best.Successes == 0 {
+		return model.DomainResult{}, false
+	}
+	res := e.res
+	res.Candidates = slices.Clone(res.Candidates)
+	return res, true
+}
+
+func (c *ResultCache) store(domain, key string, res model.DomainResult) {
+	if c == nil || res.Err != nil {
+		return
+	}
+	res.Candidates = slices.Clone(res.Candidates)
+	res.Cached = false
+	c.mu.Lock()
+	c.entries[domain] = cachedResult{key: key, res: res}
+	c.mu.Unlock()
+}
+
+func candidateKey(port int, candidates []Candidate) string {
+	ips := make([]string, len(candidates))
+	for i, c := range candidates {
+		ips[i] = c.IP.String()
+	}
+	slices.Sort(ips)
+	return strconv.Itoa(port) + "|" + strings.Join(ips, ",")
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// probeScheduler hands out a global budget of probe slots. Waiting probes are
+// queued per domain and slots are granted round-robin across domains, so a
+// domain with many candidates cannot starve the others.
+type probeScheduler struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	queues map[string][]chan struct{}
+	order  []string
+}
+
+func newProbeScheduler(limit int) *probeScheduler {
+	return &probeScheduler{limit: max(1, limit), queues: map[string][]chan struct{}{}}
+}
+
+func (s *probeScheduler) acquire(ctx context.Context, domain string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.active < s.limit && len(s.order) == 0 {
+		s.active++
+		s.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	if len(s.queues[domain]) == 0 {
+		s.order = append(s.order, domain)
+	}
+	s.queues[domain] = append(s.queues[domain], ch)
+	s.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	q := s.queues[domain]
+	for i, c := range q {
+		if c != ch {
+			continue
+		}
+		s.queues[domain] = append(q[:i:i], q[i+1:]...)
+		if len(s.queues[domain]) == 0 {
+			s.dropDomain(domain)
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+	s.mu.Unlock()
+	// The slot was granted while ctx was being cancelled; hand it back.
+	s.release()
+	return ctx.Err()
+}
+
+func (s *probeScheduler) release() {
+	s.mu.Lock()
+	s.active--
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+func (s *probeScheduler) setLimit(n int) {
+	s.mu.Lock()
+	s.limit = max(1, n)
+	s.dispatch()
+	s.mu.Unlock()
+}
+
+func (s *probeScheduler) dispatch() {
+	for s.active < s.limit && len(s.order) > 0 {
+		d := s.order[0]
+		s.order = s.order[1:]
+		q := s.queues[d]
+		close(q[0])
+		s.active++
+		if len(q) > 1 {
+			s.queues[d] = q[1:]
+			s.order = append(s.order, d)
+		} else {
+			delete(s.queues, d)
+		}
+	}
+}
+
+func (s *probeScheduler) dropDomain(domain string) {
+	delete(s.queues, domain)
+	for i, d := range s.order {
+		if d == domain {
+			s.order = append(s.order[:i:i], s.order[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+// The score is for display only: candidates are still ranked by the ordered
+// criteria in better, but the weights follow the same priority so the number
+// agrees with the ranking in all but near-tie cases.
+const (
+	scoreSuccess   = 50
+	scoreLatency   = 35
+	scoreJitter    = 15
+	scoreLoss      = 20
+	scoreBlackhole = 30
+
+	scoreLatencyRef = 500 * time.Millisecond
+	scoreJitterRef  = 50 * time.Millisecond
+)
+
+type ScoreKind string
+
+const (
+	ScoreSuccess   ScoreKind = "success"
+	ScoreLatency   ScoreKind = "p95"
+	ScoreJitter    ScoreKind = "jitter"
+	ScoreLoss      ScoreKind = "loss"
+	ScoreBlackhole ScoreKind = "mtu"
+)
+
+type ScorePart struct {
+	Kind   ScoreKind
+	Rate   float64
+	Value  time.Duration
+	Points float64
+}
+
+// Score sums the parts: up to 50 for the success rate (Wilson lower bound), 35
+// for p95 and 15 for jitter, scaled linearly down to 0 at 500ms and 50ms, with
+// penalties for measured loss and a suspected MTU blackhole.
+func Score(st model.CandidateStat, jitter JitterMetric) (float64, []ScorePart) {
+	parts := []ScorePart{{Kind: ScoreSuccess, Rate: st.SuccessRate(), Points: scoreSuccess * st.SuccessLowerBound()}}
+	if st.Successes > 0 {
+		j := jitter.Of(st)
+		parts = append(parts,
+			ScorePart{Kind: ScoreLatency, Value: st.P95, Points: scoreLatency * linear(st.P95, scoreLatencyRef)},
+			ScorePart{Kind: ScoreJitter, Value: j, Points: scoreJitter * linear(j, scoreJitterRef)},
+		)
+	}
+	if r, ok := st.LossRate(); ok && r > 0 {
+		parts = append(parts, ScorePart{Kind: ScoreLoss, Rate: r, Points: -scoreLoss * r})
+	}
+	if st.MTUBlackhole {
+		parts = append(parts, ScorePart{Kind: ScoreBlackhole, Points: -scoreBlackhole})
+	}
+	var total float64
+	for _, p := range parts {
+		total += p.Points
+	}
+	return max(0, total), parts
+}
+
+func linear(d, ref time.Duration) float64 {
+	return max(0, 1-float64(d)/float64(ref))
+}
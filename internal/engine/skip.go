@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrSkipped = errors.New("domain skipped")
+
+// Skipper cancels single domains of a running Run while the others carry on.
+// A domain skipped before a worker picks it up is dropped without probing.
+type Skipper struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	skipped map[string]bool
+}
+
+func NewSkipper() *Skipper {
+	return &Skipper{cancels: map[string]context.CancelFunc{}, skipped: map[string]bool{}}
+}
+
+func (s *Skipper) Skip(domain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped[domain] = true
+	if cancel, ok := s.cancels[domain]; ok {
+		cancel()
+	}
+}
+
+func (s *Skipper) begin(ctx context.Context, domain string) (context.Context, func()) {
+	if s == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	if s.skipped[domain] {
+		cancel()
+	} else {
+		s.cancels[domain] = cancel
+	}
+	s.mu.Unlock()
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.cancels, domain)
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+func (s *Skipper) isSkipped(domain string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skipped[domain]
+}
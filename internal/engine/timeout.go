@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	adaptiveWindow     = 256
+	adaptiveMinSamples = 8
+	adaptiveFactor     = 3
+	adaptiveFloor      = 100 * time.Millisecond
+)
+
+type adaptiveTimeout struct {
+	base     time.Duration
+	adaptive bool
+	tuner    *concurrencyTuner
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newAdaptiveTimeout(base time.Duration, adaptive bool) *adaptiveTimeout {
+	return &adaptiveTimeout{base: base, adaptive: adaptive}
+}
+
+func (a *adaptiveTimeout) timeout() time.Duration {
+	if !a.adaptive {
+		return a.base
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.samples) < adaptiveMinSamples {
+		return a.base
+	}
+	t := Quantile(a.samples, 0.5) * adaptiveFactor
+	return min(max(t, adaptiveFloor), a.base)
+}
+
+func (a *adaptiveTimeout) observe(d time.Duration) {
+	if a.tuner != nil {
+		a.tuner.observe(d, true)
+	}
+	if !a.adaptive {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.samples) < adaptiveWindow {
+		a.samples = append(a.samples, d)
+		return
+	}
+	a.samples[a.next] = d
+	a.next = (a.next + 1) % adaptiveWindow
+}
+
+func (a *adaptiveTimeout) fail() {
+	if a.tuner != nil {
+		a.tuner.observe(0, false)
+	}
+}
@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+const traceMaxHops = 30
+
+var traceCalls atomic.Uint32
+
+// traceID gives every traceroute its own ICMP id. Traceroutes for several
+// domains run at once, and a raw socket sees every ICMP reply on the host.
+func traceID() int {
+	return int(uint16(os.Getpid()) ^ uint16(traceCalls.Add(1)*0x9e37))
+}
+
+func listenICMP(v6 bool) (*icmp.PacketConn, bool, error) {
+	raw, dgram := "ip4:icmp", "udp4"
+	if v6 {
+		raw, dgram = "ip6:ipv6-icmp", "udp6"
+	}
+	if c, err := icmp.ListenPacket(raw, ""); err == nil {
+		return c, true, nil
+	}
+	c, err := icmp.ListenPacket(dgram, "")
+	return c, false, err
+}
+
+func Traceroute(ctx context.Context, ip netip.Addr, maxHops int, timeout time.Duration) ([]model.Hop, error) {
+	ip = ip.Unmap()
+	v6 := ip.Is6()
+	conn, privileged, err := listenICMP(v6)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	proto, echo := 1, icmp.Type(ipv4.ICMPTypeEcho)
+	if v6 {
+		proto, echo = 58, ipv6.ICMPTypeEchoRequest
+	}
+	var dst net.Addr = &net.IPAddr{IP: ip.AsSlice(), Zone: ip.Zone()}
+	if !privileged {
+		dst = &net.UDPAddr{IP: ip.AsSlice(), Zone: ip.Zone()}
+	}
+	id := traceID()
+
+	var hops []model.Hop
+	buf := make([]byte, 1500)
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		if ctx.Err() != nil {
+			return hops, ctx.Err()
+		}
+		if v6 {
+			err = conn.IPv6PacketConn().SetHopLimit(ttl)
+		} else {
+			err = conn.IPv4PacketConn().SetTTL(ttl)
+		}
+		if err != nil {
+			return hops, err
+		}
+		msg := icmp.Message{Type: echo, Body: &icmp.Echo{ID: id, Seq: ttl, Data: []byte("ip-opt-gui")}}
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			return hops, err
+		}
+		start := time.Now()
+		if _, err := conn.WriteTo(b, dst); err != nil {
+			return hops, err
+		}
+		_ = conn.SetReadDeadline(start.Add(timeout))
+
+		hop := model.Hop{TTL: ttl}
+		reached := false
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			m, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil {
+				continue
+			}
+			rid, seq, to, final, ok := echoIDSeq(m, v6)
+			from := peerAddr(peer)
+			if !to.IsValid() {
+				to = from
+			}
+			if !ok || seq != ttl || to.WithZone("") != ip.WithZone("") || (privileged && rid != id) {
+				continue
+			}
+			hop.IP, hop.RTT, reached = from, time.Since(start), final
+			break
+		}
+		hops = append(hops, hop)
+		if reached {
+			break
+		}
+	}
+	return hops, nil
+}
+
+// echoIDSeq reads the echo id and sequence a reply answers. For ICMP errors
+// they come from the quoted original packet, along with the destination it
+// was sent to; an echo reply has no quote and leaves dst invalid.
+func echoIDSeq(m *icmp.Message, v6 bool) (id, seq int, dst netip.Addr, final, ok bool) {
+	var inner []byte
+	switch b := m.Body.(type) {
+	case *icmp.Echo:
+		if m.Type == ipv4.ICMPTypeEchoReply || m.Type == ipv6.ICMPTypeEchoReply {
+			return b.ID, b.Seq, netip.Addr{}, true, true
+		}
+		return 0, 0, netip.Addr{}, false, false
+	case *icmp.TimeExceeded:
+		inner = b.Data
+	case *icmp.DstUnreach:
+		inner, final = b.Data, true
+	default:
+		return 0, 0, netip.Addr{}, false, false
+	}
+	hl := 40
+	if !v6 {
+		if len(inner) < 20 {
+			return 0, 0, netip.Addr{}, false, false
+		}
+		hl = int(inner[0]&0x0f) * 4
+	}
+	if len(inner) < hl+8 {
+		return 0, 0, netip.Addr{}, false, false
+	}
+	if v6 {
+		dst = netip.AddrFrom16([16]byte(inner[24:40]))
+	} else {
+		dst = netip.AddrFrom4([4]byte(inner[16:20]))
+	}
+	id = int(binary.BigEndian.Uint16(inner[hl+4 : hl+6]))
+	seq = int(binary.BigEndian.Uint16(inner[hl+6 : hl+8]))
+	return id, seq, dst, final, true
+}
+
+func peerAddr(a net.Addr) netip.Addr {
+	var ip net.IP
+	switch v := a.(type) {
+	case *net.IPAddr:
+		ip = v.IP
+	case *net.UDPAddr:
+		ip = v.IP
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// Verification is the outcome of checking a written hosts mapping through the
+// system resolver, which is what browsers and other programs will see.
+type Verification struct {
+	Domain   string
+	Resolved []netip.Addr
+	IP       netip.Addr
+	Latency  time.Duration
+	Err      error
+	// Previous is an address from before the write that still accepted a
+	// connection when the written IP did not.
+	Previous netip.Addr
+}
+
+func (v Verification) OK() bool { return v.Err == nil }
+
+// Unreachable reports that the system resolver returned the written IP but
+// connecting to it failed, as opposed to the mapping not being picked up.
+func (v Verification) Unreachable() bool { return v.IP.IsValid() && v.Err != nil }
+
+// Regressed reports that the write broke the domain: the written IP is
+// unreachable while the previous mapping still answers.
+func (v Verification) Regressed() bool { return v.Unreachable() && v.Previous.IsValid() }
+
+// RollbackRatio is the share of verified domains that must have regressed
+// before a write is undone automatically.
+const RollbackRatio = 0.3
+
+// ShouldRollBack reports whether enough of vs regressed to undo the write.
+// Domains that were already unreachable before it do not count, so an outage
+// on the network or the sites themselves does not trigger a rollback.
+func ShouldRollBack(vs []Verification) bool {
+	down := 0
+	for _, v := range vs {
+		if v.Regressed() {
+			down++
+		}
+	}
+	return down > 0 && float64(down) >= RollbackRatio*float64(len(vs))
+}
+
+// Resolvers cache the hosts file briefly (Go's for 5s), so a lookup right
+// after writing may still see the old mapping.
+const (
+	verifyLookups     = 4
+	verifyLookupDelay = 2 * time.Second
+	verifyPrevious    = 3
+)
+
+// Verify resolves domain with the system resolver, checks that one of the
+// written IPs comes back, and connects to it once on the domain's port. When
+// that fails it tries up to three of the previous addresses, so callers can
+// tell a broken mapping from a domain that was unreachable anyway.
+func Verify(ctx context.Context, domain string, written, previous []netip.Addr, cfg Config) Verification {
+	v := Verification{Domain: domain}
+	i := -1
+	for n := 0; i < 0 && n < verifyLookups; n++ {
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return v
+			case <-time.After(verifyLookupDelay):
+			}
+		}
+		lctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		addrs, err := net.DefaultResolver.LookupNetIP(lctx, "ip", domain)
+		cancel()
+		v.Resolved = v.Resolved[:0]
+		for _, a := range addrs {
+			v.Resolved = append(v.Resolved, a.Unmap())
+		}
+		if err != nil {
+			v.Err = fmt.Errorf("system resolver: %w", err)
+			continue
+		}
+		i = slices.IndexFunc(v.Resolved, func(a netip.Addr) bool { return slices.Contains(written, a) })
+		v.Err = nil
+		if i < 0 {
+			v.Err = fmt.Errorf("system resolver returned %v, not the written ip", v.Resolved)
+		}
+	}
+	if i < 0 {
+		return v
+	}
+	v.IP = v.Resolved[i]
+	v.Latency, v.Err = tcpPing(ctx, v.IP, cfg.portFor(domain), cfg.Timeout)
+	if v.Err == nil {
+		return v
+	}
+	tried := 0
+	for _, a := range previous {
+		if tried == verifyPrevious || ctx.Err() != nil {
+			break
+		}
+		if slices.Contains(written, a) {
+			continue
+		}
+		tried++
+		if _, err := tcpPing(ctx, a, cfg.portFor(domain), cfg.Timeout); err == nil {
+			v.Previous = a
+			break
+		}
+	}
+	return v
+}
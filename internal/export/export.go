@@ -0,0 +1,214 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+const header = "# generated by ip-opt-gui"
+
+type Format struct {
+	Key      string
+	Name     string
+	FileName string
+	Pattern  string
+	Render   func([]hostsfile.Mapping) string
+}
+
+var Formats = []Format{
+	{Key: "dnsmasq", Name: "dnsmasq", FileName: "ip-opt-dnsmasq.conf", Pattern: "*.conf", Render: Dnsmasq},
+	{Key: "smartdns", Name: "SmartDNS", FileName: "ip-opt-smartdns.conf", Pattern: "*.conf", Render: SmartDNS},
+	{Key: "adguardhome", Name: "AdGuard Home", FileName: "ip-opt-adguardhome-rewrites.json", Pattern: "*.json", Render: AdGuardHome},
+	{Key: "clash", Name: "Clash", FileName: "ip-opt-clash-hosts.yaml", Pattern: "*.yaml", Render: Clash},
+	{Key: "surge", Name: "Surge", FileName: "ip-opt-surge-host.conf", Pattern: "*.conf", Render: Surge},
+	{Key: "docker", Name: "Docker", FileName: "ip-opt-docker-add-host.txt", Pattern: "*.txt", Render: DockerAddHost},
+	{Key: "compose", Name: "Compose", FileName: "ip-opt-compose-extra-hosts.yaml", Pattern: "*.yaml", Render: ComposeExtraHosts},
+	{Key: "pac", Name: "PAC", FileName: "ip-opt-hosts.pac", Pattern: "*.pac", Render: PAC},
+}
+
+func Lookup(key string) (Format, bool) {
+	for _, f := range Formats {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+func Dnsmasq(mappings []hostsfile.Mapping) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("\n")
+	for _, m := range clean(mappings) {
+		b.WriteString("address=/")
+		b.WriteString(m.Domain)
+		b.WriteString("/")
+		b.WriteString(m.IP)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func SmartDNS(mappings []hostsfile.Mapping) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("\n")
+	for _, m := range clean(mappings) {
+		b.WriteString("address /")
+		b.WriteString(m.Domain)
+		b.WriteString("/")
+		b.WriteString(m.IP)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+type adGuardRewrite struct {
+	Domain string `json:"domain"`
+	Answer string `json:"answer"`
+}
+
+func AdGuardHome(mappings []hostsfile.Mapping) string {
+	rewrites := []adGuardRewrite{}
+	for _, m := range clean(mappings) {
+		rewrites = append(rewrites, adGuardRewrite{Domain: m.Domain, Answer: m.IP})
+	}
+	b, _ := json.MarshalIndent(rewrites, "", "  ")
+	return string(b) + "\n"
+}
+
+// Clash renders one hosts key per domain, since a repeated YAML key would
+// overwrite the earlier one; several IPs become a quoted flow list.
+func Clash(mappings []hostsfile.Mapping) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("\nhosts:\n")
+	domains, ips := group(mappings)
+	for _, d := range domains {
+		b.WriteString("  '")
+		b.WriteString(d)
+		b.WriteString("': ")
+		if len(ips[d]) == 1 {
+			b.WriteString(ips[d][0])
+		} else {
+			b.WriteString("['" + strings.Join(ips[d], "', '") + "']")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Surge renders one [Host] line per domain with its IPs comma-separated.
+func Surge(mappings []hostsfile.Mapping) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("\n[Host]\n")
+	domains, ips := group(mappings)
+	for _, d := range domains {
+		b.WriteString(d)
+		b.WriteString(" = ")
+		b.WriteString(strings.Join(ips[d], ", "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// DockerAddHost renders one --add-host flag per line, joined with shell line
+// continuations so the block can be pasted into a `docker run` command.
+// Docker splits host and IP at the first colon, so IPv6 needs no brackets.
+func DockerAddHost(mappings []hostsfile.Mapping) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("\n")
+	ms := clean(mappings)
+	for i, m := range ms {
+		b.WriteString("--add-host=")
+		b.WriteString(m.Domain)
+		b.WriteString(":")
+		b.WriteString(m.IP)
+		if i < len(ms)-1 {
+			b.WriteString(" \\")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// ComposeExtraHosts renders an extra_hosts key to paste under a service.
+func ComposeExtraHosts(mappings []hostsfile.Mapping) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("\nextra_hosts:\n")
+	for _, m := range clean(mappings) {
+		b.WriteString("  - \"")
+		b.WriteString(m.Domain)
+		b.WriteString(":")
+		b.WriteString(m.IP)
+		b.WriteString("\"\n")
+	}
+	return b.String()
+}
+
+// PAC renders the mappings as a JS object, domain to IPs in written order,
+// inside a valid PAC file. A PAC cannot rewrite DNS by itself: FindProxyForURL
+// always answers DIRECT, and proxy tooling that evaluates the file reads the
+// map through ipOptLookup.
+func PAC(mappings []hostsfile.Mapping) string {
+	domains, ips := group(mappings)
+	var b strings.Builder
+	b.WriteString("//" + strings.TrimPrefix(header, "#"))
+	b.WriteString("\nvar ipOptHosts = {\n")
+	for i, d := range domains {
+		k, _ := json.Marshal(d)
+		v, _ := json.Marshal(ips[d])
+		b.WriteString("  ")
+		b.Write(k)
+		b.WriteString(": ")
+		b.Write(v)
+		if i < len(domains)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(`};
+
+function ipOptLookup(host) {
+  var ips = ipOptHosts[host.toLowerCase()];
+  return ips ? ips[0] : "";
+}
+
+function FindProxyForURL(url, host) {
+  return "DIRECT";
+}
+`)
+	return b.String()
+}
+
+// group returns the cleaned mappings' domains in first-seen order and each
+// domain's IPs in written order.
+func group(mappings []hostsfile.Mapping) ([]string, map[string][]string) {
+	var domains []string
+	ips := map[string][]string{}
+	for _, m := range clean(mappings) {
+		if _, ok := ips[m.Domain]; !ok {
+			domains = append(domains, m.Domain)
+		}
+		ips[m.Domain] = append(ips[m.Domain], m.IP)
+	}
+	return domains, ips
+}
+
+func clean(mappings []hostsfile.Mapping) []hostsfile.Mapping {
+	out := make([]hostsfile.Mapping, 0, len(mappings))
+	for _, m := range mappings {
+		ip := strings.TrimSpace(m.IP)
+		d := strings.TrimSpace(m.Domain)
+		if ip == "" || d == "" {
+			continue
+		}
+		out = append(out, hostsfile.Mapping{IP: ip, Domain: d})
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package export
+
+import (
+	"strings"
+	"testing"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+func TestDnsmasq(t *testing.T) {
+	got := Dnsmasq([]hostsfile.Mapping{
+		{IP: "1.2.3.4", Domain: "example.com"},
+		{IP: "", Domain: "skip.com"},
+		{IP: "2001:db8::1", Domain: "v6.example.com"},
+	})
+	want := header + "\naddress=/example.com/1.2.3.4\naddress=/v6.example.com/2001:db8::1\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSmartDNSAndAdGuardHome(t *testing.T) {
+	ms := []hostsfile.Mapping{{IP: "1.2.3.4", Domain: "example.com"}}
+	if got, want := SmartDNS(ms), header+"\naddress /example.com/1.2.3.4\n"; got != want {
+		t.Fatalf("smartdns got:\n%s\nwant:\n%s", got, want)
+	}
+	want := "[\n  {\n    \"domain\": \"example.com\",\n    \"answer\": \"1.2.3.4\"\n  }\n]\n"
+	if got := AdGuardHome(ms); got != want {
+		t.Fatalf("adguardhome got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := AdGuardHome(nil); got != "[]\n" {
+		t.Fatalf("empty adguardhome got %q", got)
+	}
+	for _, f := range Formats {
+		if _, ok := Lookup(f.Key); !ok || f.Render == nil {
+			t.Fatalf("format %q not usable", f.Key)
+		}
+	}
+}
+
+func TestClashAndSurge(t *testing.T) {
+	ms := []hostsfile.Mapping{{IP: "1.2.3.4", Domain: "example.com"}, {IP: "5.6.7.8", Domain: "api.example.com"}}
+	if got, want := Clash(ms), header+"\nhosts:\n  'example.com': 1.2.3.4\n  'api.example.com': 5.6.7.8\n"; got != want {
+		t.Fatalf("clash got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := Surge(ms), header+"\n[Host]\nexample.com = 1.2.3.4\napi.example.com = 5.6.7.8\n"; got != want {
+		t.Fatalf("surge got:\n%s\nwant:\n%s", got, want)
+	}
+
+	dual := []hostsfile.Mapping{{IP: "1.2.3.4", Domain: "example.com"}, {IP: "5.6.7.8", Domain: "api.example.com"}, {IP: "2001:db8::1", Domain: "example.com"}}
+	if got, want := Clash(dual), header+"\nhosts:\n  'example.com': ['1.2.3.4', '2001:db8::1']\n  'api.example.com': 5.6.7.8\n"; got != want {
+		t.Fatalf("dual-stack clash got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := Surge(dual), header+"\n[Host]\nexample.com = 1.2.3.4, 2001:db8::1\napi.example.com = 5.6.7.8\n"; got != want {
+		t.Fatalf("dual-stack surge got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDockerAndCompose(t *testing.T) {
+	ms := []hostsfile.Mapping{{IP: "1.2.3.4", Domain: "example.com"}, {IP: "2001:db8::1", Domain: "example.com"}, {IP: "", Domain: "skip.com"}}
+	if got, want := DockerAddHost(ms), header+"\n--add-host=example.com:1.2.3.4 \\\n--add-host=example.com:2001:db8::1\n"; got != want {
+		t.Fatalf("docker got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := ComposeExtraHosts(ms), header+"\nextra_hosts:\n  - \"example.com:1.2.3.4\"\n  - \"example.com:2001:db8::1\"\n"; got != want {
+		t.Fatalf("compose got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPAC(t *testing.T) {
+	got := PAC([]hostsfile.Mapping{{IP: "1.2.3.4", Domain: "example.com"}, {IP: "5.6.7.8", Domain: "api.example.com"}, {IP: "2001:db8::1", Domain: "example.com"}})
+	want := "// generated by ip-opt-gui\nvar ipOptHosts = {\n  \"example.com\": [\"1.2.3.4\",\"2001:db8::1\"],\n  \"api.example.com\": [\"5.6.7.8\"]\n};\n"
+	if !strings.HasPrefix(got, want) || !strings.Contains(got, "function FindProxyForURL(url, host) {") {
+		t.Fatalf("pac got:\n%s", got)
+	}
+}
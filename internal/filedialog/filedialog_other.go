@@ -4,12 +4,17 @@ package filedialog
 
 import "errors"
 
+var ErrUnsupported = errors.New("file dialog not supported on this platform")
+
 type Filter struct {
 	Name    string
 	Pattern string
 }
 
 func OpenFile(title string, filters []Filter) (string, error) {
-	return "", errors.New("file dialog not supported on this platform")
+	return "", ErrUnsupported
 }
 
+func SaveFile(title, defaultName string, filters []Filter) (string, error) {
+	return "", ErrUnsupported
+}
@@ -8,6 +8,8 @@ import (
 	"unsafe"
 )
 
+var ErrUnsupported = errors.New("file dialog not supported on this platform")
+
 type Filter struct {
 	Name    string
 	Pattern string
@@ -43,6 +45,37 @@ func OpenFile(title string, filters []Filter) (string, error) {
 	return syscall.UTF16ToString(buf), nil
 }
 
+func SaveFile(title, defaultName string, filters []Filter) (string, error) {
+	filterStr, err := buildFilter(filters)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]uint16, 4096)
+	copy(buf, syscall.StringToUTF16(defaultName))
+
+	var ofn openFileName
+	ofn.lStructSize = uint32(unsafe.Sizeof(ofn))
+	ofn.lpstrFile = &buf[0]
+	ofn.nMaxFile = uint32(len(buf))
+	if filterStr != nil {
+		ofn.lpstrFilter = filterStr
+	}
+	ofn.Flags = ofnExplorer | ofnPathMustExist | ofnOverwritePrompt | ofnNoChangeDir
+	if title != "" {
+		ofn.lpstrTitle = syscall.StringToUTF16Ptr(title)
+	}
+
+	ret, _, callErr := procGetSaveFileNameW.Call(uintptr(unsafe.Pointer(&ofn)))
+	if ret == 0 {
+		if callErr != syscall.Errno(0) {
+			return "", callErr
+		}
+		return "", errors.New("canceled")
+	}
+	return syscall.UTF16ToString(buf), nil
+}
+
 func buildFilter(filters []Filter) (*uint16, error) {
 	if len(filters) == 0 {
 		return nil, nil
@@ -91,14 +124,15 @@ type openFileName struct {
 }
 
 const (
-	ofnExplorer     = 0x00080000
-	ofnFileMustExist = 0x00001000
-	ofnPathMustExist = 0x00000800
-	ofnNoChangeDir   = 0x00000008
+	ofnExplorer        = 0x00080000
+	ofnFileMustExist   = 0x00001000
+	ofnPathMustExist   = 0x00000800
+	ofnNoChangeDir     = 0x00000008
+	ofnOverwritePrompt = 0x00000002
 )
 
 var (
 	modComdlg32          = syscall.NewLazyDLL("comdlg32.dll")
 	procGetOpenFileNameW = modComdlg32.NewProc("GetOpenFileNameW")
+	procGetSaveFileNameW = modComdlg32.NewProc("GetSaveFileNameW")
 )
-
@@ -0,0 +1,146 @@
+package geo
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+type Info struct {
+	CountryCode string
+	Country     string
+	City        string
+	ASN         uint
+	Org         string
+}
+
+func (i Info) String() string {
+	parts := make([]string, 0, 3)
+	if i.CountryCode != "" {
+		parts = append(parts, i.CountryCode)
+	}
+	if i.City != "" {
+		parts = append(parts, i.City)
+	}
+	if i.ASN != 0 {
+		parts = append(parts, i.AS())
+	}
+	return strings.Join(parts, " ")
+}
+
+// AS is the "AS13335 Cloudflare" part of String, empty without an ASN.
+func (i Info) AS() string {
+	if i.ASN == 0 {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("AS%d %s", i.ASN, i.Org))
+}
+
+func (i Info) Merge(o Info) Info {
+	if i.CountryCode == "" {
+		i.CountryCode, i.Country, i.City = o.CountryCode, o.Country, o.City
+	}
+	if i.ASN == 0 {
+		i.ASN, i.Org = o.ASN, o.Org
+	}
+	return i
+}
+
+func (i Info) InASN(asns []uint) bool {
+	for _, a := range asns {
+		if i.ASN != 0 && a == i.ASN {
+			return true
+		}
+	}
+	return false
+}
+
+func (i Info) Matches(regions []string) bool {
+	for _, r := range regions {
+		if strings.EqualFold(r, i.CountryCode) || (i.Country != "" && r == i.Country) || (i.City != "" && r == i.City) {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseRegions(s string) []string {
+	var out []string
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		out = append(out, strings.TrimSpace(f))
+	}
+	return out
+}
+
+func ParseASNs(s string) ([]uint, error) {
+	var out []uint
+	for _, f := range ParseRegions(s) {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(f), "AS"), 10, 32)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid asn %q", f)
+		}
+		out = append(out, uint(n))
+	}
+	return out, nil
+}
+
+type DB struct {
+	r *maxminddb.Reader
+}
+
+func Open(path string) (*DB, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{r: r}, nil
+}
+
+func (d *DB) Close() error {
+	return d.r.Close()
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+func (d *DB) Lookup(ip netip.Addr) (Info, bool) {
+	var rec cityRecord
+	res := d.r.Lookup(ip.Unmap())
+	if !res.Found() {
+		return Info{}, false
+	}
+	if err := res.Decode(&rec); err != nil {
+		return Info{}, false
+	}
+	info := Info{
+		CountryCode: rec.Country.ISOCode,
+		Country:     localName(rec.Country.Names),
+		City:        localName(rec.City.Names),
+		ASN:         rec.ASN,
+		Org:         rec.Org,
+	}
+	return info, info != Info{}
+}
+
+func localName(names map[string]string) string {
+	for _, lang := range []string{"zh-CN", "en"} {
+		if n := names[lang]; n != "" {
+			return n
+		}
+	}
+	return ""
+}
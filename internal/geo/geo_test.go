@@ -0,0 +1,57 @@
+package geo
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRegions(t *testing.T) {
+	got := ParseRegions("hk, JP;SG\n 东京")
+	want := []string{"hk", "JP", "SG", "东京"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseRegions = %q, want %q", got, want)
+	}
+}
+
+func TestInfoMatches(t *testing.T) {
+	i := Info{CountryCode: "JP", Country: "日本", City: "东京"}
+	if !i.Matches([]string{"jp"}) || !i.Matches([]string{"东京"}) || !i.Matches([]string{"US", "日本"}) {
+		t.Fatal("expected match")
+	}
+	if i.Matches([]string{"US", "HK"}) || i.Matches(nil) {
+		t.Fatal("unexpected match")
+	}
+	if s := i.String(); s != "JP 东京" {
+		t.Fatalf("String = %q", s)
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestParseASNs(t *testing.T) {
+	got, err := ParseASNs("AS13335, 4134 as9808")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint{13335, 4134, 9808}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseASNs = %v, want %v", got, want)
+	}
+	if _, err := ParseASNs("AS-bad"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestInfoASN(t *testing.T) {
+	i := Info{CountryCode: "US"}.Merge(Info{ASN: 13335, Org: "Cloudflare, Inc."})
+	if s := i.String(); s != "US AS13335 Cloudflare, Inc." {
+		t.Fatalf("String = %q", s)
+	}
+	if !i.InASN([]uint{4134, 13335}) || i.InASN([]uint{4134}) || (Info{}).InASN([]uint{0}) {
+		t.Fatal("unexpected InASN result")
+	}
+}
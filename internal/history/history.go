@@ -0,0 +1,179 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	fileTimeLayout = "20060102-150405"
+	fileExt        = ".json"
+	MaxRuns        = 50
+)
+
+type Entry struct {
+	Domain string        `json:"domain"`
+	IP     string        `json:"ip,omitempty"`
+	Rate   float64       `json:"rate,omitempty"`
+	P95    time.Duration `json:"p95,omitempty"`
+	Err    string        `json:"err,omitempty"`
+}
+
+type Run struct {
+	Time    time.Time `json:"time"`
+	Entries []Entry   `json:"entries"`
+}
+
+type Info struct {
+	Path  string
+	Time  time.Time
+	Count int
+}
+
+func Save(dir string, run Run) (string, error) {
+	if len(run.Entries) == 0 {
+		return "", errors.New("empty run")
+	}
+	b, err := json.Marshal(run)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, run.Time.Format(fileTimeLayout)+fileExt)
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return "", err
+	}
+	prune(dir, MaxRuns)
+	return path, nil
+}
+
+func Load(path string) (Run, error) {
+	var run Run
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return run, err
+	}
+	err = json.Unmarshal(b, &run)
+	return run, err
+}
+
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Info
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileExt) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		run, err := Load(path)
+		if err != nil {
+			continue
+		}
+		out = append(out, Info{Path: path, Time: run.Time, Count: len(run.Entries)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+	return out, nil
+}
+
+func prune(dir string, keep int) {
+	runs, err := List(dir)
+	if err != nil || len(runs) <= keep {
+		return
+	}
+	for _, r := range runs[keep:] {
+		_ = os.Remove(r.Path)
+	}
+}
+
+type Change struct {
+	Domain string
+	Old    Entry
+	New    Entry
+	InOld  bool
+	InNew  bool
+}
+
+func (c Change) IPChanged() bool {
+	return c.Old.IP != c.New.IP
+}
+
+func (c Change) Delta() (time.Duration, bool) {
+	if c.Old.IP == "" || c.New.IP == "" {
+		return 0, false
+	}
+	return c.New.P95 - c.Old.P95, true
+}
+
+func Compare(older, newer Run) []Change {
+	idx := map[string]int{}
+	var out []Change
+	for _, e := range older.Entries {
+		idx[e.Domain] = len(out)
+		out = append(out, Change{Domain: e.Domain, Old: e, InOld: true})
+	}
+	for _, e := range newer.Entries {
+		if i, ok := idx[e.Domain]; ok {
+			out[i].New, out[i].InNew = e, true
+			continue
+		}
+		out = append(out, Change{Domain: e.Domain, New: e, InNew: true})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].IPChanged() && !out[j].IPChanged()
+	})
+	return out
+}
+
+// Latest loads the most recent saved run.
+func Latest(dir string) (Run, bool) {
+	runs, err := List(dir)
+	if err != nil || len(runs) == 0 {
+		return Run{}, false
+	}
+	run, err := Load(runs[0].Path)
+	return run, err == nil
+}
+
+// Prioritize moves domains whose entry in last failed to the front, followed
+// by those slower than slow (slowest first); the rest keep their order.
+func Prioritize(domains []string, last Run, slow time.Duration) []string {
+	prev := map[string]Entry{}
+	for _, e := range last.Entries {
+		prev[e.Domain] = e
+	}
+	rank := func(d string) (int, time.Duration) {
+		e, ok := prev[d]
+		switch {
+		case !ok:
+			return 2, 0
+		case e.Err != "" || e.IP == "":
+			return 0, 0
+		case slow > 0 && e.P95 > slow:
+			return 1, e.P95
+		}
+		return 2, 0
+	}
+	out := append([]string(nil), domains...)
+	sort.SliceStable(out, func(i, j int) bool {
+		ri, pi := rank(out[i])
+		rj, pj := rank(out[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return pi > pj
+	})
+	return out
+}
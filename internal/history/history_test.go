@@ -0,0 +1,101 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveListLoad(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	for i := 0; i < MaxRuns+2; i++ {
+		run := Run{Time: base.Add(time.Duration(i) * time.Minute), Entries: []Entry{{Domain: "a.example", IP: "1.1.1.1"}}}
+		if _, err := Save(dir, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Save(dir, Run{Time: base}); err == nil {
+		t.Fatalf("expected error for empty run")
+	}
+
+	runs, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != MaxRuns {
+		t.Fatalf("expected %d runs after pruning, got %d", MaxRuns, len(runs))
+	}
+	if !runs[0].Time.Equal(base.Add(time.Duration(MaxRuns+1)*time.Minute)) || runs[0].Count != 1 {
+		t.Fatalf("unexpected newest run: %+v", runs[0])
+	}
+	run, err := Load(runs[0].Path)
+	if err != nil || len(run.Entries) != 1 || run.Entries[0].IP != "1.1.1.1" {
+		t.Fatalf("load = %+v, %v", run, err)
+	}
+
+	if runs, err := List(dir + "/missing"); err != nil || runs != nil {
+		t.Fatalf("missing dir: %v %v", runs, err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	older := Run{Entries: []Entry{
+		{Domain: "same.example", IP: "1.1.1.1", P95: 40 * time.Millisecond},
+		{Domain: "moved.example", IP: "2.2.2.2", P95: 90 * time.Millisecond},
+		{Domain: "gone.example", IP: "3.3.3.3"},
+	}}
+	newer := Run{Entries: []Entry{
+		{Domain: "same.example", IP: "1.1.1.1", P95: 50 * time.Millisecond},
+		{Domain: "moved.example", IP: "4.4.4.4", P95: 30 * time.Millisecond},
+		{Domain: "new.example", Err: "no candidate ip"},
+	}}
+	changes := Compare(older, newer)
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got %+v", changes)
+	}
+	if changes[0].Domain != "moved.example" || !changes[0].IPChanged() {
+		t.Fatalf("changed domains must come first: %+v", changes)
+	}
+	if d, ok := changes[0].Delta(); !ok || d != -60*time.Millisecond {
+		t.Fatalf("delta = %v %v", d, ok)
+	}
+	for _, c := range changes {
+		switch c.Domain {
+		case "same.example":
+			if c.IPChanged() || !c.InOld || !c.InNew {
+				t.Fatalf("unexpected %+v", c)
+			}
+		case "gone.example":
+			if c.InNew {
+				t.Fatalf("unexpected %+v", c)
+			}
+			if _, ok := c.Delta(); ok {
+				t.Fatalf("delta must be unknown for %s", c.Domain)
+			}
+		case "new.example":
+			if c.InOld {
+				t.Fatalf("unexpected %+v", c)
+			}
+		}
+	}
+}
+
+func TestPrioritize(t *testing.T) {
+	last := Run{Entries: []Entry{
+		{Domain: "ok.example", IP: "1.1.1.1", P95: 50 * time.Millisecond},
+		{Domain: "slow.example", IP: "1.1.1.2", P95: 400 * time.Millisecond},
+		{Domain: "slower.example", IP: "1.1.1.3", P95: 900 * time.Millisecond},
+		{Domain: "fail.example", Err: "no candidate ip"},
+	}}
+	domains := []string{"new.example", "ok.example", "slow.example", "slower.example", "fail.example"}
+	got := Prioritize(domains, last, 300*time.Millisecond)
+	want := []string{"fail.example", "slower.example", "slow.example", "new.example", "ok.example"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+	if domains[0] != "new.example" {
+		t.Fatalf("input reordered in place: %v", domains)
+	}
+}
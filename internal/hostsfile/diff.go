@@ -0,0 +1,202 @@
+package hostsfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DiffOp byte
+
+const (
+	DiffContext DiffOp = ' '
+	DiffAdd     DiffOp = '+'
+	DiffRemove  DiffOp = '-'
+	DiffHunk    DiffOp = '@'
+)
+
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+func (l DiffLine) String() string {
+	if l.Op == DiffHunk {
+		return l.Text
+	}
+	return string(l.Op) + l.Text
+}
+
+func Diff(oldText, newText string, context int) []DiffLine {
+	a := splitLines(oldText)
+	b := splitLines(newText)
+	edits := diffEdits(a, b)
+
+	var changed []int
+	for i, e := range edits {
+		if e.op != DiffContext {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	var out []DiffLine
+	for k := 0; k < len(changed); {
+		start := max(changed[k]-context, 0)
+		end := changed[k]
+		for k < len(changed) && changed[k] <= end+2*context+1 {
+			end = changed[k]
+			k++
+		}
+		end = min(end+context, len(edits)-1)
+
+		oldStart, newStart := edits[start].oldLine, edits[start].newLine
+		var oldCount, newCount int
+		for _, e := range edits[start : end+1] {
+			if e.op != DiffAdd {
+				oldCount++
+			}
+			if e.op != DiffRemove {
+				newCount++
+			}
+		}
+		out = append(out, DiffLine{Op: DiffHunk, Text: fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)})
+		for _, e := range edits[start : end+1] {
+			out = append(out, DiffLine{Op: e.op, Text: e.text})
+		}
+	}
+	return out
+}
+
+func UnifiedDiff(oldText, newText string, context int) string {
+	var b strings.Builder
+	for _, l := range Diff(oldText, newText, context) {
+		b.WriteString(l.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+type edit struct {
+	op      DiffOp
+	text    string
+	oldLine int
+	newLine int
+}
+
+func diffEdits(a, b []string) []edit {
+	out := make([]edit, 0, len(a)+len(b))
+	oi, ni := 1, 1
+	emit := func(op DiffOp, text string) {
+		out = append(out, edit{op: op, text: text, oldLine: oi, newLine: ni})
+		if op != DiffAdd {
+			oi++
+		}
+		if op != DiffRemove {
+			ni++
+		}
+	}
+	myers(a, b, emit)
+	return out
+}
+
+// diffMaxCost bounds how many edits the middle-snake search explores before
+// it gives up and reports the remaining span as one replacement, so a fully
+// rewritten large file costs O((n+m)·diffMaxCost) instead of O((n+m)²).
+const diffMaxCost = 1024
+
+// myers emits the edits turning a into b using the linear-space form of
+// Myers' algorithm: strip the common prefix and suffix, find the middle
+// snake of a shortest edit path, and recurse on both sides of it.
+func myers(a, b []string, emit func(DiffOp, string)) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, l := range a[:prefix] {
+		emit(DiffContext, l)
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	switch x, y, u, v, ok := middleSnake(ma, mb); {
+	case len(ma) == 0 || len(mb) == 0 || !ok:
+		for _, l := range ma {
+			emit(DiffRemove, l)
+		}
+		for _, l := range mb {
+			emit(DiffAdd, l)
+		}
+	default:
+		myers(ma[:x], mb[:y], emit)
+		for _, l := range ma[x:u] {
+			emit(DiffContext, l)
+		}
+		myers(ma[u:], mb[v:], emit)
+	}
+	for _, l := range a[len(a)-suffix:] {
+		emit(DiffContext, l)
+	}
+}
+
+// middleSnake returns the snake (x,y)-(u,v) in the middle of a shortest edit
+// path from a to b, searching forward from the start and backward from the
+// end until the two meet. ok is false when either side is empty or the path
+// needs more than diffMaxCost edits.
+func middleSnake(a, b []string) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, 0, 0, false
+	}
+	delta := n - m
+	limit := min((n+m+1)/2, diffMaxCost)
+	off := limit + 1
+	vf := make([]int, 2*limit+3)
+	vb := make([]int, 2*limit+3)
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			x := vf[off+k-1] + 1
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			if c := delta - k; delta%2 != 0 && c >= -(d-1) && c <= d-1 && x+vb[off+c] >= n {
+				return x0, y0, x, y, true
+			}
+		}
+		for c := -d; c <= d; c += 2 {
+			x := vb[off+c-1] + 1
+			if c == -d || (c != d && vb[off+c-1] < vb[off+c+1]) {
+				x = vb[off+c+1]
+			}
+			y := x - c
+			x0, y0 := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			vb[off+c] = x
+			if k := delta - c; delta%2 == 0 && k >= -d && k <= d && x+vf[off+k] >= n {
+				return n - x, m - y, n - x0, m - y0, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(normalizeNewlines(s), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
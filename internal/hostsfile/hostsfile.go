@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
@@ -16,8 +17,17 @@ const (
 )
 
 type Mapping struct {
-	IP     string
-	Domain string
+	IP      string
+	Domain  string
+	Group   string
+	Comment string
+	Expires time.Time
+}
+
+type Backup struct {
+	Path string
+	Time time.Time
+	Size int64
 }
 
 func DefaultHostsPath() string {
@@ -33,6 +43,23 @@ func DefaultHostsPath() string {
 	}
 }
 
+// Resolve follows a symlinked hosts path (NixOS, containers) so writes,
+// backups and restores act on the real file rather than the link.
+func Resolve(path string) (target string, linked bool, err error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return "", false, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return path, false, nil
+	}
+	target, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", true, err
+	}
+	return target, true, nil
+}
+
 func Read(path string) (string, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -41,19 +68,55 @@ func Read(path string) (string, error) {
 	return string(b), nil
 }
 
-func BuildManagedBlock(mappings []Mapping) string {
+func BuildManagedBlock(mappings []Mapping, header []string) string {
 	var b strings.Builder
+	wroteGroup := map[string]bool{}
 	b.WriteString(beginMarker)
 	b.WriteString("\n")
-	for _, m := range mappings {
+	for _, h := range header {
+		b.WriteString("# ")
+		b.WriteString(strings.TrimSpace(h))
+		b.WriteString("\n")
+	}
+	for _, m := range groupMappings(mappings) {
 		ip := strings.TrimSpace(m.IP)
 		d := strings.TrimSpace(m.Domain)
 		if ip == "" || d == "" {
 			continue
 		}
+		if m.Group != "" && !wroteGroup[m.Group] {
+			wroteGroup[m.Group] = true
+			b.WriteString(groupPrefix)
+			b.WriteString(m.Group)
+			b.WriteString("]\n")
+		}
 		b.WriteString(ip)
 		b.WriteString(" ")
 		b.WriteString(d)
+		if c := entryComment(m); c != "" {
+			b.WriteString(" # ")
+			b.WriteString(c)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(endMarker)
+	b.WriteString("\n")
+	return b.String()
+}
+
+// WrapManagedBlock puts pre-formatted lines between the managed markers so
+// other '#'-commented configs such as dnsmasq.conf can reuse ApplyManagedBlock.
+func WrapManagedBlock(lines, header []string) string {
+	var b strings.Builder
+	b.WriteString(beginMarker)
+	b.WriteString("\n")
+	for _, h := range header {
+		b.WriteString("# ")
+		b.WriteString(strings.TrimSpace(h))
+		b.WriteString("\n")
+	}
+	for _, l := range lines {
+		b.WriteString(l)
 		b.WriteString("\n")
 	}
 	b.WriteString(endMarker)
@@ -61,6 +124,196 @@ func BuildManagedBlock(mappings []Mapping) string {
 	return b.String()
 }
 
+func FormatMappings(mappings []Mapping) string {
+	var b strings.Builder
+	for _, m := range mappings {
+		ip := strings.TrimSpace(m.IP)
+		d := strings.TrimSpace(m.Domain)
+		if ip == "" || d == "" {
+			continue
+		}
+		b.WriteString(ip)
+		b.WriteString(" ")
+		b.WriteString(d)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+const groupPrefix = "# ["
+
+func groupMappings(mappings []Mapping) []Mapping {
+	var order []string
+	byGroup := map[string][]Mapping{}
+	for _, m := range mappings {
+		if _, ok := byGroup[m.Group]; !ok {
+			order = append(order, m.Group)
+		}
+		byGroup[m.Group] = append(byGroup[m.Group], m)
+	}
+	out := make([]Mapping, 0, len(mappings))
+	for _, g := range order {
+		out = append(out, byGroup[g]...)
+	}
+	return out
+}
+
+func ParseManagedBlock(existing string) []Mapping {
+	var out []Mapping
+	inManaged := false
+	group := ""
+	for _, line := range strings.Split(normalizeNewlines(existing), "\n") {
+		lineTrim := strings.TrimSpace(line)
+		switch {
+		case !inManaged:
+			inManaged = lineTrim == beginMarker
+			continue
+		case lineTrim == endMarker:
+			inManaged = false
+			group = ""
+			continue
+		case strings.HasPrefix(lineTrim, groupPrefix) && strings.HasSuffix(lineTrim, "]"):
+			group = strings.TrimSuffix(strings.TrimPrefix(lineTrim, groupPrefix), "]")
+			continue
+		case lineTrim == "" || strings.HasPrefix(lineTrim, "#"):
+			continue
+		}
+		entry, comment, _ := strings.Cut(lineTrim, "#")
+		fields := strings.Fields(entry)
+		if len(fields) < 2 {
+			continue
+		}
+		comment, expires := splitComment(comment)
+		for _, d := range fields[1:] {
+			out = append(out, Mapping{IP: fields[0], Domain: d, Group: group, Comment: comment, Expires: expires})
+		}
+	}
+	return out
+}
+
+// MergeStrategy decides what happens to domains already in the managed block
+// when a new set of mappings is written.
+type MergeStrategy string
+
+const (
+	// MergeReplace writes only the new mappings.
+	MergeReplace MergeStrategy = "replace"
+	// MergeKeep keeps old entries for domains the new set does not cover.
+	MergeKeep MergeStrategy = "merge"
+	// MergePrune is MergeKeep, minus every domain in the remove set.
+	MergePrune MergeStrategy = "prune"
+)
+
+// MergeMappings combines the mappings of the current managed block (old) with
+// next according to s. Domains are compared case-insensitively; remove is
+// only used by MergePrune and keyed by lower-case domain.
+func MergeMappings(old, next []Mapping, s MergeStrategy, remove map[string]bool) []Mapping {
+	if s != MergeKeep && s != MergePrune {
+		return next
+	}
+	key := func(d string) string { return strings.ToLower(strings.TrimSpace(d)) }
+	if s != MergePrune {
+		remove = nil
+	}
+	covered := map[string]bool{}
+	var out []Mapping
+	for _, m := range next {
+		covered[key(m.Domain)] = true
+		if !remove[key(m.Domain)] {
+			out = append(out, m)
+		}
+	}
+	for _, m := range old {
+		if !covered[key(m.Domain)] && !remove[key(m.Domain)] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// VerifyManagedBlock checks that the managed block in content holds exactly
+// mappings, e.g. after reading a written file back.
+func VerifyManagedBlock(content string, mappings []Mapping) error {
+	key := func(m Mapping) string {
+		return strings.TrimSpace(m.IP) + " " + strings.ToLower(strings.TrimSpace(m.Domain))
+	}
+	got := map[string]bool{}
+	parsed := ParseManagedBlock(content)
+	for _, m := range parsed {
+		got[key(m)] = true
+	}
+	want := map[string]bool{}
+	for _, m := range mappings {
+		if strings.TrimSpace(m.IP) == "" || strings.TrimSpace(m.Domain) == "" {
+			continue
+		}
+		want[key(m)] = true
+		if !got[key(m)] {
+			return fmt.Errorf("mapping %q missing", key(m))
+		}
+	}
+	for _, m := range parsed {
+		if !want[key(m)] {
+			return fmt.Errorf("unexpected mapping %q", key(m))
+		}
+	}
+	return nil
+}
+
+func ParseManagedHeader(existing string) []string {
+	var out []string
+	inManaged := false
+	for _, line := range strings.Split(normalizeNewlines(existing), "\n") {
+		lineTrim := strings.TrimSpace(line)
+		switch {
+		case !inManaged:
+			inManaged = lineTrim == beginMarker
+		case lineTrim == endMarker, strings.HasPrefix(lineTrim, groupPrefix), !strings.HasPrefix(lineTrim, "#"):
+			return out
+		default:
+			out = append(out, strings.TrimSpace(strings.TrimPrefix(lineTrim, "#")))
+		}
+	}
+	return out
+}
+
+func Expired(mappings []Mapping, now time.Time) []Mapping {
+	var out []Mapping
+	for _, m := range mappings {
+		if !m.Expires.IsZero() && !now.Before(m.Expires) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+const expiresTag = "expires="
+
+func entryComment(m Mapping) string {
+	c := strings.TrimSpace(m.Comment)
+	if m.Expires.IsZero() {
+		return c
+	}
+	e := expiresTag + m.Expires.Format(time.RFC3339)
+	if c == "" {
+		return e
+	}
+	return c + "; " + e
+}
+
+func splitComment(s string) (string, time.Time) {
+	s = strings.TrimSpace(s)
+	i := strings.LastIndex(s, expiresTag)
+	if i < 0 {
+		return s, time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s[i+len(expiresTag):]))
+	if err != nil {
+		return s, time.Time{}
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s[:i]), ";")), t
+}
+
 func ApplyManagedBlock(existing string, block string) string {
 	existing = normalizeNewlines(existing)
 	lines := strings.Split(existing, "\n")
@@ -90,29 +343,129 @@ func ApplyManagedBlock(existing string, block string) string {
 	return next
 }
 
-func WriteWithBackup(path string, mappings []Mapping) (backupPath string, newContent string, err error) {
+type Conflict struct {
+	Line   int
+	Text   string
+	IP     string
+	Domain string
+}
+
+func FindConflicts(existing string, mappings []Mapping) []Conflict {
+	managed := map[string]bool{}
+	for _, m := range mappings {
+		managed[strings.ToLower(strings.TrimSpace(m.Domain))] = true
+	}
+	var out []Conflict
+	inManaged := false
+	for i, line := range strings.Split(normalizeNewlines(existing), "\n") {
+		lineTrim := strings.TrimSpace(line)
+		switch {
+		case !inManaged && lineTrim == beginMarker:
+			inManaged = true
+			continue
+		case inManaged:
+			inManaged = lineTrim != endMarker
+			continue
+		}
+		entry, _, _ := strings.Cut(lineTrim, "#")
+		fields := strings.Fields(entry)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, d := range fields[1:] {
+			if managed[strings.ToLower(d)] {
+				out = append(out, Conflict{Line: i + 1, Text: line, IP: fields[0], Domain: d})
+			}
+		}
+	}
+	return out
+}
+
+func DisableConflicts(existing string, conflicts []Conflict) string {
+	drop := map[int]map[string]bool{}
+	for _, c := range conflicts {
+		if drop[c.Line] == nil {
+			drop[c.Line] = map[string]bool{}
+		}
+		drop[c.Line][strings.ToLower(c.Domain)] = true
+	}
+	lines := strings.Split(normalizeNewlines(existing), "\n")
+	out := make([]string, 0, len(lines))
+	for i, line := range lines {
+		ds := drop[i+1]
+		if ds == nil {
+			out = append(out, line)
+			continue
+		}
+		out = append(out, disabledPrefix+line)
+		entry, comment, hasComment := strings.Cut(strings.TrimSpace(line), "#")
+		fields := strings.Fields(entry)
+		var keep []string
+		for _, d := range fields[1:] {
+			if !ds[strings.ToLower(d)] {
+				keep = append(keep, d)
+			}
+		}
+		if len(keep) == 0 {
+			continue
+		}
+		rest := fields[0] + " " + strings.Join(keep, " ")
+		if hasComment {
+			rest += " #" + comment
+		}
+		out = append(out, rest)
+	}
+	return strings.Join(out, "\n")
+}
+
+const disabledPrefix = "# disabled by ip-opt-gui: "
+
+// WriteWithBackup saves the current content to backupDir (next to the hosts
+// file when empty) before applying the managed block.
+func WriteWithBackup(path, backupDir string, mappings []Mapping, header []string, disableConflicts bool) (backupPath string, newContent string, err error) {
+	path, _, err = Resolve(path)
+	if err != nil {
+		return "", "", err
+	}
 	orig, err := Read(path)
 	if err != nil {
 		return "", "", err
 	}
-	block := BuildManagedBlock(mappings)
-	newContent = ApplyManagedBlock(orig, block)
+	block := BuildManagedBlock(mappings, header)
+	base := orig
+	if disableConflicts {
+		base = DisableConflicts(orig, FindConflicts(orig, mappings))
+	}
+	newContent = ApplyManagedBlock(base, block)
+	if errs := NewErrors(Lint(orig), Lint(newContent)); len(errs) > 0 {
+		return "", "", &LintError{Issues: errs}
+	}
 
-	backupPath, err = backupFile(path, orig)
+	backupPath, err = backupFile(path, backupDir, orig)
 	if err != nil {
 		return "", "", err
 	}
 
-	mode := os.FileMode(0644)
-	if st, statErr := os.Stat(path); statErr == nil {
-		mode = st.Mode()
-	}
-	if err := os.WriteFile(path, []byte(newContent), mode); err != nil {
+	if err := WriteContent(path, newContent); err != nil {
 		return "", "", err
 	}
 	return backupPath, newContent, nil
 }
 
+// WriteContent replaces the hosts file (following symlinks) with content,
+// keeping its permissions. Every write to a hosts file goes through it.
+func WriteContent(hostsPath, content string) error {
+	hostsPath, _, err := Resolve(hostsPath)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if st, statErr := os.Stat(hostsPath); statErr == nil {
+		mode = st.Mode()
+	}
+	return os.WriteFile(hostsPath, []byte(content), mode)
+}
+
 func RestoreBackup(backupPath, hostsPath string) error {
 	if strings.TrimSpace(backupPath) == "" {
 		return errors.New("empty backup path")
@@ -121,17 +474,68 @@ func RestoreBackup(backupPath, hostsPath string) error {
 	if err != nil {
 		return err
 	}
-	mode := os.FileMode(0644)
-	if st, statErr := os.Stat(hostsPath); statErr == nil {
-		mode = st.Mode()
+	return WriteContent(hostsPath, string(b))
+}
+
+func ListBackups(hostsPath, backupDir string) ([]Backup, error) {
+	if t, _, err := Resolve(hostsPath); err == nil {
+		hostsPath = t
+	}
+	dir := backupLocation(hostsPath, backupDir)
+	prefix := filepath.Base(hostsPath) + ".bak."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Backup
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		ts, err := time.ParseInLocation(backupTimeLayout, strings.TrimPrefix(e.Name(), prefix), time.Local)
+		if err != nil {
+			ts = info.ModTime()
+		}
+		out = append(out, Backup{Path: filepath.Join(dir, e.Name()), Time: ts, Size: info.Size()})
 	}
-	return os.WriteFile(hostsPath, b, mode)
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+	return out, nil
 }
 
-func backupFile(path string, content string) (string, error) {
-	dir := filepath.Dir(path)
+func DeleteBackup(backupPath, hostsPath string) error {
+	if strings.TrimSpace(backupPath) == "" {
+		return errors.New("empty backup path")
+	}
+	if t, _, err := Resolve(hostsPath); err == nil {
+		hostsPath = t
+	}
+	prefix := filepath.Base(hostsPath) + ".bak."
+	if !strings.HasPrefix(filepath.Base(backupPath), prefix) {
+		return errors.New("not a hosts backup")
+	}
+	return os.Remove(backupPath)
+}
+
+const backupTimeLayout = "20060102_150405"
+
+func backupLocation(hostsPath, backupDir string) string {
+	if strings.TrimSpace(backupDir) != "" {
+		return backupDir
+	}
+	return filepath.Dir(hostsPath)
+}
+
+func backupFile(path, backupDir, content string) (string, error) {
+	dir := backupLocation(path, backupDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
 	base := filepath.Base(path)
-	ts := time.Now().Format("20060102_150405")
+	ts := time.Now().Format(backupTimeLayout)
 	backup := filepath.Join(dir, fmt.Sprintf("%s.bak.%s", base, ts))
 	if err := os.WriteFile(backup, []byte(content), 0644); err != nil {
 		return "", err
@@ -144,4 +548,3 @@ func normalizeNewlines(s string) string {
 	s = strings.ReplaceAll(s, "\r", "\n")
 	return s
 }
-
@@ -1,15 +1,19 @@
 package hostsfile
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestApplyManagedBlock(t *testing.T) {
 	orig := "127.0.0.1 localhost\n" + beginMarker + "\n1.1.1.1 a.com\n" + endMarker + "\n"
-	block := BuildManagedBlock([]Mapping{{IP: "2.2.2.2", Domain: "b.com"}})
+	block := BuildManagedBlock([]Mapping{{IP: "2.2.2.2", Domain: "b.com"}}, nil)
 	next := ApplyManagedBlock(orig, block)
 	if strings.Count(next, beginMarker) != 1 || strings.Count(next, endMarker) != 1 {
 		t.Fatalf("managed block marker count mismatch:\n%s", next)
@@ -22,6 +26,128 @@ func TestApplyManagedBlock(t *testing.T) {
 	}
 }
 
+func TestMergeMappings(t *testing.T) {
+	old := []Mapping{{IP: "1.1.1.1", Domain: "a.com"}, {IP: "1.1.1.2", Domain: "b.com"}, {IP: "1.1.1.3", Domain: "c.com"}}
+	next := []Mapping{{IP: "2.2.2.1", Domain: "A.com"}, {IP: "2.2.2.4", Domain: "d.com"}}
+	remove := map[string]bool{"b.com": true, "d.com": true}
+	ips := func(ms []Mapping) string {
+		var s []string
+		for _, m := range ms {
+			s = append(s, m.IP)
+		}
+		return strings.Join(s, " ")
+	}
+	for _, tc := range []struct {
+		s    MergeStrategy
+		want string
+	}{
+		{MergeReplace, "2.2.2.1 2.2.2.4"},
+		{MergeKeep, "2.2.2.1 2.2.2.4 1.1.1.2 1.1.1.3"},
+		{MergePrune, "2.2.2.1 1.1.1.3"},
+	} {
+		if got := ips(MergeMappings(old, next, tc.s, remove)); got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyManagedBlock(t *testing.T) {
+	ms := []Mapping{{IP: "1.1.1.1", Domain: "a.com"}, {IP: "1.1.1.2", Domain: "b.com", Group: "g"}}
+	content := "127.0.0.1 localhost\n" + BuildManagedBlock(ms, []string{"header"})
+	if err := VerifyManagedBlock(content, ms); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyManagedBlock(content, ms[:1]); err == nil {
+		t.Fatal("extra mapping in file not reported")
+	}
+	if err := VerifyManagedBlock(content, append(ms, Mapping{IP: "1.1.1.3", Domain: "c.com"})); err == nil {
+		t.Fatal("missing mapping not reported")
+	}
+}
+
+func TestFormatMappings(t *testing.T) {
+	got := FormatMappings([]Mapping{
+		{IP: "1.1.1.1", Domain: "a.example", Group: "g"},
+		{IP: "", Domain: "skip.example"},
+		{IP: " 2606:4700::1 ", Domain: "b.example"},
+	})
+	want := "1.1.1.1 a.example\n2606:4700::1 b.example\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseManagedBlock(t *testing.T) {
+	block := BuildManagedBlock([]Mapping{
+		{IP: "1.1.1.1", Domain: "a.com", Comment: "p95 35ms via 8.8.8.8"},
+		{IP: "2.2.2.2", Domain: "b.com", Group: "cdn"},
+		{IP: "2001:db8::1", Domain: "b.com", Group: "cdn"},
+	}, []string{"generated 2024-05-01 12:00:00 by ip-opt-gui dev", "scoring: p95"})
+	if !strings.Contains(block, "\n# scoring: p95\n") || !strings.Contains(block, "\n1.1.1.1 a.com # p95 35ms via 8.8.8.8\n") {
+		t.Fatalf("missing metadata:\n%s", block)
+	}
+	orig := "127.0.0.1 localhost\n" + block + "3.3.3.3 outside.com\n"
+	got := ParseManagedBlock(orig)
+	want := []Mapping{
+		{IP: "1.1.1.1", Domain: "a.com", Comment: "p95 35ms via 8.8.8.8"},
+		{IP: "2.2.2.2", Domain: "b.com", Group: "cdn"},
+		{IP: "2001:db8::1", Domain: "b.com", Group: "cdn"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("mapping %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if h := ParseManagedHeader(orig); len(h) != 2 || h[1] != "scoring: p95" {
+		t.Fatalf("header = %q", h)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	orig := "127.0.0.1 localhost\n1.1.1.1 a.com other.com # manual\n2.2.2.2 B.com\n" + BuildManagedBlock([]Mapping{{IP: "9.9.9.9", Domain: "a.com"}}, nil)
+	ms := []Mapping{{IP: "3.3.3.3", Domain: "a.com"}, {IP: "4.4.4.4", Domain: "b.com"}}
+	cs := FindConflicts(orig, ms)
+	if len(cs) != 2 || cs[0].Line != 2 || cs[0].Domain != "a.com" || cs[1].Line != 3 || cs[1].IP != "2.2.2.2" {
+		t.Fatalf("conflicts = %+v", cs)
+	}
+	got := DisableConflicts(orig, cs)
+	for _, want := range []string{
+		"\n" + disabledPrefix + "1.1.1.1 a.com other.com # manual\n1.1.1.1 other.com # manual\n",
+		"\n" + disabledPrefix + "2.2.2.2 B.com\n" + beginMarker,
+		"\n9.9.9.9 a.com\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q in:\n%s", want, got)
+		}
+	}
+	if cs := FindConflicts(got, ms); len(cs) != 0 {
+		t.Fatalf("conflicts left after disabling: %+v", cs)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2024, 5, 8, 12, 0, 0, 0, time.UTC)
+	block := BuildManagedBlock([]Mapping{
+		{IP: "1.1.1.1", Domain: "old.com", Comment: "p95 35ms", Expires: now.Add(-time.Hour)},
+		{IP: "2.2.2.2", Domain: "new.com", Expires: now.Add(time.Hour)},
+		{IP: "3.3.3.3", Domain: "pinned.com"},
+	}, nil)
+	if !strings.Contains(block, "1.1.1.1 old.com # p95 35ms; expires=2024-05-08T11:00:00Z\n") {
+		t.Fatalf("missing expiry:\n%s", block)
+	}
+	ms := ParseManagedBlock(block)
+	if len(ms) != 3 || ms[0].Comment != "p95 35ms" || !ms[0].Expires.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("parsed %+v", ms)
+	}
+	got := Expired(ms, now)
+	if len(got) != 1 || got[0].Domain != "old.com" {
+		t.Fatalf("expired = %+v", got)
+	}
+}
+
 func TestWriteWithBackupAndRestore(t *testing.T) {
 	dir := t.TempDir()
 	hostsPath := filepath.Join(dir, "hosts")
@@ -29,7 +155,7 @@ func TestWriteWithBackupAndRestore(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	backup, newContent, err := WriteWithBackup(hostsPath, []Mapping{{IP: "1.2.3.4", Domain: "example.com"}})
+	backup, newContent, err := WriteWithBackup(hostsPath, "", []Mapping{{IP: "1.2.3.4", Domain: "example.com"}}, nil, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,3 +179,337 @@ func TestWriteWithBackupAndRestore(t *testing.T) {
 	}
 }
 
+func TestListAndDeleteBackups(t *testing.T) {
+	dir := t.TempDir()
+	hostsPath := filepath.Join(dir, "hosts")
+	files := map[string]string{
+		"hosts":                        "127.0.0.1 localhost\n",
+		"hosts.bak.20240101_120000":    "old\n",
+		"hosts.bak.20240301_080000":    "newer\n",
+		"other.bak.20240301_080000":    "ignored\n",
+		"hosts.backup.20240301_080000": "ignored\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bs, err := ListBackups(hostsPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 2 {
+		t.Fatalf("got %d backups: %#v", len(bs), bs)
+	}
+	if filepath.Base(bs[0].Path) != "hosts.bak.20240301_080000" || bs[0].Size != int64(len("newer\n")) {
+		t.Fatalf("unexpected newest backup: %#v", bs[0])
+	}
+
+	if err := DeleteBackup(filepath.Join(dir, "other.bak.20240301_080000"), hostsPath); err == nil {
+		t.Fatalf("expected error deleting non-backup file")
+	}
+	if err := DeleteBackup(bs[1].Path, hostsPath); err != nil {
+		t.Fatal(err)
+	}
+	bs, _ = ListBackups(hostsPath, "")
+	if len(bs) != 1 {
+		t.Fatalf("backup not deleted: %#v", bs)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	orig := "127.0.0.1 localhost\n" + beginMarker + "\n1.1.1.1 a.com\n" + endMarker + "\n"
+	next := ApplyManagedBlock(orig, BuildManagedBlock([]Mapping{{IP: "2.2.2.2", Domain: "a.com"}}, nil))
+	got := UnifiedDiff(orig, next, 1)
+	want := "@@ -2,3 +2,3 @@\n " + beginMarker + "\n-1.1.1.1 a.com\n+2.2.2.2 a.com\n " + endMarker + "\n"
+	if got != want {
+		t.Fatalf("diff mismatch:\n%s\nwant:\n%s", got, want)
+	}
+	if d := Diff(orig, orig, 3); d != nil {
+		t.Fatalf("expected empty diff, got %#v", d)
+	}
+}
+
+func TestDiffLarge(t *testing.T) {
+	// A full LCS table for these inputs would need tens of gigabytes.
+	var orig, next strings.Builder
+	for i := range 200000 {
+		line := fmt.Sprintf("10.0.%d.%d host%d.example", i/256%256, i%256, i)
+		orig.WriteString(line + "\n")
+		if i%40000 == 7 {
+			line = "# " + line
+		}
+		next.WriteString(line + "\n")
+	}
+	var add, del int
+	for _, l := range Diff(orig.String(), next.String(), 0) {
+		switch l.Op {
+		case DiffAdd:
+			add++
+		case DiffRemove:
+			del++
+		}
+	}
+	if add != 5 || del != 5 {
+		t.Fatalf("scattered edits: +%d -%d, want +5 -5", add, del)
+	}
+
+	var rewritten strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&rewritten, "10.1.%d.%d other%d.example\n", i/256%256, i%256, i)
+	}
+	head := strings.Join(strings.SplitAfter(orig.String(), "\n")[:20000], "")
+	add, del = 0, 0
+	for _, l := range Diff(head, rewritten.String(), 0) {
+		switch l.Op {
+		case DiffAdd:
+			add++
+		case DiffRemove:
+			del++
+		}
+	}
+	if add != 20000 || del != 20000 {
+		t.Fatalf("rewrite: +%d -%d, want +20000 -20000", add, del)
+	}
+}
+
+func TestBuildManagedBlockGroups(t *testing.T) {
+	got := BuildManagedBlock([]Mapping{
+		{IP: "1.1.1.1", Domain: "a.com"},
+		{IP: "2.2.2.2", Domain: "github.com", Group: "github"},
+		{IP: "3.3.3.3", Domain: "b.com"},
+		{IP: "4.4.4.4", Domain: "api.github.com", Group: "github"},
+	}, nil)
+	want := beginMarker + "\n1.1.1.1 a.com\n3.3.3.3 b.com\n# [github]\n2.2.2.2 github.com\n4.4.4.4 api.github.com\n" + endMarker + "\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 4)
+	if err := Watch(ctx, path, func() { changed <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+		t.Fatalf("unrelated file must not trigger")
+	case <-time.After(2 * watchDebounce):
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(path, []byte("1.1.1.1 example.com\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no change notification")
+	}
+	select {
+	case <-changed:
+		t.Fatalf("burst of writes must be debounced into one notification")
+	case <-time.After(2 * watchDebounce):
+	}
+}
+
+func TestLint(t *testing.T) {
+	orig := "127.0.0.1 localhost\n999.1.1.1 broken.com\n"
+	next := orig + "1.1.1.1\ta.com b.com\n2.2.2.2 a.com\n2001:db8::1 a.com\n3.3.3.3 bad_host!.com\n" + strings.Repeat("x", 300) + "\n"
+	var got []string
+	for _, i := range Lint(next) {
+		got = append(got, fmt.Sprintf("%d %v %s", i.Line, i.Error, i.Msg))
+	}
+	want := []string{
+		`2 true invalid ip address "999.1.1.1"`,
+		"3 false mixes tabs and spaces",
+		"4 false a.com already maps to 1.1.1.1 on line 3",
+		`6 true bad_host!.com: invalid character '!'`,
+		"7 false line is 300 characters long (limit 255)",
+		`7 true invalid ip address "` + strings.Repeat("x", 300) + `"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	errs := NewErrors(Lint(orig), Lint(next))
+	if len(errs) != 2 || errs[0].Line != 6 {
+		t.Fatalf("new errors = %+v", errs)
+	}
+}
+
+func TestWriteWithBackupRejectsInvalid(t *testing.T) {
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsPath, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := WriteWithBackup(hostsPath, "", []Mapping{{IP: "not-an-ip", Domain: "example.com"}}, nil, false)
+	var le *LintError
+	if !errors.As(err, &le) || len(le.Issues) != 1 {
+		t.Fatalf("err = %v", err)
+	}
+	if b, _ := os.ReadFile(hostsPath); string(b) != "127.0.0.1 localhost\n" {
+		t.Fatalf("hosts modified despite validation error:\n%s", b)
+	}
+}
+
+func TestWriteThroughSymlink(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "static", "hosts")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "hosts")
+	if err := os.Symlink(file, link); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	if target, linked, err := Resolve(link); err != nil || !linked || target != file {
+		t.Fatalf("Resolve = %q, %v, %v", target, linked, err)
+	}
+
+	backup, _, err := WriteWithBackup(link, "", []Mapping{{IP: "1.2.3.4", Domain: "example.com"}}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("link replaced by a regular file")
+	}
+	if filepath.Dir(backup) != filepath.Dir(file) {
+		t.Fatalf("backup %s not next to target", backup)
+	}
+	if bs, err := ListBackups(link, ""); err != nil || len(bs) != 1 {
+		t.Fatalf("ListBackups = %v, %v", bs, err)
+	}
+	if err := RestoreBackup(backup, link); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(file); string(b) != "127.0.0.1 localhost\n" {
+		t.Fatalf("restore did not reach target: %q", b)
+	}
+}
+
+func TestWithoutProtection(t *testing.T) {
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsPath, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prot, err := Protected(hostsPath)
+	if err != nil || prot != 0 {
+		t.Fatalf("Protected = %v, %v", prot, err)
+	}
+	called := false
+	if err := WithoutProtection(hostsPath, prot, func() error { called = true; return nil }); err != nil || !called {
+		t.Fatalf("WithoutProtection: called=%v err=%v", called, err)
+	}
+	if s := (ProtReadOnly | ProtImmutable).String(); s != "read-only, immutable" {
+		t.Fatalf("String = %q", s)
+	}
+}
+
+func TestUndoStack(t *testing.T) {
+	dir := t.TempDir()
+	hosts := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(hosts, []byte("v1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &UndoStack{}
+	change := func(content string) {
+		cur, _ := Read(hosts)
+		s.Record(Snapshot{Action: "写入", Path: hosts, Content: cur})
+		if err := WriteContent(hosts, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply := func(snap Snapshot) error { return WriteContent(hosts, snap.Content) }
+	step := func(redo bool, want string) {
+		t.Helper()
+		cur, _ := Read(hosts)
+		if _, err := s.Step(redo, Snapshot{Path: hosts, Content: cur}, apply); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := Read(hosts); got != want {
+			t.Fatalf("hosts = %q, want %q", got, want)
+		}
+	}
+	change("v2\n")
+	change("v3\n")
+	step(false, "v2\n")
+	step(false, "v1\n")
+	if _, err := s.Step(false, Snapshot{Path: hosts}, apply); err == nil {
+		t.Fatal("undo past the first state succeeded")
+	}
+	step(true, "v2\n")
+
+	store := filepath.Join(dir, "undo.json")
+	if err := s.Save(store); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadUndo(store)
+	if err != nil || len(s.Undo) != 1 || len(s.Redo) != 1 {
+		t.Fatalf("reloaded stack = %+v, %v", s, err)
+	}
+	step(true, "v3\n")
+	change("v4\n")
+	if len(s.Redo) != 0 {
+		t.Fatal("a new change must clear redo")
+	}
+	if _, err := s.Step(false, Snapshot{Path: filepath.Join(dir, "other")}, apply); err == nil {
+		t.Fatal("undo applied to a different hosts path")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	hostsPath := filepath.Join(dir, "hosts")
+	backupDir := filepath.Join(dir, "backups")
+	if err := os.WriteFile(hostsPath, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	for _, ts := range []string{"20240101_120000", "20240301_120000", "20240308_120000", "20240309_120000"} {
+		if err := os.WriteFile(filepath.Join(backupDir, "hosts.bak."+ts), []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := PruneBackups(hostsPath, backupDir, Retention{MaxAge: 30 * 24 * time.Hour}, now)
+	if err != nil || len(removed) != 1 || filepath.Base(removed[0]) != "hosts.bak.20240101_120000" {
+		t.Fatalf("age pruning removed %v, %v", removed, err)
+	}
+	if _, err := PruneBackups(hostsPath, backupDir, Retention{KeepLast: 2}, now); err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := ListBackups(hostsPath, backupDir)
+	if len(bs) != 2 || filepath.Base(bs[0].Path) != "hosts.bak.20240309_120000" {
+		t.Fatalf("after keep-last pruning: %#v", bs)
+	}
+	if _, err := PruneBackups(hostsPath, backupDir, Retention{MaxAge: time.Hour}, now); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ListBackups(hostsPath, backupDir); len(bs) != 1 {
+		t.Fatalf("newest backup must survive pruning: %#v", bs)
+	}
+
+	backup, _, err := WriteWithBackup(hostsPath, backupDir, []Mapping{{IP: "1.2.3.4", Domain: "example.com"}}, nil, false)
+	if err != nil || filepath.Dir(backup) != backupDir {
+		t.Fatalf("backup written to %q, %v", backup, err)
+	}
+}
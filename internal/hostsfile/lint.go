@@ -0,0 +1,143 @@
+package hostsfile
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Windows ignores hosts lines past 256 characters or with more than nine
+// hostnames, so both are reported even though glibc would accept them.
+const (
+	maxLineLen       = 255
+	maxHostsPerLine  = 9
+	maxHostnameLen   = 253
+	maxHostnameLabel = 63
+)
+
+type Issue struct {
+	Line  int
+	Text  string
+	Msg   string
+	Error bool
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Msg)
+}
+
+type LintError struct {
+	Issues []Issue
+}
+
+func (e *LintError) Error() string {
+	if len(e.Issues) == 1 {
+		return "hosts validation failed: " + e.Issues[0].String()
+	}
+	return fmt.Sprintf("hosts validation failed: %s (and %d more)", e.Issues[0], len(e.Issues)-1)
+}
+
+func Lint(content string) []Issue {
+	var out []Issue
+	type seen struct {
+		ip   string
+		line int
+	}
+	hosts := map[string]seen{}
+	for i, line := range strings.Split(normalizeNewlines(content), "\n") {
+		n := i + 1
+		add := func(isErr bool, format string, args ...any) {
+			out = append(out, Issue{Line: n, Text: line, Msg: fmt.Sprintf(format, args...), Error: isErr})
+		}
+		if len(line) > maxLineLen {
+			add(false, "line is %d characters long (limit %d)", len(line), maxLineLen)
+		}
+		entry, _, _ := strings.Cut(line, "#")
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		if strings.ContainsRune(entry, '\x00') {
+			add(true, "line contains a NUL byte")
+			continue
+		}
+		if t := strings.TrimSpace(entry); strings.Contains(t, "\t") && strings.Contains(t, " ") {
+			add(false, "mixes tabs and spaces")
+		}
+		fields := strings.Fields(entry)
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			add(true, "invalid ip address %q", fields[0])
+			continue
+		}
+		if len(fields) == 1 {
+			add(true, "no hostname after %s", fields[0])
+			continue
+		}
+		if len(fields)-1 > maxHostsPerLine {
+			add(false, "%d hostnames on one line (limit %d)", len(fields)-1, maxHostsPerLine)
+		}
+		for _, h := range fields[1:] {
+			if msg := checkHostname(h); msg != "" {
+				add(true, "%s: %s", h, msg)
+				continue
+			}
+			key := strings.ToLower(h)
+			if addr.Is6() {
+				key += "/6"
+			}
+			if prev, ok := hosts[key]; ok && prev.ip != addr.String() {
+				add(false, "%s already maps to %s on line %d", h, prev.ip, prev.line)
+				continue
+			}
+			hosts[key] = seen{ip: addr.String(), line: n}
+		}
+	}
+	return out
+}
+
+// NewErrors returns the errors in after that are not already present in
+// before, so pre-existing problems do not block a write.
+func NewErrors(before, after []Issue) []Issue {
+	old := map[string]int{}
+	for _, i := range before {
+		if i.Error {
+			old[i.Text+"\x00"+i.Msg]++
+		}
+	}
+	var out []Issue
+	for _, i := range after {
+		if !i.Error {
+			continue
+		}
+		k := i.Text + "\x00" + i.Msg
+		if old[k] > 0 {
+			old[k]--
+			continue
+		}
+		out = append(out, i)
+	}
+	return out
+}
+
+func checkHostname(h string) string {
+	if len(h) > maxHostnameLen {
+		return "hostname too long"
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(h, "."), ".") {
+		if label == "" {
+			return "empty label"
+		}
+		if len(label) > maxHostnameLabel {
+			return "label too long"
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return "label starts or ends with a hyphen"
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Sprintf("invalid character %q", r)
+			}
+		}
+	}
+	return ""
+}
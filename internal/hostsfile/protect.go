@@ -0,0 +1,51 @@
+package hostsfile
+
+import "strings"
+
+type Protection uint8
+
+const (
+	ProtReadOnly Protection = 1 << iota
+	ProtImmutable
+)
+
+func (p Protection) String() string {
+	var parts []string
+	if p&ProtReadOnly != 0 {
+		parts = append(parts, "read-only")
+	}
+	if p&ProtImmutable != 0 {
+		parts = append(parts, "immutable")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Protected reports the read-only / immutable attributes on the hosts file that
+// make a plain write fail.
+func Protected(path string) (Protection, error) {
+	path, _, err := Resolve(path)
+	if err != nil {
+		return 0, err
+	}
+	return protection(path)
+}
+
+// WithoutProtection clears p on path, runs fn and then puts p back, even if
+// fn failed. Clearing chattr +i needs root (CAP_LINUX_IMMUTABLE).
+func WithoutProtection(path string, p Protection, fn func() error) error {
+	if p == 0 {
+		return fn()
+	}
+	path, _, err := Resolve(path)
+	if err != nil {
+		return err
+	}
+	if err := setProtection(path, p, false); err != nil {
+		return err
+	}
+	err = fn()
+	if rerr := setProtection(path, p, true); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package hostsfile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const fsImmutableFL = 0x00000010
+
+func protection(path string) (Protection, error) {
+	flags, err := inodeFlags(path)
+	if err != nil {
+		// Filesystems without inode flags (tmpfs, overlayfs) cannot be immutable.
+		return 0, nil
+	}
+	if flags&fsImmutableFL != 0 {
+		return ProtImmutable, nil
+	}
+	return 0, nil
+}
+
+func setProtection(path string, p Protection, on bool) error {
+	if p&ProtImmutable == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return err
+	}
+	if on {
+		flags |= fsImmutableFL
+	} else {
+		flags &^= fsImmutableFL
+	}
+	return unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, int(flags))
+}
+
+func inodeFlags(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+}
@@ -0,0 +1,11 @@
+//go:build !windows && !linux
+
+package hostsfile
+
+func protection(path string) (Protection, error) {
+	return 0, nil
+}
+
+func setProtection(path string, p Protection, on bool) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package hostsfile
+
+import "os"
+
+// os.Chmod maps the owner write bit to FILE_ATTRIBUTE_READONLY on Windows.
+func protection(path string) (Protection, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if fi.Mode().Perm()&0200 == 0 {
+		return ProtReadOnly, nil
+	}
+	return 0, nil
+}
+
+func setProtection(path string, p Protection, on bool) error {
+	if p&ProtReadOnly == 0 {
+		return nil
+	}
+	mode := os.FileMode(0666)
+	if on {
+		mode = 0444
+	}
+	return os.Chmod(path, mode)
+}
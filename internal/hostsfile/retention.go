@@ -0,0 +1,37 @@
+package hostsfile
+
+import (
+	"os"
+	"time"
+)
+
+// Retention limits how many hosts backups are kept; zero fields are unlimited.
+type Retention struct {
+	KeepLast int
+	MaxAge   time.Duration
+}
+
+// PruneBackups deletes backups beyond the newest r.KeepLast or older than
+// r.MaxAge. The newest backup is always kept so the last write can be undone.
+func PruneBackups(hostsPath, backupDir string, r Retention, now time.Time) ([]string, error) {
+	if r.KeepLast <= 0 && r.MaxAge <= 0 {
+		return nil, nil
+	}
+	bs, err := ListBackups(hostsPath, backupDir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i, b := range bs {
+		if i == 0 {
+			continue
+		}
+		if (r.KeepLast > 0 && i >= r.KeepLast) || (r.MaxAge > 0 && now.Sub(b.Time) > r.MaxAge) {
+			if err := os.Remove(b.Path); err != nil {
+				return removed, err
+			}
+			removed = append(removed, b.Path)
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,92 @@
+package hostsfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const MaxUndo = 20
+
+// Snapshot is the full hosts content at one point; Action names the change
+// that replaced it, e.g. "写入".
+type Snapshot struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Path    string    `json:"path"`
+	Content string    `json:"content"`
+}
+
+// UndoStack keeps the hosts states the app replaced, persisted to a file so
+// the history survives restarts.
+type UndoStack struct {
+	Undo []Snapshot `json:"undo"`
+	Redo []Snapshot `json:"redo"`
+}
+
+func LoadUndo(path string) (*UndoStack, error) {
+	s := &UndoStack{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return &UndoStack{}, err
+	}
+	return s, nil
+}
+
+func (s *UndoStack) Save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// Record pushes the state before a change and drops the redo history.
+func (s *UndoStack) Record(before Snapshot) {
+	s.Undo = pushSnapshot(s.Undo, before)
+	s.Redo = nil
+}
+
+// Step moves one state back (undo) or forward (redo). apply writes the target
+// snapshot; the stacks only change when it succeeds, and current (the live
+// content) becomes the entry for the opposite direction.
+func (s *UndoStack) Step(redo bool, current Snapshot, apply func(Snapshot) error) (Snapshot, error) {
+	from, to := &s.Undo, &s.Redo
+	if redo {
+		from, to = &s.Redo, &s.Undo
+	}
+	if len(*from) == 0 {
+		return Snapshot{}, errors.New("nothing to step to")
+	}
+	target := (*from)[len(*from)-1]
+	if target.Path != current.Path {
+		return Snapshot{}, fmt.Errorf("entry belongs to %s", target.Path)
+	}
+	if err := apply(target); err != nil {
+		return Snapshot{}, err
+	}
+	*from = (*from)[:len(*from)-1]
+	current.Action = target.Action
+	*to = pushSnapshot(*to, current)
+	return target, nil
+}
+
+func pushSnapshot(stack []Snapshot, s Snapshot) []Snapshot {
+	stack = append(stack, s)
+	if len(stack) > MaxUndo {
+		stack = stack[len(stack)-MaxUndo:]
+	}
+	return stack
+}
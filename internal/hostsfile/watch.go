@@ -0,0 +1,54 @@
+package hostsfile
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const watchDebounce = 300 * time.Millisecond
+
+// Watch calls onChange after path is written, replaced or removed. The parent
+// directory is watched because editors commonly save via rename, which would
+// detach a watch placed on the file itself.
+func Watch(ctx context.Context, path string, onChange func()) error {
+	if t, _, err := Resolve(path); err == nil {
+		path = t
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return err
+	}
+	name := filepath.Clean(path)
+	go func() {
+		defer w.Close()
+		var fire <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) == name && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					fire = time.After(watchDebounce)
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			case <-fire:
+				fire = nil
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,5 @@
+package launch
+
+import "errors"
+
+var ErrUnsupported = errors.New("opening files not supported on this platform")
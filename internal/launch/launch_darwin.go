@@ -0,0 +1,13 @@
+//go:build darwin
+
+package launch
+
+import "os/exec"
+
+func Dir(dir string) error {
+	return exec.Command("open", dir).Start()
+}
+
+func Editor(path string) error {
+	return exec.Command("open", "-t", path).Start()
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package launch
+
+import "os/exec"
+
+func Dir(dir string) error {
+	return xdgOpen(dir)
+}
+
+func Editor(path string) error {
+	return xdgOpen(path)
+}
+
+func xdgOpen(target string) error {
+	path, err := exec.LookPath("xdg-open")
+	if err != nil {
+		return ErrUnsupported
+	}
+	return exec.Command(path, target).Start()
+}
@@ -0,0 +1,11 @@
+//go:build !windows && !linux && !darwin
+
+package launch
+
+func Dir(dir string) error {
+	return ErrUnsupported
+}
+
+func Editor(path string) error {
+	return ErrUnsupported
+}
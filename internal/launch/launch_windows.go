@@ -0,0 +1,29 @@
+//go:build windows
+
+package launch
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const createNoWindow = 0x08000000
+
+func Dir(dir string) error {
+	return exec.Command("explorer.exe", dir).Start()
+}
+
+// Editor opens path in Notepad. Files the current process cannot write, such
+// as the system hosts file without admin rights, are opened through a UAC
+// prompt so edits can be saved.
+func Editor(path string) error {
+	if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		f.Close()
+		return exec.Command("notepad.exe", path).Start()
+	}
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", `Start-Process -FilePath notepad.exe -ArgumentList ('"' + $env:IPOPT_EDIT_PATH + '"') -Verb RunAs`)
+	cmd.Env = append(os.Environ(), "IPOPT_EDIT_PATH="+path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: createNoWindow}
+	return cmd.Start()
+}
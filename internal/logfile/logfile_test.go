@@ -0,0 +1,56 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, 64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 29)
+	for i := 0; i < 10; i++ {
+		if err := w.WriteLine(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteLine(line); err == nil {
+		t.Fatalf("expected error writing to closed log")
+	}
+
+	names := map[string]int64{}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		info, _ := e.Info()
+		names[e.Name()] = info.Size()
+	}
+	want := []string{FileName, FileName + ".1", FileName + ".2"}
+	if len(names) != len(want) {
+		t.Fatalf("files = %v", names)
+	}
+	for _, n := range want {
+		if size, ok := names[n]; !ok || size != 60 {
+			t.Fatalf("files = %v", names)
+		}
+	}
+
+	w, err = Open(dir, 64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.WriteLine(line); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, FileName)); err != nil || info.Size() != 30 {
+		t.Fatalf("reopened log should rotate when full: %v %v", info, err)
+	}
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DomainStats struct {
+	Domain         string
+	IP             string
+	BestLatency    time.Duration
+	SuccessRate    float64
+	Candidates     int
+	LastRun        time.Time
+	MonitorLatency time.Duration
+	MonitorRate    float64
+	LastProbe      time.Time
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	domains map[string]DomainStats
+}
+
+func NewRegistry() *Registry {
+	return &Registry{domains: map[string]DomainStats{}}
+}
+
+func (r *Registry) Update(domain string, fn func(*DomainStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.domains[domain]
+	s.Domain = domain
+	fn(&s)
+	r.domains[domain] = s
+}
+
+func (r *Registry) snapshot() []DomainStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]DomainStats, 0, len(r.domains))
+	for _, s := range r.domains {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+	return out
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	stats := r.snapshot()
+	type metric struct {
+		name, help string
+		value      func(DomainStats) (float64, bool)
+		withIP     bool
+	}
+	metrics := []metric{
+		{"ipopt_best_latency_seconds", "P95 TCP connect latency of the selected IP in the last run.", func(s DomainStats) (float64, bool) {
+			return s.BestLatency.Seconds(), !s.LastRun.IsZero() && s.IP != ""
+		}, true},
+		{"ipopt_success_ratio", "Probe success ratio of the selected IP in the last run.", func(s DomainStats) (float64, bool) {
+			return s.SuccessRate, !s.LastRun.IsZero() && s.IP != ""
+		}, true},
+		{"ipopt_candidates", "Number of candidate IPs probed in the last run.", func(s DomainStats) (float64, bool) {
+			return float64(s.Candidates), !s.LastRun.IsZero()
+		}, false},
+		{"ipopt_last_run_timestamp_seconds", "Unix time of the last run for the domain.", func(s DomainStats) (float64, bool) {
+			return float64(s.LastRun.Unix()), !s.LastRun.IsZero()
+		}, false},
+		{"ipopt_monitor_latency_seconds", "Latency of the latest successful monitoring probe.", func(s DomainStats) (float64, bool) {
+			return s.MonitorLatency.Seconds(), !s.LastProbe.IsZero()
+		}, true},
+		{"ipopt_monitor_success_ratio", "Recent monitoring probe success ratio.", func(s DomainStats) (float64, bool) {
+			return s.MonitorRate, !s.LastProbe.IsZero()
+		}, true},
+	}
+
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, s := range stats {
+			v, ok := m.value(s)
+			if !ok {
+				continue
+			}
+			labels := `domain="` + escapeLabel(s.Domain) + `"`
+			if m.withIP {
+				labels += `,ip="` + escapeLabel(s.IP) + `"`
+			}
+			fmt.Fprintf(&b, "%s{%s} %s\n", m.name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func (r *Registry) Listen(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	return srv, nil
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	r.Update("a.test", func(s *DomainStats) {
+		s.IP = "1.2.3.4"
+		s.BestLatency = 25 * time.Millisecond
+		s.SuccessRate = 1
+		s.Candidates = 3
+		s.LastRun = time.Unix(1700000000, 0)
+	})
+	r.Update(`b"x`, func(s *DomainStats) {})
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE ipopt_best_latency_seconds gauge\n",
+		`ipopt_best_latency_seconds{domain="a.test",ip="1.2.3.4"} 0.025` + "\n",
+		`ipopt_success_ratio{domain="a.test",ip="1.2.3.4"} 1` + "\n",
+		`ipopt_candidates{domain="a.test"} 3` + "\n",
+		`ipopt_last_run_timestamp_seconds{domain="a.test"} 1.7e+09` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "ipopt_monitor_latency_seconds{") || strings.Contains(out, `b\"x`) {
+		t.Fatalf("unexpected series in:\n%s", out)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Update("a.test", func(s *DomainStats) {
+		s.Candidates = 2
+		s.LastRun = time.Now()
+	})
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("content type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `ipopt_candidates{domain="a.test"} 2`) {
+		t.Fatalf("unexpected body:\n%s", rec.Body.String())
+	}
+}
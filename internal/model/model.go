@@ -1,15 +1,53 @@
 package model
 
 import (
+	"math"
 	"net/netip"
 	"time"
 )
 
 type DomainResult struct {
-	Domain     string
-	Best       CandidateStat
-	Candidates []CandidateStat
-	Err        error
+	Domain         string
+	Best           CandidateStat
+	Candidates     []CandidateStat
+	CNAMEs         []CNAMEChain
+	MinTTL         time.Duration
+	ResolverErrors []ResolverError
+	Queries        []ResolverQuery
+	Err            error
+	// Cached is set when the previous result was reused because the
+	// candidate set was unchanged and its best IP passed a health probe.
+	Cached bool
+	// Hijacked lists candidates only plain resolvers returned while a DoH
+	// server answered differently; they are dropped unless kept by config.
+	Hijacked []netip.Addr
+}
+
+type CNAMEChain struct {
+	Server string
+	Chain  []string
+}
+
+// ResolverQuery is one DNS lookup made for a domain. Addrs is what the server
+// answered, so callers can tell which resolvers returned the chosen IP.
+type ResolverQuery struct {
+	Server string
+	Type   string
+	OK     bool
+	Cached bool
+	RTT    time.Duration
+	Addrs  []netip.Addr
+}
+
+type ResolverError struct {
+	Server   string
+	Type     string
+	Attempts int
+	Err      string
+}
+
+func (e ResolverError) String() string {
+	return e.Server + " " + e.Type + ": " + e.Err
 }
 
 type CandidateStat struct {
@@ -20,10 +58,45 @@ type CandidateStat struct {
 	P50         time.Duration
 	P95         time.Duration
 	JitterStd   time.Duration
+	JitterRFC   time.Duration
+	Outliers    int
 	LastError   string
 	ResolvedVia string
+	TTL         time.Duration
+	Hijacked    bool
+	Location    string
+	ASN         string
+
+	QUICSuccesses int
+	QUICFailures  int
+	QUICP50       time.Duration
+	QUICP95       time.Duration
+	QUICLastError string
+
+	MTUChecked   bool
+	MTUBlackhole bool
+
+	BurstSent int
+	BurstLost int
+
+	Hops []Hop
+}
+
+type Hop struct {
+	TTL int
+	IP  netip.Addr
+	RTT time.Duration
+}
+
+func (c CandidateStat) LossRate() (float64, bool) {
+	if c.BurstSent == 0 {
+		return 0, false
+	}
+	return float64(c.BurstLost) / float64(c.BurstSent), true
 }
 
+func (c CandidateStat) QUICProbed() bool { return c.QUICSuccesses+c.QUICFailures > 0 }
+
 func (c CandidateStat) Attempts() int { return c.Successes + c.Failures }
 
 func (c CandidateStat) SuccessRate() float64 {
@@ -33,3 +106,16 @@ func (c CandidateStat) SuccessRate() float64 {
 	return float64(c.Successes) / float64(c.Attempts())
 }
 
+// SuccessLowerBound is the lower end of the 95% Wilson score interval for the
+// success rate, so 29/30 ranks above 1/1.
+func (c CandidateStat) SuccessLowerBound() float64 {
+	n := float64(c.Attempts())
+	if n == 0 {
+		return 0
+	}
+	const z = 1.96
+	p := c.SuccessRate()
+	centre := p + z*z/(2*n)
+	margin := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	return (centre - margin) / (1 + z*z/n)
+}
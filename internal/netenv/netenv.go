@@ -0,0 +1,110 @@
+package netenv
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+type Kind string
+
+const (
+	KindProxyEnv Kind = "proxy-env"
+	KindTunRoute Kind = "tun-route"
+	KindFakeIP   Kind = "fake-ip"
+)
+
+type Indicator struct {
+	Kind   Kind
+	Detail string
+}
+
+var proxyVars = []string{"HTTPS_PROXY", "HTTP_PROXY", "ALL_PROXY", "https_proxy", "http_proxy", "all_proxy"}
+
+var tunPrefixes = []string{"tun", "tap", "utun", "wintun", "wg", "ppp", "ipsec", "zt", "tailscale", "clash", "meta", "sing", "mihomo", "nordlynx"}
+
+// Clash/sing-box TUN stacks assign themselves addresses from 198.18.0.0/15.
+var fakeIPRange = netip.MustParsePrefix("198.18.0.0/15")
+
+// probeAddrs are only used to ask the kernel which source address a packet to
+// the internet would use; UDP "dial" sends nothing.
+var probeAddrs = []string{"8.8.8.8:53", "[2001:4860:4860::8888]:53"}
+
+// Detect reports signs that traffic currently goes through a VPN or proxy,
+// which would skew latency measurements.
+func Detect() []Indicator {
+	var out []Indicator
+	for _, k := range proxyVars {
+		if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+			out = append(out, Indicator{Kind: KindProxyEnv, Detail: k + "=" + v})
+		}
+	}
+	ifaces, _ := net.Interfaces()
+	seen := map[string]bool{}
+	for _, target := range probeAddrs {
+		src, ok := routeSource(target)
+		if !ok {
+			continue
+		}
+		if fakeIPRange.Contains(src) && !seen["fake"] {
+			seen["fake"] = true
+			out = append(out, Indicator{Kind: KindFakeIP, Detail: src.String()})
+		}
+		name, flags := interfaceFor(ifaces, src)
+		if name == "" || seen[name] {
+			continue
+		}
+		if looksLikeTunnel(name, flags) {
+			seen[name] = true
+			out = append(out, Indicator{Kind: KindTunRoute, Detail: name})
+		}
+	}
+	return out
+}
+
+func routeSource(target string) (netip.Addr, bool) {
+	c, err := net.Dial("udp", target)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	defer c.Close()
+	ua, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	a, ok := netip.AddrFromSlice(ua.IP)
+	return a.Unmap(), ok
+}
+
+func interfaceFor(ifaces []net.Interface, src netip.Addr) (string, net.Flags) {
+	for _, ifc := range ifaces {
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ip, ok := netip.AddrFromSlice(ipn.IP); ok && ip.Unmap() == src {
+				return ifc.Name, ifc.Flags
+			}
+		}
+	}
+	return "", 0
+}
+
+func looksLikeTunnel(name string, flags net.Flags) bool {
+	if flags&net.FlagPointToPoint != 0 {
+		return true
+	}
+	n := strings.ToLower(name)
+	for _, p := range tunPrefixes {
+		if strings.HasPrefix(n, p) {
+			return true
+		}
+	}
+	return strings.Contains(n, "vpn") || strings.Contains(n, "wintun") || strings.Contains(n, "tap-windows")
+}
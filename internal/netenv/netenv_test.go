@@ -0,0 +1,43 @@
+package netenv
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLooksLikeTunnel(t *testing.T) {
+	for name, want := range map[string]bool{
+		"tun0":            true,
+		"utun3":           true,
+		"Wintun":          true,
+		"Clash":           true,
+		"OpenVPN TAP":     true,
+		"eth0":            false,
+		"Wi-Fi":           false,
+		"enp3s0":          false,
+		"vEthernet (WSL)": false,
+	} {
+		if got := looksLikeTunnel(name, 0); got != want {
+			t.Fatalf("looksLikeTunnel(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if !looksLikeTunnel("eth1", net.FlagPointToPoint) {
+		t.Fatalf("point-to-point interface not treated as tunnel")
+	}
+}
+
+func TestDetectProxyEnv(t *testing.T) {
+	for _, k := range proxyVars {
+		t.Setenv(k, "")
+	}
+	t.Setenv("HTTPS_PROXY", "http://127.0.0.1:7890")
+	var found bool
+	for _, in := range Detect() {
+		if in.Kind == KindProxyEnv && in.Detail == "HTTPS_PROXY=http://127.0.0.1:7890" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("proxy env var not detected")
+	}
+}
@@ -0,0 +1,5 @@
+package notify
+
+import "errors"
+
+var ErrUnsupported = errors.New("desktop notification not supported on this platform")
@@ -0,0 +1,13 @@
+//go:build linux
+
+package notify
+
+import "os/exec"
+
+func Send(title, body string) error {
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return ErrUnsupported
+	}
+	return exec.Command(path, "--app-name=ip-opt-gui", title, body).Run()
+}
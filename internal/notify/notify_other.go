@@ -0,0 +1,7 @@
+//go:build !windows && !linux && !darwin
+
+package notify
+
+func Send(title, body string) error {
+	return ErrUnsupported
+}
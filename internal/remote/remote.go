@@ -0,0 +1,141 @@
+package remote
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const maxBodySize = 16 << 20
+
+type Result struct {
+	Body      []byte
+	FromCache bool
+	Stale     bool
+}
+
+type Fetcher struct {
+	Client   *http.Client
+	CacheDir string
+}
+
+func NewFetcher() *Fetcher {
+	dir := ""
+	if base, err := os.UserCacheDir(); err == nil {
+		dir = filepath.Join(base, "ip-opt-gui", "remote")
+	}
+	return &Fetcher{
+		Client:   &http.Client{Timeout: 20 * time.Second},
+		CacheDir: dir,
+	}
+}
+
+func ValidateURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("only http and https urls are supported")
+	}
+	if u.Host == "" {
+		return "", errors.New("missing host")
+	}
+	return u.String(), nil
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Result, error) {
+	u, err := ValidateURL(rawURL)
+	if err != nil {
+		return Result{}, err
+	}
+	bodyPath, etagPath := f.cachePaths(u)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	if etagPath != "" {
+		if b, err := os.ReadFile(etagPath); err == nil && len(b) > 0 {
+			if _, err := os.Stat(bodyPath); err == nil {
+				req.Header.Set("If-None-Match", strings.TrimSpace(string(b)))
+			}
+		}
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return f.fallback(bodyPath, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		b, err := os.ReadFile(bodyPath)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Body: b, FromCache: true}, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return f.fallback(bodyPath, fmt.Errorf("http status %s", resp.Status))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return f.fallback(bodyPath, err)
+	}
+	if len(b) > maxBodySize {
+		return Result{}, errors.New("response too large")
+	}
+	if bodyPath != "" {
+		if err := os.MkdirAll(filepath.Dir(bodyPath), 0755); err == nil {
+			_ = os.WriteFile(bodyPath, b, 0644)
+			if etag := resp.Header.Get("ETag"); etag != "" {
+				_ = os.WriteFile(etagPath, []byte(etag), 0644)
+			} else {
+				_ = os.Remove(etagPath)
+			}
+		}
+	}
+	return Result{Body: b}, nil
+}
+
+func (f *Fetcher) Cached(rawURL string) ([]byte, error) {
+	u, err := ValidateURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	bodyPath, _ := f.cachePaths(u)
+	if bodyPath == "" {
+		return nil, errors.New("no cache directory")
+	}
+	return os.ReadFile(bodyPath)
+}
+
+func (f *Fetcher) fallback(bodyPath string, cause error) (Result, error) {
+	if bodyPath != "" {
+		if b, err := os.ReadFile(bodyPath); err == nil {
+			return Result{Body: b, FromCache: true, Stale: true}, nil
+		}
+	}
+	return Result{}, cause
+}
+
+func (f *Fetcher) cachePaths(u string) (body, etag string) {
+	if f.CacheDir == "" {
+		return "", ""
+	}
+	sum := sha1.Sum([]byte(u))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(f.CacheDir, name+".body"), filepath.Join(f.CacheDir, name+".etag")
+}
@@ -0,0 +1,62 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchETagCache(t *testing.T) {
+	var hits, notModified int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("example.com\n"))
+	}))
+
+	f := &Fetcher{Client: srv.Client(), CacheDir: t.TempDir()}
+	res, err := f.Fetch(context.Background(), srv.URL+"/list.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) != "example.com\n" || res.FromCache {
+		t.Fatalf("unexpected first result: %#v", res)
+	}
+
+	res, err = f.Fetch(context.Background(), srv.URL+"/list.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) != "example.com\n" || !res.FromCache || res.Stale || notModified != 1 {
+		t.Fatalf("expected cached 304 result: %#v (304s=%d)", res, notModified)
+	}
+
+	if b, err := f.Cached(srv.URL + "/list.txt"); err != nil || string(b) != "example.com\n" {
+		t.Fatalf("Cached = %q, %v", b, err)
+	}
+
+	srv.Close()
+	res, err = f.Fetch(context.Background(), srv.URL+"/list.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Stale || string(res.Body) != "example.com\n" {
+		t.Fatalf("expected stale cache fallback: %#v", res)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	if _, err := ValidateURL("ftp://example.com/list"); err == nil {
+		t.Fatalf("expected error for ftp url")
+	}
+	if _, err := ValidateURL("https://example.com/list"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,178 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"example.com/ip-opt-gui/internal/engine"
+	"example.com/ip-opt-gui/internal/model"
+)
+
+type Meta struct {
+	Time     time.Time
+	Version  string
+	Port     int
+	Attempts int
+	Rounds   int
+}
+
+// Domain is one domain's ranked candidates; Chosen is the IP that was picked
+// for it, which may differ from the first candidate after a manual choice.
+type Domain struct {
+	Domain     string
+	Chosen     string
+	Err        string
+	Candidates []model.CandidateStat
+	CNAMEs     []model.CNAMEChain
+	DNSErrors  []model.ResolverError
+}
+
+var columns = []string{"#", "IP", "Resolved via", "Success", "Min", "P50", "P90", "P95", "P99", "Max", "Jitter", "Loss", "QUIC P95", "Location", "Notes"}
+
+func row(rank int, chosen string, st model.CandidateStat) []string {
+	ip := st.IP.String()
+	if ip == chosen {
+		ip += " *"
+	}
+	cells := []string{fmt.Sprint(rank), ip, st.ResolvedVia, fmt.Sprintf("%.0f%% (%d/%d)", st.SuccessRate()*100, st.Successes, st.Attempts())}
+	if st.Successes > 0 {
+		for _, q := range []float64{0, 0.5, 0.9, 0.95, 0.99, 1} {
+			cells = append(cells, ms(engine.Quantile(st.Samples, q)))
+		}
+		cells = append(cells, ms(st.JitterStd))
+	} else {
+		cells = append(cells, "-", "-", "-", "-", "-", "-", "-")
+	}
+	loss := "-"
+	if r, ok := st.LossRate(); ok {
+		loss = fmt.Sprintf("%.0f%% (%d/%d)", r*100, st.BurstLost, st.BurstSent)
+	}
+	quic := "-"
+	if st.QUICSuccesses > 0 {
+		quic = ms(st.QUICP95)
+	}
+	var notes []string
+	if st.Outliers > 0 {
+		notes = append(notes, fmt.Sprintf("%d outlier(s) excluded from P50/P95/jitter", st.Outliers))
+	}
+	if st.MTUBlackhole {
+		notes = append(notes, "large packets stall")
+	}
+	if st.LastError != "" {
+		notes = append(notes, st.LastError)
+	}
+	return append(cells, loss, quic, st.Location, strings.Join(notes, "; "))
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1f ms", float64(d)/float64(time.Millisecond))
+}
+
+func summary(m Meta, n int) string {
+	return fmt.Sprintf("%d domains, port %d, %d attempts x %d rounds, ip-opt-gui %s, %s", n, m.Port, m.Attempts, max(1, m.Rounds), m.Version, m.Time.Format(time.RFC3339))
+}
+
+func Markdown(domains []Domain, m Meta) string {
+	var b strings.Builder
+	b.WriteString("# IP benchmark report\n\n")
+	b.WriteString(summary(m, len(domains)) + "\n\n")
+	b.WriteString("`*` marks the IP chosen for each domain.\n")
+	esc := strings.NewReplacer("|", `\|`, "\n", " ")
+	for _, d := range domains {
+		fmt.Fprintf(&b, "\n## %s\n\n", d.Domain)
+		if d.Err != "" {
+			fmt.Fprintf(&b, "Error: %s\n\n", esc.Replace(d.Err))
+		}
+		for _, c := range d.CNAMEs {
+			fmt.Fprintf(&b, "- CNAME via %s: %s\n", c.Server, strings.Join(c.Chain, " -> "))
+		}
+		for _, e := range d.DNSErrors {
+			fmt.Fprintf(&b, "- Resolver error: %s\n", esc.Replace(e.String()))
+		}
+		if len(d.CNAMEs)+len(d.DNSErrors) > 0 {
+			b.WriteString("\n")
+		}
+		if len(d.Candidates) == 0 {
+			continue
+		}
+		b.WriteString("| " + strings.Join(columns, " | ") + " |\n")
+		b.WriteString("|" + strings.Repeat(" --- |", len(columns)) + "\n")
+		for i, st := range d.Candidates {
+			cells := row(i+1, d.Chosen, st)
+			for j := range cells {
+				cells[j] = esc.Replace(cells[j])
+			}
+			b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		}
+	}
+	return b.String()
+}
+
+var htmlTmpl = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>IP benchmark report</title>
+<style>
+body{font-family:system-ui,sans-serif;margin:24px;color:#222}
+table{border-collapse:collapse;margin-bottom:24px;font-size:13px}
+th,td{border:1px solid #ddd;padding:4px 8px;text-align:left;white-space:nowrap}
+th{background:#f4f4f4}
+tr.chosen td{background:#eef6ff}
+.err{color:#b00}
+</style></head><body>
+<h1>IP benchmark report</h1>
+<p>{{.Summary}}</p>
+{{range .Domains}}<h2>{{.Domain}}</h2>
+{{if .Err}}<p class="err">Error: {{.Err}}</p>
+{{end}}{{range .CNAMEs}}<p>CNAME via {{.Server}}: {{.Chain}}</p>
+{{end}}{{range .DNSErrors}}<p class="err">Resolver error: {{.}}</p>
+{{end}}{{if .Rows}}<table>
+<tr>{{range $.Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr{{if .Chosen}} class="chosen"{{end}}>{{range .Cells}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}{{end}}</body></html>
+`))
+
+type htmlRow struct {
+	Chosen bool
+	Cells  []string
+}
+
+type htmlCNAME struct {
+	Server string
+	Chain  string
+}
+
+type htmlDomain struct {
+	Domain    string
+	Err       string
+	CNAMEs    []htmlCNAME
+	DNSErrors []string
+	Rows      []htmlRow
+}
+
+func HTML(domains []Domain, m Meta) string {
+	data := struct {
+		Summary string
+		Columns []string
+		Domains []htmlDomain
+	}{Summary: summary(m, len(domains)), Columns: columns}
+	for _, d := range domains {
+		hd := htmlDomain{Domain: d.Domain, Err: d.Err}
+		for _, c := range d.CNAMEs {
+			hd.CNAMEs = append(hd.CNAMEs, htmlCNAME{Server: c.Server, Chain: strings.Join(c.Chain, " -> ")})
+		}
+		for _, e := range d.DNSErrors {
+			hd.DNSErrors = append(hd.DNSErrors, e.String())
+		}
+		for i, st := range d.Candidates {
+			hd.Rows = append(hd.Rows, htmlRow{Chosen: st.IP.String() == d.Chosen, Cells: row(i+1, d.Chosen, st)})
+		}
+		data.Domains = append(data.Domains, hd)
+	}
+	var b strings.Builder
+	if err := htmlTmpl.Execute(&b, data); err != nil {
+		return ""
+	}
+	return b.String()
+}
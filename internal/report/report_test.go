@@ -0,0 +1,54 @@
+package report
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+)
+
+func sampleDomains() []Domain {
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	return []Domain{
+		{
+			Domain: "example.com",
+			Chosen: "1.1.1.2",
+			Candidates: []model.CandidateStat{
+				{IP: netip.MustParseAddr("1.1.1.1"), Successes: 3, Samples: []time.Duration{ms(10), ms(20), ms(30)}, ResolvedVia: "8.8.8.8:53", BurstSent: 10, BurstLost: 1},
+				{IP: netip.MustParseAddr("1.1.1.2"), Successes: 2, Failures: 1, Samples: []time.Duration{ms(15), ms(25)}, Location: "JP"},
+				{IP: netip.MustParseAddr("1.1.1.3"), Failures: 3, LastError: "i/o timeout | reset"},
+			},
+			CNAMEs: []model.CNAMEChain{{Server: "8.8.8.8:53", Chain: []string{"example.com", "cdn.example.net"}}},
+		},
+		{Domain: "broken.example", Err: "no candidate ip <none>"},
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	md := Markdown(sampleDomains(), Meta{Port: 443, Attempts: 3, Version: "dev"})
+	for _, want := range []string{
+		"## example.com",
+		"| 1 | 1.1.1.1 | 8.8.8.8:53 | 100% (3/3) | 10.0 ms | 20.0 ms | 28.0 ms | 29.0 ms | 29.8 ms | 30.0 ms |",
+		"| 10% (1/10) |",
+		"| 2 | 1.1.1.2 * |",
+		`i/o timeout \| reset`,
+		"CNAME via 8.8.8.8:53: example.com -> cdn.example.net",
+		"Error: no candidate ip <none>",
+	} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("markdown missing %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	out := HTML(sampleDomains(), Meta{Port: 443, Attempts: 3, Version: "dev"})
+	if !strings.Contains(out, `<tr class="chosen"><td>2</td><td>1.1.1.2 *</td>`) {
+		t.Fatalf("chosen row not highlighted:\n%s", out)
+	}
+	if !strings.Contains(out, "no candidate ip &lt;none&gt;") || strings.Contains(out, "<none>") {
+		t.Fatalf("error text not escaped:\n%s", out)
+	}
+}
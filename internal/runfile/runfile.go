@@ -0,0 +1,92 @@
+// Package runfile saves the settings and full per-domain results of a run as
+// a portable .ipopt.json file, so what works on one network can be loaded
+// into the results table on another machine.
+package runfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+	"example.com/ip-opt-gui/internal/settings"
+)
+
+const (
+	Ext     = ".ipopt.json"
+	Version = 1
+)
+
+type File struct {
+	Version  int               `json:"version"`
+	App      string            `json:"app,omitempty"`
+	Time     time.Time         `json:"time"`
+	Settings settings.Settings `json:"settings"`
+	Results  []Result          `json:"results"`
+}
+
+// Result is a model.DomainResult with the error flattened to text, plus the
+// row state a user set by hand: whether it is written and a pinned IP.
+type Result struct {
+	Domain         string                `json:"domain"`
+	Group          string                `json:"group,omitempty"`
+	Best           model.CandidateStat   `json:"best"`
+	Candidates     []model.CandidateStat `json:"candidates,omitempty"`
+	CNAMEs         []model.CNAMEChain    `json:"cnames,omitempty"`
+	MinTTL         time.Duration         `json:"min_ttl,omitempty"`
+	ResolverErrors []model.ResolverError `json:"resolver_errors,omitempty"`
+	Queries        []model.ResolverQuery `json:"queries,omitempty"`
+	Hijacked       []netip.Addr          `json:"hijacked,omitempty"`
+	Err            string                `json:"err,omitempty"`
+	Apply          bool                  `json:"apply,omitempty"`
+	Override       string                `json:"override,omitempty"`
+}
+
+func (r Result) DomainResult() model.DomainResult {
+	res := model.DomainResult{
+		Domain:         r.Domain,
+		Best:           r.Best,
+		Candidates:     r.Candidates,
+		CNAMEs:         r.CNAMEs,
+		MinTTL:         r.MinTTL,
+		ResolverErrors: r.ResolverErrors,
+		Queries:        r.Queries,
+		Hijacked:       r.Hijacked,
+	}
+	if r.Err != "" {
+		res.Err = errors.New(r.Err)
+	}
+	return res
+}
+
+func Save(path string, f File) error {
+	f.Version = Version
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// Load rejects files written by a newer version, whose results this build
+// could silently misread.
+func Load(path string) (File, error) {
+	var f File
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return f, err
+	}
+	switch {
+	case f.Version == 0:
+		return f, errors.New("not an ipopt run file")
+	case f.Version > Version:
+		return f, fmt.Errorf("run file version %d is newer than supported %d", f.Version, Version)
+	}
+	return f, nil
+}
@@ -0,0 +1,62 @@
+package runfile
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/ip-opt-gui/internal/model"
+	"example.com/ip-opt-gui/internal/settings"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run"+Ext)
+	best := model.CandidateStat{IP: netip.MustParseAddr("1.2.3.4"), Successes: 3, Samples: []time.Duration{time.Millisecond}, P95: time.Millisecond, ResolvedVia: "8.8.8.8"}
+	in := File{
+		App:      "dev",
+		Time:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Settings: settings.Settings{Text: map[string]string{"dns": "8.8.8.8"}},
+		Results: []Result{
+			{Domain: "example.com", Group: "web", Best: best, Candidates: []model.CandidateStat{best}, MinTTL: time.Minute, Apply: true},
+			{Domain: "down.example.com", Err: "no candidate ip answered"},
+		},
+	}
+	if err := Save(path, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != Version || out.Settings.Text["dns"] != "8.8.8.8" || len(out.Results) != 2 {
+		t.Fatalf("loaded %+v", out)
+	}
+	res := out.Results[0].DomainResult()
+	if res.Best.IP != best.IP || res.Best.P95 != time.Millisecond || len(res.Candidates) != 1 || res.MinTTL != time.Minute || res.Err != nil {
+		t.Fatalf("result 0 = %+v", res)
+	}
+	if !out.Results[0].Apply || out.Results[0].Group != "web" {
+		t.Fatalf("row state lost: %+v", out.Results[0])
+	}
+	if err := out.Results[1].DomainResult().Err; err == nil || err.Error() != "no candidate ip answered" {
+		t.Fatalf("result 1 err = %v", err)
+	}
+}
+
+func TestLoadRejectsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"settings.json": `{"text":{"dns":"8.8.8.8"}}`,
+		"future.json":   `{"version":99,"results":[]}`,
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(p); err == nil {
+			t.Fatalf("%s loaded without error", name)
+		}
+	}
+}
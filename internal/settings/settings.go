@@ -0,0 +1,53 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+// Settings is the persisted UI state, keyed by stable names rather than
+// widget variables so a rename in the UI does not drop a saved value.
+type Settings struct {
+	Text    map[string]string  `json:"text,omitempty"`
+	Checked map[string]bool    `json:"checked,omitempty"`
+	Choice  map[string]string  `json:"choice,omitempty"`
+	Number  map[string]float32 `json:"number,omitempty"`
+}
+
+func (s Settings) Equal(o Settings) bool {
+	return maps.Equal(s.Text, o.Text) && maps.Equal(s.Checked, o.Checked) && maps.Equal(s.Choice, o.Choice) && maps.Equal(s.Number, o.Number)
+}
+
+// Load returns empty settings when the file does not exist yet.
+func Load(path string) (Settings, error) {
+	var s Settings
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+// Save writes through a temporary file so a crash mid-write cannot leave a
+// truncated settings file behind.
+func Save(path string, s Settings) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,32 @@
+package settings
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-opt-gui", "settings.json")
+	s, err := Load(path)
+	if err != nil || !s.Equal(Settings{}) {
+		t.Fatalf("missing file: %+v, %v", s, err)
+	}
+
+	want := Settings{
+		Text:    map[string]string{"port": "8443", "dns": "1.1.1.1\n8.8.8.8"},
+		Checked: map[string]bool{"ipv6": true, "ipv4": false},
+		Choice:  map[string]string{"jitter": "rfc3550"},
+		Number:  map[string]float32{"ui_scale": 0.4},
+	}
+	if err := Save(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil || !got.Equal(want) {
+		t.Fatalf("round trip = %+v, %v", got, err)
+	}
+	got.Checked["ipv4"] = true
+	if got.Equal(want) {
+		t.Fatal("changed checkbox compared equal")
+	}
+}
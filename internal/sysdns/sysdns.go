@@ -0,0 +1,68 @@
+// Package sysdns reads the DNS servers the operating system is configured to
+// use, so they can be tested alongside public resolvers.
+package sysdns
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Servers returns the system resolvers in the order the OS uses them, without
+// duplicates. Loopback stubs such as systemd-resolved's 127.0.0.53 are kept:
+// they are what programs on this machine actually query.
+func Servers() ([]string, error) {
+	addrs, err := servers()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, a := range addrs {
+		if s := a.String(); !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// ParseResolvConf returns the nameserver entries of a resolv.conf.
+func ParseResolvConf(r io.Reader) []netip.Addr {
+	var out []netip.Addr
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 2 || f[0] != "nameserver" {
+			continue
+		}
+		if a, err := netip.ParseAddr(f[1]); err == nil {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// parseScutil reads the "nameserver[0] : 192.168.1.1" lines of scutil --dns.
+// Resolvers scoped to one domain (e.g. local or a VPN's search domain) are
+// skipped, since they do not answer for the domains being tested.
+func parseScutil(out string) []netip.Addr {
+	var addrs []netip.Addr
+	scoped := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "resolver #"):
+			scoped = false
+		case strings.HasPrefix(line, "domain"):
+			scoped = true
+		case strings.HasPrefix(line, "nameserver[") && !scoped:
+			if _, v, ok := strings.Cut(line, ":"); ok {
+				if a, err := netip.ParseAddr(strings.TrimSpace(v)); err == nil {
+					addrs = append(addrs, a)
+				}
+			}
+		}
+	}
+	return addrs
+}
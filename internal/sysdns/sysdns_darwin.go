@@ -0,0 +1,25 @@
+//go:build darwin
+
+package sysdns
+
+import (
+	"net/netip"
+	"os"
+	"os/exec"
+)
+
+// servers asks scutil, which knows the per-interface and VPN resolvers that
+// /etc/resolv.conf on macOS does not list, and falls back to the file.
+func servers() ([]netip.Addr, error) {
+	if out, err := exec.Command("scutil", "--dns").Output(); err == nil {
+		if addrs := parseScutil(string(out)); len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseResolvConf(f), nil
+}
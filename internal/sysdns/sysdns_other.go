@@ -0,0 +1,17 @@
+//go:build !windows && !darwin
+
+package sysdns
+
+import (
+	"net/netip"
+	"os"
+)
+
+func servers() ([]netip.Addr, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseResolvConf(f), nil
+}
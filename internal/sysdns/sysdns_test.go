@@ -0,0 +1,53 @@
+package sysdns
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf := `# generated by NetworkManager
+search lan
+nameserver 192.168.1.1
+nameserver   fe80::1%eth0
+nameserver bogus
+options edns0
+`
+	got := ParseResolvConf(strings.NewReader(conf))
+	want := []netip.Addr{netip.MustParseAddr("192.168.1.1"), netip.MustParseAddr("fe80::1%eth0")}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseScutil(t *testing.T) {
+	out := `DNS configuration
+
+resolver #1
+  nameserver[0] : 192.168.1.1
+  nameserver[1] : 2001:db8::1
+  if_index : 6 (en0)
+  flags    : Request A records, Request AAAA records
+  reach    : 0x00020002 (Reachable,Directly Reachable Address)
+
+resolver #2
+  domain   : local
+  options  : mdns
+  timeout  : 5
+
+resolver #3
+  domain   : corp.example
+  nameserver[0] : 10.8.0.1
+  flags    : Supplemental
+
+resolver #4
+  nameserver[0] : 192.168.1.1
+`
+	got := parseScutil(out)
+	want := []netip.Addr{netip.MustParseAddr("192.168.1.1"), netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("192.168.1.1")}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+//go:build windows
+
+package sysdns
+
+import (
+	"errors"
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows fills unconfigured IPv6 DNS slots with these site-local
+// placeholders; they never answer.
+var placeholder = netip.MustParsePrefix("fec0:0:0:ffff::/64")
+
+func servers() ([]netip.Addr, error) {
+	size := uint32(15 << 10)
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		first := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0]))
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_SKIP_UNICAST|windows.GAA_FLAG_SKIP_ANYCAST|windows.GAA_FLAG_SKIP_MULTICAST, 0, first, &size)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, windows.ERROR_BUFFER_OVERFLOW) || size <= uint32(len(buf)) {
+			return nil, err
+		}
+	}
+	var out []netip.Addr
+	for a := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); a != nil; a = a.Next {
+		if a.OperStatus != windows.IfOperStatusUp || a.IfType == windows.IF_TYPE_SOFTWARE_LOOPBACK {
+			continue
+		}
+		for d := a.FirstDnsServerAddress; d != nil; d = d.Next {
+			ip, ok := netip.AddrFromSlice(d.Address.IP())
+			if !ok {
+				continue
+			}
+			if ip = ip.Unmap(); !placeholder.Contains(ip) {
+				out = append(out, ip)
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package ui
+
+import (
+	"fmt"
+	"image/color"
+	"path/filepath"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+type backupItem struct {
+	hostsfile.Backup
+	Btn widget.Clickable
+}
+
+func backupsPage(th *material.Theme, gtx layout.Context,
+	list *layout.List,
+	items []backupItem,
+	selected string,
+	ed *widget.Editor,
+	refreshBtn, restoreBtn, deleteBtn *widget.Clickable,
+	onSelect func(path string),
+	onRefresh, onRestore, onDelete func(),
+) layout.Dimensions {
+	hasSel := selected != ""
+	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return sectionTitle(th, gtx, "备份")
+						}),
+						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, refreshBtn, "刷新", true, uiSurface, uiText, onRefresh)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, restoreBtn, "恢复所选", hasSel, uiPrimary, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, onRestore)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, deleteBtn, "删除所选", hasSel, uiDanger, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, onDelete)
+						}),
+					)
+				}),
+				layout.Rigid(spacer(uiGap)),
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Flexed(0.4, func(gtx layout.Context) layout.Dimensions {
+							if len(items) == 0 {
+								l := material.Caption(th, "未找到备份文件")
+								l.Color = uiMuted
+								return l.Layout(gtx)
+							}
+							return list.Layout(gtx, len(items), func(gtx layout.Context, i int) layout.Dimensions {
+								return backupRow(th, gtx, &items[i], items[i].Path == selected, onSelect)
+							})
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Flexed(0.6, func(gtx layout.Context) layout.Dimensions {
+							gtx.Constraints.Min.Y = gtx.Constraints.Max.Y
+							e := material.Editor(th, ed, "选择左侧备份以预览内容")
+							e.TextSize = unit.Sp(14)
+							e.Color = uiText
+							e.HintColor = uiMuted
+							e.LineHeightScale = 1.25
+							return card(gtx, uiRadiusSmall, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(unit.Dp(10)), e.Layout)
+						}),
+					)
+				}),
+			)
+		})
+	})
+}
+
+func backupRow(th *material.Theme, gtx layout.Context, it *backupItem, selected bool, onSelect func(path string)) layout.Dimensions {
+	for it.Btn.Clicked(gtx) {
+		onSelect(it.Path)
+	}
+	bg := uiSurface
+	border := uiBorderCol
+	if selected {
+		bg = color.NRGBA{A: 255, R: 238, G: 243, B: 254}
+		border = uiPrimary
+	}
+	return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return material.Clickable(gtx, &it.Btn, func(gtx layout.Context) layout.Dimensions {
+			return card(gtx, uiRadiusSmall, bg, border, uiBorder, layout.UniformInset(unit.Dp(10)), func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Body1(th, it.Time.Format("2006-01-02 15:04:05"))
+						l.Color = uiText
+						return l.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Caption(th, fmt.Sprintf("%s  %s", filepath.Base(it.Path), formatSize(it.Size)))
+						l.Color = uiMuted
+						return l.Layout(gtx)
+					}),
+				)
+			})
+		})
+	})
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+)
+
+var (
+	uiChartBar  = color.NRGBA{A: 255, R: 120, G: 160, B: 250}
+	uiChartFail = color.NRGBA{A: 255, R: 240, G: 140, B: 140}
+	uiChartBase = color.NRGBA{A: 255, R: 232, G: 234, B: 238}
+)
+
+func sparkline(gtx layout.Context, samples []time.Duration, failures int, maxV time.Duration, width, height unit.Dp) layout.Dimensions {
+	size := image.Pt(gtx.Dp(width), gtx.Dp(height))
+	paint.FillShape(gtx.Ops, uiChartBase, clip.Rect{Min: image.Pt(0, size.Y-1), Max: size}.Op())
+
+	n := len(samples) + failures
+	if n == 0 || maxV <= 0 {
+		return layout.Dimensions{Size: size}
+	}
+	gap := 1
+	barW := (size.X - gap*(n-1)) / n
+	if barW < 1 {
+		barW, gap = 1, 0
+	}
+	if barW > gtx.Dp(unit.Dp(8)) {
+		barW = gtx.Dp(unit.Dp(8))
+	}
+	x := 0
+	for i := 0; i < n && x < size.X; i++ {
+		h := size.Y
+		col := uiChartFail
+		if i < len(samples) {
+			col = uiChartBar
+			h = int(float64(size.Y) * float64(samples[i]) / float64(maxV))
+			h = max(min(h, size.Y), 2)
+		}
+		paint.FillShape(gtx.Ops, col, clip.Rect{Min: image.Pt(x, size.Y-h), Max: image.Pt(x+barW, size.Y)}.Op())
+		x += barW + gap
+	}
+	return layout.Dimensions{Size: size}
+}
+
+func ratioBar(gtx layout.Context, frac float64, height unit.Dp, col color.NRGBA) layout.Dimensions {
+	size := image.Pt(gtx.Constraints.Max.X, gtx.Dp(height))
+	r := gtx.Dp(unit.Dp(2))
+	paint.FillShape(gtx.Ops, uiChartBase, clip.UniformRRect(image.Rectangle{Max: size}, r).Op(gtx.Ops))
+	frac = min(max(frac, 0), 1)
+	if w := int(float64(size.X) * frac); w > 0 {
+		paint.FillShape(gtx.Ops, col, clip.UniformRRect(image.Rectangle{Max: image.Pt(w, size.Y)}, r).Op(gtx.Ops))
+	}
+	return layout.Dimensions{Size: size}
+}
@@ -0,0 +1,195 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/domain"
+)
+
+// resultColumn is one column of the result rows. Domain, IP and status are
+// always shown; the rest can be turned on in the column picker. Width is a
+// 0..1 slider position mapped onto a flex weight by colWeight.
+type resultColumn struct {
+	Key      string
+	Title    string
+	Optional bool
+	Show     widget.Bool
+	Width    widget.Float
+}
+
+const (
+	colWeightMin = 0.05
+	colWeightMax = 1.0
+)
+
+func colWeight(pos float32) float32 { return colWeightMin + pos*(colWeightMax-colWeightMin) }
+
+func newResultColumns() []*resultColumn {
+	defs := []struct {
+		key, title string
+		optional   bool
+		show       bool
+		weight     float32
+	}{
+		{"domain", "域名", false, true, 0.55},
+		{"ip", "IP", false, true, 0.25},
+		{"status", "状态 · 成功率 · P95", false, true, 0.20},
+		{"p50", "P50", true, false, 0.08},
+		{"jitter", "抖动", true, false, 0.08},
+		{"via", "解析来源", true, false, 0.15},
+		{"asn", "ASN", true, false, 0.20},
+		{"score", "评分", true, true, 0.07},
+	}
+	cols := make([]*resultColumn, len(defs))
+	for i, d := range defs {
+		c := &resultColumn{Key: d.key, Title: d.title, Optional: d.optional}
+		c.Show.Value = d.show
+		c.Width.Value = (d.weight - colWeightMin) / (colWeightMax - colWeightMin)
+		cols[i] = c
+	}
+	return cols
+}
+
+func columnPicker(th *material.Theme, gtx layout.Context, cols []*resultColumn) layout.Dimensions {
+	return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+		children := []layout.FlexChild{
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				l := material.Caption(th, "显示的列和宽度（拖动滑块调整相对宽度）：")
+				l.Color = uiMuted
+				return l.Layout(gtx)
+			}),
+		}
+		for _, c := range cols {
+			children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(unit.Dp(200))
+						gtx.Constraints.Max.X = gtx.Constraints.Min.X
+						if !c.Optional {
+							return layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								l := material.Body2(th, c.Title+"（固定）")
+								l.Color = uiText
+								return l.Layout(gtx)
+							})
+						}
+						return material.CheckBox(th, &c.Show, c.Title).Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(unit.Dp(200))
+						gtx.Constraints.Max.X = gtx.Constraints.Min.X
+						return material.Slider(th, &c.Width).Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Caption(th, fmt.Sprintf("  %.2f", colWeight(c.Width.Value)))
+						l.Color = uiMuted
+						return l.Layout(gtx)
+					}),
+				)
+			}))
+		}
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	})
+}
+
+func resultCell(th *material.Theme, gtx layout.Context, key string, target *row, w *rowWidgets, r row, failed bool) layout.Dimensions {
+	caption := func(s string) layout.Dimensions {
+		l := material.Caption(th, s)
+		l.Color = uiMuted
+		l.MaxLines = 1
+		return l.Layout(gtx)
+	}
+	measured := r.BestIP != "" && r.Stage == ""
+	switch key {
+	case "domain":
+		return material.Clickable(gtx, &w.SelBtn, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Constraints.Max.X
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Baseline}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if !failed || !uiHighContrast {
+						return layout.Dimensions{}
+					}
+					l := material.Body1(th, "✗ ")
+					l.Color = uiDanger
+					return l.Layout(gtx)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					l := material.Body1(th, domain.Display(r.Domain))
+					l.Color = uiText
+					return l.Layout(gtx)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if r.Group == "" {
+						return layout.Dimensions{}
+					}
+					l := material.Caption(th, "  ["+r.Group+"]")
+					l.Color = uiMuted
+					return l.Layout(gtx)
+				}),
+			)
+		})
+	case "ip":
+		if ip, ok := target.overrideIP(); ok {
+			l := material.Body1(th, ip+" (自定义)")
+			l.Color = uiPrimary
+			return l.Layout(gtx)
+		}
+		l := material.Body1(th, r.BestIP)
+		l.Color = uiText
+		return l.Layout(gtx)
+	case "status":
+		var s string
+		switch {
+		case r.Stage != "":
+			s = r.Stage
+		case len(r.Current) > 0:
+			s = "当前"
+			if exp := r.Current[0].Expires; !exp.IsZero() && time.Now().After(exp) {
+				s = "当前（已过期）"
+			}
+		case r.BestIP != "":
+			s = fmt.Sprintf("%.0f%%  %s", r.Rate*100, r.P95)
+			if r.LossKnown {
+				s += fmt.Sprintf("  丢包 %.0f%%", r.Loss*100)
+			}
+		}
+		if r.Cached && r.Stage == "" {
+			s += "  (缓存)"
+		}
+		if r.Picked {
+			s += "  (手动)"
+		}
+		if len(r.Hijacked) > 0 && r.Stage == "" {
+			s += fmt.Sprintf("  ⚠ 疑似污染 %d", len(r.Hijacked))
+		}
+		return caption(s)
+	case "p50":
+		if measured {
+			return caption("P50 " + r.P50.String())
+		}
+	case "jitter":
+		if measured {
+			return caption("抖动 " + r.Jitter.String())
+		}
+	case "via":
+		if measured {
+			return caption(r.Via)
+		}
+	case "asn":
+		if measured {
+			return caption(r.ASN)
+		}
+	case "score":
+		if measured {
+			return layout.Inset{Right: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return linkButton(th, gtx, &w.ScoreBtn, fmt.Sprintf("评分 %.0f", r.Score), func() { target.ScoreOpen = !target.ScoreOpen })
+			})
+		}
+	}
+	return layout.Dimensions{}
+}
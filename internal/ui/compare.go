@@ -0,0 +1,185 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/history"
+)
+
+type historyItem struct {
+	history.Info
+	Btn widget.Clickable
+}
+
+func comparePage(th *material.Theme, gtx layout.Context,
+	runList, changeList *layout.List,
+	items []historyItem,
+	selected []string,
+	changes []history.Change,
+	refreshBtn *widget.Clickable,
+	onSelect func(path string),
+	onRefresh func(),
+) layout.Dimensions {
+	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return sectionTitle(th, gtx, "运行对比")
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							l := material.Caption(th, "选择两次运行，较早的一次作为基准")
+							l.Color = uiMuted
+							return l.Layout(gtx)
+						}),
+						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, refreshBtn, "刷新", true, uiSurface, uiText, onRefresh)
+						}),
+					)
+				}),
+				layout.Rigid(spacer(uiGap)),
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Flexed(0.3, func(gtx layout.Context) layout.Dimensions {
+							if len(items) == 0 {
+								l := material.Caption(th, "暂无运行记录，完成一次测速后会自动保存")
+								l.Color = uiMuted
+								return l.Layout(gtx)
+							}
+							return runList.Layout(gtx, len(items), func(gtx layout.Context, i int) layout.Dimensions {
+								return historyRow(th, gtx, &items[i], slices.Contains(selected, items[i].Path), onSelect)
+							})
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Flexed(0.7, func(gtx layout.Context) layout.Dimensions {
+							if len(selected) < 2 {
+								l := material.Caption(th, "请在左侧选择两次运行")
+								l.Color = uiMuted
+								return l.Layout(gtx)
+							}
+							return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+									return changeLine(th, gtx, uiMuted, color.NRGBA{}, "域名", "IP（旧 → 新）", "p95（旧 → 新）", "差值")
+								}),
+								layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+									return changeList.Layout(gtx, len(changes), func(gtx layout.Context, i int) layout.Dimensions {
+										return changeRow(th, gtx, changes[i])
+									})
+								}),
+							)
+						}),
+					)
+				}),
+			)
+		})
+	})
+}
+
+func historyRow(th *material.Theme, gtx layout.Context, it *historyItem, selected bool, onSelect func(path string)) layout.Dimensions {
+	for it.Btn.Clicked(gtx) {
+		onSelect(it.Path)
+	}
+	bg := uiSurface
+	border := uiBorderCol
+	if selected {
+		bg = color.NRGBA{A: 255, R: 238, G: 243, B: 254}
+		border = uiPrimary
+	}
+	return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return material.Clickable(gtx, &it.Btn, func(gtx layout.Context) layout.Dimensions {
+			return card(gtx, uiRadiusSmall, bg, border, uiBorder, layout.UniformInset(unit.Dp(10)), func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Body1(th, it.Time.Format("2006-01-02 15:04:05"))
+						l.Color = uiText
+						return l.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Caption(th, fmt.Sprintf("%d 个域名", it.Count))
+						l.Color = uiMuted
+						return l.Layout(gtx)
+					}),
+				)
+			})
+		})
+	})
+}
+
+func changeRow(th *material.Theme, gtx layout.Context, c history.Change) layout.Dimensions {
+	ipText := func(e history.Entry, present bool) string {
+		switch {
+		case !present:
+			return "—"
+		case e.IP == "":
+			return "失败"
+		}
+		return e.IP
+	}
+	p95Text := func(e history.Entry) string {
+		if e.IP == "" {
+			return "—"
+		}
+		return e.P95.String()
+	}
+	delta, fg := "", uiMuted
+	if d, ok := c.Delta(); ok {
+		delta = d.String()
+		switch {
+		case d < 0:
+			fg = uiDiffAddFg
+		case d > 0:
+			delta = "+" + delta
+			fg = uiDiffDelFg
+		}
+	}
+	bg := color.NRGBA{}
+	if c.IPChanged() {
+		bg = color.NRGBA{A: 255, R: 255, G: 248, B: 225}
+	}
+	return changeLine(th, gtx, fg, bg,
+		c.Domain,
+		ipText(c.Old, c.InOld)+" → "+ipText(c.New, c.InNew),
+		p95Text(c.Old)+" → "+p95Text(c.New),
+		delta,
+	)
+}
+
+func changeLine(th *material.Theme, gtx layout.Context, deltaFg, bg color.NRGBA, domain, ips, p95, delta string) layout.Dimensions {
+	cell := func(weight float32, s string, fg color.NRGBA) layout.FlexChild {
+		return layout.Flexed(weight, func(gtx layout.Context) layout.Dimensions {
+			l := material.Body2(th, s)
+			l.Color = fg
+			l.MaxLines = 1
+			return l.Layout(gtx)
+		})
+	}
+	m := op.Record(gtx.Ops)
+	dims := layout.Inset{Left: unit.Dp(6), Right: unit.Dp(6), Top: unit.Dp(4), Bottom: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+			cell(0.30, domain, uiText),
+			cell(0.34, ips, uiText),
+			cell(0.24, p95, uiText),
+			cell(0.12, delta, deltaFg),
+		)
+	})
+	call := m.Stop()
+	if bg.A != 0 {
+		paint.FillShape(gtx.Ops, bg, clip.Rect{Max: image.Pt(dims.Size.X, dims.Size.Y)}.Op())
+	}
+	call.Add(gtx.Ops)
+	return dims
+}
@@ -0,0 +1,376 @@
+package ui
+
+import (
+	"fmt"
+	"image/color"
+	"net/netip"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/engine"
+	"example.com/ip-opt-gui/internal/model"
+)
+
+func (r *row) useCandidate(c model.CandidateStat) {
+	r.BestIP = c.IP.String()
+	r.Via = c.ResolvedVia
+	r.TTL = r.MinTTL
+	if c.TTL > 0 {
+		r.TTL = c.TTL
+	}
+	r.Rate = c.SuccessRate()
+	r.P50 = c.P50
+	r.P95 = c.P95
+	r.ASN = c.ASN
+	r.Jitter = r.Metric.Of(c)
+	r.Loss, r.LossKnown = c.LossRate()
+	r.Score, r.ScoreBy = engine.Score(c, r.Metric)
+	r.Picked = len(r.Candidates) > 0 && c.IP != r.Candidates[0].IP
+}
+
+func (r *row) overrideIP() (string, bool) {
+	s := strings.TrimSpace(r.Override)
+	if s == "" {
+		return "", false
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil || ip.IsUnspecified() {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+func (r *row) effectiveIP() string {
+	if ip, ok := r.overrideIP(); ok {
+		return ip
+	}
+	if r.Message != "" {
+		return ""
+	}
+	return r.BestIP
+}
+
+func linkButton(th *material.Theme, gtx layout.Context, c *widget.Clickable, label string, onClick func()) layout.Dimensions {
+	for c.Clicked(gtx) {
+		onClick()
+	}
+	return material.Clickable(gtx, c, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(unit.Dp(4)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, label)
+			l.Color = uiPrimary
+			return l.Layout(gtx)
+		})
+	})
+}
+
+func candidateDetail(th *material.Theme, gtx layout.Context, target *row, w *rowWidgets) layout.Dimensions {
+	if len(w.CandBtns) != len(target.Candidates) {
+		w.CandBtns = make([]widget.Clickable, len(target.Candidates))
+	}
+	var maxSample, maxP95 time.Duration
+	for _, c := range target.Candidates {
+		for _, s := range c.Samples {
+			maxSample = max(maxSample, s)
+		}
+		maxP95 = max(maxP95, c.P95)
+	}
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			header := func(gtx layout.Context) layout.Dimensions {
+				l := material.Caption(th, "样本 / p95 对比")
+				l.Color = uiText
+				return l.Layout(gtx)
+			}
+			return candidateLine(th, gtx, true, "IP", "成功率", "p50", "p95", "抖动", "QUIC", "来源", "TTL", "位置 / ASN", "错误", header, nil)
+		}),
+	}
+	for i := range target.Candidates {
+		c := target.Candidates[i]
+		btn := &w.CandBtns[i]
+		viz := func(gtx layout.Context) layout.Dimensions {
+			col := uiChartBar
+			if c.Successes == 0 {
+				col = uiChartFail
+			}
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return sparkline(gtx, c.Samples, c.Failures, maxSample, unit.Dp(56), unit.Dp(16))
+				}),
+				layout.Rigid(spacer(unit.Dp(6))),
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					var frac float64
+					if maxP95 > 0 {
+						frac = float64(c.P95) / float64(maxP95)
+					}
+					return ratioBar(gtx, frac, unit.Dp(6), col)
+				}),
+			)
+		}
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			chosen := c.IP.String() == target.BestIP
+			action := func(gtx layout.Context) layout.Dimensions {
+				if chosen {
+					l := material.Caption(th, "✓ 使用中")
+					l.Color = uiText
+					return layout.UniformInset(unit.Dp(4)).Layout(gtx, l.Layout)
+				}
+				return linkButton(th, gtx, btn, "使用此 IP", func() {
+					target.useCandidate(c)
+					target.Apply = true
+				})
+			}
+			return candidateLine(th, gtx, chosen,
+				c.IP.String(),
+				rateText(c),
+				c.P50.String(),
+				p95Text(c),
+				target.Metric.Of(c).String(),
+				quicText(c),
+				viaText(c),
+				ttlText(c.TTL),
+				c.Location,
+				errorText(c),
+				viz,
+				action,
+			)
+		}))
+	}
+	if len(target.Candidates) == 0 {
+		children = nil
+	}
+	head := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return overrideField(th, gtx, target, w)
+		}),
+	}
+	for _, line := range cnameLines(target.Domain, target.CNAMEs) {
+		head = append(head, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, line)
+			l.Color = uiMuted
+			return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, l.Layout)
+		}))
+	}
+	for _, c := range target.Candidates {
+		if len(c.Hops) == 0 {
+			continue
+		}
+		line := fmt.Sprintf("路由（%s，%d 跳）：%s", c.IP, len(c.Hops), hopsText(c.Hops))
+		head = append(head, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, line)
+			l.Color = uiMuted
+			return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, l.Layout)
+		}))
+	}
+	if len(target.Hijacked) > 0 {
+		ips := make([]string, len(target.Hijacked))
+		for i, ip := range target.Hijacked {
+			ips[i] = ip.String()
+		}
+		line := "⚠ 疑似 DNS 污染：" + strings.Join(ips, ", ") + " 只由普通 DNS 返回，可信 DoH 的应答中没有"
+		head = append(head, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, line)
+			l.Color = uiDanger
+			return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, l.Layout)
+		}))
+	}
+	if len(target.DNSErrors) > 0 {
+		lines := make([]string, len(target.DNSErrors))
+		for i, e := range target.DNSErrors {
+			lines[i] = fmt.Sprintf("%s（共尝试 %d 次）", e, e.Attempts)
+		}
+		head = append(head, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, "DNS 失败："+strings.Join(lines, "；"))
+			l.Color = uiDanger
+			return layout.Inset{Bottom: unit.Dp(6)}.Layout(gtx, l.Layout)
+		}))
+	}
+	children = append(head, children...)
+	return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return card(gtx, uiRadiusSmall, uiBg, uiBorderCol, uiBorder, layout.UniformInset(unit.Dp(8)), func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+		})
+	})
+}
+
+func cnameLines(domain string, chains []model.CNAMEChain) []string {
+	var order []string
+	servers := map[string][]string{}
+	for _, c := range chains {
+		key := strings.Join(append([]string{domain}, c.Chain...), " → ")
+		if _, ok := servers[key]; !ok {
+			order = append(order, key)
+		}
+		servers[key] = append(servers[key], c.Server)
+	}
+	lines := make([]string, len(order))
+	for i, key := range order {
+		lines[i] = fmt.Sprintf("CNAME：%s（%s）", key, strings.Join(servers[key], ", "))
+	}
+	return lines
+}
+
+func overrideField(th *material.Theme, gtx layout.Context, target *row, w *rowWidgets) layout.Dimensions {
+	for {
+		ev, ok := w.OverrideEd.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.ChangeEvent); ok {
+			target.Override = w.OverrideEd.Text()
+			if _, valid := target.overrideIP(); valid {
+				target.Apply = true
+			}
+		}
+	}
+	text := strings.TrimSpace(target.Override)
+	_, valid := target.overrideIP()
+	return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				l := material.Caption(th, "自定义 IP：")
+				l.Color = uiText
+				return l.Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Max.X = gtx.Dp(unit.Dp(260))
+				gtx.Constraints.Min.X = gtx.Constraints.Max.X
+				return editorLine(th, gtx, &w.OverrideEd, "留空则使用测得的最优 IP")
+			}),
+			layout.Rigid(spacer(unit.Dp(8))),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				switch {
+				case text == "":
+					return layout.Dimensions{}
+				case !valid:
+					l := material.Caption(th, "IP 无效，将忽略")
+					l.Color = uiDanger
+					return l.Layout(gtx)
+				default:
+					l := material.Caption(th, "写入时使用此 IP")
+					l.Color = uiMuted
+					return l.Layout(gtx)
+				}
+			}),
+		)
+	})
+}
+
+func rateText(c model.CandidateStat) string {
+	s := fmt.Sprintf("%.0f%% (%d/%d)", c.SuccessRate()*100, c.Successes, c.Attempts())
+	if l, ok := c.LossRate(); ok {
+		s += fmt.Sprintf(" 丢包 %.0f%%", l*100)
+	}
+	return s
+}
+
+func viaText(c model.CandidateStat) string {
+	if c.Hijacked {
+		return "⚠ " + c.ResolvedVia
+	}
+	return c.ResolvedVia
+}
+
+func ttlText(ttl time.Duration) string {
+	if ttl <= 0 {
+		return "-"
+	}
+	return ttl.String()
+}
+
+func p95Text(c model.CandidateStat) string {
+	if c.Outliers > 0 {
+		return fmt.Sprintf("%s (-%d)", c.P95, c.Outliers)
+	}
+	return c.P95.String()
+}
+
+// scoreText spells out how each measurement contributed, e.g.
+// "成功率 100% → +50，p95 38ms → +32，抖动 4ms → +14".
+func scoreText(total float64, parts []engine.ScorePart) string {
+	items := make([]string, 0, len(parts))
+	for _, p := range parts {
+		var s string
+		switch p.Kind {
+		case engine.ScoreSuccess:
+			s = fmt.Sprintf("成功率 %.0f%%", p.Rate*100)
+		case engine.ScoreLatency:
+			s = "p95 " + p.Value.Round(time.Millisecond).String()
+		case engine.ScoreJitter:
+			s = "抖动 " + p.Value.Round(100*time.Microsecond).String()
+		case engine.ScoreLoss:
+			s = fmt.Sprintf("丢包 %.0f%%", p.Rate*100)
+		case engine.ScoreBlackhole:
+			s = "大包卡住"
+		}
+		items = append(items, fmt.Sprintf("%s → %+.0f", s, p.Points))
+	}
+	return fmt.Sprintf("评分 %.0f = %s（排序仍按成功率优先，评分仅用于说明）", total, strings.Join(items, "，"))
+}
+
+func hopsText(hops []model.Hop) string {
+	parts := make([]string, len(hops))
+	for i, h := range hops {
+		if !h.IP.IsValid() {
+			parts[i] = fmt.Sprintf("%d *", h.TTL)
+			continue
+		}
+		parts[i] = fmt.Sprintf("%d %s (%s)", h.TTL, h.IP, h.RTT.Round(100*time.Microsecond))
+	}
+	return strings.Join(parts, " → ")
+}
+
+func errorText(c model.CandidateStat) string {
+	if c.MTUBlackhole {
+		return "疑似 MTU 黑洞"
+	}
+	return c.LastError
+}
+
+func quicText(c model.CandidateStat) string {
+	switch {
+	case !c.QUICProbed():
+		return "-"
+	case c.QUICSuccesses == 0:
+		return "失败"
+	}
+	return fmt.Sprintf("%s (%d/%d)", c.QUICP50, c.QUICSuccesses, c.QUICSuccesses+c.QUICFailures)
+}
+
+func candidateLine(th *material.Theme, gtx layout.Context, strong bool, ip, rate, p50, p95, jitter, quic, via, ttl, loc, lastErr string, viz, action layout.Widget) layout.Dimensions {
+	cell := func(weight float32, s string, col color.NRGBA) layout.FlexChild {
+		return layout.Flexed(weight, func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, s)
+			l.Color = col
+			l.MaxLines = 1
+			return l.Layout(gtx)
+		})
+	}
+	fg := uiMuted
+	if strong {
+		fg = uiText
+	}
+	if action == nil {
+		action = func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }
+	}
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+		cell(0.14, ip, fg),
+		cell(0.09, rate, fg),
+		cell(0.07, p50, fg),
+		cell(0.07, p95, fg),
+		cell(0.07, jitter, fg),
+		cell(0.07, quic, fg),
+		layout.Flexed(0.12, func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Right: unit.Dp(8)}.Layout(gtx, viz)
+		}),
+		cell(0.10, via, fg),
+		cell(0.05, ttl, fg),
+		cell(0.09, loc, fg),
+		cell(0.08, lastErr, uiDanger),
+		layout.Flexed(0.10, action),
+	)
+}
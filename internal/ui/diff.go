@@ -0,0 +1,62 @@
+package ui
+
+import (
+	"image"
+	"image/color"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/hostsfile"
+)
+
+var (
+	uiDiffAddBg = color.NRGBA{A: 255, R: 230, G: 248, B: 234}
+	uiDiffAddFg = color.NRGBA{A: 255, R: 26, G: 127, B: 55}
+	uiDiffDelBg = color.NRGBA{A: 255, R: 255, G: 235, B: 233}
+	uiDiffDelFg = color.NRGBA{A: 255, R: 207, G: 34, B: 46}
+)
+
+func diffView(th *material.Theme, gtx layout.Context, list *layout.List, lines []hostsfile.DiffLine, empty string) layout.Dimensions {
+	if len(lines) == 0 {
+		l := material.Caption(th, empty)
+		l.Color = uiMuted
+		return l.Layout(gtx)
+	}
+	return list.Layout(gtx, len(lines), func(gtx layout.Context, i int) layout.Dimensions {
+		return diffLineRow(th, gtx, lines[i])
+	})
+}
+
+func diffLineRow(th *material.Theme, gtx layout.Context, dl hostsfile.DiffLine) layout.Dimensions {
+	fg, bg := uiText, color.NRGBA{}
+	switch dl.Op {
+	case hostsfile.DiffAdd:
+		fg, bg = uiDiffAddFg, uiDiffAddBg
+	case hostsfile.DiffRemove:
+		fg, bg = uiDiffDelFg, uiDiffDelBg
+	case hostsfile.DiffHunk:
+		fg = uiMuted
+	}
+
+	m := op.Record(gtx.Ops)
+	dims := layout.Inset{Left: unit.Dp(6), Right: unit.Dp(6), Top: unit.Dp(1), Bottom: unit.Dp(1)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		l := material.Body2(th, dl.String())
+		l.Color = fg
+		l.Font.Typeface = "monospace"
+		l.MaxLines = 1
+		return l.Layout(gtx)
+	})
+	call := m.Stop()
+
+	dims.Size.X = gtx.Constraints.Max.X
+	if bg.A != 0 {
+		paint.FillShape(gtx.Ops, bg, clip.Rect{Max: image.Pt(dims.Size.X, dims.Size.Y)}.Op())
+	}
+	call.Add(gtx.Ops)
+	return dims
+}
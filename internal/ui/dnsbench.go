@@ -0,0 +1,144 @@
+package ui
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/engine"
+)
+
+// recommendedServers is the benchmark order minus servers that never
+// answered, ready to replace the DNS server list.
+func recommendedServers(bs []engine.ResolverBench) []string {
+	var out []string
+	for _, b := range bs {
+		if len(b.Samples) > 0 {
+			out = append(out, b.Server)
+		}
+	}
+	return out
+}
+
+func dnsBenchPage(th *material.Theme, gtx layout.Context,
+	list *layout.List,
+	domainsEd, roundsEd *widget.Editor,
+	runBtn, applyBtn *widget.Clickable,
+	results []engine.ResolverBench,
+	benching bool, done, total int,
+	onRun, onApply func(),
+) layout.Dimensions {
+	recommended := recommendedServers(results)
+	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return layout.Flex{Axis: layout.Horizontal, Alignment: layout.End}.Layout(gtx,
+								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+									return sectionTitle(th, gtx, "DNS 测速")
+								}),
+								layout.Rigid(spacer(uiGap)),
+								layout.Flexed(0.2, func(gtx layout.Context) layout.Dimensions {
+									return labeledEditor(th, gtx, "每个域名查询次数", roundsEd)
+								}),
+								layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+									label := "开始测试"
+									if benching {
+										label = fmt.Sprintf("测试中 %d / %d", done, total)
+									}
+									return actionButton(th, gtx, runBtn, label, !benching, uiPrimary, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, onRun)
+								}),
+							)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return editorBox(th, gtx, domainsEd, unit.Dp(78), "用于测试的域名（每行一个）")
+						}),
+						layout.Rigid(spacer(unit.Dp(6))),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							l := material.Caption(th, "测试「配置」页中填写的 DNS 服务器：逐个发送 A 查询（不重试），统计失败率和响应时间，并检查各服务器的应答是否与其他服务器一致")
+							l.Color = uiMuted
+							return l.Layout(gtx)
+						}),
+					)
+				})
+			}),
+			layout.Rigid(spacer(uiGap)),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if len(recommended) == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Bottom: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return card(gtx, uiRadius, uiSurface, uiPrimary, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+						return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+							layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+								l := material.Body2(th, "建议顺序："+strings.Join(recommended, " → "))
+								l.Color = uiText
+								return l.Layout(gtx)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return actionButton(th, gtx, applyBtn, "按此顺序更新 DNS 列表", !benching, uiSurface, uiText, onApply)
+							}),
+						)
+					})
+				})
+			}),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+					if len(results) == 0 {
+						l := material.Caption(th, "还没有测试结果")
+						l.Color = uiMuted
+						return l.Layout(gtx)
+					}
+					return list.Layout(gtx, len(results)+1, func(gtx layout.Context, i int) layout.Dimensions {
+						if i == 0 {
+							return dnsBenchLine(th, gtx, true, "#", "服务器", "失败率", "中位耗时", "P90", "应答与其他不同", "最近错误")
+						}
+						b := results[i-1]
+						median, p90 := "-", "-"
+						if len(b.Samples) > 0 {
+							median, p90 = b.Median.Round(100*time.Microsecond).String(), b.P90.Round(100*time.Microsecond).String()
+						}
+						diverged := "-"
+						if b.Compared > 0 {
+							diverged = fmt.Sprintf("%.0f%% (%d/%d)", b.DivergenceRate()*100, b.Diverged, b.Compared)
+						}
+						return dnsBenchLine(th, gtx, false, fmt.Sprint(i), b.Server,
+							fmt.Sprintf("%.0f%% (%d/%d)", b.FailureRate()*100, b.Failures, b.Queries),
+							median, p90, diverged, b.LastError)
+					})
+				})
+			}),
+		)
+	})
+}
+
+func dnsBenchLine(th *material.Theme, gtx layout.Context, strong bool, cells ...string) layout.Dimensions {
+	weights := []float32{0.04, 0.2, 0.12, 0.1, 0.1, 0.14, 0.3}
+	children := make([]layout.FlexChild, len(cells))
+	for i, s := range cells {
+		children[i] = layout.Flexed(weights[i], func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, s)
+			l.Color = uiMuted
+			if strong {
+				l.Color = uiText
+			}
+			if i == len(cells)-1 && !strong {
+				l.Color = uiDanger
+			}
+			l.MaxLines = 1
+			return layout.Inset{Top: unit.Dp(4), Bottom: unit.Dp(4)}.Layout(gtx, l.Layout)
+		})
+	}
+	return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, children...)
+}
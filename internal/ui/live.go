@@ -0,0 +1,97 @@
+package ui
+
+import (
+	"sync"
+
+	"example.com/ip-opt-gui/internal/engine"
+	"example.com/ip-opt-gui/internal/model"
+)
+
+// liveUpdate is the newest in-progress state of one domain: its stage and,
+// once a probe or round has finished, the provisional best.
+type liveUpdate struct {
+	Domain      string
+	Stage       engine.Stage
+	Done, Total int
+	Result      model.DomainResult
+	HasResult   bool
+}
+
+// liveRows keeps one slot per domain for stage and candidate updates. The
+// engine reports these once per probe, far more often than frames are drawn,
+// so producers overwrite the slot and the frame loop takes the latest state
+// instead of queueing a message per probe on uiCh.
+type liveRows struct {
+	mu    sync.Mutex
+	slots map[string]*liveUpdate
+	order []string
+}
+
+func (l *liveRows) slot(d string) *liveUpdate {
+	if l.slots == nil {
+		l.slots = map[string]*liveUpdate{}
+	}
+	u, ok := l.slots[d]
+	if !ok {
+		u = &liveUpdate{Domain: d}
+		l.slots[d] = u
+		l.order = append(l.order, d)
+	}
+	return u
+}
+
+func (l *liveRows) stage(d string, s engine.Stage, done, total int) {
+	if s == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u := l.slot(d)
+	u.Stage, u.Done, u.Total = s, done, total
+}
+
+func (l *liveRows) result(r model.DomainResult) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u := l.slot(r.Domain)
+	u.Result, u.HasResult = r, true
+}
+
+// drop discards a domain's pending slot. Producers call it before sending
+// the final result, so a provisional update cannot land after it.
+func (l *liveRows) drop(d string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.slots[d]; !ok {
+		return
+	}
+	delete(l.slots, d)
+	for i, o := range l.order {
+		if o == d {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// take returns the pending updates in the order domains first reported.
+func (l *liveRows) take() []liveUpdate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]liveUpdate, 0, len(l.order))
+	for _, d := range l.order {
+		out = append(out, *l.slots[d])
+	}
+	clear(l.slots)
+	l.order = l.order[:0]
+	return out
+}
+
+// reset discards every pending slot, for when a run ends and the stages of
+// domains it never finished must not reappear.
+func (l *liveRows) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.slots)
+	l.order = l.order[:0]
+}
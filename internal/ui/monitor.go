@@ -0,0 +1,186 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/domain"
+	"example.com/ip-opt-gui/internal/engine"
+)
+
+const (
+	monitorHistory = 120
+	monitorWindow  = 10
+)
+
+type monitorTrack struct {
+	Domain    string
+	IP        string
+	Samples   []engine.MonitorSample
+	Flagged   bool
+	Replacing bool
+}
+
+func (t *monitorTrack) add(s engine.MonitorSample) {
+	t.Samples = append(t.Samples, s)
+	if len(t.Samples) > monitorHistory {
+		t.Samples = t.Samples[len(t.Samples)-monitorHistory:]
+	}
+}
+
+func (t *monitorTrack) recentRate() (float64, int) {
+	recent := t.Samples[max(len(t.Samples)-monitorWindow, 0):]
+	if len(recent) == 0 {
+		return 0, 0
+	}
+	ok := 0
+	for _, s := range recent {
+		if s.OK() {
+			ok++
+		}
+	}
+	return float64(ok) / float64(len(recent)), len(recent)
+}
+
+func monitorPage(th *material.Theme, gtx layout.Context,
+	list *layout.List,
+	tracks []*monitorTrack,
+	intervalEd, thresholdEd, metricsAddrEd *widget.Editor,
+	auto *widget.Bool,
+	toggleBtn *widget.Clickable,
+	monitoring bool,
+	onToggle func(),
+) layout.Dimensions {
+	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.End}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return sectionTitle(th, gtx, "监控")
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Flexed(0.25, func(gtx layout.Context) layout.Dimensions {
+							return labeledEditor(th, gtx, "间隔(秒)", intervalEd)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Flexed(0.25, func(gtx layout.Context) layout.Dimensions {
+							return labeledEditor(th, gtx, "成功率阈值(%)", thresholdEd)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Flexed(0.3, func(gtx layout.Context) layout.Dimensions {
+							return labeledEditor(th, gtx, "指标地址(/metrics，留空关闭)", metricsAddrEd)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(material.CheckBox(th, auto, "低于阈值时自动替换").Layout),
+						layout.Flexed(0.1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							if monitoring {
+								return actionButton(th, gtx, toggleBtn, "停止监控", true, uiDanger, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, onToggle)
+							}
+							return actionButton(th, gtx, toggleBtn, "开始监控", true, uiPrimary, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, onToggle)
+						}),
+					)
+				})
+			}),
+			layout.Rigid(spacer(uiGap)),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+					if len(tracks) == 0 {
+						l := material.Caption(th, "监控已勾选结果的 IP：定期探测并记录延迟，成功率低于阈值时标记或自动重新优选")
+						l.Color = uiMuted
+						return l.Layout(gtx)
+					}
+					return list.Layout(gtx, len(tracks), func(gtx layout.Context, i int) layout.Dimensions {
+						return monitorRow(th, gtx, tracks[i])
+					})
+				})
+			}),
+		)
+	})
+}
+
+func monitorRow(th *material.Theme, gtx layout.Context, t *monitorTrack) layout.Dimensions {
+	rate, n := t.recentRate()
+	var maxV time.Duration
+	var last string
+	for _, s := range t.Samples {
+		maxV = max(maxV, s.Latency)
+	}
+	if len(t.Samples) > 0 {
+		s := t.Samples[len(t.Samples)-1]
+		last = s.Latency.Round(time.Millisecond).String()
+		if !s.OK() {
+			last = "失败"
+		}
+	}
+	border := uiBorderCol
+	if t.Flagged {
+		border = uiDanger
+	}
+	return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return card(gtx, uiRadiusSmall, uiSurface, border, uiBorder, layout.UniformInset(unit.Dp(10)), func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(0.35, func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							l := material.Body1(th, domain.Display(t.Domain))
+							l.Color = uiText
+							return l.Layout(gtx)
+						}),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							s := fmt.Sprintf("%s  近 %d 次成功 %.0f%%  最近 %s", t.IP, n, rate*100, last)
+							col := uiMuted
+							switch {
+							case t.Replacing:
+								s += "  替换中…"
+							case t.Flagged:
+								s += "  低于阈值"
+								col = uiDanger
+							}
+							l := material.Caption(th, s)
+							l.Color = col
+							return l.Layout(gtx)
+						}),
+					)
+				}),
+				layout.Rigid(spacer(uiGap)),
+				layout.Flexed(0.65, func(gtx layout.Context) layout.Dimensions {
+					return timeline(gtx, t.Samples, maxV, unit.Dp(28))
+				}),
+			)
+		})
+	})
+}
+
+func timeline(gtx layout.Context, samples []engine.MonitorSample, maxV time.Duration, height unit.Dp) layout.Dimensions {
+	size := image.Pt(gtx.Constraints.Max.X, gtx.Dp(height))
+	paint.FillShape(gtx.Ops, uiChartBase, clip.Rect{Min: image.Pt(0, size.Y-1), Max: size}.Op())
+	if len(samples) == 0 {
+		return layout.Dimensions{Size: size}
+	}
+	barW := max(size.X/monitorHistory, 1)
+	x := size.X - barW*len(samples)
+	for _, s := range samples {
+		h := size.Y
+		col := uiChartFail
+		if s.OK() && maxV > 0 {
+			col = uiChartBar
+			h = max(min(int(float64(size.Y)*float64(s.Latency)/float64(maxV)), size.Y), 2)
+		}
+		if x >= 0 {
+			paint.FillShape(gtx.Ops, col, clip.Rect{Min: image.Pt(x, size.Y-h), Max: image.Pt(x+max(barW-1, 1), size.Y)}.Op())
+		}
+		x += barW
+	}
+	return layout.Dimensions{Size: size}
+}
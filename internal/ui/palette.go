@@ -0,0 +1,159 @@
+package ui
+
+import (
+	"image/color"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// command is one palette entry; Alias holds English words so both "写入" and
+// "write hosts" find the same action.
+type command struct {
+	Title string
+	Alias string
+	Run   func()
+}
+
+const paletteMaxItems = 10
+
+// fuzzyScore matches query as a case-insensitive subsequence of s. Runs of
+// consecutive characters and matches at word starts score higher.
+func fuzzyScore(query, s string) (int, bool) {
+	q := []rune(strings.ToLower(strings.TrimSpace(query)))
+	if len(q) == 0 {
+		return 0, true
+	}
+	t := []rune(strings.ToLower(s))
+	score, qi, prev := 0, 0, -2
+	for i, r := range t {
+		if qi == len(q) {
+			break
+		}
+		if q[qi] == ' ' {
+			qi++
+			if qi == len(q) {
+				break
+			}
+		}
+		if r != q[qi] {
+			continue
+		}
+		score++
+		if i == prev+1 {
+			score += 3
+		}
+		if i == 0 || !unicode.IsLetter(t[i-1]) && !unicode.IsDigit(t[i-1]) {
+			score += 2
+		}
+		prev = i
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	return score, true
+}
+
+func matchCommands(cmds []command, query string) []command {
+	type scored struct {
+		cmd   command
+		score int
+	}
+	var out []scored
+	for _, c := range cmds {
+		st, okT := fuzzyScore(query, c.Title)
+		sa, okA := fuzzyScore(query, c.Alias)
+		if okT || okA {
+			out = append(out, scored{c, max(st, sa)})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score > out[j].score })
+	cmds = make([]command, 0, min(len(out), paletteMaxItems))
+	for _, s := range out[:min(len(out), paletteMaxItems)] {
+		cmds = append(cmds, s.cmd)
+	}
+	return cmds
+}
+
+// commandPalette draws the palette over the whole window. Enter runs the
+// first match, clicking runs any of them, and clicking outside closes it.
+func commandPalette(th *material.Theme, gtx layout.Context, ed *widget.Editor, scrim *widget.Clickable, btns []widget.Clickable, cmds []command, onRun func(command), onClose func()) layout.Dimensions {
+	ed.SingleLine = true
+	ed.Submit = true
+	matches := matchCommands(cmds, ed.Text())
+	for {
+		ev, ok := ed.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.SubmitEvent); ok && len(matches) > 0 {
+			onRun(matches[0])
+			return layout.Dimensions{}
+		}
+	}
+	for scrim.Clicked(gtx) {
+		onClose()
+		return layout.Dimensions{}
+	}
+	for i := range matches {
+		for btns[i].Clicked(gtx) {
+			onRun(matches[i])
+			return layout.Dimensions{}
+		}
+	}
+
+	size := gtx.Constraints.Max
+	scrim.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		paint.FillShape(gtx.Ops, color.NRGBA{A: 96}, clip.Rect{Max: size}.Op())
+		return layout.Dimensions{Size: size}
+	})
+	return layout.Inset{Top: unit.Dp(80)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.N.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Max.X = min(gtx.Constraints.Max.X, gtx.Dp(unit.Dp(520)))
+			gtx.Constraints.Min.X = gtx.Constraints.Max.X
+			return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+				children := []layout.FlexChild{
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return editorLine(th, gtx, ed, "输入操作名称，如 写入、start、export…")
+					}),
+					layout.Rigid(spacer(unit.Dp(6))),
+				}
+				if len(matches) == 0 {
+					children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Caption(th, "没有匹配的操作")
+						l.Color = uiMuted
+						return l.Layout(gtx)
+					}))
+				}
+				for i, c := range matches {
+					children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.Clickable(gtx, &btns[i], func(gtx layout.Context) layout.Dimensions {
+							return layout.Inset{Top: unit.Dp(6), Bottom: unit.Dp(6), Left: unit.Dp(8), Right: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								gtx.Constraints.Min.X = gtx.Constraints.Max.X
+								title := c.Title
+								if i == 0 {
+									title += "  ⏎"
+								}
+								l := material.Body1(th, title)
+								l.Color = uiText
+								if i == 0 {
+									l.Color = uiPrimary
+								}
+								return l.Layout(gtx)
+							})
+						})
+					}))
+				}
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+			})
+		})
+	})
+}
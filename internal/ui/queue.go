@@ -0,0 +1,26 @@
+package ui
+
+import "sync"
+
+// uiQueue carries messages that finish a piece of background work. Sending
+// never blocks and nothing is dropped: Gio draws no frames while the window
+// is minimized, so a bounded channel would fill up and stall the workers
+// until the window is restored.
+type uiQueue struct {
+	mu   sync.Mutex
+	msgs []any
+}
+
+func (q *uiQueue) send(m any) {
+	q.mu.Lock()
+	q.msgs = append(q.msgs, m)
+	q.mu.Unlock()
+}
+
+func (q *uiQueue) take() []any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := q.msgs
+	q.msgs = nil
+	return out
+}
@@ -0,0 +1,75 @@
+package ui
+
+import (
+	"fmt"
+	"image/color"
+	"net/netip"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/widget/material"
+
+	"example.com/ip-opt-gui/internal/engine"
+)
+
+func tallyResolvers(rows []row) []engine.ResolverStat {
+	var t engine.ResolverTally
+	for _, r := range rows {
+		var best netip.Addr
+		if r.Message == "" {
+			best, _ = netip.ParseAddr(r.BestIP)
+		}
+		t.Add(r.Queries, best)
+	}
+	return t.Stats()
+}
+
+func resolverPanel(th *material.Theme, gtx layout.Context, stats []engine.ResolverStat) layout.Dimensions {
+	line := func(strong bool, cells ...string) layout.FlexChild {
+		fg := uiMuted
+		if strong {
+			fg = uiText
+		}
+		return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			weights := []float32{0.34, 0.14, 0.14, 0.16, 0.22}
+			children := make([]layout.FlexChild, len(cells))
+			for i, s := range cells {
+				children[i] = layout.Flexed(weights[i], func(gtx layout.Context) layout.Dimensions {
+					return resolverCell(th, gtx, s, fg)
+				})
+			}
+			return layout.Flex{Axis: layout.Horizontal}.Layout(gtx, children...)
+		})
+	}
+	return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+		children := []layout.FlexChild{
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				l := material.Caption(th, "各 DNS 服务器本次的表现；「给出最优 IP」为其应答中包含最终所选 IP 的域名数，长期为 0 的服务器可以考虑去掉（缓存命中不计入查询数和耗时）")
+				l.Color = uiMuted
+				return l.Layout(gtx)
+			}),
+			line(true, "服务器", "查询", "成功", "平均耗时", "给出最优 IP / 有应答"),
+		}
+		if len(stats) == 0 {
+			children = append(children, line(false, "还没有 DNS 查询记录"))
+		}
+		for _, s := range stats {
+			rate, avg := "-", "-"
+			if s.Queries > 0 {
+				rate = fmt.Sprintf("%d（%.0f%%）", s.Successes, float64(s.Successes)/float64(s.Queries)*100)
+			}
+			if s.Successes > 0 {
+				avg = s.AvgRTT().Round(100 * time.Microsecond).String()
+			}
+			children = append(children, line(false, s.Server, fmt.Sprint(s.Queries), rate, avg, fmt.Sprintf("%d / %d", s.BestHits, s.Domains)))
+		}
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	})
+}
+
+func resolverCell(th *material.Theme, gtx layout.Context, s string, fg color.NRGBA) layout.Dimensions {
+	l := material.Caption(th, s)
+	l.Color = fg
+	l.MaxLines = 1
+	return layout.Inset{Top: uiGap / 3, Bottom: uiGap / 3}.Layout(gtx, l.Layout)
+}
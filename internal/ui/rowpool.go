@@ -0,0 +1,73 @@
+package ui
+
+import "gioui.org/widget"
+
+// rowWidgets is the widget state of one result row on screen. Rows only hold
+// data; the list borrows a rowWidgets for each row it lays out and gives it
+// back once the row scrolls out of view, so memory follows the viewport
+// rather than the number of domains.
+type rowWidgets struct {
+	domain string
+
+	Apply      widget.Bool
+	OverrideEd widget.Editor
+	SelBtn     widget.Clickable
+	DetailBtn  widget.Clickable
+	RetestBtn  widget.Clickable
+	SkipBtn    widget.Clickable
+	CopyBtn    widget.Clickable
+	ScoreBtn   widget.Clickable
+	CandBtns   []widget.Clickable
+}
+
+type rowPool struct {
+	live map[int]*rowWidgets
+	seen map[int]bool
+	free []*rowWidgets
+}
+
+func newRowPool() *rowPool {
+	return &rowPool{live: map[int]*rowWidgets{}, seen: map[int]bool{}}
+}
+
+// get returns the widgets for row i, binding a free set to it if the row was
+// not on screen in the previous frame.
+func (p *rowPool) get(i int, r *row) *rowWidgets {
+	p.seen[i] = true
+	if w := p.live[i]; w != nil && w.domain == r.Domain {
+		return w
+	}
+	w := p.live[i]
+	if w == nil {
+		if n := len(p.free); n > 0 {
+			w, p.free = p.free[n-1], p.free[:n-1]
+		} else {
+			w = new(rowWidgets)
+		}
+		p.live[i] = w
+	}
+	*w = rowWidgets{domain: r.Domain}
+	w.OverrideEd.SingleLine = true
+	w.OverrideEd.SetText(r.Override)
+	return w
+}
+
+// sweep releases the widgets of rows that were not laid out since the last
+// sweep; call it once per frame after the list.
+func (p *rowPool) sweep() {
+	for i, w := range p.live {
+		if !p.seen[i] {
+			delete(p.live, i)
+			p.free = append(p.free, w)
+		}
+	}
+	clear(p.seen)
+}
+
+func (p *rowPool) reset() {
+	for i, w := range p.live {
+		delete(p.live, i)
+		p.free = append(p.free, w)
+	}
+	clear(p.seen)
+}
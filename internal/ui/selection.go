@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"fmt"
+	"math/bits"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// The selection is separate from row.Apply: Apply decides what gets written,
+// the selection is what the bulk actions below and re-test/export act on.
+const (
+	selApply = iota
+	selUnapply
+	selClear
+	selActions
+)
+
+// bitset holds the selected row indexes. Rows are only appended during a
+// run and cleared as a whole before the next, so an index names the same row
+// for as long as the selection lives.
+type bitset []uint64
+
+func (b bitset) Has(i int) bool {
+	return i >= 0 && i/64 < len(b) && b[i/64]&(1<<(i%64)) != 0
+}
+
+func (b *bitset) Set(i int, on bool) {
+	if i < 0 {
+		return
+	}
+	for i/64 >= len(*b) {
+		*b = append(*b, 0)
+	}
+	if on {
+		(*b)[i/64] |= 1 << (i % 64)
+	} else {
+		(*b)[i/64] &^= 1 << (i % 64)
+	}
+}
+
+func (b bitset) Count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+func (b *bitset) Clear() { *b = (*b)[:0] }
+
+// selectRows applies a click on row i of n. A plain click toggles the row and
+// makes it the anchor; shift+click selects every row between the anchor and
+// i. It returns the new anchor.
+func selectRows(sel *bitset, n, anchor, i int, shift bool) int {
+	if i < 0 || i >= n {
+		return anchor
+	}
+	if shift && anchor >= 0 && anchor < n {
+		for j := min(anchor, i); j <= max(anchor, i); j++ {
+			sel.Set(j, true)
+		}
+		return anchor
+	}
+	sel.Set(i, !sel.Has(i))
+	return i
+}
+
+func selectionBar(th *material.Theme, gtx layout.Context, btns []widget.Clickable, n int, onAction func(action int)) layout.Dimensions {
+	return card(gtx, uiRadius, uiSurface, uiPrimary, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				l := material.Body2(th, fmt.Sprintf("已选 %d 行（Shift+点击域名可连续选择），重测和导出只作用于所选行", n))
+				l.Color = uiText
+				return l.Layout(gtx)
+			}),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return actionButton(th, gtx, &btns[selApply], "勾选写入", true, uiSurface, uiText, func() { onAction(selApply) })
+			}),
+			layout.Rigid(spacer(uiGap)),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return actionButton(th, gtx, &btns[selUnapply], "取消勾选", true, uiSurface, uiText, func() { onAction(selUnapply) })
+			}),
+			layout.Rigid(spacer(uiGap)),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return actionButton(th, gtx, &btns[selClear], "清除选择", true, uiSurface, uiText, func() { onAction(selClear) })
+			}),
+		)
+	})
+}
@@ -0,0 +1,46 @@
+package ui
+
+import (
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+)
+
+// targetStatus is the outcome of the last write for one hosts file; Err is
+// empty when it was written and read back intact.
+type targetStatus struct {
+	Path   string
+	Backup string
+	Err    string
+}
+
+// hostsTargets parses the extra hosts paths, one per line so paths with
+// spaces work; blank lines and # comments are skipped.
+func hostsTargets(text string) []string {
+	var out []string
+	for _, l := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func targetsPanel(th *material.Theme, gtx layout.Context, sts []targetStatus) layout.Dimensions {
+	children := make([]layout.FlexChild, 0, len(sts))
+	for _, st := range sts {
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			text, col := "✓ "+st.Path+"：已写入并校验", uiMuted
+			if st.Err != "" {
+				text, col = "✗ "+st.Path+"："+st.Err, uiDanger
+			}
+			l := material.Caption(th, text)
+			l.Color = col
+			l.MaxLines = 1
+			return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, l.Layout)
+		}))
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
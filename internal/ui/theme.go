@@ -0,0 +1,52 @@
+package ui
+
+import (
+	"image/color"
+
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+)
+
+type uiPalette struct {
+	bg, surface, borderCol, text, muted, primary, danger, success, errorBg color.NRGBA
+	border, ctrlH, ctrlHM                                                  unit.Dp
+}
+
+var defaultPalette = uiPalette{
+	bg: uiBg, surface: uiSurface, borderCol: uiBorderCol, text: uiText, muted: uiMuted,
+	primary: uiPrimary, danger: uiDanger, success: uiSuccess, errorBg: uiErrorBg,
+	border: uiBorder, ctrlH: uiCtrlH, ctrlHM: uiCtrlHM,
+}
+
+// contrastPalette keeps every foreground at WCAG AAA contrast against white,
+// drops the pale error tint (failed rows get a red border and a ✗ instead)
+// and enlarges borders and hit targets.
+var contrastPalette = uiPalette{
+	bg:        color.NRGBA{A: 255, R: 255, G: 255, B: 255},
+	surface:   color.NRGBA{A: 255, R: 255, G: 255, B: 255},
+	borderCol: color.NRGBA{A: 255, R: 0, G: 0, B: 0},
+	text:      color.NRGBA{A: 255, R: 0, G: 0, B: 0},
+	muted:     color.NRGBA{A: 255, R: 51, G: 51, B: 51},
+	primary:   color.NRGBA{A: 255, R: 0, G: 51, B: 170},
+	danger:    color.NRGBA{A: 255, R: 170, G: 0, B: 0},
+	success:   color.NRGBA{A: 255, R: 0, G: 100, B: 0},
+	errorBg:   color.NRGBA{A: 255, R: 255, G: 255, B: 255},
+	border:    2,
+	ctrlH:     48,
+	ctrlHM:    40,
+}
+
+var uiHighContrast bool
+
+func setHighContrast(th *material.Theme, on bool) {
+	p := defaultPalette
+	if on {
+		p = contrastPalette
+	}
+	uiHighContrast = on
+	uiBg, uiSurface, uiBorderCol, uiText, uiMuted = p.bg, p.surface, p.borderCol, p.text, p.muted
+	uiPrimary, uiDanger, uiSuccess, uiErrorBg = p.primary, p.danger, p.success, p.errorBg
+	uiBorder, uiCtrlH, uiCtrlHM = p.border, p.ctrlH, p.ctrlHM
+	th.FingerSize = uiCtrlH
+	th.Palette.Bg, th.Palette.Fg, th.Palette.ContrastBg = uiBg, uiText, uiPrimary
+}
@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+)
+
+// The rate is taken over the last minute of progress, so a slow DNS start or
+// a burst of cached results does not skew the estimate for the rest of the run.
+const (
+	throughputWindow = time.Minute
+	throughputMinGap = 3 * time.Second
+)
+
+type progressSample struct {
+	at   time.Time
+	done int
+}
+
+type throughput struct {
+	samples []progressSample
+}
+
+func (t *throughput) reset() { t.samples = t.samples[:0] }
+
+func (t *throughput) add(now time.Time, done int) {
+	t.samples = append(t.samples, progressSample{now, done})
+	i := 0
+	for i < len(t.samples)-1 && now.Sub(t.samples[i+1].at) >= throughputWindow {
+		i++
+	}
+	t.samples = t.samples[i:]
+}
+
+// perMinute is the completion rate up to now; it falls while nothing
+// finishes, so a stalled run shows a growing estimate rather than a frozen one.
+func (t *throughput) perMinute(now time.Time) (float64, bool) {
+	if len(t.samples) < 2 {
+		return 0, false
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	span := now.Sub(first.at)
+	if span < throughputMinGap || last.done <= first.done {
+		return 0, false
+	}
+	return float64(last.done-first.done) / span.Minutes(), true
+}
+
+func (t *throughput) eta(now time.Time, done, total int) (time.Duration, bool) {
+	rate, ok := t.perMinute(now)
+	if !ok || done >= total {
+		return 0, false
+	}
+	return time.Duration(float64(total-done) / rate * float64(time.Minute)), true
+}
+
+// speedText is the "42 个/分钟 · 剩余约 3 分 10 秒" part of the progress header.
+func speedText(t *throughput, running bool, done, total int) string {
+	if !running {
+		return ""
+	}
+	now := time.Now()
+	perMin, ok := t.perMinute(now)
+	if !ok {
+		return "正在估算速度…"
+	}
+	s := fmt.Sprintf("%.0f 个/分钟", perMin)
+	if eta, ok := t.eta(now, done, total); ok {
+		s += " · 剩余约 " + etaText(eta)
+	}
+	return s
+}
+
+func etaText(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d 秒", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d 分 %d 秒", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%d 小时 %d 分", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
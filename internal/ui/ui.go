@@ -2,18 +2,28 @@ package ui
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"io"
 	"math"
+	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"gioui.org/app"
+	"gioui.org/io/clipboard"
+	"gioui.org/io/event"
+	"gioui.org/io/key"
+	"gioui.org/io/transfer"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
@@ -23,28 +33,126 @@ import (
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 
+	"example.com/ip-opt-gui/internal/cdnranges"
+	"example.com/ip-opt-gui/internal/deploy"
+	"example.com/ip-opt-gui/internal/dnsserver"
 	"example.com/ip-opt-gui/internal/domain"
+	"example.com/ip-opt-gui/internal/dropfiles"
 	"example.com/ip-opt-gui/internal/engine"
+	"example.com/ip-opt-gui/internal/export"
 	"example.com/ip-opt-gui/internal/filedialog"
+	"example.com/ip-opt-gui/internal/geo"
+	"example.com/ip-opt-gui/internal/history"
 	"example.com/ip-opt-gui/internal/hostsfile"
+	"example.com/ip-opt-gui/internal/launch"
+	"example.com/ip-opt-gui/internal/logfile"
+	"example.com/ip-opt-gui/internal/metrics"
 	"example.com/ip-opt-gui/internal/model"
+	"example.com/ip-opt-gui/internal/netenv"
+	"example.com/ip-opt-gui/internal/notify"
+	"example.com/ip-opt-gui/internal/remote"
+	"example.com/ip-opt-gui/internal/report"
+	"example.com/ip-opt-gui/internal/runfile"
+	"example.com/ip-opt-gui/internal/settings"
+	"example.com/ip-opt-gui/internal/sysdns"
+	"example.com/ip-opt-gui/internal/webhook"
 )
 
 type row struct {
-	Domain  string
-	BestIP  string
-	Via     string
-	Rate    float64
-	P95     time.Duration
-	Jitter  time.Duration
-	Message string
-	Apply   widget.Bool
+	Domain    string
+	Group     string
+	BestIP    string
+	Via       string
+	ASN       string
+	Rate      float64
+	P50       time.Duration
+	P95       time.Duration
+	Jitter    time.Duration
+	Metric    engine.JitterMetric
+	Loss      float64
+	LossKnown bool
+	Score     float64
+	ScoreBy   []engine.ScorePart
+	Message   string
+	Stage     string
+	TTL       time.Duration
+	MinTTL    time.Duration
+	Apply     bool
+	Override  string
+
+	WrittenIP string
+	WrittenAt time.Time
+
+	Candidates []model.CandidateStat
+	CNAMEs     []model.CNAMEChain
+	DNSErrors  []model.ResolverError
+	Queries    []model.ResolverQuery
+	Picked     bool
+	Expanded   bool
+	Skipping   bool
+	Cached     bool
+	Hijacked   []netip.Addr
+	ScoreOpen  bool
+	Verify     verifyState
+	VerifyMsg  string
+
+	// Current holds the managed-block mappings the row was imported from,
+	// the shown one first; it is cleared once the domain is tested again.
+	Current []hostsfile.Mapping
 }
 
+type verifyState int
+
+const (
+	verifyNone verifyState = iota
+	verifyPending
+	verifyOK
+	verifyFailed
+)
+
 type msgLog struct{ Line string }
 type msgResult struct{ Result model.DomainResult }
 type msgProgress struct{ Done, Total int }
 type msgDone struct{ Err error }
+type msgRetested struct{ Result model.DomainResult }
+type msgVerified struct {
+	Result    engine.Verification
+	WrittenAt time.Time
+}
+type msgMonitorSample struct {
+	Gen    int
+	Sample engine.MonitorSample
+}
+type msgMonitorDone struct {
+	Gen int
+	Err error
+}
+type msgCDNRanges struct {
+	Key       string
+	Prefixes  []netip.Prefix
+	FromCache bool
+	Stale     bool
+	Err       error
+}
+type msgDNSBench struct {
+	Done, Total int
+	Results     []engine.ResolverBench
+}
+type msgDropped struct{ Paths []string }
+type msgHostsChanged struct{ Path string }
+type msgDeployed struct{ Result deploy.Result }
+type msgWSLDistros struct {
+	Distros []string
+	Err     error
+}
+type msgSubscription struct {
+	URL       string
+	Domains   []string
+	Hints     map[string][]netip.Addr
+	FromCache bool
+	Stale     bool
+	Err       error
+}
 type msgPickedPath struct {
 	Kind string
 	Path string
@@ -56,11 +164,28 @@ const (
 	uiGap         unit.Dp = 10
 	uiRadius      unit.Dp = 12
 	uiRadiusSmall unit.Dp = 10
-	uiBorder      unit.Dp = 1
-	uiCtrlH       unit.Dp = 40
-	uiCtrlHM      unit.Dp = 32
 )
 
+// Border width and control heights grow in the high-contrast palette, so they
+// live with the colors rather than the fixed dp constants.
+var (
+	uiBorder unit.Dp = 1
+	uiCtrlH  unit.Dp = 40
+	uiCtrlHM unit.Dp = 32
+)
+
+// The scale slider stores a 0..1 position; uiScale maps it onto this range,
+// multiplying every dp and sp so text and spacing grow together.
+const (
+	uiScaleMin = 0.75
+	uiScaleMax = 2.0
+)
+
+func uiScale(pos float32) float32 {
+	s := uiScaleMin + pos*(uiScaleMax-uiScaleMin)
+	return float32(math.Round(float64(s)*20) / 20)
+}
+
 var (
 	uiBg        = color.NRGBA{A: 255, R: 246, G: 247, B: 249}
 	uiSurface   = color.NRGBA{A: 255, R: 255, G: 255, B: 255}
@@ -69,6 +194,10 @@ var (
 	uiMuted     = color.NRGBA{A: 255, R: 110, G: 115, B: 125}
 	uiPrimary   = color.NRGBA{A: 255, R: 47, G: 108, B: 246}
 	uiDanger    = color.NRGBA{A: 255, R: 230, G: 70, B: 70}
+	uiSuccess   = color.NRGBA{A: 255, R: 30, G: 140, B: 70}
+	uiWarnBg    = color.NRGBA{A: 255, R: 255, G: 244, B: 229}
+	uiWarnFg    = color.NRGBA{A: 255, R: 154, G: 82, B: 0}
+	uiErrorBg   = color.NRGBA{A: 255, R: 255, G: 248, B: 248}
 )
 
 func Run() {
@@ -99,16 +228,51 @@ func loop(w *app.Window) error {
 
 	var (
 		domainsEd widget.Editor
+		subsEd    widget.Editor
 		dnsEd     widget.Editor
 		hostsEd   widget.Editor
 
 		portEd        widget.Editor
 		timeoutEd     widget.Editor
+		intervalEd    widget.Editor
+		roundsEd      widget.Editor
+		roundDelayEd  widget.Editor
 		attemptsEd    widget.Editor
 		concurrencyEd widget.Editor
+		rateEd        widget.Editor
+		lossEd        widget.Editor
+		expiryEd      widget.Editor
+		keepBakEd     widget.Editor
+		bakDaysEd     widget.Editor
+		bakDirEd      widget.Editor
+		slowEd        widget.Editor
+		minSamplesEd  widget.Editor
+		dohEd         widget.Editor
 
-		ipv4 widget.Bool
-		ipv6 widget.Bool
+		ipv4       widget.Bool
+		ipv6       widget.Bool
+		notifyDone widget.Bool
+		byPrefix   widget.Bool
+		cdnSeed    widget.Bool
+		dnsNoCache widget.Bool
+		dnsDisk    widget.Bool
+		adaptive   widget.Bool
+		autoConc   widget.Bool
+		reuseSame  widget.Bool
+		warmUp     widget.Bool
+		keepOnFail widget.Bool
+		fixDupes   widget.Bool
+		toFile     widget.Bool
+		keepBogons widget.Bool
+		httpsRR    widget.Bool
+		keepHijack widget.Bool
+		quicProbe  widget.Bool
+		quicScore  widget.Bool
+		mtuCheck   widget.Bool
+		traceBest  widget.Bool
+		fullReport widget.Bool
+		autoUndo   widget.Bool
+		wslNoGen   widget.Bool
 
 		startBtn   widget.Clickable
 		stopBtn    widget.Clickable
@@ -117,42 +281,219 @@ func loop(w *app.Window) error {
 		previewBtn widget.Clickable
 		writeBtn   widget.Clickable
 		restoreBtn widget.Clickable
+		undoBtn    widget.Clickable
+		redoBtn    widget.Clickable
 		pickHosts  widget.Clickable
+		editHosts  widget.Clickable
+
+		retestExpiredBtn widget.Clickable
+		dropExpiredBtn   widget.Clickable
+
+		pasteDomainsBtn widget.Clickable
+		subURLEd        widget.Editor
+		refreshSubsBtn  widget.Clickable
+		subsFetching    int
+		copyResultsBtn  widget.Clickable
+		copyMapsBtn     widget.Clickable
+		copyPreviewBtn  widget.Clickable
+		clipTag         int
+		clipRead        bool
+		clipWrite       *string
 
 		leftList    layout.List
 		resultsList layout.List
 
-		mainTab widget.Enum
+		mainTab      widget.Enum
+		scaleSlider  widget.Float
+		highContrast widget.Bool
+
+		paletteOpen  bool
+		paletteEd    widget.Editor
+		paletteScrim widget.Clickable
+		paletteBtns  = make([]widget.Clickable, paletteMaxItems)
 
 		tabConfigBtn  widget.Clickable
 		tabResultsBtn widget.Clickable
 		tabLogBtn     widget.Clickable
 		tabPreviewBtn widget.Clickable
+		tabBackupsBtn widget.Clickable
+		tabMonitorBtn widget.Clickable
+		tabCompareBtn widget.Clickable
+		tabDNSBtn     widget.Clickable
 
 		selectAllBtn  widget.Clickable
 		selectNoneBtn widget.Clickable
 		selectOKBtn   widget.Clickable
+		retestSelBtn  widget.Clickable
+		exportBtn     widget.Clickable
+		reportBtn     widget.Clickable
+		runExportBtn  widget.Clickable
+		runImportBtn  widget.Clickable
+		exportOpen    bool
+		exportFmtBtns = make([]widget.Clickable, len(export.Formats))
+		selBtns       = make([]widget.Clickable, selActions)
+		selection     bitset
+		selAnchor     = -1
+		rowWidgetPool = newRowPool()
+		columnsBtn    widget.Clickable
+		columnsOpen   bool
+		resolversBtn  widget.Clickable
+		resolversOpen bool
+		resolverDirty bool
+		resolverStats []engine.ResolverStat
+		resultCols    = newResultColumns()
 
 		logEd     widget.Editor
 		previewEd widget.Editor
 
-		rows      []row
-		domainIdx = map[string]int{}
+		backupsList       layout.List
+		backupPreviewEd   widget.Editor
+		refreshBackupsBtn widget.Clickable
+		restoreBackupBtn  widget.Clickable
+		deleteBackupBtn   widget.Clickable
+		backups           []backupItem
+		selectedBackup    string
+		prevTab           string
+
+		historyList       layout.List
+		changeList        layout.List
+		refreshHistoryBtn widget.Clickable
+		historyItems      []historyItem
+		compareSel        []string
+		compareChanges    []history.Change
+
+		dnsBenchList      layout.List
+		dnsBenchDomainsEd widget.Editor
+		dnsBenchRoundsEd  widget.Editor
+		dnsBenchBtn       widget.Clickable
+		dnsBenchApplyBtn  widget.Clickable
+		dnsBenchResults   []engine.ResolverBench
+		dnsBenching       bool
+		dnsBenchDone      int
+		dnsBenchTotal     int
+
+		monitorList        layout.List
+		monitorIntervalEd  widget.Editor
+		monitorThresholdEd widget.Editor
+		monitorAuto        widget.Bool
+		monitorBtn         widget.Clickable
+		monitoring         bool
+		monitorGen         int
+		monitorCancel      context.CancelFunc
+		monitorTracks      []*monitorTrack
+		metricsAddrEd      widget.Editor
+		metricsReg         = metrics.NewRegistry()
+		metricsSrv         *http.Server
+
+		webhookEd    widget.Editor
+		deployEd     widget.Editor
+		extraHostsEd widget.Editor
+
+		netWarning    string
+		dismissWarn   widget.Clickable
+		dnsServe      widget.Bool
+		dnsListenEd   widget.Editor
+		dnsServer     *dnsserver.Server
+		dnsRecordsSig string
+
+		outputEd       widget.Editor
+		outputMode     widget.Enum
+		mergeMode      widget.Enum
+		targetStatuses []targetStatus
+		pickOutputBtn  widget.Clickable
+
+		deployBtn widget.Clickable
+		wslBtn    widget.Clickable
+		deploying int
+
+		refreshCDNBtn widget.Clickable
+		sysDNSBtn     widget.Clickable
+		cdnRanges     = cdnranges.Set{}
+		cdnFetching   int
+		hostHints     = map[string]map[string][]netip.Addr{}
+
+		dnsCache    = engine.NewDNSCache()
+		resultCache = engine.NewResultCache()
+
+		rateLimiter *engine.RateLimiter
+		rateLimit   float64
+
+		geoPathEd    widget.Editor
+		geoRegionsEd widget.Editor
+		pickGeoBtn   widget.Clickable
+		geoDB        *geo.DB
+		geoPath      string
+		asnPathEd    widget.Editor
+		asnExcludeEd widget.Editor
+		pickASNBtn   widget.Clickable
+		asnDB        *geo.DB
+		asnPath      string
+
+		blocklistEd    widget.Editor
+		blocklistSaved string
+
+		rows        []row
+		domainIdx   = map[string]int{}
+		domainGroup = map[string]string{}
+
+		runGroup     widget.Enum
+		familyPolicy widget.Enum
+		jitterMetric widget.Enum
+		outlierMode  widget.Enum
+		tieBreak     widget.Enum
 
 		logLines   []string
+		logWriter  *logfile.Writer
+		fileLog    widget.Bool
+		openLogBtn widget.Clickable
+		jsonLog    widget.Bool
+		jsonWriter *logfile.Writer
+
+		logSearchEd   widget.Editor
+		logPrevBtn    widget.Clickable
+		logNextBtn    widget.Clickable
+		logMatch      = -1
+		logMatchCount int
+
 		previewTxt string
 
+		watchedHosts  string
+		watchCancel   context.CancelFunc
+		hostsKnown    string
+		hostsExternal bool
+		symlinkOK     string
+		protectionOK  = map[string]bool{}
+		expiredMaps   []hostsfile.Mapping
+		lintIssues    []hostsfile.Issue
+		lintBlocking  int
+
+		diffLines []hostsfile.DiffLine
+		diffList  layout.List
+		showDiff  widget.Bool
+
 		running    bool
 		lastBackup string
+		undoStack  = &hostsfile.UndoStack{}
+
+		verifyAt     time.Time
+		verifyBackup string
+		verifyLeft   int
+		verifyDone   []engine.Verification
 
 		domainFilePath string
 
 		done, total int
+		rate        throughput
 		cancel      context.CancelFunc
+		skipper     *engine.Skipper
+		retesting   = map[string]context.CancelFunc{}
 	)
 
 	domainsEd.SetText("")
 	domainsEd.SingleLine = false
+	subsEd.SingleLine = true
+	subURLEd.SingleLine = true
+	subsEd.SetText(strings.Join(domain.DefaultSubdomains, " "))
 	dnsEd.SingleLine = false
 	dnsEd.SetText(strings.Join([]string{
 		"223.5.5.5",
@@ -167,83 +508,632 @@ func loop(w *app.Window) error {
 	portEd.SetText("443")
 	timeoutEd.SingleLine = true
 	timeoutEd.SetText("1200")
+	intervalEd.SingleLine = true
+	intervalEd.SetText("0")
+	roundsEd.SingleLine = true
+	roundsEd.SetText("1")
+	roundDelayEd.SingleLine = true
+	roundDelayEd.SetText("10")
+	slowEd.SingleLine = true
+	slowEd.SetText("500")
+	minSamplesEd.SingleLine = true
+	minSamplesEd.SetText("0")
 	attemptsEd.SingleLine = true
 	attemptsEd.SetText("3")
 	concurrencyEd.SingleLine = true
 	concurrencyEd.SetText("16")
+	rateEd.SingleLine = true
+	rateEd.SetText("0")
+	lossEd.SingleLine = true
+	lossEd.SetText("0")
+	expiryEd.SingleLine = true
+	outputEd.SingleLine = true
+	dnsListenEd.SingleLine = true
+	dohEd.SingleLine = true
+	dohEd.SetText(strings.Join(engine.DefaultTrustedDoH, " "))
+	dnsListenEd.SetText(dnsserver.DefaultAddr)
+	outputMode.Value = "block"
+	mergeMode.Value = string(hostsfile.MergeReplace)
+	expiryEd.SetText("0")
+	keepBakEd.SingleLine = true
+	keepBakEd.SetText("20")
+	bakDaysEd.SingleLine = true
+	bakDaysEd.SetText("0")
+	bakDirEd.SingleLine = true
 
 	ipv4.Value = true
+	keepOnFail.Value = true
+	autoUndo.Value = true
+	httpsRR.Value = true
 	ipv6.Value = false
+	notifyDone.Value = true
+
+	monitorIntervalEd.SingleLine = true
+	monitorIntervalEd.SetText("30")
+	dnsBenchDomainsEd.SetText(strings.Join(engine.DefaultBenchDomains, "\n"))
+	dnsBenchRoundsEd.SingleLine = true
+	dnsBenchRoundsEd.SetText("3")
+	monitorThresholdEd.SingleLine = true
+	monitorThresholdEd.SetText("60")
+	metricsAddrEd.SingleLine = true
+	webhookEd.SingleLine = true
+	geoPathEd.SingleLine = true
+	geoRegionsEd.SingleLine = true
+	asnPathEd.SingleLine = true
+	asnExcludeEd.SingleLine = true
+
+	if p := dnsCachePath(); p != "" {
+		if c, err := engine.LoadDNSCache(p); err == nil {
+			dnsCache = c
+		}
+	}
+
+	if p := undoPath(); p != "" {
+		if s, err := hostsfile.LoadUndo(p); err == nil {
+			undoStack = s
+		}
+	}
 
+	blocklistSaved = engine.DefaultBlocklist
+	if p := blocklistPath(); p != "" {
+		if b, err := os.ReadFile(p); err == nil {
+			blocklistSaved = string(b)
+		}
+	}
+	blocklistEd.SetText(blocklistSaved)
+
+	runGroup.Value = allGroups
+	familyPolicy.Value = string(engine.FamilyBestOverall)
+	jitterMetric.Value = string(engine.JitterStddev)
 	mainTab.Value = "config"
 	logEd.SingleLine = false
 	logEd.ReadOnly = true
 	previewEd.SingleLine = false
 	previewEd.ReadOnly = true
+	backupPreviewEd.SingleLine = false
+	backupPreviewEd.ReadOnly = true
 
 	leftList.Axis = layout.Vertical
 	resultsList.Axis = layout.Vertical
+	backupsList.Axis = layout.Vertical
+	diffList.Axis = layout.Vertical
+	monitorList.Axis = layout.Vertical
+	historyList.Axis = layout.Vertical
+	changeList.Axis = layout.Vertical
+	dnsBenchList.Axis = layout.Vertical
+	showDiff.Value = true
+	scaleSlider.Value = (1 - uiScaleMin) / (uiScaleMax - uiScaleMin)
+
+	// Remote targets may carry passwords and the blocklist has its own file,
+	// so neither is part of the saved settings.
+	savedText := map[string]*widget.Editor{
+		"domains": &domainsEd, "subdomains": &subsEd, "sub_url": &subURLEd, "dns": &dnsEd, "hosts": &hostsEd,
+		"port": &portEd, "timeout": &timeoutEd, "interval": &intervalEd, "rounds": &roundsEd, "round_delay": &roundDelayEd,
+		"attempts": &attemptsEd, "concurrency": &concurrencyEd, "rate": &rateEd, "loss": &lossEd, "expiry": &expiryEd,
+		"keep_backups": &keepBakEd, "backup_days": &bakDaysEd, "backup_dir": &bakDirEd, "slow": &slowEd, "min_samples": &minSamplesEd,
+		"monitor_interval": &monitorIntervalEd, "monitor_threshold": &monitorThresholdEd, "metrics_addr": &metricsAddrEd,
+		"webhook": &webhookEd, "dns_listen": &dnsListenEd, "output": &outputEd,
+		"geo_path": &geoPathEd, "geo_regions": &geoRegionsEd, "asn_path": &asnPathEd, "asn_exclude": &asnExcludeEd,
+		"dns_bench_domains": &dnsBenchDomainsEd, "dns_bench_rounds": &dnsBenchRoundsEd, "trusted_doh": &dohEd, "extra_hosts": &extraHostsEd,
+	}
+	savedChecked := map[string]*widget.Bool{
+		"ipv4": &ipv4, "ipv6": &ipv6, "notify_done": &notifyDone, "by_prefix": &byPrefix, "cdn_seed": &cdnSeed,
+		"dns_no_cache": &dnsNoCache, "dns_disk": &dnsDisk, "adaptive": &adaptive, "auto_concurrency": &autoConc,
+		"reuse_same": &reuseSame, "warm_up": &warmUp, "keep_on_fail": &keepOnFail, "fix_dupes": &fixDupes,
+		"to_file": &toFile, "keep_bogons": &keepBogons, "https_records": &httpsRR, "keep_hijacked": &keepHijack, "quic_probe": &quicProbe, "quic_score": &quicScore,
+		"mtu_check": &mtuCheck, "trace_best": &traceBest, "full_report": &fullReport, "auto_undo": &autoUndo, "wsl_no_generate": &wslNoGen,
+		"monitor_auto": &monitorAuto, "dns_serve": &dnsServe, "file_log": &fileLog, "json_log": &jsonLog, "show_diff": &showDiff,
+		"high_contrast": &highContrast,
+	}
+	savedNumber := map[string]*widget.Float{"ui_scale": &scaleSlider}
+	for _, c := range resultCols {
+		savedNumber["col_width_"+c.Key] = &c.Width
+		if c.Optional {
+			savedChecked["col_"+c.Key] = &c.Show
+		}
+	}
+	savedChoice := map[string]*widget.Enum{
+		"family": &familyPolicy, "jitter": &jitterMetric, "outliers": &outlierMode, "tie_break": &tieBreak, "output_mode": &outputMode, "merge_mode": &mergeMode,
+	}
+	currentSettings := func() settings.Settings {
+		s := settings.Settings{Text: map[string]string{}, Checked: map[string]bool{}, Choice: map[string]string{}, Number: map[string]float32{}}
+		for k, ed := range savedText {
+			s.Text[k] = ed.Text()
+		}
+		for k, b := range savedChecked {
+			s.Checked[k] = b.Value
+		}
+		for k, en := range savedChoice {
+			s.Choice[k] = en.Value
+		}
+		for k, f := range savedNumber {
+			s.Number[k] = f.Value
+		}
+		return s
+	}
+	if p := settingsPath(); p != "" {
+		if s, err := settings.Load(p); err == nil {
+			for k, v := range s.Text {
+				if ed, ok := savedText[k]; ok {
+					ed.SetText(v)
+				}
+			}
+			for k, v := range s.Checked {
+				if b, ok := savedChecked[k]; ok {
+					b.Value = v
+				}
+			}
+			for k, v := range s.Choice {
+				if en, ok := savedChoice[k]; ok {
+					en.Value = v
+				}
+			}
+			for k, v := range s.Number {
+				if f, ok := savedNumber[k]; ok {
+					f.Value = min(max(v, 0), 1)
+				}
+			}
+		}
+	}
+	savedSettings := currentSettings()
 
 	appendLog := func(s string) {
 		if strings.TrimSpace(s) == "" {
 			return
 		}
-		ts := time.Now().Format("15:04:05")
-		logLines = append(logLines, fmt.Sprintf("[%s] %s", ts, s))
+		now := time.Now()
+		logLines = append(logLines, fmt.Sprintf("[%s] %s", now.Format("15:04:05"), s))
+		if logWriter != nil {
+			if err := logWriter.WriteLine(fmt.Sprintf("[%s] %s", now.Format("2006-01-02 15:04:05"), s)); err != nil {
+				logWriter.Close()
+				logWriter = nil
+				fileLog.Value = false
+				logLines = append(logLines, fmt.Sprintf("[%s] 写入日志文件失败：%s", now.Format("15:04:05"), err))
+			}
+		}
 		if len(logLines) > 500 {
 			logLines = logLines[len(logLines)-500:]
 		}
 		logEd.SetText(strings.Join(logLines, "\n"))
 	}
 
-	buildMappings := func() []hostsfile.Mapping {
+	saveSettings := func() {
+		s := currentSettings()
+		if s.Equal(savedSettings) {
+			return
+		}
+		savedSettings = s
+		if p := settingsPath(); p != "" {
+			if err := settings.Save(p, s); err != nil {
+				appendLog("保存设置失败：" + err.Error())
+			}
+		}
+	}
+
+	syncFileLog := func() {
+		switch {
+		case fileLog.Value && logWriter == nil:
+			dir := logDir()
+			if dir == "" {
+				fileLog.Value = false
+				return
+			}
+			w, err := logfile.Open(dir, logfile.DefaultMaxSize, logfile.DefaultKeep)
+			if err != nil {
+				fileLog.Value = false
+				appendLog("打开日志文件失败：" + err.Error())
+				return
+			}
+			logWriter = w
+			appendLog("日志写入：" + w.Path())
+		case !fileLog.Value && logWriter != nil:
+			logWriter.Close()
+			logWriter = nil
+		}
+		switch {
+		case jsonLog.Value && jsonWriter == nil:
+			dir := logDir()
+			if dir == "" {
+				jsonLog.Value = false
+				return
+			}
+			w, err := logfile.OpenFile(filepath.Join(dir, logfile.JSONFileName), logfile.DefaultMaxSize, logfile.DefaultKeep)
+			if err != nil {
+				jsonLog.Value = false
+				appendLog("打开 JSON 日志失败：" + err.Error())
+				return
+			}
+			jsonWriter = w
+			appendLog("JSON 日志写入：" + w.Path())
+		case !jsonLog.Value && jsonWriter != nil:
+			jsonWriter.Close()
+			jsonWriter = nil
+		}
+	}
+
+	eventSink := func() func(engine.Event) {
+		jw := jsonWriter
+		if jw == nil {
+			return nil
+		}
+		return func(ev engine.Event) {
+			if b, err := json.Marshal(ev); err == nil {
+				_ = jw.WriteLine(string(b))
+			}
+		}
+	}
+
+	searchLog := func(step int) {
+		matches := findMatches(logEd.Text(), logSearchEd.Text())
+		logMatchCount = len(matches)
+		if len(matches) == 0 {
+			logMatch = -1
+			return
+		}
+		if step == 0 {
+			logMatch = 0
+		} else {
+			logMatch = ((logMatch+step)%len(matches) + len(matches)) % len(matches)
+		}
+		logEd.SetCaret(matches[logMatch][0], matches[logMatch][1])
+	}
+
+	editHostsFile := func() {
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		if err := launch.Editor(p); err != nil {
+			appendLog("打开 hosts 失败：" + err.Error())
+			return
+		}
+		appendLog("已在编辑器中打开：" + p)
+	}
+
+	openLogDir := func() {
+		dir := logDir()
+		if dir == "" {
+			return
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			appendLog("打开日志目录失败：" + err.Error())
+			return
+		}
+		if err := launch.Dir(dir); err != nil {
+			appendLog("打开日志目录失败：" + err.Error())
+		}
+	}
+
+	copyText := func(what, s string) {
+		if s == "" {
+			appendLog("没有可复制的" + what)
+			return
+		}
+		clipWrite = &s
+		appendLog("已复制" + what + "到剪贴板")
+	}
+
+	pasteDomains := func(text string) {
+		ts := domain.ExpandTargets(text, parseTokens(subsEd.Text()))
+		if len(ts) == 0 && !strings.Contains(text, "*.") {
+			appendLog("剪贴板中没有可用域名")
+			return
+		}
+		domainsEd.SetText(strings.TrimSpace(text))
+		appendLog(fmt.Sprintf("已从剪贴板导入域名：%d", len(ts)))
+	}
+
+	mappingsFor := func(onlySelected bool) []hostsfile.Mapping {
+		var expires time.Time
+		if days, err := strconv.Atoi(strings.TrimSpace(expiryEd.Text())); err == nil && days > 0 {
+			expires = time.Now().AddDate(0, 0, days).Truncate(time.Second)
+		}
+		var existing map[string][]hostsfile.Mapping
+		if keepOnFail.Value {
+			p := strings.TrimSpace(hostsEd.Text())
+			if p == "" {
+				p = hostsfile.DefaultHostsPath()
+			}
+			if orig, err := hostsfile.Read(p); err == nil {
+				existing = map[string][]hostsfile.Mapping{}
+				for _, m := range hostsfile.ParseManagedBlock(orig) {
+					existing[m.Domain] = append(existing[m.Domain], m)
+				}
+			}
+		}
 		var ms []hostsfile.Mapping
-		for _, r := range rows {
-			if !r.Apply.Value || r.Domain == "" || r.BestIP == "" || r.Message != "" {
+		for i, r := range rows {
+			if onlySelected && !selection.Has(i) {
+				continue
+			}
+			ip := rows[i].effectiveIP()
+			if r.Domain != "" && ip == "" && r.Message != "" && len(existing[r.Domain]) > 0 {
+				for _, m := range existing[r.Domain] {
+					if !m.Expires.IsZero() && !time.Now().Before(m.Expires) {
+						appendLog(fmt.Sprintf("%s 本次无可用结果，现有映射 %s 已过期，不再保留", r.Domain, m.IP))
+						continue
+					}
+					appendLog(fmt.Sprintf("%s 本次无可用结果，保留现有映射 %s", r.Domain, m.IP))
+					ms = append(ms, hostsfile.Mapping{IP: m.IP, Domain: r.Domain, Group: r.Group, Comment: "kept, latest run failed", Expires: m.Expires})
+				}
 				continue
 			}
-			ms = append(ms, hostsfile.Mapping{IP: r.BestIP, Domain: r.Domain})
+			if !r.Apply && !onlySelected || r.Domain == "" || ip == "" {
+				continue
+			}
+			if len(r.Current) > 0 && r.Current[0].IP == ip {
+				ms = append(ms, r.Current...)
+				continue
+			}
+			ms = append(ms, hostsfile.Mapping{IP: ip, Domain: r.Domain, Group: r.Group, Comment: mappingComment(r, ip), Expires: expires})
+			if familyPolicy.Value != string(engine.FamilyBestPerFamily) || !ipv4.Value || !ipv6.Value {
+				continue
+			}
+			if addr, err := netip.ParseAddr(ip); err == nil {
+				if alt, ok := engine.BestOfFamily(r.Candidates, !addr.Is6()); ok {
+					ms = append(ms, hostsfile.Mapping{IP: alt.IP.String(), Domain: r.Domain, Group: r.Group, Comment: statComment(alt), Expires: expires})
+				}
+			}
 		}
 		return ms
 	}
+	buildMappings := func() []hostsfile.Mapping { return mappingsFor(false) }
+	// mergeBlock combines ms with the managed block already in orig as chosen
+	// on the preview tab; "prune" drops the domains selected in the results.
+	mergeBlock := func(orig string, ms []hostsfile.Mapping) []hostsfile.Mapping {
+		remove := map[string]bool{}
+		for i, r := range rows {
+			if selection.Has(i) {
+				remove[strings.ToLower(r.Domain)] = true
+			}
+		}
+		return hostsfile.MergeMappings(hostsfile.ParseManagedBlock(orig), ms, hostsfile.MergeStrategy(mergeMode.Value), remove)
+	}
+	// exportMappings is what copy and export act on: the selected rows when
+	// there is a selection, otherwise the rows checked for writing.
+	exportMappings := func() []hostsfile.Mapping {
+		if selection.Count() > 0 {
+			return mappingsFor(true)
+		}
+		return buildMappings()
+	}
 
-	applyResult := func(res model.DomainResult) {
-		if _, ok := domainIdx[res.Domain]; !ok {
-			domainIdx[res.Domain] = len(rows)
+	syncDNSServer := func() {
+		switch {
+		case dnsServe.Value && dnsServer == nil:
+			s := dnsserver.New(strings.TrimSpace(dnsListenEd.Text()), parseTokens(dnsEd.Text()))
+			if err := s.Start(); err != nil {
+				dnsServe.Value = false
+				appendLog("启动本地 DNS 失败：" + err.Error())
+				return
+			}
+			dnsServer, dnsRecordsSig = s, "\x00"
+			appendLog("本地 DNS 已启动：" + s.Addr + "，上游：" + strings.Join(s.Upstream, ", "))
+		case !dnsServe.Value && dnsServer != nil:
+			dnsServer.Close()
+			dnsServer = nil
+			appendLog("本地 DNS 已停止")
+		}
+		if dnsServer == nil {
+			return
+		}
+		var sig strings.Builder
+		for i := range rows {
+			fmt.Fprintf(&sig, "%s=%s,%v\n", rows[i].Domain, rows[i].effectiveIP(), rows[i].Apply)
+		}
+		if s := sig.String(); s != dnsRecordsSig {
+			dnsRecordsSig = s
+			appendLog(fmt.Sprintf("本地 DNS 记录已更新：%d 个域名", dnsServer.SetRecords(buildMappings())))
+		}
+	}
+
+	openDB := func(ed *widget.Editor, cur **geo.DB, curPath *string, label string) *geo.DB {
+		p := strings.TrimSpace(ed.Text())
+		if p == *curPath {
+			return *cur
+		}
+		if *cur != nil && !running && len(retesting) == 0 {
+			_ = (*cur).Close()
+		}
+		*cur, *curPath = nil, p
+		if p == "" {
+			return nil
+		}
+		db, err := geo.Open(p)
+		if err != nil {
+			appendLog("打开 " + label + " 数据库失败：" + err.Error())
+			return nil
+		}
+		*cur = db
+		appendLog("已加载 " + label + " 数据库：" + p)
+		return db
+	}
+	loadGeo := func() *geo.DB { return openDB(&geoPathEd, &geoDB, &geoPath, "GeoIP") }
+	loadASN := func() *geo.DB { return openDB(&asnPathEd, &asnDB, &asnPath, "ASN") }
+
+	rowIndex := func(d string) int {
+		if _, ok := domainIdx[d]; !ok {
+			domainIdx[d] = len(rows)
 			var r row
-			r.Domain = res.Domain
+			r.Domain = d
+			r.Group = domainGroup[d]
 			rows = append(rows, r)
 		}
-		i := domainIdx[res.Domain]
+		return domainIdx[d]
+	}
+
+	// importManaged fills the results table with what the managed block of
+	// the hosts file pins right now, so it can be re-tested or pruned and
+	// written back. Rows already in the table are left alone.
+	importManaged := func() {
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		orig, err := hostsfile.Read(p)
+		if err != nil {
+			appendLog("读取 hosts 失败：" + err.Error())
+			return
+		}
+		fresh := map[string]bool{}
+		for _, m := range hostsfile.ParseManagedBlock(orig) {
+			if i, ok := domainIdx[m.Domain]; ok {
+				if fresh[m.Domain] {
+					rows[i].Current = append(rows[i].Current, m)
+				}
+				continue
+			}
+			fresh[m.Domain] = true
+			r := &rows[rowIndex(m.Domain)]
+			r.Group = m.Group
+			r.BestIP, r.Via = m.IP, "hosts"
+			r.WrittenIP = m.IP
+			r.Apply = m.Expires.IsZero() || time.Now().Before(m.Expires)
+			r.Current = []hostsfile.Mapping{m}
+		}
+		if n := len(fresh); n > 0 {
+			appendLog(fmt.Sprintf("已从 hosts 导入当前映射 %d 条，可重测、修改或取消勾选后重新写入", n))
+		}
+	}
+
+	applyResult := func(res model.DomainResult) {
+		if dbs := []*geo.DB{loadGeo(), loadASN()}; dbs[0] != nil || dbs[1] != nil {
+			for j := range res.Candidates {
+				var info geo.Info
+				for _, db := range dbs {
+					if db == nil {
+						continue
+					}
+					if i, ok := db.Lookup(res.Candidates[j].IP); ok {
+						info = info.Merge(i)
+					}
+				}
+				res.Candidates[j].Location = info.String()
+				res.Candidates[j].ASN = info.AS()
+				if res.Candidates[j].IP == res.Best.IP {
+					res.Best.Location, res.Best.ASN = res.Candidates[j].Location, res.Candidates[j].ASN
+				}
+			}
+		}
+		i := rowIndex(res.Domain)
 		r := rows[i]
+		r.Stage = ""
+		r.CNAMEs = res.CNAMEs
+		r.TTL, r.MinTTL = res.MinTTL, res.MinTTL
+		r.DNSErrors = res.ResolverErrors
+		r.Queries = res.Queries
+		r.Skipping = false
+		r.Cached = res.Cached
+		r.Hijacked = res.Hijacked
+		r.Current = nil
+		if res.Err != nil && (errorsIsCanceled(res.Err) || errors.Is(res.Err, engine.ErrSkipped)) && res.Best.Successes > 0 {
+			appendLog(fmt.Sprintf("%s：已停止，采用已测 %d 个候选中的最优 %s", res.Domain, len(res.Candidates), res.Best.IP))
+			res.Err = nil
+		}
 		if res.Err != nil {
 			r.Message = res.Err.Error()
 			r.BestIP = ""
 			r.Via = ""
+			r.ASN = ""
 			r.Rate = 0
+			r.P50 = 0
 			r.P95 = 0
 			r.Jitter = 0
-			r.Apply.Value = false
+			r.Score, r.ScoreBy = 0, nil
+			r.Apply = false
+			r.Candidates = res.Candidates
 		} else {
 			r.Message = ""
-			r.BestIP = res.Best.IP.String()
-			r.Via = res.Best.ResolvedVia
-			r.Rate = res.Best.SuccessRate()
-			r.P95 = res.Best.P95
-			r.Jitter = res.Best.JitterStd
-			r.Apply.Value = true
+			r.Candidates = res.Candidates
+			r.Metric = engine.JitterMetric(jitterMetric.Value)
+			r.useCandidate(res.Best)
+			r.Apply = true
 		}
 		rows[i] = r
+		resolverDirty = true
+		metricsReg.Update(res.Domain, func(s *metrics.DomainStats) {
+			s.IP = r.BestIP
+			s.BestLatency = r.P95
+			s.SuccessRate = r.Rate
+			s.Candidates = len(res.Candidates)
+			s.LastRun = time.Now()
+		})
 	}
 
+	// uiCh carries progress, samples and log lines to the frame loop with a
+	// non-blocking send; they may be dropped when the buffer is full.
+	// Messages whose handlers clear running state or count it down go
+	// through finished instead, which never blocks and never drops, and
+	// per-probe stage and candidate updates go through live.
 	uiCh := make(chan any, 256)
+	var finished uiQueue
+	var live liveRows
 
-	startRun := func() {
-		domains := domain.ParseDomains(domainsEd.Text())
-		if len(domains) == 0 {
-			appendLog("没有可用域名")
+	sendWebhook := func(p webhook.Payload) {
+		raw := strings.TrimSpace(webhookEd.Text())
+		if raw == "" {
+			return
+		}
+		u, err := remote.ValidateURL(raw)
+		if err != nil {
+			appendLog("Webhook 地址无效：" + err.Error())
+			return
+		}
+		p.Time = time.Now()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			if err := webhook.Send(ctx, nil, u, p); err != nil {
+				select {
+				case uiCh <- msgLog{Line: "Webhook 发送失败：" + err.Error()}:
+				default:
+				}
+				w.Invalidate()
+			}
+		}()
+	}
+
+	notifyUser := func(title, body string) {
+		if !notifyDone.Value {
 			return
 		}
+		go func() {
+			if err := notify.Send("ip-opt-gui："+title, body); err != nil {
+				select {
+				case uiCh <- msgLog{Line: "发送通知失败：" + err.Error()}:
+				default:
+				}
+				w.Invalidate()
+			}
+		}()
+	}
+
+	runInput := func(group string) (domains []string, groups map[string]string, cfg engine.Config, ok bool) {
+		ports := map[string]int{}
+		dnsOverrides := map[string][]string{}
+		allow := map[string][]netip.Prefix{}
+		groups = map[string]string{}
+		for _, g := range domain.ParseGroups(domainsEd.Text(), parseTokens(subsEd.Text())) {
+			if group != allGroups && group != g.Name {
+				continue
+			}
+			for _, t := range g.Targets {
+				domains = append(domains, t.Domain)
+				groups[t.Domain] = g.Name
+				switch {
+				case t.Port > 0:
+					ports[t.Domain] = t.Port
+				case g.Port > 0:
+					ports[t.Domain] = g.Port
+				}
+				if len(g.DNSServers) > 0 {
+					dnsOverrides[t.Domain] = g.DNSServers
+				}
+				if len(g.Allow) > 0 {
+					allow[t.Domain] = g.Allow
+				}
+			}
+		}
 
 		port, err := strconv.Atoi(strings.TrimSpace(portEd.Text()))
 		if err != nil {
@@ -255,6 +1145,14 @@ func loop(w *app.Window) error {
 			appendLog("超时无效")
 			return
 		}
+		intervalMs := 0
+		if s := strings.TrimSpace(intervalEd.Text()); s != "" {
+			intervalMs, err = strconv.Atoi(s)
+			if err != nil || intervalMs < 0 {
+				appendLog("间隔无效")
+				return
+			}
+		}
 		attempts, err := strconv.Atoi(strings.TrimSpace(attemptsEd.Text()))
 		if err != nil {
 			appendLog("次数无效")
@@ -265,220 +1163,2043 @@ func loop(w *app.Window) error {
 			appendLog("并发无效")
 			return
 		}
-
-		cfg := engine.Config{
-			DNSServers:  parseTokens(dnsEd.Text()),
-			Port:        port,
-			Timeout:     time.Duration(timeoutMs) * time.Millisecond,
-			Attempts:    attempts,
-			Concurrency: concurrency,
-			IPv4:        ipv4.Value,
-			IPv6:        ipv6.Value,
+		rate := 0.0
+		if s := strings.TrimSpace(rateEd.Text()); s != "" {
+			rate, err = strconv.ParseFloat(s, 64)
+			if err != nil || rate < 0 {
+				appendLog("限速无效")
+				return
+			}
+		}
+		rounds := 1
+		if s := strings.TrimSpace(roundsEd.Text()); s != "" {
+			rounds, err = strconv.Atoi(s)
+			if err != nil || rounds < 1 {
+				appendLog("轮数无效")
+				return
+			}
+		}
+		minSamples := 0
+		if s := strings.TrimSpace(minSamplesEd.Text()); s != "" {
+			minSamples, err = strconv.Atoi(s)
+			if err != nil || minSamples < 0 {
+				appendLog("最少样本数无效")
+				return
+			}
+		}
+		roundDelay := 0.0
+		if s := strings.TrimSpace(roundDelayEd.Text()); s != "" {
+			roundDelay, err = strconv.ParseFloat(s, 64)
+			if err != nil || roundDelay < 0 {
+				appendLog("轮间隔无效")
+				return
+			}
+		}
+		lossBurst := 0
+		if s := strings.TrimSpace(lossEd.Text()); s != "" {
+			lossBurst, err = strconv.Atoi(s)
+			if err != nil || lossBurst < 0 {
+				appendLog("丢包测量次数无效")
+				return
+			}
+		}
+		if rate != rateLimit {
+			rateLimit = rate
+			rateLimiter = engine.NewRateLimiter(rate)
 		}
 
-		rows = nil
-		domainIdx = map[string]int{}
-		logLines = nil
-		logEd.SetText("")
-		previewTxt = ""
-		previewEd.SetText("")
-		lastBackup = ""
-		done, total = 0, 0
-
-		ctx, c := context.WithCancel(context.Background())
-		cancel = c
-		running = true
-
-		go func() {
-			err := engine.Run(ctx, domains, cfg, engine.Callbacks{
-				OnLog: func(s string) {
-					select {
-					case uiCh <- msgLog{Line: s}:
-					default:
+		cfg = engine.Config{
+			DNSServers:      parseTokens(dnsEd.Text()),
+			Port:            port,
+			Timeout:         time.Duration(timeoutMs) * time.Millisecond,
+			Attempts:        attempts,
+			Interval:        time.Duration(intervalMs) * time.Millisecond,
+			Concurrency:     concurrency,
+			IPv4:            ipv4.Value,
+			IPv6:            ipv6.Value,
+			Ports:           ports,
+			DomainDNS:       dnsOverrides,
+			Allow:           allow,
+			DNSCache:        dnsCache,
+			RefreshDNS:      dnsNoCache.Value,
+			AdaptiveTimeout: adaptive.Value,
+			AutoConcurrency: autoConc.Value,
+			WarmUp:          warmUp.Value,
+			Rounds:          rounds,
+			RoundDelay:      time.Duration(roundDelay * float64(time.Second)),
+			RateLimit:       rateLimiter,
+			Family:          engine.FamilyPolicy(familyPolicy.Value),
+			Jitter:          engine.JitterMetric(jitterMetric.Value),
+			KeepBogons:      keepBogons.Value,
+			HTTPSRecords:    httpsRR.Value,
+			TrustedDoH:      parseTokens(dohEd.Text()),
+			KeepHijacked:    keepHijack.Value,
+			QUIC:            quicProbe.Value,
+			MTUCheck:        mtuCheck.Value,
+			Traceroute:      traceBest.Value,
+			LossBurst:       lossBurst,
+			FullReport:      fullReport.Value,
+			MinSamples:      minSamples,
+			Outliers:        engine.OutlierFilter(outlierMode.Value),
+			TieBreak:        engine.FamilyTieBreak(tieBreak.Value),
+		}
+		if byPrefix.Value {
+			cfg.PrefixExpand = prefixExpandTop
+		}
+		if quicProbe.Value && quicScore.Value {
+			cfg.QUICWeight = quicScoreWeight
+		}
+		v4, v6 := ipv4.Value, ipv6.Value
+		var seeders []func(string, []engine.Candidate) []engine.Candidate
+		if len(hostHints) > 0 {
+			hints := map[string][]netip.Addr{}
+			for _, hs := range hostHints {
+				for d, ips := range hs {
+					hints[d] = append(hints[d], ips...)
+				}
+			}
+			seeders = append(seeders, func(d string, _ []engine.Candidate) []engine.Candidate {
+				var out []engine.Candidate
+				for _, ip := range hints[d] {
+					if (ip.Is4() && v4) || (ip.Is6() && v6) {
+						out = append(out, engine.Candidate{IP: ip, ResolvedVia: "hosts-hint"})
+					}
+				}
+				return out
+			})
+		}
+		if cdnSeed.Value {
+			if len(cdnRanges) == 0 {
+				appendLog("尚未加载 CDN 地址段，请先点击「刷新地址段」")
+			}
+			set := cdnranges.Set{}
+			for k, v := range cdnRanges {
+				set[k] = v
+			}
+			seeders = append(seeders, func(d string, resolved []engine.Candidate) []engine.Candidate {
+				for _, c := range resolved {
+					key, ok := set.Match(c.IP)
+					if !ok {
+						continue
+					}
+					var out []engine.Candidate
+					for _, ip := range cdnranges.Sample(set[key], cdnSeedCount, v4, v6) {
+						out = append(out, engine.Candidate{IP: ip, ResolvedVia: "cdn:" + key})
+					}
+					return out
+				}
+				return nil
+			})
+		}
+		if len(seeders) > 0 {
+			cfg.Seed = func(d string, resolved []engine.Candidate) []engine.Candidate {
+				var out []engine.Candidate
+				for _, s := range seeders {
+					out = append(out, s(d, resolved)...)
+				}
+				return out
+			}
+		}
+		if regions := geo.ParseRegions(geoRegionsEd.Text()); len(regions) > 0 {
+			if db := loadGeo(); db != nil {
+				cfg.Prefer = func(ip netip.Addr) bool {
+					info, ok := db.Lookup(ip)
+					return ok && info.Matches(regions)
+				}
+			} else {
+				appendLog("已设置优先地区，但未加载 GeoIP 数据库，忽略")
+			}
+		}
+		blocklist, err := engine.ParseBlocklist(blocklistEd.Text())
+		if err != nil {
+			appendLog("IP 黑名单无效：" + err.Error())
+			return
+		}
+		cfg.Blocklist = blocklist
+		if text := blocklistEd.Text(); text != blocklistSaved {
+			if p := blocklistPath(); p != "" {
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+					err = os.WriteFile(p, []byte(text), 0644)
+				}
+				if err != nil {
+					appendLog("保存 IP 黑名单失败：" + err.Error())
+				}
+			}
+			blocklistSaved = text
+		}
+		asns, err := geo.ParseASNs(asnExcludeEd.Text())
+		if err != nil {
+			appendLog("排除 ASN 无效：" + err.Error())
+			return
+		}
+		if len(asns) > 0 {
+			if db := loadASN(); db != nil {
+				cfg.Exclude = func(ip netip.Addr) bool {
+					info, ok := db.Lookup(ip)
+					return ok && info.InASN(asns)
+				}
+			} else {
+				appendLog("已设置排除 ASN，但未加载 ASN 数据库，忽略")
+			}
+		}
+		return domains, groups, cfg, true
+	}
+
+	// launchRun starts engine.Run in the background; results merge into rows
+	// by domain, so callers decide whether to clear the table first.
+	launchRun := func(domains []string, cfg engine.Config) {
+		ctx, c := context.WithCancel(context.Background())
+		cancel = c
+		running = true
+		rate.reset()
+		rate.add(time.Now(), done)
+		skipper = engine.NewSkipper()
+		cfg.Skipper = skipper
+		if reuseSame.Value {
+			cfg.ResultCache = resultCache
+		}
+
+		onEvent := eventSink()
+		go func() {
+			err := engine.Run(ctx, domains, cfg, engine.Callbacks{
+				OnEvent: onEvent,
+				OnLog: func(s string) {
+					select {
+					case uiCh <- msgLog{Line: s}:
+					default:
 					}
 					w.Invalidate()
 				},
 				OnResult: func(r model.DomainResult) {
+					live.drop(r.Domain)
+					finished.send(msgResult{Result: r})
+					w.Invalidate()
+				},
+				OnProgress: func(d, t int) {
 					select {
-					case uiCh <- msgResult{Result: r}:
+					case uiCh <- msgProgress{Done: d, Total: t}:
 					default:
 					}
 					w.Invalidate()
 				},
-				OnProgress: func(d, t int) {
+				OnDomainStage: func(d string, s engine.Stage, n, t int) {
+					live.stage(d, s, n, t)
+					w.Invalidate()
+				},
+				OnRound: func(r model.DomainResult, round, rounds int) {
+					live.result(r)
 					select {
-					case uiCh <- msgProgress{Done: d, Total: t}:
+					case uiCh <- msgLog{Line: fmt.Sprintf("%s：第 %d/%d 轮暂列最优 %s（p95 %s）", r.Domain, round, rounds, r.Best.IP, r.Best.P95)}:
 					default:
 					}
 					w.Invalidate()
 				},
+				OnCandidate: func(r model.DomainResult) {
+					live.result(r)
+					w.Invalidate()
+				},
 			})
-			select {
-			case uiCh <- msgDone{Err: err}:
-			default:
-			}
+			live.reset()
+			finished.send(msgDone{Err: err})
 			w.Invalidate()
 		}()
 	}
 
+	startRun := func() {
+		domains, groups, cfg, ok := runInput(runGroup.Value)
+		if !ok {
+			return
+		}
+		if len(domains) == 0 {
+			appendLog("没有可用域名")
+			return
+		}
+		slowMs := 0
+		if s := strings.TrimSpace(slowEd.Text()); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				appendLog("慢域名阈值无效")
+				return
+			}
+			slowMs = v
+		}
+		if last, ok := history.Latest(historyDir()); ok {
+			domains = history.Prioritize(domains, last, time.Duration(slowMs)*time.Millisecond)
+		}
+		netWarning = netenvText(netenv.Detect())
+		if netWarning != "" {
+			appendLog("警告：" + netWarning)
+		}
+
+		rows = nil
+		resolverDirty = true
+		selection.Clear()
+		selAnchor = -1
+		rowWidgetPool.reset()
+		domainIdx = map[string]int{}
+		domainGroup = groups
+		logLines = nil
+		logEd.SetText("")
+		previewTxt = ""
+		previewEd.SetText("")
+		diffLines = nil
+		lastBackup = ""
+		done, total = 0, 0
+
+		launchRun(domains, cfg)
+	}
+
+	retestSelected := func() {
+		if running {
+			return
+		}
+		var domains []string
+		useSel := selection.Count() > 0
+		for i, r := range rows {
+			if _, busy := retesting[r.Domain]; (useSel && selection.Has(i) || !useSel && r.Apply) && !busy {
+				domains = append(domains, r.Domain)
+			}
+		}
+		if len(domains) == 0 {
+			appendLog("没有选中的域名")
+			return
+		}
+		_, _, cfg, ok := runInput(allGroups)
+		if !ok {
+			return
+		}
+		appendLog(fmt.Sprintf("重测选中的 %d 个域名", len(domains)))
+		done, total = 0, len(domains)
+		launchRun(domains, cfg)
+	}
+
 	stopRun := func() {
 		if cancel != nil {
 			cancel()
 		}
+		for _, c := range retesting {
+			c()
+		}
+	}
+
+	skipDomain := func(d string) {
+		if !running || skipper == nil {
+			return
+		}
+		skipper.Skip(d)
+		rows[rowIndex(d)].Skipping = true
+		appendLog("跳过：" + d)
+	}
+
+	retestDomain := func(d string) {
+		if _, busy := retesting[d]; busy {
+			return
+		}
+		_, _, cfg, ok := runInput(allGroups)
+		if !ok {
+			return
+		}
+		ctx, c := context.WithCancel(context.Background())
+		retesting[d] = c
+		appendLog("重测：" + d)
+
+		onEvent := eventSink()
+		go func() {
+			res := engine.RunOneDomain(ctx, d, cfg, engine.Callbacks{
+				OnEvent: onEvent,
+				OnLog: func(s string) {
+					select {
+					case uiCh <- msgLog{Line: s}:
+					default:
+					}
+					w.Invalidate()
+				},
+				OnDomainStage: func(d string, s engine.Stage, n, t int) {
+					live.stage(d, s, n, t)
+					w.Invalidate()
+				},
+			})
+			live.drop(d)
+			finished.send(msgRetested{Result: res})
+			w.Invalidate()
+		}()
+	}
+
+	findTrack := func(d string) *monitorTrack {
+		for _, t := range monitorTracks {
+			if t.Domain == d {
+				return t
+			}
+		}
+		return nil
+	}
+
+	stopMonitor := func() {
+		if monitorCancel != nil {
+			monitorCancel()
+			monitorCancel = nil
+		}
+		if metricsSrv != nil {
+			_ = metricsSrv.Close()
+			metricsSrv = nil
+		}
+		monitoring = false
+	}
+
+	startMonitor := func() {
+		intervalSec, err := strconv.Atoi(strings.TrimSpace(monitorIntervalEd.Text()))
+		if err != nil || intervalSec <= 0 {
+			appendLog("监控间隔无效")
+			return
+		}
+		_, _, cfg, ok := runInput(allGroups)
+		if !ok {
+			return
+		}
+
+		var targets []engine.MonitorTarget
+		var tracks []*monitorTrack
+		for i := range rows {
+			ip, err := netip.ParseAddr(rows[i].effectiveIP())
+			if !rows[i].Apply || err != nil {
+				continue
+			}
+			t := findTrack(rows[i].Domain)
+			if t == nil || t.IP != ip.String() {
+				t = &monitorTrack{Domain: rows[i].Domain, IP: ip.String()}
+			}
+			tracks = append(tracks, t)
+			targets = append(targets, engine.MonitorTarget{Domain: rows[i].Domain, IP: ip})
+		}
+		if len(targets) == 0 {
+			appendLog("没有可监控的结果（请先优选并勾选）")
+			return
+		}
+
+		stopMonitor()
+		if addr := strings.TrimSpace(metricsAddrEd.Text()); addr != "" {
+			srv, err := metricsReg.Listen(addr)
+			if err != nil {
+				appendLog("启动指标服务失败：" + err.Error())
+			} else {
+				metricsSrv = srv
+				appendLog("指标服务：http://" + addr + "/metrics")
+			}
+		}
+		monitorTracks = tracks
+		monitorGen++
+		gen := monitorGen
+		ctx, c := context.WithCancel(context.Background())
+		monitorCancel = c
+		monitoring = true
+		appendLog(fmt.Sprintf("开始监控 %d 个域名，间隔 %ds", len(targets), intervalSec))
+
+		go func() {
+			err := engine.Monitor(ctx, targets, cfg, time.Duration(intervalSec)*time.Second, func(s engine.MonitorSample) {
+				select {
+				case uiCh <- msgMonitorSample{Gen: gen, Sample: s}:
+				default:
+				}
+				w.Invalidate()
+			})
+			finished.send(msgMonitorDone{Gen: gen, Err: err})
+			w.Invalidate()
+		}()
+	}
+
+	onMonitorSample := func(s engine.MonitorSample) {
+		t := findTrack(s.Domain)
+		if t == nil || t.IP != s.IP.String() {
+			return
+		}
+		t.add(s)
+		rate, n := t.recentRate()
+		metricsReg.Update(s.Domain, func(m *metrics.DomainStats) {
+			m.IP = t.IP
+			if s.OK() {
+				m.MonitorLatency = s.Latency
+			}
+			m.MonitorRate = rate
+			m.LastProbe = s.Time
+		})
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(monitorThresholdEd.Text()), 64)
+		if err != nil {
+			return
+		}
+		if n < monitorWindow {
+			return
+		}
+		below := rate*100 < threshold
+		if below == t.Flagged {
+			return
+		}
+		t.Flagged = below
+		if !below {
+			appendLog(fmt.Sprintf("监控：%s 已恢复（成功率 %.0f%%）", t.Domain, rate*100))
+			return
+		}
+		appendLog(fmt.Sprintf("监控：%s -> %s 成功率 %.0f%% 低于阈值 %.0f%%", t.Domain, t.IP, rate*100, threshold))
+		if !monitorAuto.Value {
+			notifyUser("监控告警", fmt.Sprintf("%s 成功率 %.0f%%", t.Domain, rate*100))
+			return
+		}
+		retestDomain(t.Domain)
+		_, t.Replacing = retesting[t.Domain]
+	}
+
+	loadDomainsFromHosts := func() {
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		ds, err := domain.ReadDomainsFromHosts(p)
+		if err != nil {
+			appendLog("读取 hosts 失败：" + err.Error())
+			return
+		}
+		domainsEd.SetText(strings.Join(ds, "\n"))
+		appendLog(fmt.Sprintf("已导入 hosts 域名：%d", len(ds)))
+	}
+
+	pickDomainsFile := func() {
+		go func() {
+			p, err := filedialog.OpenFile("选择域名文件", []filedialog.Filter{
+				{Name: "文本文件 (*.txt)", Pattern: "*.txt"},
+				{Name: "所有文件 (*.*)", Pattern: "*.*"},
+			})
+			select {
+			case uiCh <- msgPickedPath{Kind: "domains", Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	pickHostsFile := func() {
+		go func() {
+			p, err := filedialog.OpenFile("选择 hosts 文件", []filedialog.Filter{
+				{Name: "hosts", Pattern: "hosts"},
+				{Name: "所有文件 (*.*)", Pattern: "*.*"},
+			})
+			select {
+			case uiCh <- msgPickedPath{Kind: "hosts", Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	pickOutputFile := func() {
+		go func() {
+			p, err := filedialog.SaveFile("输出到文件", "hosts.ip-opt", []filedialog.Filter{
+				{Name: "所有文件 (*.*)", Pattern: "*.*"},
+			})
+			select {
+			case uiCh <- msgPickedPath{Kind: "output", Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	pickMMDB := func(title, kind string) {
+		go func() {
+			p, err := filedialog.OpenFile(title, []filedialog.Filter{
+				{Name: "MaxMind DB (*.mmdb)", Pattern: "*.mmdb"},
+				{Name: "所有文件 (*.*)", Pattern: "*.*"},
+			})
+			select {
+			case uiCh <- msgPickedPath{Kind: kind, Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	exportAs := func(f export.Format) {
+		exportOpen = false
+		ms := exportMappings()
+		if len(ms) == 0 {
+			appendLog("没有可导出的映射（请先在「结果」页勾选或选择）")
+			return
+		}
+		content := f.Render(ms)
+		go func() {
+			p, err := filedialog.SaveFile("导出 "+f.Name, f.FileName, []filedialog.Filter{
+				{Name: fmt.Sprintf("%s (%s)", f.Name, f.Pattern), Pattern: f.Pattern},
+				{Name: "所有文件 (*.*)", Pattern: "*.*"},
+			})
+			if errors.Is(err, filedialog.ErrUnsupported) {
+				if home, herr := os.UserHomeDir(); herr == nil {
+					p, err = filepath.Join(home, f.FileName), nil
+				}
+			}
+			if err == nil && strings.TrimSpace(p) != "" {
+				err = os.WriteFile(p, []byte(content), 0644)
+			}
+			select {
+			case uiCh <- msgPickedPath{Kind: "export", Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	exportReport := func() {
+		var domains []report.Domain
+		for _, r := range rows {
+			domains = append(domains, report.Domain{Domain: r.Domain, Chosen: r.effectiveIP(), Err: r.Message, Candidates: r.Candidates, CNAMEs: r.CNAMEs, DNSErrors: r.DNSErrors})
+		}
+		if len(domains) == 0 {
+			appendLog("没有可导出的结果")
+			return
+		}
+		meta := report.Meta{Time: time.Now(), Version: Version}
+		meta.Port, _ = strconv.Atoi(strings.TrimSpace(portEd.Text()))
+		meta.Attempts, _ = strconv.Atoi(strings.TrimSpace(attemptsEd.Text()))
+		meta.Rounds, _ = strconv.Atoi(strings.TrimSpace(roundsEd.Text()))
+		go func() {
+			p, err := filedialog.SaveFile("导出测速报告", "ip-opt-report.md", []filedialog.Filter{
+				{Name: "Markdown (*.md)", Pattern: "*.md"},
+				{Name: "HTML (*.html)", Pattern: "*.html"},
+			})
+			if errors.Is(err, filedialog.ErrUnsupported) {
+				if home, herr := os.UserHomeDir(); herr == nil {
+					p, err = filepath.Join(home, "ip-opt-report.md"), nil
+				}
+			}
+			if err == nil && strings.TrimSpace(p) != "" {
+				content := report.Markdown(domains, meta)
+				if ext := strings.ToLower(filepath.Ext(p)); ext == ".html" || ext == ".htm" {
+					content = report.HTML(domains, meta)
+				}
+				err = os.WriteFile(p, []byte(content), 0644)
+			}
+			select {
+			case uiCh <- msgPickedPath{Kind: "export", Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	// Paths, listen addresses, the webhook and the subscription URL only make
+	// sense on this machine or may carry tokens, so run files leave them out.
+	exportRun := func() {
+		f := runfile.File{App: Version, Time: time.Now(), Settings: currentSettings()}
+		f.Settings.Number = nil
+		for _, k := range []string{"hosts", "backup_dir", "output", "extra_hosts", "geo_path", "asn_path", "webhook", "sub_url", "metrics_addr", "dns_listen"} {
+			delete(f.Settings.Text, k)
+		}
+		for _, r := range rows {
+			if r.BestIP == "" && r.Message == "" {
+				continue
+			}
+			res := runfile.Result{Domain: r.Domain, Group: r.Group, Candidates: r.Candidates, CNAMEs: r.CNAMEs, MinTTL: r.MinTTL, ResolverErrors: r.DNSErrors, Queries: r.Queries, Hijacked: r.Hijacked, Err: r.Message, Apply: r.Apply, Override: r.Override}
+			if ip, err := netip.ParseAddr(r.BestIP); err == nil && r.Message == "" {
+				res.Best = model.CandidateStat{IP: ip, ResolvedVia: r.Via, TTL: r.TTL}
+				if j := slices.IndexFunc(r.Candidates, func(c model.CandidateStat) bool { return c.IP == ip }); j >= 0 {
+					res.Best = r.Candidates[j]
+				}
+			}
+			f.Results = append(f.Results, res)
+		}
+		if len(f.Results) == 0 {
+			appendLog("没有可导出的结果")
+			return
+		}
+		name := "ip-opt-run-" + f.Time.Format("20060102-150405") + runfile.Ext
+		go func() {
+			p, err := filedialog.SaveFile("导出运行", name, []filedialog.Filter{
+				{Name: "ip-opt 运行 (*" + runfile.Ext + ")", Pattern: "*" + runfile.Ext},
+				{Name: "所有文件 (*.*)", Pattern: "*.*"},
+			})
+			if errors.Is(err, filedialog.ErrUnsupported) {
+				if home, herr := os.UserHomeDir(); herr == nil {
+					p, err = filepath.Join(home, name), nil
+				}
+			}
+			if err == nil && strings.TrimSpace(p) != "" {
+				err = runfile.Save(p, f)
+			}
+			select {
+			case uiCh <- msgPickedPath{Kind: "export", Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	// importRun loads a run file into the results table, replacing rows for
+	// the same domains. The file's settings are not applied: they describe
+	// the other machine and are only kept for reference.
+	importRun := func(path string) {
+		if running || len(retesting) > 0 {
+			appendLog("测试进行中，请结束后再导入运行")
+			return
+		}
+		f, err := runfile.Load(path)
+		if err != nil {
+			appendLog("导入运行失败：" + err.Error())
+			return
+		}
+		for _, res := range f.Results {
+			applyResult(res.DomainResult())
+			r := &rows[domainIdx[res.Domain]]
+			if res.Group != "" {
+				r.Group = res.Group
+			}
+			r.Apply, r.Override = res.Apply, res.Override
+		}
+		mainTab.Value = "results"
+		appendLog(fmt.Sprintf("已导入运行 %s：%d 个域名（%s 于 %s 测试）", filepath.Base(path), len(f.Results), f.App, f.Time.Local().Format("2006-01-02 15:04")))
+	}
+
+	pickRunFile := func() {
+		go func() {
+			p, err := filedialog.OpenFile("导入运行", []filedialog.Filter{
+				{Name: "ip-opt 运行 (*" + runfile.Ext + ")", Pattern: "*" + runfile.Ext},
+				{Name: "所有文件 (*.*)", Pattern: "*.*"},
+			})
+			select {
+			case uiCh <- msgPickedPath{Kind: "run", Path: p, Err: err}:
+			default:
+			}
+			w.Invalidate()
+		}()
+	}
+
+	fetcher := remote.NewFetcher()
+
+	for _, p := range cdnranges.Providers {
+		var all []netip.Prefix
+		for _, u := range p.URLs {
+			b, err := fetcher.Cached(u)
+			if err != nil {
+				all = nil
+				break
+			}
+			ps, err := p.Parse(b)
+			if err != nil {
+				all = nil
+				break
+			}
+			all = append(all, ps...)
+		}
+		if len(all) > 0 {
+			cdnRanges[p.Key] = all
+		}
+	}
+
+	refreshCDN := func() {
+		for _, p := range cdnranges.Providers {
+			cdnFetching++
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				m := msgCDNRanges{Key: p.Key}
+				for _, u := range p.URLs {
+					res, err := fetcher.Fetch(ctx, u)
+					if err == nil {
+						var ps []netip.Prefix
+						ps, err = p.Parse(res.Body)
+						m.Prefixes = append(m.Prefixes, ps...)
+						m.FromCache = m.FromCache || res.FromCache
+						m.Stale = m.Stale || res.Stale
+					}
+					if err != nil {
+						m.Err = err
+						break
+					}
+				}
+				finished.send(m)
+				w.Invalidate()
+			}()
+		}
+	}
+
+	refreshSubscriptions := func() {
+		urls := parseTokens(subURLEd.Text())
+		if len(urls) == 0 {
+			appendLog("请先填写订阅 URL")
+			return
+		}
+		for _, u := range urls {
+			u, err := remote.ValidateURL(u)
+			if err != nil {
+				appendLog("订阅 URL 无效：" + err.Error())
+				continue
+			}
+			subsFetching++
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				m := msgSubscription{URL: u}
+				res, err := fetcher.Fetch(ctx, u)
+				if err != nil {
+					m.Err = err
+				} else {
+					body := string(res.Body)
+					if domain.LooksLikeHosts(body) {
+						m.Domains = domain.ParseHostsDomains(body)
+						m.Hints = domain.ParseHostsHints(body)
+					} else {
+						m.Domains = domain.ParseDomains(body)
+					}
+					m.FromCache, m.Stale = res.FromCache, res.Stale
+				}
+				finished.send(m)
+				w.Invalidate()
+			}()
+		}
+	}
+
+	importDomainsFile := func(path string) {
+		ds, err := domain.ReadDomainsFromFile(path)
+		if err != nil {
+			appendLog("读取文件失败：" + err.Error())
+			return
+		}
+		domainFilePath = path
+		domainsEd.SetText(strings.Join(ds, "\n"))
+		appendLog(fmt.Sprintf("已导入文件域名：%d (%s)", len(ds), filepath.Base(path)))
+	}
+
+	importDropped := func(path string) {
+		st, err := os.Stat(path)
+		if err != nil {
+			appendLog("读取拖入文件失败：" + err.Error())
+			return
+		}
+		if st.IsDir() {
+			appendLog("已忽略拖入的目录：" + path)
+			return
+		}
+		base := strings.ToLower(filepath.Base(path))
+		if strings.HasSuffix(base, runfile.Ext) {
+			importRun(path)
+			return
+		}
+		switch strings.ToLower(filepath.Ext(base)) {
+		case ".txt", ".list", ".csv":
+			importDomainsFile(path)
+			return
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			appendLog("读取拖入文件失败：" + err.Error())
+			return
+		}
+		if strings.HasPrefix(base, "hosts") || domain.LooksLikeHosts(string(b)) {
+			hostsEd.SetText(path)
+			appendLog("已选择 hosts：" + path)
+			return
+		}
+		importDomainsFile(path)
+	}
+
+	hostsHeader := func() []string {
+		scoring := []string{"tcp p95", "jitter " + jitterMetric.Value, "family " + familyPolicy.Value}
+		if outlierMode.Value != "" {
+			scoring = append(scoring, "outliers "+outlierMode.Value)
+		}
+		if tieBreak.Value != "" {
+			scoring = append(scoring, "tie "+tieBreak.Value)
+		}
+		if quicProbe.Value && quicScore.Value {
+			scoring = append(scoring, fmt.Sprintf("quic weight %.1f", quicScoreWeight))
+		}
+		if s := strings.TrimSpace(lossEd.Text()); s != "" && s != "0" {
+			scoring = append(scoring, "loss burst "+s)
+		}
+		return []string{
+			"generated " + time.Now().Format("2006-01-02 15:04:05") + " by ip-opt-gui " + Version,
+			"scoring: " + strings.Join(scoring, ", "),
+		}
+	}
+
+	logConflicts := func(cs []hostsfile.Conflict) {
+		if len(cs) == 0 {
+			return
+		}
+		for _, c := range cs {
+			appendLog(fmt.Sprintf("托管段外已有 %s 的条目（第 %d 行：%s）", c.Domain, c.Line, strings.TrimSpace(c.Text)))
+		}
+		if fixDupes.Value {
+			appendLog(fmt.Sprintf("将注释掉 %d 处托管段外的重复条目", len(cs)))
+		} else {
+			appendLog("托管段外存在重复域名，hosts 解析结果可能不确定；可勾选「注释掉托管段外的重复条目」")
+		}
+	}
+
+	refreshPreview := func() bool {
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		orig, err := hostsfile.Read(p)
+		if err != nil {
+			appendLog("读取 hosts 失败：" + err.Error())
+			return false
+		}
+		ms := buildMappings()
+		if !toFile.Value {
+			ms = mergeBlock(orig, ms)
+		}
+		block := hostsfile.BuildManagedBlock(ms, hostsHeader())
+		if toFile.Value && outputMode.Value == "block" {
+			b, _ := os.ReadFile(strings.TrimSpace(outputEd.Text()))
+			orig, previewTxt = string(b), block
+		} else {
+			base := orig
+			if cs := hostsfile.FindConflicts(orig, ms); len(cs) > 0 {
+				logConflicts(cs)
+				if fixDupes.Value {
+					base = hostsfile.DisableConflicts(orig, cs)
+				}
+			}
+			previewTxt = hostsfile.ApplyManagedBlock(base, block)
+			if toFile.Value {
+				b, _ := os.ReadFile(strings.TrimSpace(outputEd.Text()))
+				orig = string(b)
+			} else {
+				hostsKnown, hostsExternal = orig, false
+			}
+		}
+		lintIssues = hostsfile.Lint(previewTxt)
+		lintBlocking = len(hostsfile.NewErrors(hostsfile.Lint(orig), lintIssues))
+		previewEd.SetText(previewTxt)
+		diffLines = hostsfile.Diff(orig, previewTxt, 3)
+		return true
+	}
+
+	buildPreview := func() {
+		if !refreshPreview() {
+			return
+		}
+		mainTab.Value = "preview"
+		appendLog(fmt.Sprintf("已生成预览（变更 %d 行）", countChanged(diffLines)))
+		if len(lintIssues) > 0 {
+			appendLog(fmt.Sprintf("hosts 校验发现 %d 个问题，其中 %d 个会阻止写入", len(lintIssues), lintBlocking))
+		}
+		w.Invalidate()
+	}
+
+	checkExpired := func(content string) {
+		prev := len(expiredMaps)
+		expiredMaps = hostsfile.Expired(hostsfile.ParseManagedBlock(content), time.Now())
+		if len(expiredMaps) == 0 || len(expiredMaps) == prev {
+			return
+		}
+		var ds []string
+		for _, m := range expiredMaps {
+			ds = append(ds, m.Domain+" "+m.IP)
+		}
+		appendLog(fmt.Sprintf("hosts 中有 %d 条映射已过期：%s；可在「预览」页重测或移除", len(expiredMaps), strings.Join(ds, "，")))
+	}
+
+	guardProtection := func(p, action string) (hostsfile.Protection, bool) {
+		prot, err := hostsfile.Protected(p)
+		if err != nil || prot == 0 {
+			return 0, true
+		}
+		if protectionOK[p] {
+			return prot, true
+		}
+		protectionOK[p] = true
+		reason := "带有只读属性"
+		if prot&hostsfile.ProtImmutable != 0 {
+			reason = "被设置了不可变属性（chattr +i）"
+		}
+		appendLog("hosts 文件 " + p + " " + reason + "，直接" + action + "会失败；确认后请再次点击「" + action + "」，程序将临时清除该属性并在完成后恢复（需要管理员/root 权限）")
+		return prot, false
+	}
+
+	writeOutput := func() {
+		out := strings.TrimSpace(outputEd.Text())
+		if out == "" {
+			appendLog("请先填写输出文件路径")
+			return
+		}
+		if !refreshPreview() {
+			return
+		}
+		if lintBlocking > 0 {
+			appendLog("输出内容未通过校验，请在「预览」页查看问题")
+			mainTab.Value = "preview"
+			return
+		}
+		if err := os.WriteFile(out, []byte(previewTxt), 0644); err != nil {
+			appendLog("输出到文件失败：" + err.Error())
+			return
+		}
+		appendLog("已输出到文件（未修改系统 hosts）：" + out)
+	}
+
+	backupDir := func() string { return strings.TrimSpace(bakDirEd.Text()) }
+
+	pruneBackups := func(p, dir string) {
+		keep, err1 := strconv.Atoi(strings.TrimSpace(keepBakEd.Text()))
+		days, err2 := strconv.Atoi(strings.TrimSpace(bakDaysEd.Text()))
+		if err1 != nil || err2 != nil || keep < 0 || days < 0 {
+			appendLog("备份保留设置无效，本次未清理旧备份")
+			return
+		}
+		removed, err := hostsfile.PruneBackups(p, dir, hostsfile.Retention{KeepLast: keep, MaxAge: time.Duration(days) * 24 * time.Hour}, time.Now())
+		if err != nil {
+			appendLog("清理旧备份失败：" + err.Error())
+		}
+		if len(removed) > 0 {
+			appendLog(fmt.Sprintf("已按保留策略清理 %s 的 %d 个旧备份", p, len(removed)))
+		}
+	}
+
+	recordUndo := func(p, action, before string) {
+		undoStack.Record(hostsfile.Snapshot{Time: time.Now(), Action: action, Path: p, Content: before})
+		if up := undoPath(); up != "" {
+			if err := undoStack.Save(up); err != nil {
+				appendLog("保存撤销记录失败：" + err.Error())
+			}
+		}
+	}
+
+	verifyWritten := func(p, backup string, mappings []hostsfile.Mapping, at time.Time) {
+		pi, err1 := os.Stat(p)
+		si, err2 := os.Stat(hostsfile.DefaultHostsPath())
+		if err1 != nil || err2 != nil || !os.SameFile(pi, si) {
+			appendLog("hosts 路径不是系统 hosts，跳过写入后验证")
+			return
+		}
+		_, _, cfg, ok := runInput(allGroups)
+		if !ok {
+			return
+		}
+		written := map[string][]netip.Addr{}
+		for _, m := range mappings {
+			if ip, err := netip.ParseAddr(m.IP); err == nil {
+				written[m.Domain] = append(written[m.Domain], ip)
+			}
+		}
+		old, _ := hostsfile.Read(backup)
+		verifyAt, verifyBackup, verifyLeft, verifyDone = at, backup, 0, nil
+		for i := range rows {
+			ips := written[rows[i].Domain]
+			if len(ips) == 0 {
+				continue
+			}
+			verifyLeft++
+			rows[i].Verify, rows[i].VerifyMsg = verifyPending, ""
+			prev := previousAddrs(old, rows[i])
+			go func(d string) {
+				finished.send(msgVerified{Result: engine.Verify(context.Background(), d, ips, prev, cfg), WrittenAt: at})
+				w.Invalidate()
+			}(rows[i].Domain)
+		}
+	}
+
+	extraHostsTargets := func(primary string) []string {
+		var out []string
+		for _, t := range hostsTargets(extraHostsEd.Text()) {
+			if t != primary {
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+
+	// writeExtraHosts applies base to the additional hosts files once the
+	// main one is written. Each is merged with its own managed block, backed
+	// up and pruned next to itself rather than in the backup directory (they
+	// usually share the name "hosts") and read back. Undo/redo and 恢复备份
+	// act on the main file only; the automatic rollback restores these too.
+	writeExtraHosts := func(primary string, base []hostsfile.Mapping) {
+		for _, t := range extraHostsTargets(primary) {
+			st := targetStatus{Path: t}
+			err := func() error {
+				orig, err := hostsfile.Read(t)
+				if err != nil {
+					return err
+				}
+				prot, ok := guardProtection(t, "写入")
+				if !ok {
+					return errors.New("文件受保护，确认后请再次点击「写入」")
+				}
+				ms := mergeBlock(orig, base)
+				err = hostsfile.WithoutProtection(t, prot, func() (err error) {
+					st.Backup, _, err = hostsfile.WriteWithBackup(t, "", ms, hostsHeader(), fixDupes.Value)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				written, err := hostsfile.Read(t)
+				if err != nil {
+					return err
+				}
+				if err := hostsfile.VerifyManagedBlock(written, ms); err != nil {
+					return fmt.Errorf("写入后校验失败：%w", err)
+				}
+				return nil
+			}()
+			if err != nil {
+				st.Err = err.Error()
+				appendLog(fmt.Sprintf("写入 %s 失败：%s", t, err))
+				notifyUser("写入 hosts 失败", t+"："+err.Error())
+			} else {
+				appendLog(fmt.Sprintf("已写入 %s 并校验通过，备份：%s", t, st.Backup))
+				pruneBackups(t, "")
+			}
+			targetStatuses = append(targetStatuses, st)
+		}
+	}
+
+	// restoreExtraHosts rolls the additional hosts files of the last write
+	// back to their backups; targetStatuses[0] is the main file.
+	restoreExtraHosts := func() {
+		for _, st := range targetStatuses[min(1, len(targetStatuses)):] {
+			if st.Err != "" || st.Backup == "" {
+				continue
+			}
+			prot, ok := guardProtection(st.Path, "恢复备份")
+			if !ok {
+				appendLog("未回滚 " + st.Path + "：文件受保护")
+				continue
+			}
+			if err := hostsfile.WithoutProtection(st.Path, prot, func() error { return hostsfile.RestoreBackup(st.Backup, st.Path) }); err != nil {
+				appendLog("回滚 " + st.Path + " 失败：" + err.Error())
+				continue
+			}
+			appendLog("已回滚 " + st.Path + "：" + st.Backup)
+		}
+	}
+
+	writeHosts := func() {
+		if toFile.Value {
+			writeOutput()
+			return
+		}
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		if hostsExternal {
+			refreshPreview()
+			appendLog("hosts 已被外部修改，预览已按最新内容刷新；确认无误后请再次点击「写入」")
+			return
+		}
+		if target, linked, err := hostsfile.Resolve(p); err == nil && linked && symlinkOK != p {
+			symlinkOK = p
+			appendLog(fmt.Sprintf("hosts 路径 %s 是符号链接，指向 %s；写入、备份和恢复都会作用于实际文件。确认后请再次点击「写入」", p, target))
+			return
+		}
+		orig, err := hostsfile.Read(p)
+		if err != nil {
+			appendLog("读取 hosts 失败：" + err.Error())
+			return
+		}
+		// Ask about every protected file in one go, so confirming one never
+		// leaves another waiting for a second round of writes.
+		prot, ok := guardProtection(p, "写入")
+		for _, t := range extraHostsTargets(p) {
+			if _, tok := guardProtection(t, "写入"); !tok {
+				ok = false
+			}
+		}
+		if !ok {
+			return
+		}
+		checkExpired(orig)
+		base := buildMappings()
+		mappings := mergeBlock(orig, base)
+		logConflicts(hostsfile.FindConflicts(orig, mappings))
+		var backup, newContent string
+		err = hostsfile.WithoutProtection(p, prot, func() (err error) {
+			backup, newContent, err = hostsfile.WriteWithBackup(p, backupDir(), mappings, hostsHeader(), fixDupes.Value)
+			return err
+		})
+		var lintErr *hostsfile.LintError
+		if errors.As(err, &lintErr) {
+			for _, is := range lintErr.Issues {
+				appendLog(fmt.Sprintf("hosts 校验失败，第 %d 行：%s", is.Line, is.Msg))
+			}
+			appendLog("写入已阻止：生成的 hosts 内容无法被系统正确解析，请在「预览」页查看问题")
+			refreshPreview()
+			mainTab.Value = "preview"
+			return
+		}
+		if err != nil {
+			targetStatuses = []targetStatus{{Path: p, Err: err.Error()}}
+			appendLog("写入失败：" + err.Error())
+			notifyUser("写入 hosts 失败", err.Error())
+			sendWebhook(webhook.Payload{Event: webhook.EventHostsWriteFailed, HostsPath: p, Error: err.Error()})
+			return
+		}
+		lastBackup = backup
+		hostsKnown = newContent
+		targetStatuses = []targetStatus{{Path: p, Backup: backup}}
+		writeExtraHosts(p, base)
+		recordUndo(p, "写入", orig)
+		pruneBackups(p, backupDir())
+		checkExpired(newContent)
+		written := map[string]string{}
+		for _, m := range mappings {
+			if prev := written[m.Domain]; prev != "" {
+				m.IP = prev + ", " + m.IP
+			}
+			written[m.Domain] = m.IP
+		}
+		now := time.Now()
+		for i := range rows {
+			rows[i].WrittenIP, rows[i].WrittenAt = written[rows[i].Domain], time.Time{}
+			rows[i].Verify, rows[i].VerifyMsg = verifyNone, ""
+			if rows[i].WrittenIP != "" {
+				rows[i].WrittenAt = now
+			}
+		}
+		appendLog("写入成功，备份：" + backup)
+		notifyUser("写入 hosts 成功", "备份："+backup)
+		var changes []string
+		if orig, err := hostsfile.Read(backup); err == nil {
+			for _, l := range hostsfile.Diff(orig, newContent, 0) {
+				if l.Op == hostsfile.DiffAdd || l.Op == hostsfile.DiffRemove {
+					changes = append(changes, l.String())
+				}
+			}
+		}
+		sendWebhook(webhook.Payload{Event: webhook.EventHostsWritten, HostsPath: p, Backup: backup, Changes: changes})
+		verifyWritten(p, backup, mappings, now)
+	}
+
+	deployTargets := func(targets []deploy.Target) {
+		mappings, header := buildMappings(), hostsHeader()
+		deploying = len(targets)
+		appendLog(fmt.Sprintf("开始部署到 %d 个远程目标", len(targets)))
+		for _, t := range targets {
+			go func() {
+				ctx, c := context.WithTimeout(context.Background(), deployTimeout)
+				defer c()
+				finished.send(msgDeployed{Result: deploy.Deploy(ctx, t, mappings, header)})
+				w.Invalidate()
+			}()
+		}
+	}
+
+	deployRemote := func() {
+		if deploying > 0 {
+			return
+		}
+		targets, err := deploy.ParseTargets(deployEd.Text())
+		if err != nil {
+			appendLog("远程目标无效：" + err.Error())
+			return
+		}
+		if len(targets) == 0 {
+			appendLog("没有配置远程目标（「配置」页 hosts 区域）")
+			return
+		}
+		deployTargets(targets)
+	}
+
+	// syncWSL mirrors the managed block into every installed WSL distro.
+	// Listing runs first in the background; deploying stays non-zero
+	// meanwhile so the buttons cannot start a second sync.
+	syncWSL := func() {
+		if deploying > 0 {
+			return
+		}
+		deploying = 1
+		appendLog("正在查找已安装的 WSL 发行版…")
+		go func() {
+			ctx, c := context.WithTimeout(context.Background(), deployTimeout)
+			defer c()
+			distros, err := deploy.WSLDistros(ctx)
+			finished.send(msgWSLDistros{Distros: distros, Err: err})
+			w.Invalidate()
+		}()
+	}
+
+	retestExpired := func() {
+		seen := map[string]bool{}
+		for _, m := range expiredMaps {
+			if !seen[m.Domain] {
+				seen[m.Domain] = true
+				retestDomain(m.Domain)
+			}
+		}
+	}
+
+	dropExpired := func() {
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		orig, err := hostsfile.Read(p)
+		if err != nil {
+			appendLog("读取 hosts 失败：" + err.Error())
+			return
+		}
+		prot, ok := guardProtection(p, "移除过期项")
+		if !ok {
+			return
+		}
+		var kept []hostsfile.Mapping
+		now := time.Now()
+		for _, m := range hostsfile.ParseManagedBlock(orig) {
+			if m.Expires.IsZero() || now.Before(m.Expires) {
+				kept = append(kept, m)
+			}
+		}
+		var backup, newContent string
+		err = hostsfile.WithoutProtection(p, prot, func() (err error) {
+			backup, newContent, err = hostsfile.WriteWithBackup(p, backupDir(), kept, hostsfile.ParseManagedHeader(orig), false)
+			return err
+		})
+		if err != nil {
+			appendLog("移除过期映射失败：" + err.Error())
+			return
+		}
+		lastBackup = backup
+		hostsKnown, hostsExternal = newContent, false
+		recordUndo(p, "移除过期项", orig)
+		pruneBackups(p, backupDir())
+		appendLog(fmt.Sprintf("已移除 %d 条过期映射，备份：%s", len(expiredMaps), backup))
+		expiredMaps = nil
+		if previewTxt != "" {
+			refreshPreview()
+		}
+	}
+
+	restoreHosts := func() {
+		if strings.TrimSpace(lastBackup) == "" {
+			appendLog("没有可恢复的备份（本次未写入）")
+			return
+		}
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		if hostsExternal {
+			hostsExternal = false
+			appendLog("hosts 在写入后被外部修改，恢复备份会覆盖这些修改；确认后请再次点击「恢复备份」")
+			return
+		}
+		prot, ok := guardProtection(p, "恢复备份")
+		if !ok {
+			return
+		}
+		before, err := hostsfile.Read(p)
+		if err != nil {
+			appendLog("读取 hosts 失败：" + err.Error())
+			return
+		}
+		if err := hostsfile.WithoutProtection(p, prot, func() error { return hostsfile.RestoreBackup(lastBackup, p) }); err != nil {
+			appendLog("恢复失败：" + err.Error())
+			return
+		}
+		recordUndo(p, "恢复备份", before)
+		if s, err := hostsfile.Read(p); err == nil {
+			hostsKnown, hostsExternal = s, false
+		}
+		appendLog("已恢复：" + lastBackup)
+		for i := range rows {
+			rows[i].WrittenIP, rows[i].WrittenAt = "", time.Time{}
+		}
+	}
+
+	currentHostsPath := func() string {
+		p := strings.TrimSpace(hostsEd.Text())
+		if p == "" {
+			p = hostsfile.DefaultHostsPath()
+		}
+		return p
+	}
+
+	syncHostsWatch := func() {
+		p := currentHostsPath()
+		if p == watchedHosts {
+			return
+		}
+		if watchCancel != nil {
+			watchCancel()
+			watchCancel = nil
+		}
+		watchedHosts = p
+		hostsKnown, hostsExternal = "", false
+		symlinkOK = ""
+		clear(protectionOK)
+		if target, linked, err := hostsfile.Resolve(p); linked {
+			if err != nil {
+				appendLog("hosts 路径是符号链接，但无法解析目标：" + err.Error())
+			} else {
+				appendLog("hosts 路径是符号链接，实际文件：" + target)
+			}
+		}
+		if prot, err := hostsfile.Protected(p); err == nil && prot != 0 {
+			appendLog("hosts 文件带有 " + prot.String() + " 属性，写入或恢复前需要确认")
+		}
+		expiredMaps = nil
+		if s, err := hostsfile.Read(p); err == nil {
+			hostsKnown = s
+			checkExpired(s)
+		}
+		ctx, c := context.WithCancel(context.Background())
+		if err := hostsfile.Watch(ctx, p, func() {
+			select {
+			case uiCh <- msgHostsChanged{Path: p}:
+			default:
+			}
+			w.Invalidate()
+		}); err != nil {
+			c()
+			return
+		}
+		watchCancel = c
+	}
+
+	onHostsChanged := func(p string) {
+		if p != watchedHosts {
+			return
+		}
+		s, err := hostsfile.Read(p)
+		if err != nil || s == hostsKnown {
+			return
+		}
+		appendLog("检测到 hosts 被外部修改：" + p)
+		if previewTxt != "" {
+			refreshPreview()
+			appendLog("已按最新内容刷新预览，写入前需再次确认")
+		}
+		hostsKnown, hostsExternal = s, true
+		checkExpired(s)
+	}
+
+	selectBackup := func(path string) {
+		selectedBackup = path
+		b, err := os.ReadFile(path)
+		if err != nil {
+			backupPreviewEd.SetText("")
+			appendLog("读取备份失败：" + err.Error())
+			return
+		}
+		backupPreviewEd.SetText(string(b))
+	}
+
+	refreshBackups := func() {
+		bs, err := hostsfile.ListBackups(currentHostsPath(), backupDir())
+		if err != nil {
+			appendLog("扫描备份失败：" + err.Error())
+			return
+		}
+		backups = make([]backupItem, len(bs))
+		found := false
+		for i, b := range bs {
+			backups[i].Backup = b
+			found = found || b.Path == selectedBackup
+		}
+		if !found {
+			selectedBackup = ""
+			backupPreviewEd.SetText("")
+		}
+	}
+
+	loadComparison := func() {
+		compareChanges = nil
+		if len(compareSel) < 2 {
+			return
+		}
+		a, err := history.Load(compareSel[0])
+		if err != nil {
+			appendLog("读取运行记录失败：" + err.Error())
+			return
+		}
+		b, err := history.Load(compareSel[1])
+		if err != nil {
+			appendLog("读取运行记录失败：" + err.Error())
+			return
+		}
+		if b.Time.Before(a.Time) {
+			a, b = b, a
+		}
+		compareChanges = history.Compare(a, b)
+	}
+
+	selectRun := func(path string) {
+		if i := slices.Index(compareSel, path); i >= 0 {
+			compareSel = slices.Delete(compareSel, i, i+1)
+		} else {
+			if len(compareSel) >= 2 {
+				compareSel = compareSel[1:]
+			}
+			compareSel = append(compareSel, path)
+		}
+		loadComparison()
+	}
+
+	refreshHistory := func() {
+		runs, err := history.List(historyDir())
+		if err != nil {
+			appendLog("读取运行记录失败：" + err.Error())
+			return
+		}
+		historyItems = make([]historyItem, len(runs))
+		var kept []string
+		for i, r := range runs {
+			historyItems[i].Info = r
+			if slices.Contains(compareSel, r.Path) {
+				kept = append(kept, r.Path)
+			}
+		}
+		compareSel = kept
+		loadComparison()
+	}
+
+	saveRun := func() {
+		dir := historyDir()
+		if dir == "" {
+			return
+		}
+		run := history.Run{Time: time.Now()}
+		for _, r := range rows {
+			run.Entries = append(run.Entries, history.Entry{Domain: r.Domain, IP: r.BestIP, Rate: r.Rate, P95: r.P95, Err: r.Message})
+		}
+		if _, err := history.Save(dir, run); err != nil {
+			appendLog("保存运行记录失败：" + err.Error())
+		}
 	}
 
-	loadDomainsFromHosts := func() {
-		p := strings.TrimSpace(hostsEd.Text())
-		if p == "" {
-			p = hostsfile.DefaultHostsPath()
+	restoreSelectedBackup := func() {
+		if selectedBackup == "" {
+			return
 		}
-		ds, err := domain.ReadDomainsFromHosts(p)
+		p := currentHostsPath()
+		prot, ok := guardProtection(p, "恢复所选")
+		if !ok {
+			return
+		}
+		before, err := hostsfile.Read(p)
 		if err != nil {
 			appendLog("读取 hosts 失败：" + err.Error())
 			return
 		}
-		domainsEd.SetText(strings.Join(ds, "\n"))
-		appendLog(fmt.Sprintf("已导入 hosts 域名：%d", len(ds)))
-	}
-
-	pickDomainsFile := func() {
-		go func() {
-			p, err := filedialog.OpenFile("选择域名文件", []filedialog.Filter{
-				{Name: "文本文件 (*.txt)", Pattern: "*.txt"},
-				{Name: "所有文件 (*.*)", Pattern: "*.*"},
-			})
-			select {
-			case uiCh <- msgPickedPath{Kind: "domains", Path: p, Err: err}:
-			default:
-			}
-			w.Invalidate()
-		}()
-	}
-
-	pickHostsFile := func() {
-		go func() {
-			p, err := filedialog.OpenFile("选择 hosts 文件", []filedialog.Filter{
-				{Name: "hosts", Pattern: "hosts"},
-				{Name: "所有文件 (*.*)", Pattern: "*.*"},
-			})
-			select {
-			case uiCh <- msgPickedPath{Kind: "hosts", Path: p, Err: err}:
-			default:
-			}
-			w.Invalidate()
-		}()
+		if err := hostsfile.WithoutProtection(p, prot, func() error { return hostsfile.RestoreBackup(selectedBackup, p) }); err != nil {
+			appendLog("恢复失败：" + err.Error())
+			return
+		}
+		recordUndo(p, "恢复所选备份", before)
+		appendLog("已恢复：" + selectedBackup)
 	}
 
-	buildPreview := func() {
-		p := strings.TrimSpace(hostsEd.Text())
-		if p == "" {
-			p = hostsfile.DefaultHostsPath()
+	stepHosts := func(redo bool) {
+		p := currentHostsPath()
+		verb := "撤销"
+		if redo {
+			verb = "重做"
 		}
-		orig, err := hostsfile.Read(p)
+		prot, ok := guardProtection(p, verb)
+		if !ok {
+			return
+		}
+		cur, err := hostsfile.Read(p)
 		if err != nil {
 			appendLog("读取 hosts 失败：" + err.Error())
 			return
 		}
-		block := hostsfile.BuildManagedBlock(buildMappings())
-		previewTxt = hostsfile.ApplyManagedBlock(orig, block)
-		previewEd.SetText(previewTxt)
-		mainTab.Value = "preview"
-		appendLog("已生成预览")
-		w.Invalidate()
+		snap, err := undoStack.Step(redo, hostsfile.Snapshot{Time: time.Now(), Path: p, Content: cur}, func(s hostsfile.Snapshot) error {
+			return hostsfile.WithoutProtection(p, prot, func() error { return hostsfile.WriteContent(p, s.Content) })
+		})
+		if err != nil {
+			appendLog(verb + "失败：" + err.Error())
+			return
+		}
+		if up := undoPath(); up != "" {
+			if err := undoStack.Save(up); err != nil {
+				appendLog("保存撤销记录失败：" + err.Error())
+			}
+		}
+		if s, err := hostsfile.Read(p); err == nil {
+			hostsKnown, hostsExternal = s, false
+		}
+		for i := range rows {
+			rows[i].WrittenIP, rows[i].WrittenAt = "", time.Time{}
+		}
+		appendLog(fmt.Sprintf("已%s「%s」（%s）", verb, snap.Action, snap.Time.Format("01-02 15:04:05")))
+		if previewTxt != "" {
+			refreshPreview()
+		}
 	}
 
-	writeHosts := func() {
-		p := strings.TrimSpace(hostsEd.Text())
-		if p == "" {
-			p = hostsfile.DefaultHostsPath()
+	deleteSelectedBackup := func() {
+		if selectedBackup == "" {
+			return
+		}
+		if err := hostsfile.DeleteBackup(selectedBackup, currentHostsPath()); err != nil {
+			appendLog("删除备份失败：" + err.Error())
+			return
 		}
-		backup, _, err := hostsfile.WriteWithBackup(p, buildMappings())
+		appendLog("已删除备份：" + selectedBackup)
+		if selectedBackup == lastBackup {
+			lastBackup = ""
+		}
+		refreshBackups()
+	}
+
+	appendSystemDNS := func() {
+		found, err := sysdns.Servers()
 		if err != nil {
-			appendLog("写入失败：" + err.Error())
+			appendLog("读取系统 DNS 失败：" + err.Error())
 			return
 		}
-		lastBackup = backup
-		appendLog("写入成功，备份：" + backup)
+		have := parseTokens(dnsEd.Text())
+		var added []string
+		for _, s := range found {
+			if !slices.Contains(have, s) {
+				have = append(have, s)
+				added = append(added, s)
+			}
+		}
+		switch {
+		case len(found) == 0:
+			appendLog("没有找到系统配置的 DNS 服务器")
+		case len(added) == 0:
+			appendLog("系统 DNS 已在列表中：" + strings.Join(found, ", "))
+		default:
+			dnsEd.SetText(strings.Join(have, "\n"))
+			appendLog("已追加系统 DNS：" + strings.Join(added, ", "))
+		}
 	}
 
-	restoreHosts := func() {
-		if strings.TrimSpace(lastBackup) == "" {
-			appendLog("没有可恢复的备份（本次未写入）")
+	runDNSBench := func() {
+		if dnsBenching {
 			return
 		}
-		p := strings.TrimSpace(hostsEd.Text())
-		if p == "" {
-			p = hostsfile.DefaultHostsPath()
+		servers := parseTokens(dnsEd.Text())
+		if len(servers) == 0 {
+			appendLog("请先在「配置」页填写要测试的 DNS 服务器")
+			return
 		}
-		if err := hostsfile.RestoreBackup(lastBackup, p); err != nil {
-			appendLog("恢复失败：" + err.Error())
+		domains := parseTokens(dnsBenchDomainsEd.Text())
+		if len(domains) == 0 {
+			appendLog("请填写用于测试的域名")
 			return
 		}
-		appendLog("已恢复：" + lastBackup)
+		rounds, err := strconv.Atoi(strings.TrimSpace(dnsBenchRoundsEd.Text()))
+		if err != nil || rounds < 1 || rounds > 20 {
+			appendLog("每个域名查询次数需为 1-20")
+			return
+		}
+		dnsBenching, dnsBenchDone, dnsBenchTotal = true, 0, len(servers)*len(domains)*rounds
+		appendLog(fmt.Sprintf("开始测试 %d 个 DNS 服务器（%d 个域名 × %d 次）", len(servers), len(domains), rounds))
+		go func() {
+			bs := engine.BenchmarkResolvers(context.Background(), servers, domains, rounds, func(d, t int) {
+				select {
+				case uiCh <- msgDNSBench{Done: d, Total: t}:
+				default:
+				}
+				w.Invalidate()
+			})
+			finished.send(msgDNSBench{Results: bs})
+			w.Invalidate()
+		}()
 	}
 
+	commands := func() []command {
+		tab := func(key, name string) command {
+			return command{Title: "切换到「" + name + "」页", Alias: "tab go " + key, Run: func() { mainTab.Value = key }}
+		}
+		cmds := []command{
+			{Title: "开始测速", Alias: "start run", Run: func() {
+				if !running {
+					startRun()
+				}
+			}},
+			{Title: "停止测速", Alias: "stop cancel run", Run: func() { stopRun() }},
+			{Title: "重测选中", Alias: "retest selected", Run: func() { retestSelected() }},
+			{Title: "导入 hosts 中的当前映射", Alias: "import managed hosts current", Run: func() {
+				if !running {
+					importManaged()
+					mainTab.Value = "results"
+				}
+			}},
+			{Title: "生成预览", Alias: "preview", Run: func() { buildPreview(); mainTab.Value = "preview" }},
+			{Title: "写入 hosts", Alias: "write hosts apply", Run: func() { writeHosts() }},
+			{Title: "恢复备份", Alias: "restore backup rollback", Run: func() { restoreHosts() }},
+			{Title: undoLabel(undoStack.Undo, "撤销"), Alias: "undo", Run: func() { stepHosts(false) }},
+			{Title: undoLabel(undoStack.Redo, "重做"), Alias: "redo", Run: func() { stepHosts(true) }},
+			{Title: "复制结果", Alias: "copy results", Run: func() { copyText("结果", resultsText(rows)) }},
+			{Title: "复制已选映射", Alias: "copy mappings hosts", Run: func() { copyText("映射", hostsfile.FormatMappings(exportMappings())) }},
+			{Title: "导出报告", Alias: "export report markdown html", Run: func() { exportReport() }},
+			{Title: "导出运行 (.ipopt.json)", Alias: "export run snapshot ipopt json", Run: func() { exportRun() }},
+			{Title: "导入运行 (.ipopt.json)", Alias: "import run snapshot ipopt json", Run: func() { pickRunFile() }},
+			{Title: "部署到远程", Alias: "deploy remote ssh", Run: func() { deployRemote() }},
+			{Title: "同步到 WSL", Alias: "wsl sync distro hosts", Run: func() { syncWSL() }},
+			{Title: "开始/停止监控", Alias: "monitor", Run: func() {
+				if monitoring {
+					stopMonitor()
+				} else {
+					startMonitor()
+				}
+			}},
+			{Title: "切换高对比度", Alias: "high contrast theme", Run: func() { highContrast.Value = !highContrast.Value }},
+		}
+		for _, f := range export.Formats {
+			cmds = append(cmds, command{Title: "导出为 " + f.Name, Alias: "export " + f.Key, Run: func() { exportAs(f) }})
+		}
+		return append(cmds,
+			tab("config", "配置"), tab("results", "结果"), tab("log", "日志"), tab("preview", "预览"),
+			tab("backups", "备份"), tab("monitor", "监控"), tab("compare", "对比"), tab("dns", "DNS 测速"),
+			command{Title: "测试 DNS 服务器", Alias: "dns benchmark resolvers", Run: func() { mainTab.Value = "dns"; runDNSBench() }},
+		)
+	}
+
+	importManaged()
+
 	var ops op.Ops
 	for {
 		e := w.Event()
 		switch e := e.(type) {
 		case app.DestroyEvent:
+			saveSettings()
 			stopRun()
+			stopMonitor()
+			if logWriter != nil {
+				logWriter.Close()
+			}
+			if jsonWriter != nil {
+				jsonWriter.Close()
+			}
+			if watchCancel != nil {
+				watchCancel()
+			}
+			if dnsServer != nil {
+				dnsServer.Close()
+			}
 			return e.Err
+		case app.ViewEvent:
+			if hwnd := nativeWindow(e); hwnd != 0 {
+				err := dropfiles.Enable(hwnd, func(paths []string) {
+					select {
+					case uiCh <- msgDropped{Paths: paths}:
+					default:
+					}
+					w.Invalidate()
+				})
+				if err != nil {
+					appendLog("启用拖放导入失败：" + err.Error())
+				}
+			}
 		case app.FrameEvent:
+			// Live updates go first: a domain's final result is only sent
+			// after its slot is dropped, so it always lands on top of them.
+			for _, lu := range live.take() {
+				i := rowIndex(lu.Domain)
+				if lu.Stage != "" {
+					rows[i].Stage = stageText(lu.Stage, lu.Done, lu.Total)
+				}
+				if lu.HasResult {
+					rows[i].Candidates = lu.Result.Candidates
+					rows[i].Metric = engine.JitterMetric(jitterMetric.Value)
+					rows[i].useCandidate(lu.Result.Best)
+				}
+			}
+			pending := finished.take()
 			for {
-				select {
-				case m := <-uiCh:
-					switch m := m.(type) {
-					case msgLog:
-						appendLog(m.Line)
-					case msgResult:
-						applyResult(m.Result)
-					case msgProgress:
-						done, total = m.Done, m.Total
-					case msgDone:
-						running = false
-						if m.Err != nil && !errorsIsCanceled(m.Err) {
-							appendLog("任务结束：" + m.Err.Error())
-						} else {
-							appendLog("任务结束")
+				var msg any
+				if len(pending) > 0 {
+					msg, pending = pending[0], pending[1:]
+				} else {
+					select {
+					case msg = <-uiCh:
+					default:
+						goto drained
+					}
+				}
+				switch m := msg.(type) {
+				case msgLog:
+					appendLog(m.Line)
+				case msgResult:
+					applyResult(m.Result)
+				case msgProgress:
+					done, total = m.Done, m.Total
+					rate.add(time.Now(), done)
+				case msgRetested:
+					d := m.Result.Domain
+					if c, ok := retesting[d]; ok {
+						c()
+						delete(retesting, d)
+					}
+					switch {
+					case m.Result.Err != nil && errorsIsCanceled(m.Result.Err):
+						if i, ok := domainIdx[d]; ok {
+							rows[i].Stage = ""
 						}
-					case msgPickedPath:
-						if m.Err != nil {
-							if strings.Contains(strings.ToLower(m.Err.Error()), "canceled") {
-								break
+						appendLog("已取消重测：" + d)
+					case m.Result.Err != nil:
+						applyResult(m.Result)
+						appendLog("重测失败：" + d + "：" + m.Result.Err.Error())
+					default:
+						applyResult(m.Result)
+						appendLog("重测完成：" + d + " -> " + m.Result.Best.IP.String())
+					}
+					if t := findTrack(d); t != nil && t.Replacing {
+						t.Replacing = false
+						if m.Result.Err == nil && m.Result.Best.IP.String() != t.IP {
+							appendLog("监控：自动替换 " + d + "：" + t.IP + " -> " + m.Result.Best.IP.String())
+							notifyUser("监控自动替换", d+" -> "+m.Result.Best.IP.String())
+							if lastBackup != "" {
+								writeHosts()
+							}
+							if monitoring {
+								startMonitor()
 							}
-							appendLog("选择文件失败：" + m.Err.Error())
-							break
 						}
-						if strings.TrimSpace(m.Path) == "" {
-							break
+					}
+				case msgDNSBench:
+					if m.Results == nil {
+						dnsBenchDone, dnsBenchTotal = m.Done, m.Total
+						break
+					}
+					dnsBenching, dnsBenchResults = false, m.Results
+					if rec := recommendedServers(m.Results); len(rec) > 0 {
+						appendLog("DNS 测试完成，建议顺序：" + strings.Join(rec, ", "))
+					} else {
+						appendLog("DNS 测试完成：所有服务器都没有应答")
+					}
+				case msgMonitorSample:
+					if m.Gen == monitorGen {
+						onMonitorSample(m.Sample)
+					}
+				case msgMonitorDone:
+					if m.Gen != monitorGen {
+						break
+					}
+					monitoring = false
+					if m.Err != nil && !errorsIsCanceled(m.Err) {
+						appendLog("监控结束：" + m.Err.Error())
+					} else {
+						appendLog("监控已停止")
+					}
+				case msgDone:
+					running = false
+					if p := dnsCachePath(); dnsDisk.Value && p != "" {
+						if err := dnsCache.Save(p); err != nil {
+							appendLog("保存 DNS 缓存失败：" + err.Error())
+						}
+					}
+					for i := range rows {
+						if _, busy := retesting[rows[i].Domain]; !busy {
+							rows[i].Stage = ""
+						}
+					}
+					ok := 0
+					for _, r := range rows {
+						if r.BestIP != "" {
+							ok++
 						}
-						switch m.Kind {
-						case "domains":
-							ds, err := domain.ReadDomainsFromFile(m.Path)
-							if err != nil {
-								appendLog("读取文件失败：" + err.Error())
-								break
+					}
+					switch {
+					case m.Err != nil && !errorsIsCanceled(m.Err):
+						appendLog("任务结束：" + m.Err.Error())
+						notifyUser("优选失败", m.Err.Error())
+					case m.Err != nil:
+						appendLog("任务结束")
+					default:
+						appendLog("任务结束")
+						notifyUser("优选完成", fmt.Sprintf("成功 %d / %d 个域名", ok, len(rows)))
+						saveRun()
+						results := webhookResults(rows)
+						sendWebhook(webhook.Payload{Event: webhook.EventRunDone, Summary: webhook.Summarize(results), Results: results})
+					}
+				case msgCDNRanges:
+					cdnFetching--
+					if m.Err != nil {
+						appendLog("获取 CDN 地址段失败：" + m.Key + "：" + m.Err.Error())
+						break
+					}
+					cdnRanges[m.Key] = m.Prefixes
+					note := ""
+					switch {
+					case m.Stale:
+						note = "（网络失败，使用本地缓存）"
+					case m.FromCache:
+						note = "（未变化，使用缓存）"
+					}
+					appendLog(fmt.Sprintf("已加载 CDN 地址段：%s %d 段%s", m.Key, len(m.Prefixes), note))
+				case msgSubscription:
+					subsFetching--
+					if m.Err != nil {
+						appendLog("获取订阅失败：" + m.URL + "：" + m.Err.Error())
+						break
+					}
+					domainsEd.SetText(domain.MergeSource(domainsEd.Text(), m.URL, m.Domains))
+					if len(m.Hints) > 0 {
+						hostHints[m.URL] = m.Hints
+						appendLog(fmt.Sprintf("订阅为 hosts 格式：%d 个域名附带 IP，将作为候选参与测速", len(m.Hints)))
+					} else {
+						delete(hostHints, m.URL)
+					}
+					note := ""
+					switch {
+					case m.Stale:
+						note = "（网络失败，使用本地缓存）"
+					case m.FromCache:
+						note = "（未变化，使用缓存）"
+					}
+					appendLog(fmt.Sprintf("已合并订阅域名：%d %s%s", len(m.Domains), m.URL, note))
+				case msgHostsChanged:
+					onHostsChanged(m.Path)
+				case msgVerified:
+					v := m.Result
+					if !m.WrittenAt.Equal(verifyAt) {
+						break
+					}
+					verifyLeft--
+					verifyDone = append(verifyDone, v)
+					if verifyLeft == 0 && engine.ShouldRollBack(verifyDone) {
+						down := 0
+						for _, d := range verifyDone {
+							if d.Regressed() {
+								down++
 							}
-							domainFilePath = m.Path
-							domainsEd.SetText(strings.Join(ds, "\n"))
-							appendLog(fmt.Sprintf("已导入文件域名：%d (%s)", len(ds), filepath.Base(m.Path)))
-						case "hosts":
-							hostsEd.SetText(m.Path)
-							appendLog("已选择 hosts：" + m.Path)
+						}
+						msg := fmt.Sprintf("写入后 %d/%d 个域名解析到新 IP 却无法连接，原地址仍可连接", down, len(verifyDone))
+						switch {
+						case !autoUndo.Value:
+							appendLog(msg + "，建议点击「恢复备份」")
+							notifyUser("hosts 写入后验证失败", msg)
+						case lastBackup != verifyBackup || hostsExternal:
+							appendLog(msg + "；hosts 已在写入后变化，未自动回滚")
+							notifyUser("hosts 写入后验证失败", msg)
+						default:
+							appendLog(msg + "，自动恢复备份")
+							restoreHosts()
+							restoreExtraHosts()
+							notifyUser("已自动回滚 hosts", msg+"，已恢复："+verifyBackup)
 						}
 					}
-				default:
-					goto drained
+					i, ok := domainIdx[v.Domain]
+					if !ok || !rows[i].WrittenAt.Equal(m.WrittenAt) {
+						break
+					}
+					if v.OK() {
+						rows[i].Verify, rows[i].VerifyMsg = verifyOK, fmt.Sprintf("✓ 已生效：系统解析到 %s，连接 %s", v.IP, v.Latency.Round(100*time.Microsecond))
+					} else {
+						rows[i].Verify, rows[i].VerifyMsg = verifyFailed, "✗ 验证失败："+v.Err.Error()
+						appendLog(fmt.Sprintf("写入后验证失败 %s：%s", v.Domain, v.Err))
+					}
+				case msgDeployed:
+					deploying--
+					r := m.Result
+					switch {
+					case r.Err != nil:
+						appendLog("部署失败 " + r.Target.String() + "：" + r.Err.Error())
+					case !r.Changed:
+						appendLog("远程 hosts 无需变更：" + r.Target.String())
+					default:
+						appendLog("已部署到 " + r.Target.String() + "，备份：" + r.Backup)
+					}
+					if r.ConfChanged {
+						appendLog(r.Target.String() + "：已在 /etc/wsl.conf 关闭 generateHosts，执行 wsl --shutdown 后生效")
+					} else if r.GenerateHosts && r.Err == nil {
+						appendLog(r.Target.String() + "：/etc/wsl.conf 未关闭 generateHosts，发行版重启后 /etc/hosts 会被重新生成")
+					}
+					if deploying == 0 {
+						appendLog("远程部署结束")
+					}
+				case msgWSLDistros:
+					deploying--
+					switch {
+					case errors.Is(m.Err, deploy.ErrNoWSL):
+						appendLog("同步到 WSL 仅支持 Windows")
+					case m.Err != nil:
+						appendLog("查找 WSL 发行版失败：" + m.Err.Error())
+					case len(m.Distros) == 0:
+						appendLog("没有找到已安装的 WSL 发行版")
+					default:
+						targets := make([]deploy.Target, len(m.Distros))
+						for i, d := range m.Distros {
+							targets[i] = deploy.WSLTarget(d, wslNoGen.Value)
+						}
+						deployTargets(targets)
+					}
+				case msgDropped:
+					for _, p := range m.Paths {
+						importDropped(p)
+					}
+				case msgPickedPath:
+					if m.Err != nil {
+						if strings.Contains(strings.ToLower(m.Err.Error()), "canceled") {
+							break
+						}
+						appendLog("选择文件失败：" + m.Err.Error())
+						break
+					}
+					if strings.TrimSpace(m.Path) == "" {
+						break
+					}
+					switch m.Kind {
+					case "domains":
+						importDomainsFile(m.Path)
+					case "hosts":
+						hostsEd.SetText(m.Path)
+						appendLog("已选择 hosts：" + m.Path)
+					case "export":
+						appendLog("已导出：" + m.Path)
+					case "run":
+						importRun(m.Path)
+					case "output":
+						outputEd.SetText(m.Path)
+					case "geo":
+						geoPathEd.SetText(m.Path)
+						loadGeo()
+					case "asn":
+						asnPathEd.SetText(m.Path)
+						loadASN()
+					}
 				}
 			}
 		drained:
 
+			syncFileLog()
+			syncHostsWatch()
+			syncDNSServer()
+			if mainTab.Value != prevTab {
+				switch mainTab.Value {
+				case "backups":
+					refreshBackups()
+				case "compare":
+					refreshHistory()
+				}
+				prevTab = mainTab.Value
+			}
+
 			ops.Reset()
 			gtx := app.NewContext(&ops, e)
+			if highContrast.Value != uiHighContrast {
+				setHighContrast(th, highContrast.Value)
+			}
+			scale := uiScale(scaleSlider.Value)
+			gtx.Metric.PxPerDp *= scale
+			gtx.Metric.PxPerSp *= scale
+			for {
+				ev, ok := gtx.Event(transfer.TargetFilter{Target: &clipTag, Type: "application/text"})
+				if !ok {
+					break
+				}
+				if de, ok := ev.(transfer.DataEvent); ok {
+					rc := de.Open()
+					b, err := io.ReadAll(rc)
+					_ = rc.Close()
+					if err != nil {
+						appendLog("读取剪贴板失败：" + err.Error())
+						continue
+					}
+					pasteDomains(string(b))
+				}
+			}
+			event.Op(gtx.Ops, &clipTag)
 			layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-					return headerBar(th, gtx, &startBtn, &stopBtn, running, done, total,
+					return headerBar(th, gtx, &startBtn, &stopBtn, running, done, total, speedText(&rate, running, done, total),
 						func() {
 							if !running {
 								startRun()
@@ -488,57 +3209,289 @@ func loop(w *app.Window) error {
 					)
 				}),
 				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-					return tabBar(th, gtx, &mainTab, &tabConfigBtn, &tabResultsBtn, &tabLogBtn, &tabPreviewBtn)
+					if netWarning == "" {
+						return layout.Dimensions{}
+					}
+					return warningBanner(th, gtx, netWarning, &dismissWarn, func() { netWarning = "" })
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return tabBar(th, gtx, &mainTab, &scaleSlider, &highContrast, &tabConfigBtn, &tabResultsBtn, &tabLogBtn, &tabPreviewBtn, &tabBackupsBtn, &tabMonitorBtn, &tabCompareBtn, &tabDNSBtn)
 				}),
 				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
 					switch mainTab.Value {
 					case "results":
-						return rightPanel(th, gtx, &resultsList, &selectAllBtn, &selectNoneBtn, &selectOKBtn, rows,
+						if resolversOpen && resolverDirty {
+							resolverStats, resolverDirty = tallyResolvers(rows), false
+						}
+						return rightPanel(th, gtx, &resultsList, &selectAllBtn, &selectNoneBtn, &selectOKBtn, &retestSelBtn, &copyResultsBtn, &copyMapsBtn, &exportBtn, &reportBtn, &runExportBtn, &runImportBtn, &columnsBtn, &resolversBtn, exportFmtBtns, selBtns, exportOpen, columnsOpen, resolversOpen, resultCols, resolverStats, rowWidgetPool, rows, selection, running, retesting,
 							func(mode string) {
 								switch mode {
 								case "all":
 									for i := range rows {
-										if rows[i].Message == "" && rows[i].BestIP != "" {
-											rows[i].Apply.Value = true
+										if rows[i].effectiveIP() != "" {
+											rows[i].Apply = true
 										}
 									}
 								case "none":
 									for i := range rows {
-										rows[i].Apply.Value = false
+										rows[i].Apply = false
 									}
 								case "ok":
 									for i := range rows {
-										rows[i].Apply.Value = rows[i].Message == "" && rows[i].BestIP != ""
+										rows[i].Apply = rows[i].effectiveIP() != ""
+									}
+								}
+							},
+							func() { retestSelected() },
+							func() { copyText("结果", resultsText(rows)) },
+							func() { copyText("映射", hostsfile.FormatMappings(exportMappings())) },
+							func() { exportOpen = !exportOpen },
+							func() { columnsOpen = !columnsOpen },
+							func() { resolversOpen = !resolversOpen },
+							func(f export.Format) { exportAs(f) },
+							func() { exportReport() },
+							func() { exportRun() },
+							func() { pickRunFile() },
+							func(d string) { retestDomain(d) },
+							func(d string) { skipDomain(d) },
+							func(s string) { copyText("映射", s) },
+							func(i int, shift bool) { selAnchor = selectRows(&selection, len(rows), selAnchor, i, shift) },
+							func(action int) {
+								if action == selClear {
+									selection.Clear()
+									selAnchor = -1
+									return
+								}
+								for i := range rows {
+									if selection.Has(i) {
+										rows[i].Apply = action == selApply && rows[i].effectiveIP() != ""
 									}
 								}
 							},
 						)
 					case "log":
-						return editorPage(th, gtx, "日志", &logEd)
+						return logPage(th, gtx, &logEd, &logSearchEd, &fileLog, &jsonLog, &openLogBtn, &logPrevBtn, &logNextBtn, logSearchEd.Text() != "", logMatch, logMatchCount,
+							func() { openLogDir() },
+							func(step int) { searchLog(step) },
+						)
 					case "preview":
-						return previewPage(th, gtx, &previewEd, &previewBtn, &writeBtn, &restoreBtn,
-							func() { buildPreview() },
-							func() { writeHosts() },
-							func() { restoreHosts() },
+						return previewPage(th, gtx, &previewState{
+							ed:               &previewEd,
+							diffList:         &diffList,
+							diffLines:        diffLines,
+							showDiff:         &showDiff,
+							mergeMode:        &mergeMode,
+							targets:          targetStatuses,
+							hasPreview:       previewTxt != "",
+							canDeploy:        deploying == 0,
+							writeText:        writeLabel(toFile.Value),
+							expired:          len(expiredMaps),
+							issues:           lintIssues,
+							blocking:         lintBlocking,
+							previewBtn:       &previewBtn,
+							copyBtn:          &copyPreviewBtn,
+							writeBtn:         &writeBtn,
+							restoreBtn:       &restoreBtn,
+							undoBtn:          &undoBtn,
+							redoBtn:          &redoBtn,
+							deployBtn:        &deployBtn,
+							wslBtn:           &wslBtn,
+							retestExpiredBtn: &retestExpiredBtn,
+							dropExpiredBtn:   &dropExpiredBtn,
+							undoText:         undoLabel(undoStack.Undo, "撤销"),
+							redoText:         undoLabel(undoStack.Redo, "重做"),
+							canUndo:          len(undoStack.Undo) > 0,
+							canRedo:          len(undoStack.Redo) > 0,
+							onPreview:        func() { buildPreview() },
+							onCopy:           func() { copyText("预览", previewTxt) },
+							onWrite:          func() { writeHosts() },
+							onRestore:        func() { restoreHosts() },
+							onUndo:           func() { stepHosts(false) },
+							onRedo:           func() { stepHosts(true) },
+							onDeploy:         func() { deployRemote() },
+							onWSL:            func() { syncWSL() },
+							onRetestExpired:  func() { retestExpired() },
+							onDropExpired:    func() { dropExpired() },
+							onMergeMode: func() {
+								if previewTxt != "" {
+									refreshPreview()
+								}
+							},
+						})
+					case "monitor":
+						return monitorPage(th, gtx, &monitorList, monitorTracks, &monitorIntervalEd, &monitorThresholdEd, &metricsAddrEd, &monitorAuto, &monitorBtn, monitoring,
+							func() {
+								if monitoring {
+									stopMonitor()
+									appendLog("监控已停止")
+								} else {
+									startMonitor()
+								}
+							},
 						)
-					default:
-						return leftPanel(th, gtx, &leftList, &domainsEd, &dnsEd, &hostsEd, &portEd, &timeoutEd, &attemptsEd, &concurrencyEd, &ipv4, &ipv6,
-							&loadHosts, &pickFile, &pickHosts,
-							running,
-							domainFilePath,
-							func() { loadDomainsFromHosts() },
-							func() { pickDomainsFile() },
-							func() { pickHostsFile() },
+					case "dns":
+						return dnsBenchPage(th, gtx, &dnsBenchList, &dnsBenchDomainsEd, &dnsBenchRoundsEd, &dnsBenchBtn, &dnsBenchApplyBtn, dnsBenchResults, dnsBenching, dnsBenchDone, dnsBenchTotal,
+							func() { runDNSBench() },
+							func() {
+								dnsEd.SetText(strings.Join(recommendedServers(dnsBenchResults), "\n"))
+								appendLog("已按测试结果更新 DNS 服务器列表")
+							},
+						)
+					case "compare":
+						return comparePage(th, gtx, &historyList, &changeList, historyItems, compareSel, compareChanges, &refreshHistoryBtn,
+							func(path string) { selectRun(path) },
+							func() { refreshHistory() },
 						)
+					case "backups":
+						return backupsPage(th, gtx, &backupsList, backups, selectedBackup, &backupPreviewEd,
+							&refreshBackupsBtn, &restoreBackupBtn, &deleteBackupBtn,
+							func(path string) { selectBackup(path) },
+							func() { refreshBackups() },
+							func() { restoreSelectedBackup() },
+							func() { deleteSelectedBackup() },
+						)
+					default:
+						return leftPanel(th, gtx, &configState{
+							leftList:        &leftList,
+							runGroup:        &runGroup,
+							familyPolicy:    &familyPolicy,
+							jitterMetric:    &jitterMetric,
+							outlierMode:     &outlierMode,
+							tieBreak:        &tieBreak,
+							outputMode:      &outputMode,
+							domainsEd:       &domainsEd,
+							subsEd:          &subsEd,
+							subURLEd:        &subURLEd,
+							dnsEd:           &dnsEd,
+							hostsEd:         &hostsEd,
+							portEd:          &portEd,
+							timeoutEd:       &timeoutEd,
+							intervalEd:      &intervalEd,
+							attemptsEd:      &attemptsEd,
+							concurrencyEd:   &concurrencyEd,
+							rateEd:          &rateEd,
+							lossEd:          &lossEd,
+							expiryEd:        &expiryEd,
+							keepBakEd:       &keepBakEd,
+							bakDaysEd:       &bakDaysEd,
+							bakDirEd:        &bakDirEd,
+							roundsEd:        &roundsEd,
+							roundDelayEd:    &roundDelayEd,
+							slowEd:          &slowEd,
+							minSamplesEd:    &minSamplesEd,
+							webhookEd:       &webhookEd,
+							deployEd:        &deployEd,
+							extraHostsEd:    &extraHostsEd,
+							outputEd:        &outputEd,
+							dnsListenEd:     &dnsListenEd,
+							geoPathEd:       &geoPathEd,
+							geoRegionsEd:    &geoRegionsEd,
+							asnPathEd:       &asnPathEd,
+							asnExcludeEd:    &asnExcludeEd,
+							blocklistEd:     &blocklistEd,
+							dohEd:           &dohEd,
+							ipv4:            &ipv4,
+							ipv6:            &ipv6,
+							byPrefix:        &byPrefix,
+							cdnSeed:         &cdnSeed,
+							dnsNoCache:      &dnsNoCache,
+							dnsDisk:         &dnsDisk,
+							adaptive:        &adaptive,
+							autoConc:        &autoConc,
+							reuseSame:       &reuseSame,
+							warmUp:          &warmUp,
+							notifyDone:      &notifyDone,
+							keepOnFail:      &keepOnFail,
+							fixDupes:        &fixDupes,
+							autoUndo:        &autoUndo,
+							toFile:          &toFile,
+							dnsServe:        &dnsServe,
+							keepBogons:      &keepBogons,
+							httpsRR:         &httpsRR,
+							keepHijack:      &keepHijack,
+							quicProbe:       &quicProbe,
+							quicScore:       &quicScore,
+							mtuCheck:        &mtuCheck,
+							traceBest:       &traceBest,
+							fullReport:      &fullReport,
+							wslNoGen:        &wslNoGen,
+							loadHosts:       &loadHosts,
+							pickFile:        &pickFile,
+							pasteDomains:    &pasteDomainsBtn,
+							refreshSubs:     &refreshSubsBtn,
+							pickHosts:       &pickHosts,
+							editHosts:       &editHosts,
+							pickOutput:      &pickOutputBtn,
+							pickGeo:         &pickGeoBtn,
+							pickASN:         &pickASNBtn,
+							refreshCDN:      &refreshCDNBtn,
+							sysDNS:          &sysDNSBtn,
+							running:         running,
+							fetching:        subsFetching > 0,
+							cdnFetching:     cdnFetching > 0,
+							domainFilePath:  domainFilePath,
+							cdnStatus:       cdnStatus(cdnRanges),
+							dnsCacheStatus:  fmt.Sprintf("DNS 缓存 %d 条", dnsCache.Len()),
+							dnsServerStatus: dnsServerStatus(dnsServer),
+							onLoadHosts:     func() { loadDomainsFromHosts() },
+							onPickFile:      func() { pickDomainsFile() },
+							onPaste:         func() { clipRead = true },
+							onRefreshSubs:   func() { refreshSubscriptions() },
+							onPickHosts:     func() { pickHostsFile() },
+							onEditHosts:     func() { editHostsFile() },
+							onPickOutput:    func() { pickOutputFile() },
+							onPickGeo:       func() { pickMMDB("选择 GeoIP 数据库", "geo") },
+							onPickASN:       func() { pickMMDB("选择 ASN 数据库", "asn") },
+							onRefreshCDN:    func() { refreshCDN() },
+							onSysDNS:        func() { appendSystemDNS() },
+						})
 					}
 				}),
 			)
+			if clipRead {
+				clipRead = false
+				gtx.Execute(clipboard.ReadCmd{Tag: &clipTag})
+			}
+			if clipWrite != nil {
+				gtx.Execute(clipboard.WriteCmd{Type: "application/text", Data: io.NopCloser(strings.NewReader(*clipWrite))})
+				clipWrite = nil
+			}
+			for {
+				ev, ok := gtx.Event(key.Filter{Name: "K", Required: key.ModShortcut}, key.Filter{Name: key.NameEscape})
+				if !ok {
+					break
+				}
+				if ke, ok := ev.(key.Event); ok && ke.State == key.Press {
+					switch {
+					case ke.Name == "K" && !paletteOpen:
+						paletteOpen = true
+						paletteEd.SetText("")
+						gtx.Execute(key.FocusCmd{Tag: &paletteEd})
+						w.Invalidate()
+					case paletteOpen:
+						paletteOpen = false
+						w.Invalidate()
+					}
+				}
+			}
+			if paletteOpen {
+				commandPalette(th, gtx, &paletteEd, &paletteScrim, paletteBtns, commands(),
+					func(c command) {
+						paletteOpen = false
+						appendLog("命令面板：" + c.Title)
+						c.Run()
+						w.Invalidate()
+					},
+					func() { paletteOpen = false },
+				)
+			}
 			e.Frame(&ops)
+			saveSettings()
 		}
 	}
 }
 
-func headerBar(th *material.Theme, gtx layout.Context, startBtn, stopBtn *widget.Clickable, running bool, done, total int, onStart, onStop func()) layout.Dimensions {
+func headerBar(th *material.Theme, gtx layout.Context, startBtn, stopBtn *widget.Clickable, running bool, done, total int, speed string, onStart, onStop func()) layout.Dimensions {
 	gtx.Constraints.Min.Y = gtx.Dp(unit.Dp(88))
 	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
@@ -550,6 +3503,12 @@ func headerBar(th *material.Theme, gtx layout.Context, startBtn, stopBtn *widget
 			if total > 0 {
 				progress = float32(done) / float32(total)
 				progressText = fmt.Sprintf("%d / %d", done, total)
+				if speed != "" {
+					progressText += " · " + speed
+				}
+			}
+			if running {
+				gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(time.Second)})
 			}
 
 			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
@@ -596,7 +3555,43 @@ func headerBar(th *material.Theme, gtx layout.Context, startBtn, stopBtn *widget
 	})
 }
 
-func tabBar(th *material.Theme, gtx layout.Context, tab *widget.Enum, configBtn, resultsBtn, logBtn, previewBtn *widget.Clickable) layout.Dimensions {
+func netenvText(ins []netenv.Indicator) string {
+	var parts []string
+	for _, in := range ins {
+		switch in.Kind {
+		case netenv.KindProxyEnv:
+			parts = append(parts, "检测到代理环境变量 "+in.Detail)
+		case netenv.KindTunRoute:
+			parts = append(parts, "默认路由经过虚拟网卡 "+in.Detail)
+		case netenv.KindFakeIP:
+			parts = append(parts, "本机出口地址 "+in.Detail+" 属于 TUN 代理常用的 198.18.0.0/15")
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, "；") + "。VPN/代理开启时测得的 IP 在关闭后可能并不最优"
+}
+
+func warningBanner(th *material.Theme, gtx layout.Context, text string, dismissBtn *widget.Clickable, onDismiss func()) layout.Dimensions {
+	return layout.Inset{Left: uiPad, Right: uiPad, Bottom: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return card(gtx, uiRadiusSmall, uiWarnBg, uiWarnFg, uiBorder, layout.UniformInset(unit.Dp(10)), func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					l := material.Body2(th, "⚠ "+text)
+					l.Color = uiWarnFg
+					return l.Layout(gtx)
+				}),
+				layout.Rigid(spacer(uiGap)),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return actionButton(th, gtx, dismissBtn, "知道了", true, uiSurface, uiText, onDismiss)
+				}),
+			)
+		})
+	})
+}
+
+func tabBar(th *material.Theme, gtx layout.Context, tab *widget.Enum, scale *widget.Float, contrast *widget.Bool, configBtn, resultsBtn, logBtn, previewBtn, backupsBtn, monitorBtn, compareBtn, dnsBtn *widget.Clickable) layout.Dimensions {
 	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -614,7 +3609,35 @@ func tabBar(th *material.Theme, gtx layout.Context, tab *widget.Enum, configBtn,
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 				return tabButton(th, gtx, previewBtn, tab, "preview", "预览")
 			}),
+			layout.Rigid(spacer(unit.Dp(12))),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return tabButton(th, gtx, backupsBtn, tab, "backups", "备份")
+			}),
+			layout.Rigid(spacer(unit.Dp(12))),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return tabButton(th, gtx, monitorBtn, tab, "monitor", "监控")
+			}),
+			layout.Rigid(spacer(unit.Dp(12))),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return tabButton(th, gtx, compareBtn, tab, "compare", "对比")
+			}),
+			layout.Rigid(spacer(unit.Dp(12))),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return tabButton(th, gtx, dnsBtn, tab, "dns", "DNS 测速")
+			}),
 			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+			layout.Rigid(material.CheckBox(th, contrast, "高对比度").Layout),
+			layout.Rigid(spacer(uiGap)),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				l := material.Caption(th, fmt.Sprintf("缩放 %.0f%%", uiScale(scale.Value)*100))
+				l.Color = uiMuted
+				return l.Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(unit.Dp(140))
+				gtx.Constraints.Max.X = gtx.Constraints.Min.X
+				return material.Slider(th, scale).Layout(gtx)
+			}),
 		)
 	})
 }
@@ -663,48 +3686,99 @@ func tabButton(th *material.Theme, gtx layout.Context, c *widget.Clickable, tab
 	})
 }
 
-func leftPanel(th *material.Theme, gtx layout.Context,
-	leftList *layout.List,
-	domainsEd, dnsEd, hostsEd, portEd, timeoutEd, attemptsEd, concurrencyEd *widget.Editor,
-	ipv4, ipv6 *widget.Bool,
-	loadHosts, pickFile, pickHosts *widget.Clickable,
-	running bool,
-	domainFilePath string,
-	onLoadHosts, onPickFile, onPickHosts func(),
-) layout.Dimensions {
+// configState holds the widgets, status and callbacks leftPanel lays out.
+type configState struct {
+	leftList *layout.List
+
+	runGroup, familyPolicy, jitterMetric, outlierMode, tieBreak, outputMode *widget.Enum
+
+	domainsEd, subsEd, subURLEd, dnsEd, hostsEd, portEd, timeoutEd, intervalEd, attemptsEd *widget.Editor
+	concurrencyEd, rateEd, lossEd, expiryEd, keepBakEd, bakDaysEd, bakDirEd, roundsEd      *widget.Editor
+	roundDelayEd, slowEd, minSamplesEd, webhookEd, deployEd, extraHostsEd, outputEd        *widget.Editor
+	dnsListenEd, geoPathEd, geoRegionsEd, asnPathEd, asnExcludeEd, blocklistEd, dohEd      *widget.Editor
+
+	ipv4, ipv6, byPrefix, cdnSeed, dnsNoCache, dnsDisk, adaptive, autoConc, reuseSame, warmUp *widget.Bool
+	notifyDone, keepOnFail, fixDupes, autoUndo, toFile, dnsServe, keepBogons, httpsRR         *widget.Bool
+	keepHijack, quicProbe, quicScore, mtuCheck, traceBest, fullReport, wslNoGen               *widget.Bool
+
+	loadHosts, pickFile, pasteDomains, refreshSubs, pickHosts, editHosts, pickOutput, pickGeo *widget.Clickable
+	pickASN, refreshCDN, sysDNS                                                               *widget.Clickable
+
+	running, fetching, cdnFetching bool
+
+	domainFilePath, cdnStatus, dnsCacheStatus, dnsServerStatus string
+
+	onLoadHosts, onPickFile, onPaste, onRefreshSubs, onPickHosts, onEditHosts, onPickOutput func()
+	onPickGeo, onPickASN, onRefreshCDN, onSysDNS                                            func()
+}
+
+func leftPanel(th *material.Theme, gtx layout.Context, st *configState) layout.Dimensions {
 	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-		return leftList.Layout(gtx, 1, func(gtx layout.Context, _ int) layout.Dimensions {
+		return st.leftList.Layout(gtx, 1, func(gtx layout.Context, _ int) layout.Dimensions {
 			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 					return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
 						return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-								return sectionTitle(th, gtx, "输入")
+								return sectionTitle(th, gtx, "输入")
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return editorBox(th, gtx, st.domainsEd, unit.Dp(120), "每行一个域名，支持 # 注释、*.example.com 通配符和 [分组名 port=443 dns=1.1.1.1 allow=104.16.0.0/13] 分组")
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								names := domain.GroupNames(st.domainsEd.Text())
+								if len(names) == 0 {
+									return layout.Dimensions{}
+								}
+								return layout.Inset{Top: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+									return groupSelector(th, gtx, st.runGroup, names)
+								})
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return labeledEditor(th, gtx, "通配符展开子域名（空格分隔）", st.subsEd)
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-								return editorBox(th, gtx, domainsEd, unit.Dp(120), "每行一个域名，支持 # 注释")
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.End}.Layout(gtx,
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "订阅 URL（域名列表或 hosts，空格分隔多个）", st.subURLEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										label := "刷新订阅"
+										if st.fetching {
+											label = "获取中…"
+										}
+										return actionButton(th, gtx, st.refreshSubs, label, !st.running && !st.fetching, uiSurface, uiText, st.onRefreshSubs)
+									}),
+								)
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 								return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
 									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-										return actionButton(th, gtx, loadHosts, "从 hosts 读取", !running, uiSurface, uiText, onLoadHosts)
+										return actionButton(th, gtx, st.loadHosts, "从 hosts 读取", !st.running, uiSurface, uiText, st.onLoadHosts)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return actionButton(th, gtx, st.pickFile, "选择域名文件", true, uiSurface, uiText, st.onPickFile)
 									}),
 									layout.Rigid(spacer(uiGap)),
 									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-										return actionButton(th, gtx, pickFile, "选择域名文件", true, uiSurface, uiText, onPickFile)
+										return actionButton(th, gtx, st.pasteDomains, "从剪贴板导入", !st.running, uiSurface, uiText, st.onPaste)
 									}),
 								)
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-								if strings.TrimSpace(domainFilePath) == "" {
-									l := material.Caption(th, "未选择域名文件（可直接在上方粘贴域名）")
+								if strings.TrimSpace(st.domainFilePath) == "" {
+									l := material.Caption(th, "未选择域名文件（可直接在上方粘贴域名，或把文件拖入窗口）")
 									l.Color = uiMuted
 									return l.Layout(gtx)
 								}
-								l := material.Caption(th, "已选择："+filepath.Base(domainFilePath))
+								l := material.Caption(th, "已选择："+filepath.Base(st.domainFilePath))
 								l.Color = uiMuted
 								return l.Layout(gtx)
 							}),
@@ -720,33 +3794,243 @@ func leftPanel(th *material.Theme, gtx layout.Context,
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-								return editorBox(th, gtx, dnsEd, unit.Dp(78), "DNS 服务器（每行一个，可为空）")
+								return editorBox(th, gtx, st.dnsEd, unit.Dp(78), "DNS 服务器（每行一个，可为空）")
+							}),
+							layout.Rigid(spacer(unit.Dp(6))),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return actionButton(th, gtx, st.sysDNS, "使用系统 DNS", true, uiSurface, uiText, st.onSysDNS)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, "把系统当前配置的 DNS 服务器追加到列表，便于与公共 DNS 的结果对比")
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+								)
+							}),
+							layout.Rigid(spacer(unit.Dp(6))),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.End}.Layout(gtx,
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "可信 DoH（空格分隔，留空不检测 DNS 污染）", st.dohEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.keepHijack, "保留疑似污染的 IP").Layout),
+								)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return editorBox(th, gtx, st.blocklistEd, unit.Dp(78), "IP 黑名单（IP 或 CIDR，每行一个，# 注释），命中的候选在测速前丢弃")
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "端口", st.portEd) }),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "超时(ms)", st.timeoutEd) }),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "间隔(ms)", st.intervalEd) }),
+								)
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 								return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
-									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "端口", portEd) }),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "次数", st.attemptsEd) }),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "并发", st.concurrencyEd) }),
 									layout.Rigid(spacer(uiGap)),
-									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "超时(ms)", timeoutEd) }),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "限速(次/秒)", st.rateEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "丢包测量(次)", st.lossEd)
+									}),
 								)
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 								return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
-									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "次数", attemptsEd) }),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "N 轮取平均", st.roundsEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "轮间隔(秒)", st.roundDelayEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "慢域名阈值(ms)", st.slowEd)
+									}),
 									layout.Rigid(spacer(uiGap)),
-									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return labeledEditor(th, gtx, "并发", concurrencyEd) }),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "最少样本数", st.minSamplesEd)
+									}),
 								)
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
-									layout.Rigid(material.CheckBox(th, ipv4, "IPv4").Layout),
+									layout.Rigid(material.CheckBox(th, st.ipv4, "IPv4").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.ipv6, "IPv6").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.byPrefix, "按 /24、/48 前缀合并候选").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.notifyDone, "完成时通知").Layout),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+								)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(material.CheckBox(th, st.adaptive, "自适应超时").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.autoConc, "自动并发（以并发数为上限）").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.reuseSame, "候选未变时复用上次结果").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.warmUp, "预热探测").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.quicProbe, "QUIC 握手探测").Layout),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										if !st.quicProbe.Value {
+											return layout.Dimensions{}
+										}
+										return layout.Inset{Left: uiGap}.Layout(gtx, material.CheckBox(th, st.quicScore, "QUIC 计入评分").Layout)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.mtuCheck, "大包可达性检测").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.traceBest, "追踪最优 IP 路由").Layout),
 									layout.Rigid(spacer(uiGap)),
-									layout.Rigid(material.CheckBox(th, ipv6, "IPv6").Layout),
+									layout.Rigid(material.CheckBox(th, st.fullReport, "完整报告（所有候选测丢包）").Layout),
 									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
 								)
 							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, "抖动指标：")
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+									layout.Rigid(material.RadioButton(th, st.jitterMetric, string(engine.JitterStddev), "标准差").Layout),
+									layout.Rigid(material.RadioButton(th, st.jitterMetric, string(engine.JitterRFC3550), "相邻差值(RFC 3550)").Layout),
+								)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, "异常值：")
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+									layout.Rigid(material.RadioButton(th, st.outlierMode, string(engine.OutliersKeep), "全部保留").Layout),
+									layout.Rigid(material.RadioButton(th, st.outlierMode, string(engine.OutliersTrim), "截尾（去掉最慢 10%）").Layout),
+									layout.Rigid(material.RadioButton(th, st.outlierMode, string(engine.OutliersMAD), "MAD 剔除").Layout),
+								)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								if !st.ipv4.Value || !st.ipv6.Value {
+									return layout.Dimensions{}
+								}
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, "双栈策略：")
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+									layout.Rigid(material.RadioButton(th, st.familyPolicy, string(engine.FamilyBestOverall), "综合最优").Layout),
+									layout.Rigid(material.RadioButton(th, st.familyPolicy, string(engine.FamilyBestPerFamily), "IPv4/IPv6 各取最优").Layout),
+									layout.Rigid(material.RadioButton(th, st.familyPolicy, string(engine.FamilyPreferV6), "优先 IPv6").Layout),
+								)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								if !st.ipv4.Value || !st.ipv6.Value {
+									return layout.Dimensions{}
+								}
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, "相近时优先：")
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+									layout.Rigid(material.RadioButton(th, st.tieBreak, string(engine.TieIndifferent), "不限").Layout),
+									layout.Rigid(material.RadioButton(th, st.tieBreak, string(engine.TiePreferV4), "IPv4").Layout),
+									layout.Rigid(material.RadioButton(th, st.tieBreak, string(engine.TiePreferV6), "IPv6").Layout),
+								)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(material.CheckBox(th, st.dnsNoCache, "忽略缓存").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.dnsDisk, "DNS 缓存保存到磁盘").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.keepBogons, "保留内网/保留地址").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(material.CheckBox(th, st.httpsRR, "查询 HTTPS 记录").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, st.dnsCacheStatus)
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+								)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								label := "刷新地址段"
+								if st.cdnFetching {
+									label = "刷新中…"
+								}
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Rigid(material.CheckBox(th, st.cdnSeed, "用 CDN 公布的地址段补充候选").Layout),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return actionButton(th, gtx, st.refreshCDN, label, !st.cdnFetching, uiSurface, uiText, st.onRefreshCDN)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, st.cdnStatus)
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+								)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.End}.Layout(gtx,
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "GeoIP 数据库（GeoLite2-City .mmdb，可选）", st.geoPathEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return actionButton(th, gtx, st.pickGeo, "选择", !st.running, uiSurface, uiText, st.onPickGeo)
+									}),
+								)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return labeledEditor(th, gtx, "优先地区（国家代码或城市，如 HK JP SG；需 GeoIP）", st.geoRegionsEd)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.End}.Layout(gtx,
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "ASN 数据库（GeoLite2-ASN .mmdb，可选）", st.asnPathEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return actionButton(th, gtx, st.pickASN, "选择", !st.running, uiSurface, uiText, st.onPickASN)
+									}),
+								)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return labeledEditor(th, gtx, "排除 ASN（如 AS4134 AS9808；需 ASN 数据库）", st.asnExcludeEd)
+							}),
 						)
 					})
 				}),
@@ -759,11 +4043,78 @@ func leftPanel(th *material.Theme, gtx layout.Context,
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-								return editorLine(th, gtx, hostsEd, "hosts 文件路径")
+								return editorLine(th, gtx, st.hostsEd, "hosts 文件路径")
 							}),
 							layout.Rigid(spacer(uiGap)),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-								return actionButton(th, gtx, pickHosts, "选择 hosts 文件", true, uiSurface, uiText, onPickHosts)
+								return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return actionButton(th, gtx, st.pickHosts, "选择 hosts 文件", true, uiSurface, uiText, st.onPickHosts)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return actionButton(th, gtx, st.editHosts, "用编辑器打开", true, uiSurface, uiText, st.onEditHosts)
+									}),
+								)
+							}),
+							layout.Rigid(material.CheckBox(th, st.keepOnFail, "域名全部失败时保留现有映射").Layout),
+							layout.Rigid(material.CheckBox(th, st.fixDupes, "注释掉托管段外的重复条目").Layout),
+							layout.Rigid(material.CheckBox(th, st.autoUndo, "写入后验证发现大量不可达时自动回滚").Layout),
+							layout.Rigid(material.CheckBox(th, st.toFile, "输出到文件（不修改系统 hosts）").Layout),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								if !st.toFile.Value {
+									return layout.Dimensions{}
+								}
+								return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+											layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+												return editorLine(th, gtx, st.outputEd, "输出文件路径")
+											}),
+											layout.Rigid(spacer(uiGap)),
+											layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+												return actionButton(th, gtx, st.pickOutput, "选择…", true, uiSurface, uiText, st.onPickOutput)
+											}),
+										)
+									}),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+											layout.Rigid(material.RadioButton(th, st.outputMode, "block", "仅托管段").Layout),
+											layout.Rigid(material.RadioButton(th, st.outputMode, "merged", "完整 hosts（系统 hosts + 托管段）").Layout),
+										)
+									}),
+								)
+							}),
+							layout.Rigid(material.CheckBox(th, st.dnsServe, "启用本地 DNS 服务（代替修改 hosts）").Layout),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return editorLine(th, gtx, st.dnsListenEd, "监听地址，如 127.0.0.1:53")
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										l := material.Caption(th, st.dnsServerStatus)
+										l.Color = uiMuted
+										return l.Layout(gtx)
+									}),
+								)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return labeledEditor(th, gtx, "映射有效期（天，0 为不过期）", st.expiryEd)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "保留最近备份数（0 为不限）", st.keepBakEd)
+									}),
+									layout.Rigid(spacer(uiGap)),
+									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+										return labeledEditor(th, gtx, "备份保留天数（0 为不限）", st.bakDaysEd)
+									}),
+								)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return labeledEditor(th, gtx, "备份目录（留空为 hosts 所在目录）", st.bakDirEd)
 							}),
 							layout.Rigid(spacer(unit.Dp(6))),
 							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -771,6 +4122,21 @@ func leftPanel(th *material.Theme, gtx layout.Context,
 								l.Color = uiMuted
 								return l.Layout(gtx)
 							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return editorBox(th, gtx, st.extraHostsEd, unit.Dp(56), "其他 hosts 文件（每行一个路径，如 WSL 的 \\\\wsl$\\Ubuntu\\etc\\hosts），「写入」时一并更新、备份（备份在各文件所在目录）并校验")
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return labeledEditor(th, gtx, "Webhook（运行完成/写入 hosts 时 POST JSON，留空关闭）", st.webhookEd)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return editorBox(th, gtx, st.deployEd, unit.Dp(78), "远程目标（每行 user@host[:port]，可选 key=私钥路径 password=密码 path=/etc/hosts type=openwrt），在「预览」页部署")
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return material.CheckBox(th, st.wslNoGen, "同步到 WSL 时关闭自动生成 hosts（wsl.conf generateHosts=false）").Layout(gtx)
+							}),
 						)
 					})
 				}),
@@ -779,7 +4145,179 @@ func leftPanel(th *material.Theme, gtx layout.Context,
 	})
 }
 
-func previewPage(th *material.Theme, gtx layout.Context, ed *widget.Editor, previewBtn, writeBtn, restoreBtn *widget.Clickable, onPreview, onWrite, onRestore func()) layout.Dimensions {
+const allGroups = "*"
+
+const prefixExpandTop = 2
+
+const quicScoreWeight = 0.5
+
+const cdnSeedCount = 8
+
+const deployTimeout = time.Minute
+
+// Version is overridden at build time with -ldflags "-X example.com/ip-opt-gui/internal/ui.Version=...".
+var Version = "dev"
+
+func blocklistPath() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ip-opt-gui", "blocklist.txt")
+}
+
+func mappingComment(r row, ip string) string {
+	if _, ok := r.overrideIP(); ok {
+		return "manual override"
+	}
+	for _, c := range r.Candidates {
+		if c.IP.String() == ip {
+			return statComment(c)
+		}
+	}
+	return ""
+}
+
+func statComment(c model.CandidateStat) string {
+	s := "p95 " + c.P95.Round(time.Millisecond).String()
+	if c.ResolvedVia != "" {
+		s += " via " + c.ResolvedVia
+	}
+	return s
+}
+
+func findMatches(text, query string) [][2]int {
+	q := []rune(query)
+	for i, r := range q {
+		q[i] = unicode.ToLower(r)
+	}
+	if len(q) == 0 {
+		return nil
+	}
+	t := []rune(text)
+	var out [][2]int
+	for i := 0; i+len(q) <= len(t); i++ {
+		j := 0
+		for j < len(q) && unicode.ToLower(t[i+j]) == q[j] {
+			j++
+		}
+		if j == len(q) {
+			out = append(out, [2]int{i, i + len(q)})
+			i += len(q) - 1
+		}
+	}
+	return out
+}
+
+func logDir() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ip-opt-gui", "logs")
+}
+
+func settingsPath() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ip-opt-gui", "settings.json")
+}
+
+func undoPath() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ip-opt-gui", "hosts-undo.json")
+}
+
+func historyDir() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ip-opt-gui", "history")
+}
+
+func dnsCachePath() string {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "ip-opt-gui", "dns-cache.json")
+}
+
+func cdnStatus(set cdnranges.Set) string {
+	var parts []string
+	for _, p := range cdnranges.Providers {
+		if n := len(set[p.Key]); n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", p.Name, n))
+		}
+	}
+	if len(parts) == 0 {
+		return "Cloudflare / Fastly / CloudFront / GCore：未加载"
+	}
+	return "已加载：" + strings.Join(parts, "，")
+}
+
+func groupSelector(th *material.Theme, gtx layout.Context, sel *widget.Enum, names []string) layout.Dimensions {
+	valid := sel.Value == allGroups
+	for _, n := range names {
+		valid = valid || sel.Value == n
+	}
+	if !valid {
+		sel.Value = allGroups
+	}
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, "运行分组：")
+			l.Color = uiMuted
+			return l.Layout(gtx)
+		}),
+		layout.Rigid(material.RadioButton(th, sel, allGroups, "全部").Layout),
+	}
+	for _, n := range names {
+		children = append(children, layout.Rigid(material.RadioButton(th, sel, n, n).Layout))
+	}
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+}
+
+// undoLabel names the change the next undo/redo step reverts, e.g. "撤销写入".
+func undoLabel(stack []hostsfile.Snapshot, verb string) string {
+	if len(stack) == 0 {
+		return verb
+	}
+	return verb + stack[len(stack)-1].Action
+}
+
+// previewState holds the widgets, status and callbacks previewPage lays out.
+type previewState struct {
+	ed        *widget.Editor
+	diffList  *layout.List
+	diffLines []hostsfile.DiffLine
+	showDiff  *widget.Bool
+	mergeMode *widget.Enum
+	targets   []targetStatus
+	issues    []hostsfile.Issue
+	blocking  int
+	expired   int
+
+	hasPreview, canDeploy, canUndo, canRedo bool
+	writeText, undoText, redoText           string
+
+	previewBtn, copyBtn, writeBtn, restoreBtn, undoBtn, redoBtn *widget.Clickable
+	deployBtn, wslBtn, retestExpiredBtn, dropExpiredBtn         *widget.Clickable
+
+	onPreview, onCopy, onWrite, onRestore, onUndo, onRedo        func()
+	onDeploy, onWSL, onRetestExpired, onDropExpired, onMergeMode func()
+}
+
+func previewPage(th *material.Theme, gtx layout.Context, st *previewState) layout.Dimensions {
+	if st.mergeMode.Update(gtx) {
+		st.onMergeMode()
+	}
 	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
 			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
@@ -788,24 +4326,115 @@ func previewPage(th *material.Theme, gtx layout.Context, ed *widget.Editor, prev
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 							return sectionTitle(th, gtx, "预览")
 						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(material.CheckBox(th, st.showDiff, "仅显示差异").Layout),
 						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							return actionButton(th, gtx, previewBtn, "生成预览", true, uiSurface, uiText, onPreview)
+							return actionButton(th, gtx, st.previewBtn, "生成预览", true, uiSurface, uiText, st.onPreview)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, st.copyBtn, "复制预览", st.hasPreview, uiSurface, uiText, st.onCopy)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, st.writeBtn, st.writeText, true, uiPrimary, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, st.onWrite)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, st.restoreBtn, "恢复备份", true, uiSurface, uiText, st.onRestore)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, st.undoBtn, st.undoText, st.canUndo, uiSurface, uiText, st.onUndo)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, st.redoBtn, st.redoText, st.canRedo, uiSurface, uiText, st.onRedo)
 						}),
 						layout.Rigid(spacer(uiGap)),
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							return actionButton(th, gtx, writeBtn, "写入", true, uiPrimary, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, onWrite)
+							return actionButton(th, gtx, st.deployBtn, "部署到远程", st.canDeploy, uiSurface, uiText, st.onDeploy)
 						}),
 						layout.Rigid(spacer(uiGap)),
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							return actionButton(th, gtx, restoreBtn, "恢复备份", true, uiSurface, uiText, onRestore)
+							return actionButton(th, gtx, st.wslBtn, "同步到 WSL", st.canDeploy, uiSurface, uiText, st.onWSL)
+						}),
+					)
+				}),
+				layout.Rigid(spacer(unit.Dp(6))),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							l := material.Body2(th, "托管段更新方式：")
+							l.Color = uiText
+							return l.Layout(gtx)
+						}),
+						layout.Rigid(material.RadioButton(th, st.mergeMode, string(hostsfile.MergeReplace), "替换").Layout),
+						layout.Rigid(material.RadioButton(th, st.mergeMode, string(hostsfile.MergeKeep), "合并").Layout),
+						layout.Rigid(material.RadioButton(th, st.mergeMode, string(hostsfile.MergePrune), "合并并删除所选").Layout),
+						layout.Rigid(spacer(uiGap)),
+						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+							hint := map[string]string{
+								string(hostsfile.MergeReplace): "只写入本次勾选的映射，托管段中其他旧条目会被删除",
+								string(hostsfile.MergeKeep):    "保留托管段中本次没有重测的旧条目",
+								string(hostsfile.MergePrune):   "同「合并」，但删除「结果」页中所选域名的条目",
+							}[st.mergeMode.Value]
+							l := material.Caption(th, hint)
+							l.Color = uiMuted
+							l.MaxLines = 1
+							return l.Layout(gtx)
 						}),
 					)
 				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if len(st.targets) < 2 && (len(st.targets) == 0 || st.targets[0].Err == "") {
+						return layout.Dimensions{}
+					}
+					return targetsPanel(th, gtx, st.targets)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if st.expired == 0 {
+						return layout.Dimensions{}
+					}
+					return layout.Inset{Top: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+							layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+								l := material.Body2(th, fmt.Sprintf("hosts 中有 %d 条映射已过期", st.expired))
+								l.Color = uiDanger
+								return l.Layout(gtx)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return actionButton(th, gtx, st.retestExpiredBtn, "重测过期项", true, uiSurface, uiText, st.onRetestExpired)
+							}),
+							layout.Rigid(spacer(uiGap)),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return actionButton(th, gtx, st.dropExpiredBtn, "移除过期项", true, uiDanger, color.NRGBA{A: 255, R: 255, G: 255, B: 255}, st.onDropExpired)
+							}),
+						)
+					})
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if len(st.issues) == 0 {
+						return layout.Dimensions{}
+					}
+					return layout.Inset{Top: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return lintView(th, gtx, st.issues, st.blocking)
+					})
+				}),
 				layout.Rigid(spacer(uiGap)),
 				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
 					gtx.Constraints.Min.Y = gtx.Constraints.Max.Y
-					e := material.Editor(th, ed, "")
+					if st.showDiff.Value {
+						empty := "尚未生成预览"
+						if st.hasPreview {
+							empty = "与当前 hosts 相比没有变化"
+						}
+						return card(gtx, uiRadiusSmall, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(unit.Dp(10)), func(gtx layout.Context) layout.Dimensions {
+							return diffView(th, gtx, st.diffList, st.diffLines, empty)
+						})
+					}
+					e := material.Editor(th, st.ed, "")
 					e.TextSize = unit.Sp(14)
 					e.Color = uiText
 					e.HintColor = uiMuted
@@ -817,7 +4446,61 @@ func previewPage(th *material.Theme, gtx layout.Context, ed *widget.Editor, prev
 	})
 }
 
-func rightPanel(th *material.Theme, gtx layout.Context, list *layout.List, selectAllBtn, selectNoneBtn, selectOKBtn *widget.Clickable, rows []row, onSelect func(mode string)) layout.Dimensions {
+const maxLintShown = 5
+
+func dnsServerStatus(s *dnsserver.Server) string {
+	if s == nil {
+		return "未启动"
+	}
+	return fmt.Sprintf("本地应答 %d / 转发 %d", s.Answered.Load(), s.Forwarded.Load())
+}
+
+func writeLabel(toFile bool) string {
+	if toFile {
+		return "输出到文件"
+	}
+	return "写入"
+}
+
+func lintView(th *material.Theme, gtx layout.Context, issues []hostsfile.Issue, blocking int) layout.Dimensions {
+	title := fmt.Sprintf("校验发现 %d 个问题", len(issues))
+	if blocking > 0 {
+		title += fmt.Sprintf("，其中 %d 个会阻止写入", blocking)
+	}
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Body2(th, title)
+			l.Color = uiText
+			if blocking > 0 {
+				l.Color = uiDanger
+			}
+			return l.Layout(gtx)
+		}),
+	}
+	for i, is := range issues {
+		if i == maxLintShown {
+			children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				l := material.Caption(th, fmt.Sprintf("…还有 %d 个问题", len(issues)-maxLintShown))
+				l.Color = uiMuted
+				return l.Layout(gtx)
+			}))
+			break
+		}
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(th, fmt.Sprintf("第 %d 行：%s", is.Line, is.Msg))
+			l.Color = uiMuted
+			if is.Error {
+				l.Color = uiDanger
+			}
+			l.MaxLines = 1
+			return l.Layout(gtx)
+		}))
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+func rightPanel(th *material.Theme, gtx layout.Context, list *layout.List, selectAllBtn, selectNoneBtn, selectOKBtn, retestSelBtn, copyBtn, copyMapsBtn, exportBtn, reportBtn, runExportBtn, runImportBtn, columnsBtn, resolversBtn *widget.Clickable, exportFmtBtns, selBtns []widget.Clickable, exportOpen, columnsOpen, resolversOpen bool, cols []*resultColumn, resolvers []engine.ResolverStat, pool *rowPool, rows []row, sel bitset, running bool, retesting map[string]context.CancelFunc, onSelect func(mode string), onRetestSel, onCopy, onCopyMaps, onToggleExport, onToggleColumns, onToggleResolvers func(), onExport func(export.Format), onReport, onExportRun, onImportRun func(), onRetest, onSkip func(domain string), onCopyRow func(line string), onPick func(i int, shift bool), onSelAction func(action int)) layout.Dimensions {
+	nSel := sel.Count()
 	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
@@ -840,28 +4523,190 @@ func rightPanel(th *material.Theme, gtx layout.Context, list *layout.List, selec
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 							return actionButton(th, gtx, selectOKBtn, "只选成功", true, uiSurface, uiText, func() { onSelect("ok") })
 						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, retestSelBtn, "重测选中", !running && len(rows) > 0, uiSurface, uiText, onRetestSel)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, copyBtn, "复制结果", len(rows) > 0, uiSurface, uiText, onCopy)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, copyMapsBtn, "复制已选映射", len(rows) > 0, uiSurface, uiText, onCopyMaps)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							label := "导出 ▾"
+							if exportOpen {
+								label = "导出 ▴"
+							}
+							return actionButton(th, gtx, exportBtn, label, len(rows) > 0, uiSurface, uiText, onToggleExport)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, reportBtn, "导出报告", len(rows) > 0 && !running, uiSurface, uiText, onReport)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, runExportBtn, "导出运行", len(rows) > 0 && !running, uiSurface, uiText, onExportRun)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, runImportBtn, "导入运行", !running, uiSurface, uiText, onImportRun)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							label := "列 ▾"
+							if columnsOpen {
+								label = "列 ▴"
+							}
+							return actionButton(th, gtx, columnsBtn, label, true, uiSurface, uiText, onToggleColumns)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							label := "DNS 统计 ▾"
+							if resolversOpen {
+								label = "DNS 统计 ▴"
+							}
+							return actionButton(th, gtx, resolversBtn, label, len(rows) > 0, uiSurface, uiText, onToggleResolvers)
+						}),
 					)
 				})
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if !exportOpen || len(rows) == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return exportMenu(th, gtx, exportFmtBtns, nSel, onExport)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if !columnsOpen {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return columnPicker(th, gtx, cols)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if !resolversOpen || len(rows) == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return resolverPanel(th, gtx, resolvers)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if nSel == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: uiGap}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return selectionBar(th, gtx, selBtns, nSel, onSelAction)
+				})
+			}),
 			layout.Rigid(spacer(uiGap)),
 			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
 				return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
-					return list.Layout(gtx, len(rows), func(gtx layout.Context, i int) layout.Dimensions {
+					dims := list.Layout(gtx, len(rows), func(gtx layout.Context, i int) layout.Dimensions {
 						r := rows[i]
-						return resultRow(th, gtx, &rows[i], r)
+						_, busy := retesting[r.Domain]
+						return resultRow(th, gtx, &rows[i], pool.get(i, &rows[i]), r, sel.Has(i), running, busy, cols, onRetest, onSkip, onCopyRow, func(shift bool) { onPick(i, shift) })
 					})
+					pool.sweep()
+					return dims
 				})
 			}),
 		)
 	})
 }
 
-func editorPage(th *material.Theme, gtx layout.Context, title string, ed *widget.Editor) layout.Dimensions {
+func exportMenu(th *material.Theme, gtx layout.Context, btns []widget.Clickable, selected int, onExport func(export.Format)) layout.Dimensions {
+	return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
+		title := "导出已勾选映射为："
+		if selected > 0 {
+			title = fmt.Sprintf("导出所选 %d 行的映射为：", selected)
+		}
+		children := []layout.FlexChild{
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				l := material.Caption(th, title)
+				l.Color = uiMuted
+				return l.Layout(gtx)
+			}),
+		}
+		for i := range export.Formats {
+			f := export.Formats[i]
+			children = append(children,
+				layout.Rigid(spacer(uiGap)),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return actionButton(th, gtx, &btns[i], f.Name, true, uiSurface, uiText, func() { onExport(f) })
+				}),
+			)
+		}
+		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+	})
+}
+
+func logPage(th *material.Theme, gtx layout.Context, ed, searchEd *widget.Editor, fileLog, jsonLog *widget.Bool, openDirBtn, prevBtn, nextBtn *widget.Clickable, searching bool, match, matchCount int, onOpenDir func(), onSearch func(step int)) layout.Dimensions {
+	searchEd.SingleLine = true
+	searchEd.Submit = true
+	for {
+		ev, ok := searchEd.Update(gtx)
+		if !ok {
+			break
+		}
+		switch ev.(type) {
+		case widget.ChangeEvent:
+			onSearch(0)
+		case widget.SubmitEvent:
+			onSearch(1)
+		}
+	}
+	status := ""
+	switch {
+	case searching && matchCount == 0:
+		status = "无匹配"
+	case searching && match >= 0:
+		status = fmt.Sprintf("%d/%d", match+1, matchCount)
+	}
 	return layout.UniformInset(uiPad).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return card(gtx, uiRadius, uiSurface, uiBorderCol, uiBorder, layout.UniformInset(uiPad), func(gtx layout.Context) layout.Dimensions {
 			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-					return sectionTitle(th, gtx, title)
+					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return sectionTitle(th, gtx, "日志")
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(material.CheckBox(th, fileLog, "保存到文件").Layout),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(material.CheckBox(th, jsonLog, "JSON 日志").Layout),
+						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions { return layout.Dimensions{} }),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							gtx.Constraints.Min.X = gtx.Dp(unit.Dp(220))
+							gtx.Constraints.Max.X = gtx.Constraints.Min.X
+							return editorLine(th, gtx, searchEd, "搜索域名、IP、失败…")
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							l := material.Caption(th, status)
+							l.Color = uiMuted
+							return l.Layout(gtx)
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, prevBtn, "上一个", matchCount > 0, uiSurface, uiText, func() { onSearch(-1) })
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, nextBtn, "下一个", matchCount > 0, uiSurface, uiText, func() { onSearch(1) })
+						}),
+						layout.Rigid(spacer(uiGap)),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return actionButton(th, gtx, openDirBtn, "打开日志目录", true, uiSurface, uiText, onOpenDir)
+						}),
+					)
 				}),
 				layout.Rigid(spacer(uiGap)),
 				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
@@ -878,44 +4723,114 @@ func editorPage(th *material.Theme, gtx layout.Context, title string, ed *widget
 	})
 }
 
-func resultRow(th *material.Theme, gtx layout.Context, target *row, r row) layout.Dimensions {
+func resultRow(th *material.Theme, gtx layout.Context, target *row, w *rowWidgets, r row, selected, running, retesting bool, cols []*resultColumn, onRetest, onSkip func(domain string), onCopy func(line string), onPick func(shift bool)) layout.Dimensions {
+	for {
+		c, ok := w.SelBtn.Update(gtx)
+		if !ok {
+			break
+		}
+		onPick(c.Modifiers.Contain(key.ModShift))
+	}
+	if w.Apply.Update(gtx) {
+		target.Apply = w.Apply.Value
+	}
+	w.Apply.Value = target.Apply
 	return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-		bg := uiSurface
-		if strings.TrimSpace(r.Message) != "" {
-			bg = color.NRGBA{A: 255, R: 255, G: 248, B: 248}
+		failed := strings.TrimSpace(r.Message) != ""
+		bg, border := uiSurface, uiBorderCol
+		if failed {
+			bg = uiErrorBg
+			if uiHighContrast {
+				border = uiDanger
+			}
+		}
+		if selected {
+			border = uiPrimary
 		}
-		return card(gtx, uiRadiusSmall, bg, uiBorderCol, uiBorder, layout.UniformInset(unit.Dp(10)), func(gtx layout.Context) layout.Dimensions {
+		return card(gtx, uiRadiusSmall, bg, border, uiBorder, layout.UniformInset(unit.Dp(10)), func(gtx layout.Context) layout.Dimensions {
 			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
-						layout.Rigid(material.CheckBox(th, &target.Apply, "").Layout),
+					children := []layout.FlexChild{
+						layout.Rigid(material.CheckBox(th, &w.Apply, "").Layout),
 						layout.Rigid(spacer(unit.Dp(8))),
-						layout.Flexed(0.55, func(gtx layout.Context) layout.Dimensions {
-							l := material.Body1(th, r.Domain)
-							l.Color = uiText
-							return l.Layout(gtx)
+					}
+					for _, c := range cols {
+						if c.Optional && !c.Show.Value {
+							continue
+						}
+						children = append(children, layout.Flexed(colWeight(c.Width.Value), func(gtx layout.Context) layout.Dimensions {
+							return resultCell(th, gtx, c.Key, target, w, r, failed)
+						}))
+					}
+					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, append(children,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							label := fmt.Sprintf("详情 · 候选 %d ▾", len(r.Candidates))
+							if target.Expanded {
+								label = fmt.Sprintf("详情 · 候选 %d ▴", len(r.Candidates))
+							}
+							return linkButton(th, gtx, &w.DetailBtn, label, func() { target.Expanded = !target.Expanded })
 						}),
-						layout.Flexed(0.25, func(gtx layout.Context) layout.Dimensions {
-							l := material.Body1(th, r.BestIP)
-							l.Color = uiText
-							return l.Layout(gtx)
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							ip := target.effectiveIP()
+							if ip == "" {
+								return layout.Dimensions{}
+							}
+							return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								return linkButton(th, gtx, &w.CopyBtn, "复制", func() { onCopy(ip + " " + r.Domain) })
+							})
 						}),
-						layout.Flexed(0.20, func(gtx layout.Context) layout.Dimensions {
-							var s string
-							if r.BestIP != "" {
-								s = fmt.Sprintf("%.0f%%  %s", r.Rate*100, r.P95)
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							switch {
+							case retesting:
+								l := material.Caption(th, "  重测中…")
+								l.Color = uiMuted
+								return l.Layout(gtx)
+							case running && r.Skipping:
+								l := material.Caption(th, "  跳过中…")
+								l.Color = uiMuted
+								return l.Layout(gtx)
+							case running && r.Stage != "":
+								return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+									return linkButton(th, gtx, &w.SkipBtn, "跳过", func() { onSkip(r.Domain) })
+								})
+							case running:
+								return layout.Dimensions{}
 							}
-							l := material.Caption(th, s)
-							l.Color = uiMuted
-							return l.Layout(gtx)
+							return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+								return linkButton(th, gtx, &w.RetestBtn, "重测", func() { onRetest(r.Domain) })
+							})
 						}),
-					)
+					)...)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if r.BestIP == "" || r.Stage != "" || !(target.ScoreOpen || w.ScoreBtn.Hovered()) {
+						return layout.Dimensions{}
+					}
+					l := material.Caption(th, scoreText(r.Score, r.ScoreBy))
+					l.Color = uiMuted
+					return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, l.Layout)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return verifyLine(th, gtx, r)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return staleWarning(th, gtx, r)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if !target.Expanded {
+						return layout.Dimensions{}
+					}
+					return candidateDetail(th, gtx, target, w)
 				}),
 				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 					if strings.TrimSpace(r.Message) == "" {
 						return layout.Dimensions{}
 					}
-					l := material.Caption(th, r.Message)
+					msg := r.Message
+					if uiHighContrast {
+						msg = "✗ 失败：" + msg
+					}
+					l := material.Caption(th, msg)
 					l.Color = uiDanger
 					l.Alignment = text.Start
 					return layout.Inset{Top: unit.Dp(6)}.Layout(gtx, l.Layout)
@@ -925,6 +4840,41 @@ func resultRow(th *material.Theme, gtx layout.Context, target *row, r row) layou
 	})
 }
 
+func verifyLine(th *material.Theme, gtx layout.Context, r row) layout.Dimensions {
+	var l material.LabelStyle
+	switch r.Verify {
+	case verifyPending:
+		l = material.Caption(th, "正在通过系统解析器验证写入结果…")
+		l.Color = uiMuted
+	case verifyOK:
+		l = material.Caption(th, r.VerifyMsg)
+		l.Color = uiSuccess
+	case verifyFailed:
+		l = material.Caption(th, r.VerifyMsg)
+		l.Color = uiDanger
+	default:
+		return layout.Dimensions{}
+	}
+	return layout.Inset{Top: unit.Dp(6)}.Layout(gtx, l.Layout)
+}
+
+const staleTTLFactor = 10
+
+func staleWarning(th *material.Theme, gtx layout.Context, r row) layout.Dimensions {
+	if r.WrittenAt.IsZero() || r.TTL <= 0 {
+		return layout.Dimensions{}
+	}
+	if staleAt := r.WrittenAt.Add(staleTTLFactor * r.TTL); gtx.Now.Before(staleAt) {
+		gtx.Execute(op.InvalidateCmd{At: staleAt})
+		return layout.Dimensions{}
+	}
+	age := gtx.Now.Sub(r.WrittenAt)
+	msg := fmt.Sprintf("⚠ %s 已写入 %s，超过 DNS TTL（%s）的 %d 倍，该 IP 可能已轮换，建议重测", r.WrittenIP, age.Round(time.Second), r.TTL, int(age/r.TTL))
+	l := material.Caption(th, msg)
+	l.Color = uiDanger
+	return layout.Inset{Top: unit.Dp(6)}.Layout(gtx, l.Layout)
+}
+
 func editorBox(th *material.Theme, gtx layout.Context, ed *widget.Editor, height unit.Dp, hint string) layout.Dimensions {
 	gtx.Constraints.Min.Y = gtx.Dp(height)
 	gtx.Constraints.Max.Y = gtx.Dp(height)
@@ -963,6 +4913,90 @@ func spacer(h unit.Dp) layout.Widget {
 	}
 }
 
+// previousAddrs returns what r's domain resolved to before a write: its
+// entries in the old hosts content, or else the resolvers' answers from the
+// run, minus the ones a DoH server contradicted.
+func previousAddrs(old string, r row) []netip.Addr {
+	var out []netip.Addr
+	add := func(s string) {
+		if a, err := netip.ParseAddr(s); err == nil && !slices.Contains(out, a) {
+			out = append(out, a)
+		}
+	}
+	for _, m := range hostsfile.ParseManagedBlock(old) {
+		if strings.EqualFold(m.Domain, r.Domain) {
+			add(m.IP)
+		}
+	}
+	for _, c := range hostsfile.FindConflicts(old, []hostsfile.Mapping{{Domain: r.Domain}}) {
+		add(c.IP)
+	}
+	if len(out) > 0 {
+		return out
+	}
+	for _, q := range r.Queries {
+		for _, a := range q.Addrs {
+			if !slices.Contains(r.Hijacked, a) {
+				add(a.String())
+			}
+		}
+	}
+	return out
+}
+
+func resultsText(rows []row) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("domain\tip\tvia\tsuccess\tp95\tjitter\terror\n")
+	for _, r := range rows {
+		var rate, p95, jitter string
+		if r.BestIP != "" {
+			rate = fmt.Sprintf("%.0f%%", r.Rate*100)
+			p95 = r.P95.String()
+			jitter = r.Jitter.String()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Domain, r.BestIP, r.Via, rate, p95, jitter, r.Message)
+	}
+	return b.String()
+}
+
+func stageText(s engine.Stage, done, total int) string {
+	switch s {
+	case engine.StageResolving:
+		return "解析中…"
+	case engine.StageProbing:
+		return fmt.Sprintf("探测中 %d/%d", done, total)
+	case engine.StageWaiting:
+		return fmt.Sprintf("第 %d/%d 轮完成，等待下一轮", done, total)
+	}
+	return string(s)
+}
+
+func webhookResults(rows []row) []webhook.Result {
+	out := make([]webhook.Result, 0, len(rows))
+	for _, r := range rows {
+		res := webhook.Result{Domain: r.Domain, IP: r.BestIP, Error: r.Message}
+		if r.BestIP != "" {
+			res.SuccessRate = r.Rate
+			res.P95Ms = float64(r.P95) / float64(time.Millisecond)
+		}
+		out = append(out, res)
+	}
+	return out
+}
+
+func countChanged(lines []hostsfile.DiffLine) int {
+	n := 0
+	for _, l := range lines {
+		if l.Op == hostsfile.DiffAdd || l.Op == hostsfile.DiffRemove {
+			n++
+		}
+	}
+	return n
+}
+
 func errorsIsCanceled(err error) bool {
 	return errors.Is(err, context.Canceled) || strings.Contains(strings.ToLower(err.Error()), "canceled")
 }
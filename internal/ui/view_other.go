@@ -0,0 +1,9 @@
+//go:build !windows
+
+package ui
+
+import "gioui.org/app"
+
+func nativeWindow(e app.ViewEvent) uintptr {
+	return 0
+}
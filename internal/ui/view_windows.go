@@ -0,0 +1,12 @@
+//go:build windows
+
+package ui
+
+import "gioui.org/app"
+
+func nativeWindow(e app.ViewEvent) uintptr {
+	if v, ok := e.(app.Win32ViewEvent); ok {
+		return v.HWND
+	}
+	return 0
+}
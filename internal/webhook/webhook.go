@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	EventRunDone          = "run_done"
+	EventHostsWritten     = "hosts_written"
+	EventHostsWriteFailed = "hosts_write_failed"
+)
+
+type Result struct {
+	Domain      string  `json:"domain"`
+	IP          string  `json:"ip,omitempty"`
+	SuccessRate float64 `json:"success_rate"`
+	P95Ms       float64 `json:"p95_ms,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type Summary struct {
+	Total  int `json:"total"`
+	OK     int `json:"ok"`
+	Failed int `json:"failed"`
+}
+
+type Payload struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Summary   *Summary  `json:"summary,omitempty"`
+	Results   []Result  `json:"results,omitempty"`
+	HostsPath string    `json:"hosts_path,omitempty"`
+	Backup    string    `json:"backup,omitempty"`
+	Changes   []string  `json:"changes,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func Summarize(results []Result) *Summary {
+	s := &Summary{Total: len(results)}
+	for _, r := range results {
+		if r.IP != "" {
+			s.OK++
+		} else {
+			s.Failed++
+		}
+	}
+	return s
+}
+
+func Send(ctx context.Context, client *http.Client, url string, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ip-opt-gui")
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	var got Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	results := []Result{{Domain: "a.test", IP: "1.2.3.4", SuccessRate: 1}, {Domain: "b.test", Error: "no candidate ip"}}
+	p := Payload{Event: EventRunDone, Time: time.Now(), Summary: Summarize(results), Results: results}
+	if err := Send(context.Background(), srv.Client(), srv.URL, p); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != EventRunDone || got.Summary == nil || got.Summary.OK != 1 || got.Summary.Failed != 1 || len(got.Results) != 2 {
+		t.Fatalf("unexpected payload: %+v", got)
+	}
+}
+
+func TestSendStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	if err := Send(context.Background(), srv.Client(), srv.URL, Payload{Event: EventHostsWritten}); err == nil {
+		t.Fatal("expected error for non-2xx status")
+	}
+}